@@ -3,11 +3,13 @@ package admin
 import (
 	"fmt"
 	"github.com/go-chi/chi"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/settings"
 	"net/http"
 )
 
 // A completely separate router for administrator routes
-func Router() chi.Router {
+func Router(auditApi *audit.Api, settingsApi *settings.Api) chi.Router {
 	r := chi.NewRouter()
 	r.Use(adminOnly)
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
@@ -19,6 +21,8 @@ func Router() chi.Router {
 	r.Get("/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = w.Write([]byte(fmt.Sprintf("admin: view user id %v", chi.URLParam(r, "userId"))))
 	})
+	r.Route("/audit", auditApi.ConfigureRouter)
+	r.Route("/settings", settingsApi.ConfigureRouter)
 	return r
 }
 
@@ -5,6 +5,7 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/db"
 	"net/http"
 	"time"
 )
@@ -17,11 +18,11 @@ var (
 		},
 		[]string{"method", "url"},
 	)
-	urlLatency = prometheus.NewSummaryVec(
-		prometheus.SummaryOpts{
-			Name:       "smfg_inventory_url_latency",
-			Help:       "The latency quantiles for the given URL",
-			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	urlLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "smfg_inventory_url_latency",
+			Help:    "The latency in milliseconds for the given URL",
+			Buckets: db.LatencyBuckets,
 		},
 		[]string{"method", "url"},
 	)
@@ -35,7 +36,7 @@ func MetricsMiddleware(next http.Handler) http.Handler {
 			ctx := chi.RouteContext(r.Context())
 
 			if len(ctx.RoutePatterns) > 0 {
-				dur := float64(time.Since(start).Milliseconds())
+				dur := db.Milliseconds(time.Since(start))
 				urlLatency.WithLabelValues(ctx.RouteMethod, ctx.RoutePatterns[0]).Observe(dur)
 				urlHitCount.WithLabelValues(ctx.RouteMethod, ctx.RoutePatterns[0]).Inc()
 			}
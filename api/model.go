@@ -1,8 +1,10 @@
 package api
 
 import (
-	"github.com/go-chi/render"
+	"encoding/json"
 	"net/http"
+
+	"github.com/go-chi/render"
 	"github.com/rs/zerolog/log"
 )
 
@@ -10,18 +12,34 @@ import (
 // Error response payloads & renderers
 //--
 
-// ErrResponse renderer type for handling all sorts of errors.
-//
-// In the best case scenario, the excellent github.com/pkg/errors package
-// helps reveal information on the error, setting it on Err, and in the Render()
-// method, using it to set the application-specific error code in AppCode.
+const (
+	ProblemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:smfg-inventory:problem:"
+)
+
+// Stable application error codes. Clients branch on these, so existing values must never change meaning.
+const (
+	CodeInvalidRequest = "invalid-request"
+	CodeInternalError  = "internal-error"
+	CodeRenderError    = "render-error"
+	CodeNotFound       = "not-found"
+	CodeRateLimited    = "rate-limited"
+)
+
+// ErrResponse renderer type for handling all sorts of errors. It is rendered as an RFC 7807 problem detail with an
+// additional code member holding a stable, application-specific error code.
 type ErrResponse struct {
 	Err            error `json:"-"` // low-level runtime error
 	HTTPStatusCode int   `json:"-"` // http response status code
 
-	StatusText string `json:"status"`          // user-level status message
-	AppCode    int64  `json:"code,omitempty"`  // application-specific error code
-	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
+	Type     string `json:"type"`               // uri identifying the problem type
+	Title    string `json:"title"`              // user-level status message
+	Status   int    `json:"status"`             // http response status code
+	Detail   string `json:"detail,omitempty"`   // application-level error message, for debugging
+	Instance string `json:"instance,omitempty"` // the request path the problem occurred on
+	Code     string `json:"code,omitempty"`     // application-specific error code
+
+	Details interface{} `json:"details,omitempty"` // problem specific members, such as the items that were short
 }
 
 func (e *ErrResponse) Render(_ http.ResponseWriter, r *http.Request) error {
@@ -29,43 +47,71 @@ func (e *ErrResponse) Render(_ http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func ErrInvalidRequest(err error) render.Renderer {
+// ErrProblem creates a problem response for the given status with a stable application error code.
+func ErrProblem(status int, code, detail string) *ErrResponse {
 	return &ErrResponse{
-		Err:            err,
-		HTTPStatusCode: 400,
-		StatusText:     "Invalid request.",
-		ErrorText:      err.Error(),
+		HTTPStatusCode: status,
+		Type:           problemTypePrefix + code,
+		Title:          http.StatusText(status),
+		Status:         status,
+		Detail:         detail,
+		Code:           code,
 	}
 }
 
+func ErrInvalidRequest(err error) render.Renderer {
+	e := ErrProblem(http.StatusBadRequest, CodeInvalidRequest, err.Error())
+	e.Err = err
+	return e
+}
+
 func ErrInternalServerError() render.Renderer {
-	return &ErrResponse{
-		Err:            nil,
-		HTTPStatusCode: 500,
-		StatusText:     "Internal server error.",
-		ErrorText:      "An internal server error has occurred.",
-	}
+	return ErrProblem(http.StatusInternalServerError, CodeInternalError, "An internal server error has occurred.")
 }
 
 func ErrRender(err error) render.Renderer {
-	return &ErrResponse{
-		Err:            err,
-		HTTPStatusCode: 422,
-		StatusText:     "Error rendering response.",
-		ErrorText:      err.Error(),
-	}
+	e := ErrProblem(http.StatusUnprocessableEntity, CodeRenderError, err.Error())
+	e.Err = err
+	return e
 }
 
 func Render(w http.ResponseWriter, r *http.Request, rnd render.Renderer) {
+	if e, ok := rnd.(*ErrResponse); ok {
+		renderProblem(w, r, *e)
+		return
+	}
 	if err := render.Render(w, r, rnd); err != nil {
 		log.Warn().Err(err).Msg("failed to render")
 	}
 }
 
+// renderProblem writes the problem itself since render.JSON would overwrite the problem content type. It takes a
+// copy so that shared responses such as ErrNotFound are never modified.
+func renderProblem(w http.ResponseWriter, r *http.Request, e ErrResponse) {
+	if e.Status == 0 {
+		e.Status = e.HTTPStatusCode
+	}
+	if e.Instance == "" {
+		e.Instance = r.URL.Path
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to render")
+		return
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(e.HTTPStatusCode)
+	if _, err = w.Write(body); err != nil {
+		log.Warn().Err(err).Msg("failed to render")
+	}
+}
+
 func RenderList(w http.ResponseWriter, r *http.Request, l []render.Renderer) {
 	if err := render.RenderList(w, r, l); err != nil {
 		log.Warn().Err(err).Msg("failed to render")
 	}
 }
 
-var ErrNotFound = &ErrResponse{HTTPStatusCode: 404, StatusText: "Resource not found."}
+var ErrNotFound = ErrProblem(http.StatusNotFound, CodeNotFound, "Resource not found.")
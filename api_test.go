@@ -7,12 +7,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
-	"github.com/sksmith/bunnyq"
+	"github.com/sksmith/smfg-inventory/api"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/broker"
 	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/health"
 	"github.com/sksmith/smfg-inventory/inventory"
+	"github.com/sksmith/smfg-inventory/settings"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -70,6 +75,25 @@ var testReservations = []inventory.Reservation{
 	},
 }
 
+var testExchanges = inventory.Exchanges{
+	Inventory:   "inventory.fanout",
+	Reservation: "reservation.filled.fanout",
+	Alert:       "stock.alert.fanout",
+	Order:       "production.order.fanout",
+	Location:    "location.inventory.fanout",
+	Return:      "return.fanout",
+	SalesOrder:  "sales.order.fanout",
+	Backorder:   "backorder.fanout",
+}
+
+func testRouter(queue inventory.Queue, repo inventory.Repository, auditRepo audit.Repository) http.Handler {
+	return testRouterWithSettings(queue, repo, auditRepo, settings.NewStore(settings.Settings{AllocationPolicy: settings.AllocateFifo}))
+}
+
+func testRouterWithSettings(queue inventory.Queue, repo inventory.Repository, auditRepo audit.Repository, store *settings.Store) http.Handler {
+	return configureRouter(queue, repo, auditRepo, store, health.NewChecker(nil), testExchanges)
+}
+
 func TestList(t *testing.T) {
 	mockRepo := inventory.NewMockRepo()
 	mockQueue := inventory.NewMockQueue()
@@ -81,7 +105,7 @@ func TestList(t *testing.T) {
 		return products, nil
 	}
 
-	ts := httptest.NewServer(configureRouter(mockQueue, mockRepo, "inventory.fanout", "reservation.filled.fanout"))
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
 	defer ts.Close()
 
 	res, err := http.Get(ts.URL + "/inventory/v1")
@@ -106,7 +130,7 @@ func TestListError(t *testing.T) {
 		return nil, errors.New("some terrible error has occurred in the repo")
 	}
 
-	ts := httptest.NewServer(configureRouter(mockQueue, mockRepo, "inventory.fanout", "reservation.filled.fanout"))
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
 	defer ts.Close()
 
 	res, err := http.Get(ts.URL + "/inventory/v1")
@@ -137,7 +161,7 @@ func TestPagination(t *testing.T) {
 		return nil, nil
 	}
 
-	ts := httptest.NewServer(configureRouter(mockQueue, mockRepo, "inventory.fanout", "reservation.filled.fanout"))
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
 	defer ts.Close()
 
 	_, err := http.Get(ts.URL + fmt.Sprintf("/inventory/v1?limit=%d&offset=%d", wantLimit, wantOffset))
@@ -152,6 +176,10 @@ func TestCreate(t *testing.T) {
 
 	tp := testProducts[0]
 
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return inventory.Product{}, sql.ErrNoRows
+	}
+
 	mockRepo.SaveProductFunc = func(ctx context.Context, product inventory.Product, tx ...db.Transaction) error {
 		if product.Name != tp.Name {
 			t.Errorf("name got=%s want=%s", product.Name, tp.Name)
@@ -171,7 +199,7 @@ func TestCreate(t *testing.T) {
 		return nil
 	}
 
-	ts := httptest.NewServer(configureRouter(mockQueue, mockRepo, "inventory.fanout", "reservation.filled.fanout"))
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
 	defer ts.Close()
 
 	data, err := json.Marshal(tp)
@@ -230,7 +258,7 @@ func TestCreateProductionEvent(t *testing.T) {
 		return nil
 	}
 
-	ts := httptest.NewServer(configureRouter(mockQueue, mockRepo, "inventory.fanout", "reservation.filled.fanout"))
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
 	defer ts.Close()
 
 	data, err := json.Marshal(tpe)
@@ -273,7 +301,7 @@ func TestCreateProductNotFound(t *testing.T) {
 		return inventory.Product{}, sql.ErrNoRows
 	}
 
-	ts := httptest.NewServer(configureRouter(mockQueue, mockRepo, "inventory.fanout", "reservation.filled.fanout"))
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
 	defer ts.Close()
 
 	data, err := json.Marshal(tpe)
@@ -324,6 +352,7 @@ func TestCreateReservation(t *testing.T) {
 		if r.Created == tr.Created {
 			t.Errorf("event created should be set upon creation")
 		}
+		r.ID = tr.ID
 		return nil
 	}
 
@@ -358,13 +387,15 @@ func TestCreateReservation(t *testing.T) {
 			return nil
 		}
 
+	bookLocationStock(&mockRepo, map[string]int64{tp.Sku: tp.Available})
+
 	sentToQueue := false
-	mockQueue.PublishFunc = func(ctx context.Context, exchange string, body []byte, options ...bunnyq.PublishOption) error {
+	mockQueue.PublishFunc = func(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error {
 		sentToQueue = true
 		return nil
 	}
 
-	ts := httptest.NewServer(configureRouter(mockQueue, mockRepo, "inventory.fanout", "reservation.filled.fanout"))
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
 	defer ts.Close()
 
 	data, err := json.Marshal(tr)
@@ -397,3 +428,942 @@ func TestCreateReservation(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCreateRecordsAudit(t *testing.T) {
+	mockRepo := inventory.NewMockRepo()
+	mockQueue := inventory.NewMockQueue()
+	mockAudit := audit.NewMockRepo()
+
+	tp := testProducts[0]
+
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return inventory.Product{}, sql.ErrNoRows
+	}
+
+	var entries []audit.Entry
+	mockAudit.SaveEntryFunc = func(ctx context.Context, entry *audit.Entry, tx ...db.Transaction) error {
+		if len(tx) == 0 {
+			t.Errorf("audit entry should be saved in the same transaction as the change")
+		}
+		entries = append(entries, *entry)
+		return nil
+	}
+
+	// stands in for the authentication middleware in front of the router
+	router := testRouter(mockQueue, mockRepo, mockAudit)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		router.ServeHTTP(w, r.WithContext(audit.WithPrincipal(r.Context(), "jdoe")))
+	}))
+	defer ts.Close()
+
+	data, err := json.Marshal(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/inventory/v1", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Principal", "mallory")
+	if _, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("audit entries got=%d want=%d", len(entries), 1)
+	}
+	e := entries[0]
+	if e.Action != audit.CreateProduct {
+		t.Errorf("action got=%s want=%s", e.Action, audit.CreateProduct)
+	}
+	if e.Principal != "jdoe" {
+		t.Errorf("principal got=%s want=%s", e.Principal, "jdoe")
+	}
+	if e.RequestID == "" {
+		t.Errorf("request id should be captured from the request")
+	}
+	if e.ClientIP == "" {
+		t.Errorf("client ip should be captured from the request")
+	}
+	if e.Before != nil {
+		t.Errorf("before should be empty on creation got=%s", e.Before)
+	}
+	after := inventory.Product{}
+	if err = json.Unmarshal(e.After, &after); err != nil {
+		t.Fatal(err)
+	}
+	if after.Sku != tp.Sku {
+		t.Errorf("after sku got=%s want=%s", after.Sku, tp.Sku)
+	}
+}
+
+func TestAuditUnauthenticated(t *testing.T) {
+	mockRepo := inventory.NewMockRepo()
+	mockAudit := audit.NewMockRepo()
+
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return inventory.Product{}, sql.ErrNoRows
+	}
+	var principals []string
+	mockAudit.SaveEntryFunc = func(ctx context.Context, entry *audit.Entry, tx ...db.Transaction) error {
+		principals = append(principals, entry.Principal)
+		return nil
+	}
+
+	ts := httptest.NewServer(testRouter(inventory.NewMockQueue(), mockRepo, mockAudit))
+	defer ts.Close()
+
+	data, err := json.Marshal(testProducts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/inventory/v1", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a principal the client names itself is never trusted
+	req.Header.Set("X-Principal", "jdoe")
+	if _, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(principals) != 1 || principals[0] != audit.Unauthenticated {
+		t.Errorf("principals got=%v want=[%s]", principals, audit.Unauthenticated)
+	}
+}
+
+func TestCancelReservation(t *testing.T) {
+	mockRepo := inventory.NewMockRepo()
+	mockQueue := inventory.NewMockQueue()
+	mockAudit := audit.NewMockRepo()
+
+	tp := testProducts[0]
+	tp.Available = 10
+	tp.Reserved = 5
+	tr := testReservations[0]
+	tr.ReservedQuantity = 5
+
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return tp, nil
+	}
+	mockRepo.GetReservationFunc = func(ctx context.Context, ID uint64, tx ...db.Transaction) (inventory.Reservation, error) {
+		if ID != tr.ID {
+			t.Errorf("id got=%d want=%d", ID, tr.ID)
+		}
+		return tr, nil
+	}
+	mockRepo.UpdateReservationFunc =
+		func(ctx context.Context, ID uint64, state inventory.ReserveState, qty int64, txs ...db.Transaction) error {
+			if state != inventory.Cancelled {
+				t.Errorf("state got=%s want=%s", state, inventory.Cancelled)
+			}
+			return nil
+		}
+	mockRepo.SaveProductFunc = func(ctx context.Context, product inventory.Product, tx ...db.Transaction) error {
+		if product.Available != 15 {
+			t.Errorf("available got=%d want=%d", product.Available, 15)
+		}
+		if product.Reserved != 0 {
+			t.Errorf("reserved got=%d want=%d", product.Reserved, 0)
+		}
+		return nil
+	}
+	audited := false
+	mockAudit.SaveEntryFunc = func(ctx context.Context, entry *audit.Entry, tx ...db.Transaction) error {
+		audited = entry.Action == audit.CancelReservation
+		return nil
+	}
+
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, mockAudit))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodDelete, ts.URL+fmt.Sprintf("/inventory/v1/%s/reservation/%d", tp.Sku, tr.ID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != 200 {
+		t.Errorf("status code got=%d want=%d", res.StatusCode, 200)
+	}
+	if !audited {
+		t.Errorf("cancellation should be audited")
+	}
+}
+
+func TestCreateDuplicateProduct(t *testing.T) {
+	mockRepo := inventory.NewMockRepo()
+	mockQueue := inventory.NewMockQueue()
+
+	tp := testProducts[0]
+
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return tp, nil
+	}
+	mockRepo.SaveProductFunc = func(ctx context.Context, product inventory.Product, tx ...db.Transaction) error {
+		t.Errorf("an existing product should not be overwritten")
+		return nil
+	}
+
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+
+	data, err := json.Marshal(tp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(ts.URL+"/inventory/v1", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != 409 {
+		t.Errorf("status code got=%d want=%d", res.StatusCode, 409)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != api.ProblemContentType {
+		t.Errorf("content type got=%s want=%s", ct, api.ProblemContentType)
+	}
+
+	problem := &api.ErrResponse{}
+	if err = json.NewDecoder(res.Body).Decode(problem); err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if problem.Code != inventory.CodeProductExists {
+		t.Errorf("code got=%s want=%s", problem.Code, inventory.CodeProductExists)
+	}
+	if problem.Status != 409 {
+		t.Errorf("status got=%d want=%d", problem.Status, 409)
+	}
+}
+
+func TestCreateProductionEventIdempotencyMismatch(t *testing.T) {
+	mockRepo := inventory.NewMockRepo()
+	mockQueue := inventory.NewMockQueue()
+
+	tpe := testProductionEvents[0]
+
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return testProducts[0], nil
+	}
+	mockRepo.GetIdempotencyKeyFunc = func(ctx context.Context, op inventory.Operation, key string, tx ...db.Transaction) (inventory.IdempotencyKey, error) {
+		if key != tpe.RequestID {
+			t.Errorf("key got=%s want=%s", key, tpe.RequestID)
+		}
+		return inventory.IdempotencyKey{Operation: op, Key: key, RequestHash: "hash-of-another-payload"}, nil
+	}
+	mockRepo.SaveProductionEventFunc = func(ctx context.Context, event *inventory.ProductionEvent, tx ...db.Transaction) error {
+		t.Errorf("a mismatched retry should not be saved")
+		return nil
+	}
+
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+
+	data, err := json.Marshal(tpe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(ts.URL+fmt.Sprintf("/inventory/v1/%s/productionEvent", tpe.Sku),
+		"application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != 422 {
+		t.Errorf("status code got=%d want=%d", res.StatusCode, 422)
+	}
+}
+
+func TestCreateProductionEventIdempotencyKeyHeader(t *testing.T) {
+	mockRepo := inventory.NewMockRepo()
+	mockQueue := inventory.NewMockQueue()
+
+	const key = "HeaderRID"
+	tpe := testProductionEvents[0]
+	tpe.RequestID = ""
+
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return testProducts[0], nil
+	}
+	mockRepo.GetProductionEventByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (pe inventory.ProductionEvent, err error) {
+		return pe, sql.ErrNoRows
+	}
+	saved := false
+	mockRepo.SaveIdempotencyKeyFunc = func(ctx context.Context, k *inventory.IdempotencyKey, tx ...db.Transaction) error {
+		saved = true
+		if k.Key != key {
+			t.Errorf("key got=%s want=%s", k.Key, key)
+		}
+		if k.RequestHash == "" {
+			t.Errorf("request hash should be stored with the key")
+		}
+		return nil
+	}
+
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+
+	data, err := json.Marshal(tpe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, ts.URL+fmt.Sprintf("/inventory/v1/%s/productionEvent", tpe.Sku), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(inventory.IdempotencyKeyHeader, key)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != 201 {
+		t.Errorf("status code got=%d want=%d", res.StatusCode, 201)
+	}
+	if !saved {
+		t.Errorf("idempotency key should be saved")
+	}
+
+	resp := &inventory.ProductionEventResponse{}
+	if err = json.NewDecoder(res.Body).Decode(resp); err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if resp.RequestID != key {
+		t.Errorf("requestId got=%s want=%s", resp.RequestID, key)
+	}
+}
+
+func TestGetProductNotModified(t *testing.T) {
+	mockRepo := inventory.NewMockRepo()
+	mockQueue := inventory.NewMockQueue()
+
+	tp := testProducts[0]
+	tp.Version = 3
+
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return tp, nil
+	}
+
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/inventory/v1/" + tp.Sku)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	etag := res.Header.Get("ETag")
+	if etag != `"3"` {
+		t.Errorf("etag got=%s want=%s", etag, `"3"`)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/inventory/v1/"+tp.Sku, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", etag)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != 304 {
+		t.Errorf("status code got=%d want=%d", res.StatusCode, 304)
+	}
+}
+
+func TestUpdateProductPreconditions(t *testing.T) {
+	mockRepo := inventory.NewMockRepo()
+	mockQueue := inventory.NewMockQueue()
+
+	tp := testProducts[0]
+	tp.Version = 3
+
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return tp, nil
+	}
+	saves := 0
+	mockRepo.SaveProductFunc = func(ctx context.Context, product inventory.Product, tx ...db.Transaction) error {
+		saves++
+		if product.Name != "Renamed" {
+			t.Errorf("name got=%s want=%s", product.Name, "Renamed")
+		}
+		if product.Available != tp.Available {
+			t.Errorf("available should not change got=%d want=%d", product.Available, tp.Available)
+		}
+		return nil
+	}
+
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+
+	update := tp
+	update.Name = "Renamed"
+	update.Available = 1000
+	data, err := json.Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    int
+	}{
+		{"missing", "", 428},
+		{"stale", `"2"`, 412},
+		{"current", `"3"`, 200},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodPut, ts.URL+"/inventory/v1/"+tp.Sku, bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.ifMatch != "" {
+			req.Header.Set("If-Match", tt.ifMatch)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != tt.want {
+			t.Errorf("%s: status code got=%d want=%d", tt.name, res.StatusCode, tt.want)
+		}
+		if tt.want == 200 && res.Header.Get("ETag") != `"4"` {
+			t.Errorf("%s: etag got=%s want=%s", tt.name, res.Header.Get("ETag"), `"4"`)
+		}
+	}
+
+	if saves != 1 {
+		t.Errorf("saves got=%d want=%d", saves, 1)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	stopping := false
+	checker := health.NewChecker(func() bool { return stopping })
+
+	var brokerErr error
+	checker.Register("database", func(ctx context.Context) (map[string]interface{}, error) {
+		return map[string]interface{}{"saturation": 0.1}, nil
+	})
+	checker.Register("broker", func(ctx context.Context) (map[string]interface{}, error) {
+		return nil, brokerErr
+	})
+
+	ts := httptest.NewServer(configureRouter(inventory.NewMockQueue(), inventory.NewMockRepo(), audit.NewMockRepo(),
+		settings.NewStore(settings.Settings{}), checker, testExchanges))
+	defer ts.Close()
+
+	ready := func() (int, health.Report) {
+		res, err := http.Get(ts.URL + "/inventory/health/ready")
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := health.Report{}
+		if err = json.NewDecoder(res.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		return res.StatusCode, report
+	}
+
+	res, err := http.Get(ts.URL + "/inventory/health/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Errorf("live status code got=%d want=%d", res.StatusCode, 200)
+	}
+
+	status, report := ready()
+	if status != 200 || report.Status != health.Up {
+		t.Errorf("ready got=%d/%s want=%d/%s", status, report.Status, 200, health.Up)
+	}
+	if len(report.Checks) != 3 {
+		t.Errorf("checks got=%d want=%d", len(report.Checks), 3)
+	}
+
+	brokerErr = errors.New("connection refused")
+	status, report = ready()
+	if status != 503 || report.Status != health.Down {
+		t.Errorf("ready with broker down got=%d/%s want=%d/%s", status, report.Status, 503, health.Down)
+	}
+	for _, c := range report.Checks {
+		if c.Name == "broker" && c.Error == "" {
+			t.Errorf("the failing check should report its error")
+		}
+		if c.Name == "database" && c.Status != health.Up {
+			t.Errorf("database got=%s want=%s", c.Status, health.Up)
+		}
+	}
+
+	brokerErr = nil
+	stopping = true
+	status, _ = ready()
+	if status != 503 {
+		t.Errorf("ready while stopping got=%d want=%d", status, 503)
+	}
+}
+
+type brokerConnection bool
+
+func (c brokerConnection) Connected() bool {
+	return bool(c)
+}
+
+func TestQueueCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		connected bool
+	}{
+		{name: "connected", connected: true},
+		{name: "disconnected", connected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := inventory.QueueCheck(brokerConnection(test.connected))(context.Background())
+			if (err == nil) != test.connected {
+				t.Errorf("error got=%v want connected=%t", err, test.connected)
+			}
+		})
+	}
+}
+
+func TestAllocationPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []uint64
+	}{
+		{policy: settings.AllocateFifo, want: []uint64{1}},
+		{policy: settings.AllocateSmallestFirst, want: []uint64{2, 1}},
+	}
+
+	for _, test := range tests {
+		mockRepo := inventory.NewMockRepo()
+		mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+			return inventory.Product{Sku: "TestOneSKU"}, nil
+		}
+		mockRepo.GetProductionEventByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (pe inventory.ProductionEvent, err error) {
+			return pe, sql.ErrNoRows
+		}
+		mockRepo.GetSkuReservesByStateFunc = func(ctx context.Context, sku string, state inventory.ReserveState, limit, offset int, tx ...db.Transaction) ([]inventory.Reservation, error) {
+			return []inventory.Reservation{
+				{ID: 1, Sku: sku, State: inventory.Open, RequestedQuantity: 30},
+				{ID: 2, Sku: sku, State: inventory.Open, RequestedQuantity: 5},
+			}, nil
+		}
+		var filled []uint64
+		mockRepo.UpdateReservationFunc = func(ctx context.Context, ID uint64, state inventory.ReserveState, qty int64, txs ...db.Transaction) error {
+			filled = append(filled, ID)
+			return nil
+		}
+		bookLocationStock(&mockRepo, map[string]int64{})
+
+		store := settings.NewStore(settings.Settings{AllocationPolicy: test.policy})
+		ts := httptest.NewServer(testRouterWithSettings(inventory.NewMockQueue(), mockRepo, audit.NewMockRepo(), store))
+
+		data, err := json.Marshal(inventory.ProductionEvent{RequestID: "alloc-" + test.policy, Quantity: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(ts.URL+"/inventory/v1/TestOneSKU/productionEvent", "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		ts.Close()
+
+		if res.StatusCode != http.StatusCreated {
+			t.Errorf("%s status got=%d want=%d", test.policy, res.StatusCode, http.StatusCreated)
+		}
+		if fmt.Sprint(filled) != fmt.Sprint(test.want) {
+			t.Errorf("%s fill order got=%v want=%v", test.policy, filled, test.want)
+		}
+	}
+}
+
+// bookLocationStock keeps the stock the mock repository books at the main location, starting with the stock given
+// by sku.
+func bookLocationStock(repo *inventory.MockRepo, held map[string]int64) {
+	repo.GetLocationStockFunc = func(ctx context.Context, sku string, tx ...db.Transaction) ([]inventory.LocationStock, error) {
+		return []inventory.LocationStock{{Sku: sku, Location: inventory.DefaultLocation, Available: held[sku]}}, nil
+	}
+	repo.AddLocationStockFunc = func(ctx context.Context, sku, location string, qty int64, tx ...db.Transaction) error {
+		held[sku] += qty
+		return nil
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	store := settings.NewStore(settings.Settings{AllocationPolicy: settings.AllocateFifo})
+	ts := httptest.NewServer(testRouterWithSettings(inventory.NewMockQueue(), inventory.NewMockRepo(), audit.NewMockRepo(), store))
+	defer ts.Close()
+
+	get := func() *http.Response {
+		res, err := http.Get(ts.URL + "/inventory/v1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		return res
+	}
+
+	if res := get(); res.StatusCode != http.StatusOK {
+		t.Errorf("unlimited status got=%d want=%d", res.StatusCode, http.StatusOK)
+	}
+
+	store.Update(settings.Settings{AllocationPolicy: settings.AllocateFifo, RateLimit: 0.001, RateBurst: 1})
+
+	if res := get(); res.StatusCode != http.StatusOK {
+		t.Errorf("first limited status got=%d want=%d", res.StatusCode, http.StatusOK)
+	}
+	res := get()
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("second limited status got=%d want=%d", res.StatusCode, http.StatusTooManyRequests)
+	}
+	if res.Header.Get("Retry-After") == "" {
+		t.Errorf("expected a Retry-After header")
+	}
+	if res.Header.Get("Content-Type") != api.ProblemContentType {
+		t.Errorf("content type got=%s want=%s", res.Header.Get("Content-Type"), api.ProblemContentType)
+	}
+
+	live, err := http.Get(ts.URL + "/inventory/health/live")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = live.Body.Close()
+	if live.StatusCode != http.StatusOK {
+		t.Errorf("health status got=%d want=%d", live.StatusCode, http.StatusOK)
+	}
+}
+
+func TestBusinessMetrics(t *testing.T) {
+	inventory.LimitSkuMetrics(0)
+	defer inventory.LimitSkuMetrics(inventory.DefaultSkuMetricLimit)
+
+	mockRepo := inventory.NewMockRepo()
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return inventory.Product{Sku: sku, Available: 5}, nil
+	}
+	mockRepo.GetProductionEventByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (pe inventory.ProductionEvent, err error) {
+		return pe, sql.ErrNoRows
+	}
+
+	ts := httptest.NewServer(testRouter(inventory.NewMockQueue(), mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+
+	data, err := json.Marshal(inventory.ProductionEvent{RequestID: "metrics-guard", Quantity: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(ts.URL+"/inventory/v1/GuardSKU/productionEvent", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("status got=%d want=%d", res.StatusCode, http.StatusCreated)
+	}
+
+	res, err = http.Get(ts.URL + "/inventory/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := string(body)
+
+	for _, want := range []string{
+		`smfg_inventory_units_produced{sku="other"}`,
+		`smfg_inventory_url_latency_bucket{method="POST",url="/inventory/v1/*",le="10"}`,
+		`smfg_inventory_reservation_fill_seconds_bucket`,
+		`smfg_inventory_open_reservations`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("expected metric %s", want)
+		}
+	}
+	if strings.Contains(metrics, `sku="GuardSKU"`) {
+		t.Errorf("sku beyond the limit should not get its own series")
+	}
+}
+
+func TestStockAlerts(t *testing.T) {
+	product := inventory.Product{Sku: "AlertSKU", Upc: "4444444444", Name: "Alert", Available: 25,
+		ReorderPoint: 20, SafetyStock: 10, ReorderQuantity: 50}
+	var current *inventory.StockAlert
+
+	mockRepo := inventory.NewMockRepo()
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return product, nil
+	}
+	mockRepo.SaveProductFunc = func(ctx context.Context, p inventory.Product, tx ...db.Transaction) error {
+		product = p
+		product.Version++
+		return nil
+	}
+	bookLocationStock(&mockRepo, map[string]int64{product.Sku: product.Available})
+	mockRepo.GetStockAlertFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.StockAlert, error) {
+		if current == nil {
+			return inventory.StockAlert{}, sql.ErrNoRows
+		}
+		return *current, nil
+	}
+	mockRepo.SaveStockAlertFunc = func(ctx context.Context, alert inventory.StockAlert, tx ...db.Transaction) error {
+		current = &alert
+		return nil
+	}
+	mockRepo.DeleteStockAlertFunc = func(ctx context.Context, sku string, tx ...db.Transaction) error {
+		current = nil
+		return nil
+	}
+	mockRepo.GetStockAlertsFunc = func(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]inventory.StockAlert, error) {
+		if current == nil {
+			return []inventory.StockAlert{}, nil
+		}
+		return []inventory.StockAlert{*current}, nil
+	}
+
+	var published []inventory.StockAlert
+	mockQueue := inventory.NewMockQueue()
+	mockQueue.PublishFunc = func(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error {
+		if exchange != testExchanges.Alert {
+			return nil
+		}
+		alert := inventory.StockAlert{}
+		if err := json.Unmarshal(body, &alert); err != nil {
+			t.Fatal(err)
+		}
+		published = append(published, alert)
+		return nil
+	}
+
+	store := settings.NewStore(settings.Settings{AllocationPolicy: settings.AllocateFifo, AlertHysteresis: 10})
+	ts := httptest.NewServer(testRouterWithSettings(mockQueue, mockRepo, audit.NewMockRepo(), store))
+	defer ts.Close()
+
+	steps := []struct {
+		quantity int64
+		want     inventory.AlertLevel
+	}{
+		{quantity: -6, want: inventory.AlertReorder}, // 19, at the reorder point
+		{quantity: 2, want: inventory.AlertReorder},  // 21, within the hysteresis margin of 2
+		{quantity: -12, want: inventory.AlertSafety}, // 9, below safety stock
+		{quantity: 12, want: inventory.AlertReorder}, // 21, clear of safety stock but not the reorder point
+		{quantity: 2, want: inventory.AlertNone},     // 23, clear of the reorder point
+	}
+	for i, step := range steps {
+		data, err := json.Marshal(inventory.Adjustment{RequestID: fmt.Sprintf("alert-%d", i), Quantity: step.quantity, Reason: "count"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(ts.URL+"/inventory/v1/AlertSKU/adjustment", "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusCreated {
+			t.Fatalf("step %d status got=%d want=%d", i, res.StatusCode, http.StatusCreated)
+		}
+
+		level := inventory.AlertNone
+		if current != nil {
+			level = current.Level
+		}
+		if level != step.want {
+			t.Errorf("step %d level got=%s want=%s", i, level, step.want)
+		}
+		if i == 1 {
+			res, err := http.Get(ts.URL + "/inventory/v1/alerts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var alerts []inventory.StockAlert
+			if err = json.NewDecoder(res.Body).Decode(&alerts); err != nil {
+				t.Fatal(err)
+			}
+			_ = res.Body.Close()
+			if len(alerts) != 1 || alerts[0].Sku != "AlertSKU" || alerts[0].Available != 19 {
+				t.Errorf("alerts got=%+v", alerts)
+			}
+		}
+	}
+
+	var levels []string
+	for _, alert := range published {
+		levels = append(levels, string(alert.Level))
+	}
+	if got := strings.Join(levels, ","); got != "reorder,safety,reorder,none" {
+		t.Errorf("published levels got=%s want=%s", got, "reorder,safety,reorder,none")
+	}
+}
+
+func TestCreateProductThresholds(t *testing.T) {
+	mockRepo := inventory.NewMockRepo()
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return inventory.Product{}, sql.ErrNoRows
+	}
+	ts := httptest.NewServer(testRouter(inventory.NewMockQueue(), mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+
+	data, err := json.Marshal(inventory.Product{Sku: "NewSKU", Upc: "5555555555", Name: "New", ReorderPoint: 5, SafetyStock: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(ts.URL+"/inventory/v1", "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("status got=%d want=%d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestCreateReservedSku(t *testing.T) {
+	mockRepo := inventory.NewMockRepo()
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return inventory.Product{}, sql.ErrNoRows
+	}
+	ts := httptest.NewServer(testRouter(inventory.NewMockQueue(), mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+
+	tests := []struct {
+		sku    string
+		kit    bool
+		status int
+	}{
+		{"alerts", false, http.StatusBadRequest},
+		{"alerts", true, http.StatusBadRequest},
+		{"replenishment", false, http.StatusBadRequest},
+		{"serial", false, http.StatusBadRequest},
+		{"location", false, http.StatusBadRequest},
+		{"cycleCount", false, http.StatusBadRequest},
+		{"salesOrder", false, http.StatusBadRequest},
+		{"backorders", false, http.StatusBadRequest},
+		{"Alerts", false, http.StatusOK},
+	}
+	for _, test := range tests {
+		data, err := json.Marshal(inventory.Product{Sku: test.sku, Upc: "1212121212", Name: test.sku, Kit: test.kit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(ts.URL+"/inventory/v1", "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != test.status {
+			t.Errorf("%s status got=%d want=%d", test.sku, res.StatusCode, test.status)
+		}
+	}
+}
+
+// recordingTx is a transaction that remembers whether it was committed or rolled back.
+type recordingTx struct {
+	inventory.MockTransaction
+	committed, rolledBack bool
+}
+
+func (tx *recordingTx) Commit(_ context.Context) error {
+	tx.committed = true
+	return nil
+}
+
+func (tx *recordingTx) Rollback(_ context.Context) error {
+	tx.rolledBack = true
+	return nil
+}
+
+func TestReserveInOneTransaction(t *testing.T) {
+	modified := &inventory.Error{Kind: inventory.KindConflict, Code: inventory.CodeConcurrentModification}
+
+	tests := []struct {
+		name    string
+		saveErr error
+		status  int
+		entries int
+	}{
+		{"committed together", nil, http.StatusCreated, 1},
+		{"product modified", modified, http.StatusConflict, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var txs []*recordingTx
+			mockRepo := inventory.NewMockRepo()
+			mockRepo.BeginTransactionFunc = func(ctx context.Context) (db.Transaction, error) {
+				tx := &recordingTx{}
+				txs = append(txs, tx)
+				return tx, nil
+			}
+			mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+				return testProducts[0], nil
+			}
+			var saved []inventory.Reservation
+			mockRepo.SaveReservationFunc = func(ctx context.Context, r *inventory.Reservation, tx ...db.Transaction) error {
+				r.ID = 1
+				saved = append(saved, *r)
+				return nil
+			}
+			mockRepo.GetSkuReservesByStateFunc = func(ctx context.Context, sku string, state inventory.ReserveState,
+				limit, offset int, tx ...db.Transaction) ([]inventory.Reservation, error) {
+				if offset > 0 {
+					return nil, nil
+				}
+				return saved, nil
+			}
+			mockRepo.UpdateReservationFunc = func(ctx context.Context, ID uint64, state inventory.ReserveState, qty int64,
+				txs ...db.Transaction) error {
+				return nil
+			}
+			mockRepo.SaveProductFunc = func(ctx context.Context, product inventory.Product, tx ...db.Transaction) error {
+				return test.saveErr
+			}
+			bookLocationStock(&mockRepo, map[string]int64{testProducts[0].Sku: testProducts[0].Available})
+
+			entries := 0
+			mockAudit := audit.NewMockRepo()
+			mockAudit.SaveEntryFunc = func(ctx context.Context, entry *audit.Entry, tx ...db.Transaction) error {
+				entries++
+				return nil
+			}
+
+			ts := httptest.NewServer(testRouter(inventory.NewMockQueue(), mockRepo, mockAudit))
+			defer ts.Close()
+
+			data, err := json.Marshal(inventory.Reservation{RequestID: "OneTxRID", Requester: "OneTx", RequestedQuantity: 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.Post(ts.URL+"/inventory/v1/"+testProducts[0].Sku+"/reservation", "application/json",
+				bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = res.Body.Close()
+
+			if res.StatusCode != test.status {
+				t.Errorf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if len(txs) != 1 || txs[0].committed == (test.saveErr != nil) || txs[0].rolledBack == (test.saveErr == nil) {
+				t.Errorf("transactions got=%d committed=%v want 1 committed=%v", len(txs),
+					len(txs) > 0 && txs[0].committed, test.saveErr == nil)
+			}
+			if entries != test.entries {
+				t.Errorf("audit entries got=%d want=%d", entries, test.entries)
+			}
+		})
+	}
+}
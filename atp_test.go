@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/inventory"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+func TestCalculateATP(t *testing.T) {
+	now := time.Date(2021, 3, 11, 12, 0, 0, 0, time.UTC)
+	product := inventory.Product{Sku: "AtpSKU", Available: 10}
+	open := []inventory.Reservation{
+		{ID: 1, Sku: "AtpSKU", State: inventory.Open, RequestedQuantity: 30},
+		{ID: 2, Sku: "AtpSKU", State: inventory.Open, RequestedQuantity: 5},
+	}
+	orders := []inventory.ProductionOrder{
+		{ID: 1, Status: inventory.OrderPlanned, TargetQuantity: 20, Due: time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, Status: inventory.OrderPartiallyCompleted, TargetQuantity: 30, Produced: 10,
+			Due: time.Date(2021, 3, 12, 0, 0, 0, 0, time.UTC)},
+		// overdue, expected now
+		{ID: 3, Status: inventory.OrderInProgress, TargetQuantity: 5, Due: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)},
+		// closed short and cancelled orders won't produce anything more
+		{ID: 4, Status: inventory.OrderCompleted, TargetQuantity: 10, Produced: 4,
+			Due: time.Date(2021, 3, 13, 0, 0, 0, 0, time.UTC)},
+		{ID: 5, Status: inventory.OrderCancelled, TargetQuantity: 10, Due: time.Date(2021, 3, 13, 0, 0, 0, 0, time.UTC)},
+	}
+
+	tests := []struct {
+		name     string
+		qty      int64
+		policy   string
+		ahead    int64
+		date     time.Time
+		schedule []int64
+	}{
+		{
+			name:     "fifo waits for every open reservation",
+			qty:      10,
+			policy:   settings.AllocateFifo,
+			ahead:    35,
+			date:     time.Date(2021, 3, 15, 0, 0, 0, 0, time.UTC),
+			schedule: []int64{-25, -20, 0, 20},
+		},
+		{
+			name:     "smallest first jumps larger reservations",
+			qty:      10,
+			policy:   settings.AllocateSmallestFirst,
+			ahead:    5,
+			date:     now,
+			schedule: []int64{5, 10, 30, 50},
+		},
+		{
+			name:     "more than the known supply",
+			qty:      100,
+			policy:   settings.AllocateFifo,
+			ahead:    35,
+			schedule: []int64{-25, -20, 0, 20},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			atp := inventory.CalculateATP(product, open, orders, test.qty, now, test.policy)
+
+			if atp.Ahead != test.ahead {
+				t.Errorf("ahead got=%d want=%d", atp.Ahead, test.ahead)
+			}
+			if len(atp.Schedule) != len(test.schedule) {
+				t.Fatalf("schedule length got=%d want=%d", len(atp.Schedule), len(test.schedule))
+			}
+			for i, want := range test.schedule {
+				if atp.Schedule[i].Cumulative != want {
+					t.Errorf("schedule[%d] cumulative got=%d want=%d", i, atp.Schedule[i].Cumulative, want)
+				}
+			}
+			if test.date.IsZero() {
+				if atp.Promisable || atp.Date != nil {
+					t.Errorf("promisable got=%v want=%v", atp.Promisable, false)
+				}
+				return
+			}
+			if !atp.Promisable || atp.Date == nil {
+				t.Fatalf("promisable got=%v want=%v", atp.Promisable, true)
+			}
+			if !atp.Date.Equal(test.date) {
+				t.Errorf("date got=%s want=%s", atp.Date, test.date)
+			}
+		})
+	}
+}
+
+func TestGetATP(t *testing.T) {
+	due := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+
+	mockRepo := inventory.NewMockRepo()
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return testProducts[0], nil
+	}
+	mockRepo.GetSkuReservesByStateFunc = func(ctx context.Context, sku string, state inventory.ReserveState, limit, offset int, tx ...db.Transaction) ([]inventory.Reservation, error) {
+		return testReservations, nil
+	}
+	mockRepo.GetSkuProductionOrdersFunc = func(ctx context.Context, sku string, tx ...db.Transaction) ([]inventory.ProductionOrder, error) {
+		return []inventory.ProductionOrder{{ID: 1, Sku: sku, Status: inventory.OrderReleased, TargetQuantity: 40, Due: due}}, nil
+	}
+
+	ts := httptest.NewServer(testRouter(inventory.NewMockQueue(), mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/inventory/v1/TestOneSKU/atp?qty=25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status got=%d want=%d", res.StatusCode, http.StatusOK)
+	}
+
+	atp := inventory.ATP{}
+	if err = json.NewDecoder(res.Body).Decode(&atp); err != nil {
+		t.Fatal(err)
+	}
+	// 50 available less the 30 reserved ahead leaves 20 now, the ordered 40 covers the rest
+	if atp.Ahead != 30 {
+		t.Errorf("ahead got=%d want=%d", atp.Ahead, 30)
+	}
+	if !atp.Promisable || atp.Date == nil || !atp.Date.Equal(due) {
+		t.Errorf("date got=%v want=%s", atp.Date, due)
+	}
+
+	res, err = http.Get(ts.URL + "/inventory/v1/TestOneSKU/atp?qty=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("status got=%d want=%d", res.StatusCode, http.StatusBadRequest)
+	}
+}
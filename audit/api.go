@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/api"
+)
+
+const DefaultPageLimit = 100
+
+type Api struct {
+	repo Repository
+}
+
+func NewApi(repo Repository) *Api {
+	return &Api{repo: repo}
+}
+
+func (a *Api) ConfigureRouter(r chi.Router) {
+	r.With(api.Paginate).Get("/", a.List)
+}
+
+type EntryResponse struct {
+	Entry
+}
+
+func (e *EntryResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func NewEntryListResponse(entries []Entry) []render.Renderer {
+	list := make([]render.Renderer, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, &EntryResponse{Entry: entry})
+	}
+	return list
+}
+
+// List searches the audit trail. Supported query parameters are sku, principal, requestId, action, and from and to as
+// RFC 3339 timestamps.
+func (a *Api) List(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := api.GetLimitAndOffset(r, DefaultPageLimit)
+	if err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	filter, err := getFilter(r)
+	if err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	entries, err := a.repo.FindEntries(r.Context(), filter, limit, offset)
+	if err != nil {
+		log.Err(err).Send()
+		api.Render(w, r, api.ErrInternalServerError())
+		return
+	}
+
+	api.RenderList(w, r, NewEntryListResponse(entries))
+}
+
+func getFilter(r *http.Request) (Filter, error) {
+	q := r.URL.Query()
+	f := Filter{
+		Sku:       q.Get("sku"),
+		Principal: q.Get("principal"),
+		RequestID: q.Get("requestId"),
+		Action:    Action(q.Get("action")),
+	}
+
+	var err error
+	if from := q.Get("from"); from != "" {
+		if f.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return f, errors.WithMessage(err, "from must be an RFC 3339 timestamp")
+		}
+	}
+	if to := q.Get("to"); to != "" {
+		if f.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return f, errors.WithMessage(err, "to must be an RFC 3339 timestamp")
+		}
+	}
+
+	return f, nil
+}
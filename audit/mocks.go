@@ -0,0 +1,29 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+type MockRepo struct {
+	SaveEntryFunc   func(ctx context.Context, entry *Entry, tx ...db.Transaction) error
+	FindEntriesFunc func(ctx context.Context, filter Filter, limit, offset int, tx ...db.Transaction) ([]Entry, error)
+}
+
+func (r MockRepo) SaveEntry(ctx context.Context, entry *Entry, tx ...db.Transaction) error {
+	return r.SaveEntryFunc(ctx, entry, tx...)
+}
+
+func (r MockRepo) FindEntries(ctx context.Context, filter Filter, limit, offset int, tx ...db.Transaction) ([]Entry, error) {
+	return r.FindEntriesFunc(ctx, filter, limit, offset, tx...)
+}
+
+func NewMockRepo() MockRepo {
+	return MockRepo{
+		SaveEntryFunc: func(ctx context.Context, entry *Entry, tx ...db.Transaction) error { return nil },
+		FindEntriesFunc: func(ctx context.Context, filter Filter, limit, offset int, tx ...db.Transaction) ([]Entry, error) {
+			return nil, nil
+		},
+	}
+}
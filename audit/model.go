@@ -0,0 +1,156 @@
+// Package audit keeps an append-only trail of every change made to inventory so that we can answer who changed what,
+// when, from where and what it looked like before and after.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/pkg/errors"
+)
+
+type Action string
+
+const (
+	CreateProduct     Action = "CreateProduct"
+	UpdateProduct     Action = "UpdateProduct"
+	Produce           Action = "Produce"
+	Reserve           Action = "Reserve"
+	CancelReservation Action = "CancelReservation"
+	ChangeReservation Action = "ChangeReservation"
+	Adjust            Action = "Adjust"
+
+	CreateProductionOrder Action = "CreateProductionOrder"
+	ChangeProductionOrder Action = "ChangeProductionOrder"
+	UpdateBom             Action = "UpdateBom"
+	Backflush             Action = "Backflush"
+	UpdateUnits           Action = "UpdateUnits"
+	ChangeStatus          Action = "ChangeStatus"
+	CreateLocation        Action = "CreateLocation"
+	ShipTransfer          Action = "ShipTransfer"
+	ReceiveTransfer       Action = "ReceiveTransfer"
+	CreateCycleCount      Action = "CreateCycleCount"
+	RecordCount           Action = "RecordCount"
+	ApproveCycleCount     Action = "ApproveCycleCount"
+	CreateReturn          Action = "CreateReturn"
+	ReceiveReturn         Action = "ReceiveReturn"
+	CreateSalesOrder      Action = "CreateSalesOrder"
+	AllocateSalesOrder    Action = "AllocateSalesOrder"
+)
+
+// Entry is an entity. An immutable record of a single change made to inventory.
+type Entry struct {
+	ID        uint64          `json:"id"`
+	Action    Action          `json:"action"`
+	Principal string          `json:"principal"`
+	RequestID string          `json:"requestId"`
+	ClientIP  string          `json:"clientIp"`
+	Sku       string          `json:"sku"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	Detail    json.RawMessage `json:"detail,omitempty"`
+	Created   time.Time       `json:"created"`
+}
+
+// Filter narrows down a search of the audit trail. Zero values are ignored.
+type Filter struct {
+	Sku       string
+	Principal string
+	RequestID string
+	Action    Action
+	From      time.Time
+	To        time.Time
+}
+
+// Meta is the information about the caller that is attached to every entry.
+type Meta struct {
+	Principal string
+	RequestID string
+	ClientIP  string
+}
+
+const (
+	metaKey         = "audit.meta"
+	principalKey    = "acl.principal"
+	Unauthenticated = "unauthenticated"
+)
+
+// WithPrincipal returns a context carrying the principal the caller was authenticated as. It is meant for the
+// authentication middleware, whatever it is, and never for anything the client sent.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey, principal)
+}
+
+// Middleware captures the caller's principal, the request id and the client ip so that the service layer can record
+// them without knowing anything about http. The principal is only ever taken from the authenticated request context,
+// requests without one are recorded as unauthenticated.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := r.Context().Value(principalKey).(string)
+		if !ok || principal == "" {
+			principal = Unauthenticated
+		}
+
+		ip := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			ip = host
+		}
+
+		meta := Meta{
+			Principal: principal,
+			RequestID: middleware.GetReqID(r.Context()),
+			ClientIP:  ip,
+		}
+
+		ctx := context.WithValue(r.Context(), metaKey, meta)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// FromContext returns the caller information stored by Middleware. Calls that did not come in through http are
+// attributed to the unauthenticated principal.
+func FromContext(ctx context.Context) Meta {
+	meta, ok := ctx.Value(metaKey).(Meta)
+	if !ok {
+		return Meta{Principal: Unauthenticated}
+	}
+	return meta
+}
+
+// NewEntry builds an entry for the given action using the caller information in the context. The before, after and
+// detail values are serialized as they are at the time of the call.
+func NewEntry(ctx context.Context, action Action, sku string, before, after, detail interface{}) (Entry, error) {
+	meta := FromContext(ctx)
+	entry := Entry{
+		Action:    action,
+		Principal: meta.Principal,
+		RequestID: meta.RequestID,
+		ClientIP:  meta.ClientIP,
+		Sku:       sku,
+		Created:   time.Now(),
+	}
+
+	var err error
+	if entry.Before, err = marshal(before); err != nil {
+		return entry, errors.WithMessage(err, "failed to serialize before state")
+	}
+	if entry.After, err = marshal(after); err != nil {
+		return entry, errors.WithMessage(err, "failed to serialize after state")
+	}
+	if entry.Detail, err = marshal(detail); err != nil {
+		return entry, errors.WithMessage(err, "failed to serialize detail")
+	}
+
+	return entry, nil
+}
+
+func marshal(v interface{}) (json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+type Repository interface {
+	SaveEntry(ctx context.Context, entry *Entry, tx ...db.Transaction) error
+	FindEntries(ctx context.Context, filter Filter, limit, offset int, tx ...db.Transaction) ([]Entry, error)
+}
+
+type dbRepo struct {
+	conn db.Conn
+}
+
+func NewPostgresRepo(conn db.Conn) Repository {
+	return &dbRepo{
+		conn: conn,
+	}
+}
+
+func (d *dbRepo) SaveEntry(ctx context.Context, e *Entry, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveAuditEntry")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+	insert := `INSERT INTO audit_log (action, principal, request_id, client_ip, sku, before, after, detail, created)
+                    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id;`
+
+	err := tx.QueryRow(ctx, insert, e.Action, e.Principal, e.RequestID, e.ClientIP, e.Sku,
+		nullJson(e.Before), nullJson(e.After), nullJson(e.Detail), e.Created).Scan(&e.ID)
+	m.Complete(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *dbRepo) FindEntries(ctx context.Context, f Filter, limit, offset int, txs ...db.Transaction) ([]Entry, error) {
+	m := db.StartMetric("FindAuditEntries")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	var where []string
+	var args []interface{}
+	add := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(clause, len(args)))
+	}
+	if f.Sku != "" {
+		add("sku = $%d", f.Sku)
+	}
+	if f.Principal != "" {
+		add("principal = $%d", f.Principal)
+	}
+	if f.RequestID != "" {
+		add("request_id = $%d", f.RequestID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if !f.From.IsZero() {
+		add("created >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created < $%d", f.To)
+	}
+
+	query := `SELECT id, action, principal, request_id, client_ip, sku, before, after, detail, created FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, limit, offset)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d;", len(args)-1, len(args))
+
+	entries := make([]Entry, 0)
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := Entry{}
+		var before, after, detail []byte
+		err = rows.Scan(&e.ID, &e.Action, &e.Principal, &e.RequestID, &e.ClientIP, &e.Sku, &before, &after, &detail, &e.Created)
+		if err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		e.Before, e.After, e.Detail = before, after, detail
+		entries = append(entries, e)
+	}
+
+	m.Complete(nil)
+	return entries, nil
+}
+
+// nullJson keeps absent states as NULL rather than an empty jsonb document.
+func nullJson(raw []byte) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/inventory"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+func TestFillSchedule(t *testing.T) {
+	now := time.Date(2021, 3, 11, 12, 0, 0, 0, time.UTC)
+	product := inventory.Product{Sku: "EtaSKU"}
+	open := []inventory.Reservation{
+		{ID: 1, Sku: "EtaSKU", State: inventory.Open, RequestedQuantity: 4},
+		{ID: 2, Sku: "EtaSKU", State: inventory.Open, RequestedQuantity: 3},
+		{ID: 3, Sku: "EtaSKU", State: inventory.Open, RequestedQuantity: 20},
+	}
+	first := time.Date(2021, 3, 13, 0, 0, 0, 0, time.UTC)
+	second := time.Date(2021, 3, 16, 0, 0, 0, 0, time.UTC)
+	orders := []inventory.ProductionOrder{
+		{ID: 1, Status: inventory.OrderReleased, TargetQuantity: 10, Due: second},
+		{ID: 2, Status: inventory.OrderPlanned, TargetQuantity: 4, Due: first},
+	}
+
+	tests := []struct {
+		name   string
+		res    int
+		policy string
+		want   *time.Time
+	}{
+		{"first in line", 0, settings.AllocateFifo, &first},
+		{"behind the first", 1, settings.AllocateFifo, &second},
+		{"smallest first jumps the queue", 1, settings.AllocateSmallestFirst, &first},
+		{"more than scheduled", 2, settings.AllocateFifo, nil},
+	}
+	for _, test := range tests {
+		got := inventory.FillSchedule(product, open, orders, now, test.policy)[open[test.res].ID]
+		if (got == nil) != (test.want == nil) || (got != nil && !got.Equal(*test.want)) {
+			t.Errorf("%s got=%v want=%v", test.name, got, test.want)
+		}
+	}
+}
+
+// newBackorderServer has two units of stock and ten more due in two days.
+func newBackorderServer(due time.Time) (*memRepo, *memQueue, *httptest.Server) {
+	m := newMemRepo(inventory.Product{Sku: "BackSKU", Upc: "9999999999", Name: "Backordered", Available: 2})
+	m.productionOrders[1] = inventory.ProductionOrder{ID: 1, Sku: "BackSKU", Status: inventory.OrderReleased,
+		TargetQuantity: 10, Due: due}
+	q := newMemQueue()
+	return m, q, httptest.NewServer(testRouter(q.queue, m.repo, audit.NewMockRepo()))
+}
+
+// backorderAll reserves five and one for Acme and twenty for Beta, all but two of which wait on production.
+func backorderAll(t *testing.T, ts *httptest.Server) {
+	t.Helper()
+	for i, r := range []inventory.Reservation{
+		{Requester: "Acme", RequestedQuantity: 5},
+		{Requester: "Acme", RequestedQuantity: 1},
+		{Requester: "Beta", RequestedQuantity: 20},
+	} {
+		r.RequestID = "BackRID" + strconv.Itoa(i)
+		if res := call(t, ts, http.MethodPost, "/inventory/v1/BackSKU/reservation", r, nil); res.StatusCode != http.StatusCreated {
+			t.Fatalf("reserve %d status got=%d want=%d", r.RequestedQuantity, res.StatusCode, http.StatusCreated)
+		}
+	}
+}
+
+func TestReserveBackordered(t *testing.T) {
+	due := time.Now().Add(48 * time.Hour).UTC()
+
+	tests := []struct {
+		name        string
+		before      []int64
+		quantity    int64
+		backordered bool
+		reserved    int64
+		eta         *time.Time
+	}{
+		{name: "filled from stock", quantity: 2, reserved: 2},
+		{name: "partly filled", quantity: 5, backordered: true, reserved: 2, eta: &due},
+		{name: "behind the first", before: []int64{5}, quantity: 1, backordered: true, eta: &due},
+		{name: "more than scheduled", before: []int64{5, 1}, quantity: 20, backordered: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, q, ts := newBackorderServer(due)
+			defer ts.Close()
+
+			for i, quantity := range append(test.before, test.quantity) {
+				r := inventory.Reservation{RequestID: "BackRID" + strconv.Itoa(i), Requester: "Acme",
+					RequestedQuantity: quantity}
+				got := inventory.Reservation{}
+				if res := call(t, ts, http.MethodPost, "/inventory/v1/BackSKU/reservation", r, &got); res.StatusCode != http.StatusCreated {
+					t.Fatalf("reserve %d status got=%d want=%d", quantity, res.StatusCode, http.StatusCreated)
+				}
+				if i < len(test.before) {
+					continue
+				}
+				if got.Backordered != test.backordered || got.ReservedQuantity != test.reserved {
+					t.Errorf("backordered got=%v/%d want=%v/%d", got.Backordered, got.ReservedQuantity,
+						test.backordered, test.reserved)
+				}
+				if (got.EstimatedFill == nil) != (test.eta == nil) || (got.EstimatedFill != nil && !got.EstimatedFill.Equal(due)) {
+					t.Errorf("estimated fill got=%v want=%v", got.EstimatedFill, test.eta)
+				}
+			}
+
+			var events []inventory.BackorderEvent
+			q.published(t, testExchanges.Backorder, &events)
+			want := len(test.before)
+			if test.backordered {
+				want++
+			}
+			if len(events) != want {
+				t.Fatalf("backorder events got=%d want=%d", len(events), want)
+			}
+			if test.backordered {
+				last := events[len(events)-1]
+				if last.Event != inventory.BackorderCreated || last.Reservation.ReservedQuantity != test.reserved {
+					t.Errorf("backorder event got=%+v want created with %d reserved", last, test.reserved)
+				}
+			}
+		})
+	}
+}
+
+func TestReserveBehindWaiting(t *testing.T) {
+	due := time.Now().Add(48 * time.Hour).UTC()
+	m, _, ts := newBackorderServer(due)
+	defer ts.Close()
+	m.addReservations(inventory.Reservation{ID: 1, RequestID: "WaitRID", Requester: "Acme", Sku: "BackSKU",
+		State: inventory.Open, RequestedQuantity: 3, Backordered: true})
+
+	r := inventory.Reservation{RequestID: "BackRID", Requester: "Beta", RequestedQuantity: 2}
+	got := inventory.Reservation{}
+	if res := call(t, ts, http.MethodPost, "/inventory/v1/BackSKU/reservation", r, &got); res.StatusCode != http.StatusCreated {
+		t.Fatalf("reserve status got=%d want=%d", res.StatusCode, http.StatusCreated)
+	}
+	if got.ReservedQuantity != 0 || !got.Backordered {
+		t.Errorf("new reservation got=%d backordered=%v want=0 backordered", got.ReservedQuantity, got.Backordered)
+	}
+	if waiting := m.reservations[1]; waiting.ReservedQuantity != 2 {
+		t.Errorf("waiting reservation got=%d want=%d", waiting.ReservedQuantity, 2)
+	}
+	if p := m.products["BackSKU"]; p.Available != 0 || p.Reserved != 2 {
+		t.Errorf("product got=%d/%d want=%d/%d", p.Available, p.Reserved, 0, 2)
+	}
+}
+
+func TestBackorderReport(t *testing.T) {
+	due := time.Now().Add(48 * time.Hour).UTC()
+	m, _, ts := newBackorderServer(due)
+	defer ts.Close()
+	backorderAll(t, ts)
+	// Gamma's backorder has been filled and isn't reported
+	m.addReservations(inventory.Reservation{ID: 4, RequestID: "BackRID4", Requester: "Gamma", Sku: "BackSKU",
+		State: inventory.Closed, RequestedQuantity: 3, ReservedQuantity: 3, Backordered: true})
+
+	report := make([]inventory.Backorder, 0)
+	if res := call(t, ts, http.MethodGet, "/inventory/v1/backorders", nil, &report); res.StatusCode != http.StatusOK {
+		t.Fatalf("status got=%d want=%d", res.StatusCode, http.StatusOK)
+	}
+
+	tests := []struct {
+		name         string
+		requester    string
+		reservations int
+		quantity     int64
+		eta          *time.Time
+	}{
+		{"reservations grouped by requester", "Acme", 2, 4, &due},
+		{"more than scheduled", "Beta", 1, 20, nil},
+	}
+	if len(report) != len(tests) {
+		t.Fatalf("report got=%d groups want=%d", len(report), len(tests))
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := report[i]
+			if b.Requester != test.requester || len(b.Reservations) != test.reservations || b.Quantity != test.quantity {
+				t.Errorf("backorder got=%+v want %s with %d reservations of %d", b, test.requester,
+					test.reservations, test.quantity)
+			}
+			if (b.EstimatedFill == nil) != (test.eta == nil) || (b.EstimatedFill != nil && !b.EstimatedFill.Equal(due)) {
+				t.Errorf("estimated fill got=%v want=%v", b.EstimatedFill, test.eta)
+			}
+		})
+	}
+}
+
+func TestFillBackorders(t *testing.T) {
+	tests := []struct {
+		name     string
+		produced int64
+		filled   []uint64
+	}{
+		{"not enough for the first", 2, []uint64{}},
+		{"the first", 3, []uint64{1}},
+		{"everything acme is waiting on", 4, []uint64{1, 2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, q, ts := newBackorderServer(time.Now().Add(48 * time.Hour).UTC())
+			defer ts.Close()
+			backorderAll(t, ts)
+
+			event := inventory.ProductionEvent{RequestID: "BackProduce", Quantity: test.produced}
+			res := call(t, ts, http.MethodPost, "/inventory/v1/BackSKU/productionEvent", event, nil)
+			if res.StatusCode != http.StatusCreated {
+				t.Fatalf("produce status got=%d want=%d", res.StatusCode, http.StatusCreated)
+			}
+
+			var events []inventory.BackorderEvent
+			q.published(t, testExchanges.Backorder, &events)
+			filled := make([]uint64, 0)
+			for _, e := range events {
+				if e.Event == inventory.BackorderFilled {
+					filled = append(filled, e.Reservation.ID)
+				}
+			}
+			if len(filled) != len(test.filled) {
+				t.Fatalf("filled notifications got=%v want=%v", filled, test.filled)
+			}
+			for i := range filled {
+				if filled[i] != test.filled[i] {
+					t.Errorf("filled notifications got=%v want=%v", filled, test.filled)
+				}
+			}
+		})
+	}
+}
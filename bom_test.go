@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/inventory"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+// newBomRepo holds TestOneSKU, made of two of CompA and one of CompB.
+func newBomRepo(compB int64) *memRepo {
+	m := newMemRepo(
+		testProducts[0],
+		inventory.Product{Sku: "CompA", Upc: "5555555555", Name: "Component A", Available: 20},
+		inventory.Product{Sku: "CompB", Upc: "6666666666", Name: "Component B", Available: compB},
+	)
+	m.boms["TestOneSKU"] = inventory.BillOfMaterials{Sku: "TestOneSKU", Version: 3,
+		Components: []inventory.BomComponent{{Sku: "CompA", Quantity: 2}, {Sku: "CompB", Quantity: 1}}}
+	return m
+}
+
+func TestBackflush(t *testing.T) {
+	tests := []struct {
+		name      string
+		compB     int64
+		policy    string
+		status    int
+		consumed  int
+		shortages []inventory.Shortage
+		available map[string]int64
+	}{
+		{
+			name:      "components consumed",
+			compB:     10,
+			policy:    settings.BackflushReject,
+			status:    http.StatusCreated,
+			consumed:  2,
+			available: map[string]int64{"TestOneSKU": testProducts[0].Available + 5, "CompA": 10, "CompB": 5},
+		},
+		{
+			name:      "shortage rejected",
+			compB:     3,
+			policy:    settings.BackflushReject,
+			status:    http.StatusUnprocessableEntity,
+			shortages: []inventory.Shortage{{Sku: "CompB", Required: 5, Available: 3, Short: 2}},
+			available: map[string]int64{"TestOneSKU": testProducts[0].Available, "CompA": 20, "CompB": 3},
+		},
+		{
+			name:      "shortage allowed to go negative",
+			compB:     3,
+			policy:    settings.BackflushAllowNegative,
+			status:    http.StatusCreated,
+			consumed:  2,
+			shortages: []inventory.Shortage{{Sku: "CompB", Required: 5, Available: 3, Short: 2}},
+			available: map[string]int64{"TestOneSKU": testProducts[0].Available + 5, "CompA": 10, "CompB": -2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newBomRepo(test.compB)
+			q := newMemQueue()
+			store := settings.NewStore(settings.Settings{AllocationPolicy: settings.AllocateFifo,
+				BackflushPolicy: test.policy})
+			ts := httptest.NewServer(testRouterWithSettings(q.queue, m.repo, audit.NewMockRepo(), store))
+			defer ts.Close()
+
+			data, err := json.Marshal(inventory.ProductionEvent{RequestID: "BackflushRID", Quantity: 5, Backflush: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.Post(ts.URL+"/inventory/v1/TestOneSKU/productionEvent", "application/json", bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a rejected event describes its shortages in the details of the error
+			got := struct {
+				inventory.ProductionEvent
+				Code    string               `json:"code"`
+				Details []inventory.Shortage `json:"details"`
+			}{}
+			err = json.NewDecoder(res.Body).Decode(&got)
+			_ = res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			shortages := got.Shortages
+			if test.status == http.StatusCreated {
+				if got.BomVersion != 3 || len(got.Consumed) != test.consumed {
+					t.Errorf("bom version got=%d consumed=%v want=%d and %d components", got.BomVersion,
+						got.Consumed, 3, test.consumed)
+				}
+			} else {
+				if got.Code != inventory.CodeComponentShortage {
+					t.Errorf("code got=%s want=%s", got.Code, inventory.CodeComponentShortage)
+				}
+				shortages = got.Details
+			}
+			if len(shortages) != len(test.shortages) || (len(shortages) > 0 && shortages[0] != test.shortages[0]) {
+				t.Errorf("shortages got=%+v want=%+v", shortages, test.shortages)
+			}
+
+			var published []inventory.Product
+			q.published(t, testExchanges.Inventory, &published)
+			for sku, available := range test.available {
+				if got := m.products[sku].Available; got != available {
+					t.Errorf("%s available got=%d want=%d", sku, got, available)
+				}
+				sent := false
+				for _, p := range published {
+					sent = sent || p.Sku == sku
+				}
+				if changed := test.status == http.StatusCreated; sent != changed {
+					t.Errorf("%s inventory published got=%v want=%v", sku, sent, changed)
+				}
+			}
+		})
+	}
+}
+
+func TestSaveBom(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []inventory.BomComponent
+		status     int
+	}{
+		{"component", []inventory.BomComponent{{Sku: "CompA", Quantity: 2}}, http.StatusOK},
+		{"itself", []inventory.BomComponent{{Sku: "TestOneSKU", Quantity: 1}}, http.StatusBadRequest},
+		{"unknown component", []inventory.BomComponent{{Sku: "Unknown", Quantity: 1}}, http.StatusBadRequest},
+		{"no quantity", []inventory.BomComponent{{Sku: "CompA", Quantity: 0}}, http.StatusBadRequest},
+		{"component twice", []inventory.BomComponent{{Sku: "CompA", Quantity: 1}, {Sku: "CompA", Quantity: 1}},
+			http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newBomRepo(10)
+			ts := httptest.NewServer(testRouter(newMemQueue().queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			bom := inventory.BillOfMaterials{}
+			body := inventory.BomRequest{Components: test.components}
+			if res := call(t, ts, http.MethodPut, "/inventory/v1/TestOneSKU/bom", body, &bom); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if test.status == http.StatusOK && bom.Version != 4 {
+				t.Errorf("version got=%d want=%d", bom.Version, 4)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Package broker publishes messages to RabbitMQ. It keeps a connection open in the background, reconnecting whenever
+// it drops, and waits for the broker to confirm every message it publishes.
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/streadway/amqp"
+)
+
+const reconnectDelay = 5 * time.Second
+
+// ErrDisconnected is returned when publishing while there is no connection to the broker.
+var ErrDisconnected = errors.New("not connected to the broker")
+
+type Address struct {
+	User string
+	Pass string
+	Host string
+	Port string
+}
+
+func (a Address) url() string {
+	return fmt.Sprintf("amqp://%s:%s@%s:%s/", a.User, a.Pass, a.Host, a.Port)
+}
+
+// Message is what is sent to the broker, the publishing along with the routing key it is sent with.
+type Message struct {
+	RoutingKey string
+	amqp.Publishing
+}
+
+// PublishOption changes the message before it is published.
+type PublishOption func(m *Message)
+
+func RoutingKey(key string) PublishOption {
+	return func(m *Message) {
+		m.RoutingKey = key
+	}
+}
+
+func ContentType(contentType string) PublishOption {
+	return func(m *Message) {
+		m.ContentType = contentType
+	}
+}
+
+// Headers adds headers to the message, replacing any already set under the same name.
+func Headers(headers map[string]interface{}) PublishOption {
+	return func(m *Message) {
+		if m.Headers == nil {
+			m.Headers = amqp.Table{}
+		}
+		for k, v := range headers {
+			m.Headers[k] = v
+		}
+	}
+}
+
+// NewMessage builds the message for a body with the options applied. Messages are json unless told otherwise.
+func NewMessage(body []byte, options ...PublishOption) Message {
+	m := Message{Publishing: amqp.Publishing{ContentType: "application/json", Body: body}}
+	for _, option := range options {
+		option(&m)
+	}
+	return m
+}
+
+// Publisher is anything messages can be published to, the client itself or something in front of it.
+type Publisher interface {
+	Publish(ctx context.Context, exchange string, body []byte, options ...PublishOption) error
+}
+
+// Channel is the part of an amqp channel the client publishes on.
+type Channel interface {
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+}
+
+// Client publishes to the broker.
+type Client struct {
+	addr    Address
+	mu      sync.RWMutex
+	conn    *amqp.Connection
+	channel Channel
+	closing chan struct{}
+	stopped chan struct{}
+}
+
+// Dial creates a client and starts connecting to the broker in the background. Until it is connected, publishing
+// fails with ErrDisconnected rather than waiting.
+func Dial(addr Address) *Client {
+	c := &Client{addr: addr, closing: make(chan struct{}), stopped: make(chan struct{})}
+	go c.run()
+	return c
+}
+
+// NewClient creates a client that publishes on a channel that is already open. It never reconnects.
+func NewClient(channel Channel) *Client {
+	c := &Client{channel: channel, closing: make(chan struct{}), stopped: make(chan struct{})}
+	close(c.stopped)
+	return c
+}
+
+// Connected reports whether the client has a channel to publish on. It doesn't talk to the broker.
+func (c *Client) Connected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.channel != nil
+}
+
+func (c *Client) Publish(_ context.Context, exchange string, body []byte, options ...PublishOption) error {
+	c.mu.RLock()
+	channel := c.channel
+	c.mu.RUnlock()
+	if channel == nil {
+		return ErrDisconnected
+	}
+
+	m := NewMessage(body, options...)
+	if err := channel.Publish(exchange, m.RoutingKey, false, false, m.Publishing); err != nil {
+		return errors.WithMessage(err, "failed to publish to channel")
+	}
+	return nil
+}
+
+// Close stops reconnecting and closes the connection, giving up once the context expires.
+func (c *Client) Close(ctx context.Context) error {
+	select {
+	case <-c.closing:
+	default:
+		close(c.closing)
+	}
+
+	closed := make(chan error, 1)
+	go func() {
+		<-c.stopped
+		c.mu.Lock()
+		conn := c.conn
+		c.conn, c.channel = nil, nil
+		c.mu.Unlock()
+		if conn == nil {
+			closed <- nil
+			return
+		}
+		closed <- conn.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run keeps the client connected until it is closed.
+func (c *Client) run() {
+	defer close(c.stopped)
+	for {
+		conn, channel, err := c.connect()
+		if err != nil {
+			log.Error().Err(err).Str("host", c.addr.Host).Msg("failed to connect to the broker... retrying")
+			select {
+			case <-c.closing:
+				return
+			case <-time.After(reconnectDelay):
+				continue
+			}
+		}
+
+		dropped := conn.NotifyClose(make(chan *amqp.Error, 1))
+		c.mu.Lock()
+		c.conn, c.channel = conn, channel
+		c.mu.Unlock()
+		log.Info().Str("host", c.addr.Host).Msg("connected to the broker")
+
+		select {
+		case <-c.closing:
+			return
+		case err := <-dropped:
+			log.Warn().Err(err).Msg("connection to the broker dropped")
+			c.mu.Lock()
+			c.conn, c.channel = nil, nil
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *Client) connect() (*amqp.Connection, Channel, error) {
+	conn, err := amqp.Dial(c.addr.url())
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "failed to dial")
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, errors.WithMessage(err, "failed to open a channel")
+	}
+	if err = ch.Confirm(false); err != nil {
+		_ = conn.Close()
+		return nil, nil, errors.WithMessage(err, "failed to put the channel in confirm mode")
+	}
+	return conn, &confirmed{ch: ch, confirms: ch.NotifyPublish(make(chan amqp.Confirmation, 1))}, nil
+}
+
+// confirmed publishes on a channel in confirm mode, one message at a time, waiting for the broker to take each one.
+type confirmed struct {
+	mu       sync.Mutex
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+func (c *confirmed) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.ch.Publish(exchange, key, mandatory, immediate, msg); err != nil {
+		return err
+	}
+	confirm, ok := <-c.confirms
+	if !ok {
+		return ErrDisconnected
+	}
+	if !confirm.Ack {
+		return errors.New("broker rejected the message")
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/inventory"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+// newBucketServer produces to quarantine by default and has a reservation waiting on five units.
+func newBucketServer(product inventory.Product) (*memRepo, *httptest.Server) {
+	product.Sku, product.Upc, product.Name = "BucketSKU", "4444444444", "Quarantined"
+	m := newMemRepo(product)
+	m.addReservations(inventory.Reservation{ID: 1, RequestID: "BucketResRID", Requester: "BucketRequester",
+		Sku: "BucketSKU", State: inventory.Open, RequestedQuantity: 5})
+	store := settings.NewStore(settings.Settings{AllocationPolicy: settings.AllocateFifo,
+		ProductionBucket: settings.ProduceToQuarantine})
+	return m, httptest.NewServer(testRouterWithSettings(newMemQueue().queue, m.repo, audit.NewMockRepo(), store))
+}
+
+func TestProduceToBucket(t *testing.T) {
+	tests := []struct {
+		name       string
+		event      inventory.ProductionEvent
+		available  int64
+		quarantine int64
+		reserved   int64
+	}{
+		{
+			name:       "the configured bucket",
+			event:      inventory.ProductionEvent{RequestID: "BucketRID", Quantity: 10},
+			quarantine: 10,
+		},
+		{
+			name:     "the configured bucket is only the default",
+			event:    inventory.ProductionEvent{RequestID: "BucketRID", Quantity: 3, Bucket: inventory.BucketAvailable},
+			reserved: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, ts := newBucketServer(inventory.Product{})
+			defer ts.Close()
+
+			res := call(t, ts, http.MethodPost, "/inventory/v1/BucketSKU/productionEvent", test.event, nil)
+			if res.StatusCode != http.StatusCreated {
+				t.Fatalf("produce status got=%d want=%d", res.StatusCode, http.StatusCreated)
+			}
+			product, reserved := m.products["BucketSKU"], m.reservations[1].ReservedQuantity
+			if product.Available != test.available || product.Quarantine != test.quarantine || reserved != test.reserved {
+				t.Errorf("got available=%d quarantine=%d reserved=%d want=%d/%d/%d", product.Available,
+					product.Quarantine, reserved, test.available, test.quarantine, test.reserved)
+			}
+		})
+	}
+}
+
+func TestStatusChange(t *testing.T) {
+	tests := []struct {
+		name       string
+		product    inventory.Product
+		change     inventory.StatusChange
+		status     int
+		available  int64
+		quarantine int64
+		damaged    int64
+		reserved   int64
+	}{
+		{
+			name:    "release fills the waiting reservation",
+			product: inventory.Product{Quarantine: 10},
+			change: inventory.StatusChange{RequestID: "ReleaseRID", From: inventory.BucketQuarantine,
+				To: inventory.BucketAvailable, Quantity: 6, Reason: "qc passed"},
+			status:     http.StatusCreated,
+			available:  1,
+			quarantine: 4,
+			reserved:   5,
+		},
+		{
+			name:    "reject",
+			product: inventory.Product{Quarantine: 4},
+			change: inventory.StatusChange{RequestID: "RejectRID", From: inventory.BucketQuarantine,
+				To: inventory.BucketDamaged, Quantity: 4, Reason: "qc failed"},
+			status:  http.StatusCreated,
+			damaged: 4,
+		},
+		{
+			name:    "not enough quarantined",
+			product: inventory.Product{Damaged: 4},
+			change: inventory.StatusChange{RequestID: "ShortRID", From: inventory.BucketQuarantine,
+				To: inventory.BucketDamaged, Quantity: 1, Reason: "qc failed"},
+			status:  http.StatusUnprocessableEntity,
+			damaged: 4,
+		},
+		{
+			name:    "unknown bucket",
+			product: inventory.Product{Damaged: 4},
+			change: inventory.StatusChange{RequestID: "UnknownRID", From: inventory.BucketDamaged, To: "lost",
+				Quantity: 1, Reason: "gone"},
+			status:  http.StatusBadRequest,
+			damaged: 4,
+		},
+		{
+			name:    "same bucket",
+			product: inventory.Product{Damaged: 4},
+			change: inventory.StatusChange{RequestID: "SameRID", From: inventory.BucketDamaged,
+				To: inventory.BucketDamaged, Quantity: 1, Reason: "again"},
+			status:  http.StatusBadRequest,
+			damaged: 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, ts := newBucketServer(test.product)
+			defer ts.Close()
+
+			res := call(t, ts, http.MethodPost, "/inventory/v1/BucketSKU/statusChange", test.change, nil)
+			if res.StatusCode != test.status {
+				t.Errorf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			product := m.products["BucketSKU"]
+			if product.Available != test.available || product.Quarantine != test.quarantine ||
+				product.Damaged != test.damaged {
+				t.Errorf("got=%d/%d/%d want=%d/%d/%d", product.Available, product.Quarantine, product.Damaged,
+					test.available, test.quarantine, test.damaged)
+			}
+			if r := m.reservations[1]; r.ReservedQuantity != test.reserved {
+				t.Errorf("reserved got=%d want=%d", r.ReservedQuantity, test.reserved)
+			}
+		})
+	}
+}
@@ -1,87 +1,625 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	sc "github.com/sksmith/go-spring-config"
+	"github.com/sksmith/smfg-inventory/settings"
+	"github.com/sksmith/smfg-inventory/tracing"
+	"gopkg.in/yaml.v2"
 )
 
 type AppConfig struct {
-	Port                 string
+	Port                 int
 	GenerateRoutes       bool
 	LogLevel             string
 	LogText              bool
 	InMemoryDb           bool
 	DbHost               string
-	DbPort               string
+	DbPort               int
 	DbUser               string
 	DbPass               string
 	DbName               string
 	DbMigrate            bool
 	QHost                string
-	QPort                string
+	QPort                int
 	QUser                string
 	QPass                string
 	Revision             string
 	QInventoryExchange   string
 	QReservationExchange string
+	IdempotencyTTL       time.Duration
+	ShutdownTimeout      time.Duration
+	ShutdownDelay        time.Duration
+	ConfigFile           string
+	ConfigServerUrl      string
+	ConfigServerBranch   string
+	ConfigRefresh        time.Duration
+	Profile              string
+	AllocationPolicy     string
+	RateLimit            float64
+	RateBurst            int
+	AlertHysteresis      float64
+	BackflushPolicy      string
+	ProductionBucket     string
+	CountThreshold       float64
+	QAlertExchange       string
+	QOrderExchange       string
+	QLocationExchange    string
+	QReturnExchange      string
+	QSalesOrderExchange  string
+	QBackorderExchange   string
+	MetricSkuLimit       int
+	TraceExporter        string
+	TraceEndpoint        string
+	TraceInsecure        bool
+	TraceFile            string
+	TraceSampleRatio     float64
+	ReplenishWindowDays  int
+	ReplenishLeadTime    float64
+	ReplenishServiceZ    float64
+	ReplenishOrderCost   float64
+	ReplenishHoldingCost float64
+	ReplenishReportHour  int
+	OutboxMaxBacklog     int
+	OutboxMaxAge         time.Duration
+
+	// values holds every resolved setting along with the layer it came from
+	values []resolvedValue
 }
 
 const maxRetries = 12
 const retryBackoffSec = 5
 
-func LoadConfigs(url, branch, profile string) (*AppConfig, error) {
-	appConfig := &AppConfig{}
-	var config *sc.Config
-	var err error
+// Configuration layers, from lowest to highest priority.
+const (
+	layerDefaults = "defaults"
+	layerFile     = "file"
+	layerEnv      = "env"
+	layerServer   = "config-server"
+	layerFlags    = "flags"
+)
 
-	for tryCount := 1; tryCount < maxRetries; tryCount++ {
-		config, err = sc.Load(url, "smfg-inventory", branch, profile)
-		if err == nil {
-			break
-		}
-		log.Error().Err(err).Msg("failed to load configurations... retrying")
-		time.Sleep(5 * time.Second)
-	}
+const (
+	envPrefix = "SMFG_"
+	redacted  = "********"
+)
 
-	if err != nil {
-		log.Warn().Err(err).Msg("unable to read configurations from config server")
-	} else {
+var defaults = map[string]string{
+	"app.port":                    "8080",
+	"app.shutdown.timeout":        "30s",
+	"app.shutdown.delay":          "5s",
+	"generate.routes":             "false",
+	"log.level":                   "info",
+	"log.text":                    "false",
+	"in.memory":                   "false",
+	"db.port":                     "5432",
+	"db.migrate":                  "false",
+	"queue.port":                  "5672",
+	"queue.inventory.exchange":    "inventory.fanout",
+	"queue.reservation.exchange":  "reservation.filled.fanout",
+	"queue.alert.exchange":        "stock.alert.fanout",
+	"queue.order.exchange":        "production.order.fanout",
+	"queue.location.exchange":     "location.inventory.fanout",
+	"queue.return.exchange":       "return.fanout",
+	"queue.salesorder.exchange":   "sales.order.fanout",
+	"queue.backorder.exchange":    "backorder.fanout",
+	"idempotency.ttl":             "168h",
+	"config.server.branch":        "master",
+	"config.refresh.interval":     "1m",
+	"inventory.allocation.policy": settings.AllocateFifo,
+	"api.ratelimit.rps":           "0",
+	"api.ratelimit.burst":         "0",
+	"inventory.alert.hysteresis":  "10",
+	"inventory.backflush.policy":  settings.BackflushReject,
+	"inventory.production.bucket": settings.ProduceToAvailable,
+	"inventory.count.threshold":   "5",
+	"metrics.sku.limit":           "500",
+	"tracing.exporter":            tracing.ExporterNone,
+	"tracing.otlp.endpoint":       "localhost:4317",
+	"tracing.otlp.insecure":       "false",
+	"tracing.sample.ratio":        "1",
+	"replenishment.window.days":   "90",
+	"replenishment.lead.days":     "7",
+	"replenishment.service.z":     "1.65",
+	"replenishment.order.cost":    "50",
+	"replenishment.holding.cost":  "1",
+	"replenishment.report.hour":   "2",
+	"outbox.backlog.max":          "10000",
+	"outbox.backlog.age":          "5m",
+}
+
+// runtimeKeys are the settings that take effect without a restart.
+var runtimeKeys = map[string]bool{
+	"log.level":                   true,
+	"inventory.allocation.policy": true,
+	"api.ratelimit.rps":           true,
+	"api.ratelimit.burst":         true,
+	"inventory.alert.hysteresis":  true,
+	"inventory.backflush.policy":  true,
+	"inventory.production.bucket": true,
+	"inventory.count.threshold":   true,
+	"replenishment.window.days":   true,
+	"replenishment.lead.days":     true,
+	"replenishment.service.z":     true,
+	"replenishment.order.cost":    true,
+	"replenishment.holding.cost":  true,
+}
+
+// setting binds a configuration key to a field of AppConfig.
+type setting struct {
+	key      string
+	secret   bool
+	required func(c *AppConfig) bool
+	parse    func(c *AppConfig, v string) error
+}
+
+func always(_ *AppConfig) bool { return true }
+
+func unlessInMemory(c *AppConfig) bool { return !c.InMemoryDb }
+
+func allSettings() []setting {
+	return []setting{
 		// API Configs
-		appConfig.Port = config.Get("app.port")
-		appConfig.GenerateRoutes = getBool(config, "generate.routes")
+		intSetting("app.port", always, 1, 65535, func(c *AppConfig) *int { return &c.Port }),
+		durationSetting("app.shutdown.timeout", func(c *AppConfig) *time.Duration { return &c.ShutdownTimeout }),
+		durationSetting("app.shutdown.delay", func(c *AppConfig) *time.Duration { return &c.ShutdownDelay }),
+		boolSetting("generate.routes", func(c *AppConfig) *bool { return &c.GenerateRoutes }),
 
 		// Log Configs
-		appConfig.LogLevel = config.Get("log.level")
-		appConfig.LogText = getBool(config, "log.text")
+		{key: "log.level", parse: func(c *AppConfig, v string) error {
+			if _, err := zerolog.ParseLevel(v); err != nil {
+				return errors.Errorf("%q is not a valid log level", v)
+			}
+			c.LogLevel = v
+			return nil
+		}},
+		boolSetting("log.text", func(c *AppConfig) *bool { return &c.LogText }),
 
 		// DB Configs
-		appConfig.DbHost = config.Get("db.host")
-		appConfig.DbPort = config.Get("db.port")
-		appConfig.DbUser = config.Get("db.user")
-		appConfig.DbPass = config.Get("db.pass")
-		appConfig.DbName = config.Get("db.name")
-		appConfig.DbMigrate = getBool(config, "db.migrate")
-		appConfig.InMemoryDb = getBool(config, "in.memory")
+		boolSetting("in.memory", func(c *AppConfig) *bool { return &c.InMemoryDb }),
+		stringSetting("db.host", unlessInMemory, false, func(c *AppConfig) *string { return &c.DbHost }),
+		intSetting("db.port", unlessInMemory, 1, 65535, func(c *AppConfig) *int { return &c.DbPort }),
+		stringSetting("db.user", unlessInMemory, false, func(c *AppConfig) *string { return &c.DbUser }),
+		stringSetting("db.pass", unlessInMemory, true, func(c *AppConfig) *string { return &c.DbPass }),
+		stringSetting("db.name", unlessInMemory, false, func(c *AppConfig) *string { return &c.DbName }),
+		boolSetting("db.migrate", func(c *AppConfig) *bool { return &c.DbMigrate }),
 
 		// Queue Configs
-		appConfig.QHost = config.Get("queue.host")
-		appConfig.QPort = config.Get("queue.port")
-		appConfig.QUser = config.Get("queue.user")
-		appConfig.QPass = config.Get("queue.pass")
-		appConfig.QInventoryExchange = config.Get("queue.inventory.exchange")
-		appConfig.QReservationExchange = config.Get("queue.reservation.exchange")
+		stringSetting("queue.host", always, false, func(c *AppConfig) *string { return &c.QHost }),
+		intSetting("queue.port", always, 1, 65535, func(c *AppConfig) *int { return &c.QPort }),
+		stringSetting("queue.user", always, false, func(c *AppConfig) *string { return &c.QUser }),
+		stringSetting("queue.pass", always, true, func(c *AppConfig) *string { return &c.QPass }),
+		stringSetting("queue.inventory.exchange", always, false, func(c *AppConfig) *string { return &c.QInventoryExchange }),
+		stringSetting("queue.reservation.exchange", always, false, func(c *AppConfig) *string { return &c.QReservationExchange }),
+		stringSetting("queue.alert.exchange", always, false, func(c *AppConfig) *string { return &c.QAlertExchange }),
+		stringSetting("queue.order.exchange", always, false, func(c *AppConfig) *string { return &c.QOrderExchange }),
+		stringSetting("queue.location.exchange", always, false, func(c *AppConfig) *string { return &c.QLocationExchange }),
+		stringSetting("queue.return.exchange", always, false, func(c *AppConfig) *string { return &c.QReturnExchange }),
+		stringSetting("queue.salesorder.exchange", always, false, func(c *AppConfig) *string { return &c.QSalesOrderExchange }),
+		stringSetting("queue.backorder.exchange", always, false, func(c *AppConfig) *string { return &c.QBackorderExchange }),
+
+		// Outbox Configs
+		intSetting("outbox.backlog.max", nil, 0, math.MaxInt32, func(c *AppConfig) *int { return &c.OutboxMaxBacklog }),
+		durationSetting("outbox.backlog.age", func(c *AppConfig) *time.Duration { return &c.OutboxMaxAge }),
+
+		// Idempotency Configs
+		durationSetting("idempotency.ttl", func(c *AppConfig) *time.Duration { return &c.IdempotencyTTL }),
+
+		// Metrics Configs
+		intSetting("metrics.sku.limit", nil, 0, 100000, func(c *AppConfig) *int { return &c.MetricSkuLimit }),
+
+		// Tracing Configs
+		{key: "tracing.exporter", parse: func(c *AppConfig, v string) error {
+			switch v {
+			case tracing.ExporterNone, tracing.ExporterOtlp, tracing.ExporterStdout, tracing.ExporterFile:
+				c.TraceExporter = v
+				return nil
+			}
+			return errors.Errorf("%q must be one of %s, %s, %s or %s", v,
+				tracing.ExporterNone, tracing.ExporterOtlp, tracing.ExporterStdout, tracing.ExporterFile)
+		}},
+		stringSetting("tracing.otlp.endpoint", func(c *AppConfig) bool { return c.TraceExporter == tracing.ExporterOtlp },
+			false, func(c *AppConfig) *string { return &c.TraceEndpoint }),
+		boolSetting("tracing.otlp.insecure", func(c *AppConfig) *bool { return &c.TraceInsecure }),
+		stringSetting("tracing.file", func(c *AppConfig) bool { return c.TraceExporter == tracing.ExporterFile },
+			false, func(c *AppConfig) *string { return &c.TraceFile }),
+		floatSetting("tracing.sample.ratio", 0, 1, func(c *AppConfig) *float64 { return &c.TraceSampleRatio }),
+
+		// Replenishment Configs
+		intSetting("replenishment.report.hour", nil, 0, 23, func(c *AppConfig) *int { return &c.ReplenishReportHour }),
+
+		// Runtime Configs
+		{key: "inventory.allocation.policy", parse: func(c *AppConfig, v string) error {
+			if v != settings.AllocateFifo && v != settings.AllocateSmallestFirst {
+				return errors.Errorf("%q must be %s or %s", v, settings.AllocateFifo, settings.AllocateSmallestFirst)
+			}
+			c.AllocationPolicy = v
+			return nil
+		}},
+		floatSetting("api.ratelimit.rps", 0, math.MaxFloat64, func(c *AppConfig) *float64 { return &c.RateLimit }),
+		intSetting("api.ratelimit.burst", nil, 0, 1000000, func(c *AppConfig) *int { return &c.RateBurst }),
+		floatSetting("inventory.alert.hysteresis", 0, 100, func(c *AppConfig) *float64 { return &c.AlertHysteresis }),
+		{key: "inventory.backflush.policy", parse: func(c *AppConfig, v string) error {
+			if v != settings.BackflushReject && v != settings.BackflushAllowNegative {
+				return errors.Errorf("%q must be %s or %s", v, settings.BackflushReject, settings.BackflushAllowNegative)
+			}
+			c.BackflushPolicy = v
+			return nil
+		}},
+		{key: "inventory.production.bucket", parse: func(c *AppConfig, v string) error {
+			if v != settings.ProduceToAvailable && v != settings.ProduceToQuarantine {
+				return errors.Errorf("%q must be %s or %s", v, settings.ProduceToAvailable, settings.ProduceToQuarantine)
+			}
+			c.ProductionBucket = v
+			return nil
+		}},
+		floatSetting("inventory.count.threshold", 0, math.MaxFloat64, func(c *AppConfig) *float64 { return &c.CountThreshold }),
+		intSetting("replenishment.window.days", nil, 1, 3650, func(c *AppConfig) *int { return &c.ReplenishWindowDays }),
+		floatSetting("replenishment.lead.days", 0, 3650, func(c *AppConfig) *float64 { return &c.ReplenishLeadTime }),
+		floatSetting("replenishment.service.z", 0, 10, func(c *AppConfig) *float64 { return &c.ReplenishServiceZ }),
+		floatSetting("replenishment.order.cost", 0, math.MaxFloat64, func(c *AppConfig) *float64 { return &c.ReplenishOrderCost }),
+		floatSetting("replenishment.holding.cost", 0, math.MaxFloat64, func(c *AppConfig) *float64 { return &c.ReplenishHoldingCost }),
+
+		// Config Sources
+		stringSetting("config.file", nil, false, func(c *AppConfig) *string { return &c.ConfigFile }),
+		stringSetting("config.server.url", nil, false, func(c *AppConfig) *string { return &c.ConfigServerUrl }),
+		stringSetting("config.server.branch", nil, false, func(c *AppConfig) *string { return &c.ConfigServerBranch }),
+		durationSetting("config.refresh.interval", func(c *AppConfig) *time.Duration { return &c.ConfigRefresh }),
+		stringSetting("profile", nil, false, func(c *AppConfig) *string { return &c.Profile }),
 	}
+}
 
+func stringSetting(key string, required func(c *AppConfig) bool, secret bool, field func(c *AppConfig) *string) setting {
+	return setting{key: key, secret: secret, required: required, parse: func(c *AppConfig, v string) error {
+		*field(c) = v
+		return nil
+	}}
+}
+
+func intSetting(key string, required func(c *AppConfig) bool, min, max int, field func(c *AppConfig) *int) setting {
+	return setting{key: key, required: required, parse: func(c *AppConfig, v string) error {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return errors.Errorf("%q is not an integer", v)
+		}
+		if i < min || i > max {
+			return errors.Errorf("%d is not between %d and %d", i, min, max)
+		}
+		*field(c) = i
+		return nil
+	}}
+}
+
+func floatSetting(key string, min, max float64, field func(c *AppConfig) *float64) setting {
+	return setting{key: key, parse: func(c *AppConfig, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return errors.Errorf("%q is not a number", v)
+		}
+		if f < min || f > max {
+			return errors.Errorf("%g is not between %g and %g", f, min, max)
+		}
+		*field(c) = f
+		return nil
+	}}
+}
+
+func boolSetting(key string, field func(c *AppConfig) *bool) setting {
+	return setting{key: key, parse: func(c *AppConfig, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return errors.Errorf("%q is not a boolean", v)
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+func durationSetting(key string, field func(c *AppConfig) *time.Duration) setting {
+	return setting{key: key, parse: func(c *AppConfig, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return errors.Errorf("%q is not a duration", v)
+		}
+		if d < 0 {
+			return errors.Errorf("%s must not be negative", d)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+// ValidationError lists every problem found with the configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+type layer struct {
+	name   string
+	values map[string]string
+	strict bool // unknown keys are reported as problems
+}
+
+type resolvedValue struct {
+	key    string
+	value  string
+	source string
+	secret bool
+}
+
+// LoadConfigs resolves the configuration from, in increasing priority, the defaults, a local yaml file, SMFG_*
+// environment variables, the config server and the command line flags. Every problem with the result is reported
+// at once.
+func LoadConfigs(args []string) (*AppConfig, error) {
+	return loadConfigs(args, false)
+}
+
+// loadConfigs resolves every layer. On startup the config server is retried and skipped if it never answers. When
+// reloading it is tried once and failing to reach it is a problem, so the running settings are kept.
+func loadConfigs(args []string, reload bool) (*AppConfig, error) {
+	flagValues, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+
+	env := layer{name: layerEnv, values: envValues(os.Environ())}
+	flags := layer{name: layerFlags, values: flagValues, strict: true}
+	base := layer{name: layerDefaults, values: defaults}
+
+	var problems []string
+	file := layer{name: layerFile, values: map[string]string{}, strict: true}
+	if path := resolve(base, env, flags)["config.file"]; path != "" {
+		if file.values, err = fileValues(path); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	server := layer{name: layerServer, values: map[string]string{}}
+	bootstrap := resolve(base, file, env, flags)
+	if url := bootstrap["config.server.url"]; url != "" {
+		retries := maxRetries
+		if reload {
+			retries = 1
+		}
+		server.values, err = serverValues(url, bootstrap["config.server.branch"], bootstrap["profile"], retries)
+		if err != nil {
+			if reload {
+				problems = append(problems, fmt.Sprintf("config server: %v", err))
+			} else {
+				log.Warn().Err(err).Msg("unable to read configurations from config server, continuing without it")
+			}
+		}
+	}
+
+	appConfig, parseProblems := build(base, file, env, server, flags)
+	problems = append(problems, parseProblems...)
+	if len(problems) > 0 {
+		return appConfig, &ValidationError{Problems: problems}
+	}
 	return appConfig, nil
 }
 
-func getBool(c *sc.Config, property string) bool {
-	val, err := strconv.ParseBool(c.Get(property))
+type setFlag map[string]string
+
+func (s setFlag) String() string {
+	return ""
+}
+
+func (s setFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return errors.Errorf("%q must be in the form key=value", v)
+	}
+	s[kv[0]] = kv[1]
+	return nil
+}
+
+func parseFlags(args []string) (map[string]string, error) {
+	values := setFlag{}
+	fs := flag.NewFlagSet(AppName, flag.ContinueOnError)
+	fs.Var(values, "set", "override a configuration value, in the form key=value (repeatable)")
+	file := fs.String("config", "", "path to a local yaml configuration file")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if *file != "" {
+		values["config.file"] = *file
+	}
+	return values, nil
+}
+
+// envValues maps SMFG_DB_HOST to db.host and so on.
+func envValues(environ []string) map[string]string {
+	values := map[string]string{}
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(kv, envPrefix), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values[strings.ToLower(strings.ReplaceAll(parts[0], "_", "."))] = parts[1]
+	}
+	return values
+}
+
+func fileValues(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
-		return false
+		return nil, errors.WithMessagef(err, "config.file: failed to read %s", path)
+	}
+
+	doc := map[interface{}]interface{}{}
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errors.WithMessagef(err, "config.file: failed to parse %s", path)
+	}
+
+	values := map[string]string{}
+	flatten("", doc, values)
+	return values, nil
+}
+
+// flatten turns nested yaml maps into the dotted keys used by every other layer.
+func flatten(prefix string, doc map[interface{}]interface{}, values map[string]string) {
+	for k, v := range doc {
+		key := fmt.Sprint(k)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if m, ok := v.(map[interface{}]interface{}); ok {
+			flatten(key, m, values)
+			continue
+		}
+		values[key] = fmt.Sprint(v)
+	}
+}
+
+func serverValues(url, branch, profile string, retries int) (map[string]string, error) {
+	var config *sc.Config
+	var err error
+
+	for tryCount := 1; tryCount <= retries; tryCount++ {
+		config, err = sc.Load(url, AppName, branch, profile)
+		if err == nil {
+			return config.Values, nil
+		}
+		if tryCount < retries {
+			log.Error().Err(err).Msg("failed to load configurations... retrying")
+			time.Sleep(retryBackoffSec * time.Second)
+		}
+	}
+
+	return map[string]string{}, err
+}
+
+// resolve merges the layers, later layers taking priority.
+func resolve(layers ...layer) map[string]string {
+	values := map[string]string{}
+	for _, l := range layers {
+		for k, v := range l.values {
+			values[k] = v
+		}
+	}
+	return values
+}
+
+func build(layers ...layer) (*AppConfig, []string) {
+	c := &AppConfig{}
+	var problems []string
+
+	known := map[string]bool{}
+	for _, s := range allSettings() {
+		known[s.key] = true
+	}
+	for _, l := range layers {
+		if !l.strict {
+			continue
+		}
+		for k := range l.values {
+			if !known[k] {
+				problems = append(problems, fmt.Sprintf("%s: unknown setting from %s", k, l.name))
+			}
+		}
+	}
+
+	// in.memory decides which settings are required, so it is parsed before the rest
+	all := allSettings()
+	sort.SliceStable(all, func(i, j int) bool { return all[i].key == "in.memory" && all[j].key != "in.memory" })
+
+	for _, s := range all {
+		value, source := "", ""
+		for _, l := range layers {
+			if v, ok := l.values[s.key]; ok {
+				value, source = v, l.name
+			}
+		}
+
+		if value == "" {
+			if s.required != nil && s.required(c) {
+				problems = append(problems, fmt.Sprintf("%s: is required", s.key))
+			}
+			continue
+		}
+
+		c.values = append(c.values, resolvedValue{key: s.key, value: value, source: source, secret: s.secret})
+		if err := s.parse(c, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v (from %s)", s.key, err, source))
+		}
+	}
+
+	sort.Slice(c.values, func(i, j int) bool { return c.values[i].key < c.values[j].key })
+	return c, problems
+}
+
+// RuntimeSettings extracts the settings that can be changed without a restart.
+func (c *AppConfig) RuntimeSettings() settings.Settings {
+	return settings.Settings{
+		LogLevel:         c.LogLevel,
+		AllocationPolicy: c.AllocationPolicy,
+		RateLimit:        c.RateLimit,
+		RateBurst:        c.RateBurst,
+		AlertHysteresis:  c.AlertHysteresis,
+		BackflushPolicy:  c.BackflushPolicy,
+		ProductionBucket: c.ProductionBucket,
+		CountThreshold:   c.CountThreshold,
+
+		ReplenishmentWindowDays: c.ReplenishWindowDays,
+		DefaultLeadTimeDays:     c.ReplenishLeadTime,
+		ServiceLevelZ:           c.ReplenishServiceZ,
+		OrderCost:               c.ReplenishOrderCost,
+		HoldingCost:             c.ReplenishHoldingCost,
+	}
+}
+
+// restartRequired lists the settings that differ from next but only take effect after a restart.
+func (c *AppConfig) restartRequired(next *AppConfig) []string {
+	current := map[string]string{}
+	for _, v := range c.values {
+		current[v.key] = v.value
+	}
+
+	var keys []string
+	seen := map[string]bool{}
+	for _, v := range next.values {
+		seen[v.key] = true
+		if !runtimeKeys[v.key] && current[v.key] != v.value {
+			keys = append(keys, v.key)
+		}
+	}
+	for k := range current {
+		if !seen[k] && !runtimeKeys[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Print writes the effective configuration and where each value came from, with secrets redacted.
+func (c *AppConfig) Print(w io.Writer) {
+	for _, v := range c.values {
+		value := v.value
+		if v.secret {
+			value = redacted
+		}
+		_, _ = fmt.Fprintf(w, "%-28s %-32s (%s)\n", v.key, value, v.source)
 	}
-	return val
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+func TestLoadConfigsLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smfg-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yml")
+	yml := `
+app:
+  port: 9000
+db:
+  host: file-host
+  user: file-user
+  pass: file-secret
+  name: smfg-db
+queue:
+  host: file-queue
+  user: guest
+  pass: guest
+log:
+  level: debug
+`
+	if err = ioutil.WriteFile(file, []byte(yml), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	setEnv(t, "SMFG_DB_HOST", "env-host")
+	setEnv(t, "SMFG_APP_PORT", "9100")
+
+	c, err := LoadConfigs([]string{"-config", file, "-set", "app.port=9200", "-set", "app.shutdown.timeout=10s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Port != 9200 {
+		t.Errorf("port got=%d want=%d", c.Port, 9200)
+	}
+	if c.DbHost != "env-host" {
+		t.Errorf("db host got=%s want=%s", c.DbHost, "env-host")
+	}
+	if c.DbUser != "file-user" {
+		t.Errorf("db user got=%s want=%s", c.DbUser, "file-user")
+	}
+	if c.DbPort != 5432 {
+		t.Errorf("db port got=%d want=%d", c.DbPort, 5432)
+	}
+	if c.LogLevel != "debug" {
+		t.Errorf("log level got=%s want=%s", c.LogLevel, "debug")
+	}
+	if c.ShutdownTimeout != 10*time.Second {
+		t.Errorf("shutdown timeout got=%s want=%s", c.ShutdownTimeout, 10*time.Second)
+	}
+
+	out := &bytes.Buffer{}
+	c.Print(out)
+	if strings.Contains(out.String(), "file-secret") {
+		t.Errorf("secrets should be redacted got=%s", out.String())
+	}
+	if !strings.Contains(out.String(), "env-host") || !strings.Contains(out.String(), "(env)") {
+		t.Errorf("printed values should include their source got=%s", out.String())
+	}
+}
+
+func TestLoadConfigsValidation(t *testing.T) {
+	c, err := LoadConfigs([]string{"-set", "app.port=http", "-set", "log.level=loud", "-set", "db.hots=localhost"})
+	if c == nil {
+		t.Fatal("the partial configuration should be returned for printing")
+	}
+
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("error got=%v want=%T", err, verr)
+	}
+
+	for _, want := range []string{"app.port", "log.level", "db.hots", "db.host: is required", "queue.pass: is required"} {
+		found := false
+		for _, p := range verr.Problems {
+			if strings.HasPrefix(p, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("expected a problem for %s in %v", want, verr.Problems)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "smfg-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yml")
+	write := func(yml string) {
+		base := `
+in:
+  memory: true
+queue:
+  host: localhost
+  user: guest
+  pass: guest
+`
+		if err := ioutil.WriteFile(file, []byte(base+yml), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("")
+	args := []string{"-config", file}
+	running, err := LoadConfigs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := settings.NewStore(running.RuntimeSettings())
+	var changes []settings.Change
+	store.Subscribe(func(c settings.Change) { changes = append(changes, c) })
+
+	write(`
+inventory:
+  allocation:
+    policy: smallest-first
+api:
+  ratelimit:
+    rps: 2.5
+app:
+  port: 9000
+`)
+	if err = reloadConfig(args, running, store); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Get().AllocationPolicy; got != settings.AllocateSmallestFirst {
+		t.Errorf("allocation policy got=%s want=%s", got, settings.AllocateSmallestFirst)
+	}
+	if got := store.Get().RateLimit; got != 2.5 {
+		t.Errorf("rate limit got=%f want=%f", got, 2.5)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("change events got=%d want=%d", len(changes), 1)
+	}
+	if got := strings.Join(changes[0].Changed, ","); got != "allocationPolicy,rateLimit" {
+		t.Errorf("changed got=%s want=%s", got, "allocationPolicy,rateLimit")
+	}
+
+	next, err := LoadConfigs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(running.restartRequired(next), ","); got != "app.port" {
+		t.Errorf("restart required got=%s want=%s", got, "app.port")
+	}
+
+	write(`
+inventory:
+  allocation:
+    policy: largest-first
+`)
+	if err = reloadConfig(args, running, store); err == nil {
+		t.Errorf("expected an invalid configuration to be rejected")
+	}
+	if got := store.Get().AllocationPolicy; got != settings.AllocateSmallestFirst {
+		t.Errorf("allocation policy after rejection got=%s want=%s", got, settings.AllocateSmallestFirst)
+	}
+	if len(changes) != 1 {
+		t.Errorf("change events after rejection got=%d want=%d", len(changes), 1)
+	}
+}
+
+func setEnv(t *testing.T, key, value string) {
+	old, had := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if had {
+			_ = os.Setenv(key, old)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	})
+}
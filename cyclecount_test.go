@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/inventory"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+func TestClassifyABC(t *testing.T) {
+	classes := inventory.ClassifyABC(map[string]int64{"S1": 700, "S2": 100, "S3": 90, "S4": 60, "S5": 50, "S6": 0})
+	want := map[string]string{"S1": inventory.ClassA, "S2": inventory.ClassA, "S3": inventory.ClassB,
+		"S4": inventory.ClassB, "S5": inventory.ClassC, "S6": inventory.ClassC}
+	for sku, class := range want {
+		if classes[sku] != class {
+			t.Errorf("%s class got=%s want=%s", sku, classes[sku], class)
+		}
+	}
+}
+
+// newCountServer holds a fast and a slow mover, five of the slow one in the east, and posts variances of up to five
+// percent without approval.
+func newCountServer() (*memRepo, *httptest.Server) {
+	m := newMemRepo(
+		inventory.Product{Sku: "CountFast", Upc: "5555555551", Name: "Fast", Available: 80, Reserved: 15,
+			Quarantine: 5},
+		inventory.Product{Sku: "CountSlow", Upc: "5555555552", Name: "Slow", Available: 40},
+		inventory.Product{Sku: "CountKit", Upc: "5555555553", Name: "Kit", Kit: true},
+	)
+	m.locations["east"] = inventory.Location{Code: "east", Name: "East"}
+	m.locationStock["CountSlow"] = map[string]int64{inventory.DefaultLocation: 35, "east": 5}
+	demand := map[string]int64{"CountFast": 900, "CountSlow": 10}
+	m.repo.GetDailyDemandFunc = func(ctx context.Context, sku string, since, until time.Time, tx ...db.Transaction) ([]inventory.DailyQuantity, error) {
+		return []inventory.DailyQuantity{{Day: until, Quantity: demand[sku]}}, nil
+	}
+	store := settings.NewStore(settings.Settings{AllocationPolicy: settings.AllocateFifo, CountThreshold: 5})
+	return m, httptest.NewServer(testRouterWithSettings(newMemQueue().queue, m.repo, audit.NewMockRepo(), store))
+}
+
+func TestCreateCycleCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   map[string]interface{}
+		status int
+		lines  int
+	}{
+		{name: "class A", body: map[string]interface{}{"method": "abc", "class": "A"}, status: http.StatusCreated, lines: 1},
+		{name: "random sample", body: map[string]interface{}{"method": "random", "size": 5}, status: http.StatusCreated, lines: 2},
+		{name: "random without size", body: map[string]interface{}{"method": "random"}, status: http.StatusBadRequest},
+		{name: "by location", body: map[string]interface{}{"method": "location", "location": "east"}, status: http.StatusCreated, lines: 1},
+		{name: "main location", body: map[string]interface{}{"method": "location", "location": "main"}, status: http.StatusCreated, lines: 2},
+		{name: "by location without one", body: map[string]interface{}{"method": "location"}, status: http.StatusBadRequest},
+		{name: "unknown location", body: map[string]interface{}{"method": "location", "location": "west"}, status: http.StatusNotFound},
+		{name: "unknown class", body: map[string]interface{}{"method": "abc", "class": "D"}, status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, ts := newCountServer()
+			defer ts.Close()
+
+			count := inventory.CycleCount{}
+			if res := call(t, ts, http.MethodPost, "/inventory/v1/cycleCount", test.body, &count); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if len(count.Lines) != test.lines {
+				t.Errorf("lines got=%d want=%d", len(count.Lines), test.lines)
+			}
+		})
+	}
+}
+
+type countStep struct {
+	url  string
+	body map[string]interface{}
+}
+
+func TestCountAndApprove(t *testing.T) {
+	counted := countStep{"/inventory/v1/cycleCount/1/counts", map[string]interface{}{
+		"counts": []inventory.Count{{Sku: "CountFast", Counted: 98}, {Sku: "CountSlow", Counted: 30}}}}
+	approved := countStep{"/inventory/v1/cycleCount/1/approval", map[string]interface{}{"approved": true}}
+
+	tests := []struct {
+		name     string
+		before   []countStep
+		step     countStep
+		status   int
+		want     inventory.CountStatus
+		fast     int64
+		slow     int64
+		adjusted int64
+	}{
+		{
+			// the expected quantity is all of the stock on hand, not just what is available
+			name:     "the small variance posts and the large one waits",
+			step:     counted,
+			status:   http.StatusOK,
+			want:     inventory.CountPendingApproval,
+			fast:     78,
+			slow:     40,
+			adjusted: -2,
+		},
+		{
+			name:     "record again",
+			before:   []countStep{counted},
+			step:     counted,
+			status:   http.StatusConflict,
+			fast:     78,
+			slow:     40,
+			adjusted: -2,
+		},
+		{
+			name:     "approve",
+			before:   []countStep{counted},
+			step:     approved,
+			status:   http.StatusOK,
+			want:     inventory.CountClosed,
+			fast:     78,
+			slow:     30,
+			adjusted: -2,
+		},
+		{
+			name:     "approve a closed count",
+			before:   []countStep{counted, approved},
+			step:     approved,
+			status:   http.StatusConflict,
+			fast:     78,
+			slow:     30,
+			adjusted: -2,
+		},
+		{
+			name:   "unknown count",
+			step:   countStep{"/inventory/v1/cycleCount/9/approval", map[string]interface{}{"approved": true}},
+			status: http.StatusNotFound,
+			fast:   80,
+			slow:   40,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, ts := newCountServer()
+			defer ts.Close()
+
+			sample := map[string]interface{}{"method": "random", "size": 5}
+			if res := call(t, ts, http.MethodPost, "/inventory/v1/cycleCount", sample, nil); res.StatusCode != http.StatusCreated {
+				t.Fatalf("create status got=%d want=%d", res.StatusCode, http.StatusCreated)
+			}
+			for _, step := range test.before {
+				if res := call(t, ts, http.MethodPost, step.url, step.body, nil); res.StatusCode != http.StatusOK {
+					t.Fatalf("%s status got=%d want=%d", step.url, res.StatusCode, http.StatusOK)
+				}
+			}
+
+			count := inventory.CycleCount{}
+			if res := call(t, ts, http.MethodPost, test.step.url, test.step.body, &count); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if fast, slow := m.products["CountFast"].Available, m.products["CountSlow"].Available; fast != test.fast ||
+				slow != test.slow {
+				t.Errorf("available got=%d/%d want=%d/%d", fast, slow, test.fast, test.slow)
+			}
+			var adjusted int64
+			for _, adj := range m.adjustments {
+				if adj.Sku == "CountFast" {
+					adjusted += adj.Quantity
+				}
+			}
+			if adjusted != test.adjusted {
+				t.Errorf("fast adjusted got=%d want=%d", adjusted, test.adjusted)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			if count.Status != test.want {
+				t.Errorf("count status got=%s want=%s", count.Status, test.want)
+			}
+			for _, l := range count.Lines {
+				if l.Sku == "CountFast" && (l.Expected != 100 || l.Variance != -2) {
+					t.Errorf("fast line got=%+v want expected=%d variance=%d", l, 100, -2)
+				}
+				if test.want == inventory.CountClosed && l.Status != inventory.LinePosted {
+					t.Errorf("%s line status got=%s want=%s", l.Sku, l.Status, inventory.LinePosted)
+				}
+			}
+		})
+	}
+}
+
+func TestCountVarianceBuckets(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		counts   []inventory.Count
+		approve  bool
+		status   int
+		fast     inventory.Product
+		slow     int64
+		east     int64
+	}{
+		{
+			name:     "short at a location only takes its stock",
+			location: "east",
+			counts:   []inventory.Count{{Sku: "CountSlow", Counted: 3}},
+			approve:  true,
+			status:   http.StatusOK,
+			fast:     inventory.Product{Available: 80, Reserved: 15, Quarantine: 5},
+			slow:     38,
+			east:     3,
+		},
+		{
+			name:     "short beyond what is available takes quarantined stock",
+			location: "main",
+			counts:   []inventory.Count{{Sku: "CountFast", Counted: 17}, {Sku: "CountSlow", Counted: 35}},
+			approve:  true,
+			status:   http.StatusOK,
+			fast:     inventory.Product{Available: 0, Reserved: 15, Quarantine: 2},
+			slow:     40,
+			east:     5,
+		},
+		{
+			name:     "reserved stock is never written off",
+			location: "main",
+			counts:   []inventory.Count{{Sku: "CountFast", Counted: 10}, {Sku: "CountSlow", Counted: 30}},
+			approve:  true,
+			status:   http.StatusUnprocessableEntity,
+			fast:     inventory.Product{Available: 80, Reserved: 15, Quarantine: 5},
+			slow:     40,
+			east:     5,
+		},
+		{
+			name:     "found at a location is available there",
+			location: "east",
+			counts:   []inventory.Count{{Sku: "CountSlow", Counted: 6}},
+			approve:  true,
+			status:   http.StatusOK,
+			fast:     inventory.Product{Available: 80, Reserved: 15, Quarantine: 5},
+			slow:     41,
+			east:     6,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, ts := newCountServer()
+			defer ts.Close()
+
+			create := map[string]interface{}{"method": "location", "location": test.location}
+			if res := call(t, ts, http.MethodPost, "/inventory/v1/cycleCount", create, nil); res.StatusCode != http.StatusCreated {
+				t.Fatalf("create status got=%d want=%d", res.StatusCode, http.StatusCreated)
+			}
+			counts := map[string]interface{}{"counts": test.counts}
+			if res := call(t, ts, http.MethodPost, "/inventory/v1/cycleCount/1/counts", counts, nil); res.StatusCode != http.StatusOK {
+				t.Fatalf("counts status got=%d want=%d", res.StatusCode, http.StatusOK)
+			}
+			approval := map[string]interface{}{"approved": test.approve}
+			if res := call(t, ts, http.MethodPost, "/inventory/v1/cycleCount/1/approval", approval, nil); res.StatusCode != test.status {
+				t.Fatalf("approval status got=%d want=%d", res.StatusCode, test.status)
+			}
+
+			fast := m.products["CountFast"]
+			if fast.Available != test.fast.Available || fast.Reserved != test.fast.Reserved ||
+				fast.Quarantine != test.fast.Quarantine {
+				t.Errorf("fast got=%d/%d/%d want=%d/%d/%d", fast.Available, fast.Reserved, fast.Quarantine,
+					test.fast.Available, test.fast.Reserved, test.fast.Quarantine)
+			}
+			if slow, east := m.products["CountSlow"].Available, m.locationStock["CountSlow"]["east"]; slow != test.slow ||
+				east != test.east {
+				t.Errorf("slow got=%d east=%d want=%d east=%d", slow, east, test.slow, test.east)
+			}
+			if m.counts[1].Status != inventory.CountClosed && test.status == http.StatusOK {
+				t.Errorf("count status got=%s want=%s", m.counts[1].Status, inventory.CountClosed)
+			}
+		})
+	}
+}
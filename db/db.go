@@ -74,12 +74,15 @@ func (l logger) Log(ctx context.Context, level pgx.LogLevel, msg string, data ma
 	evt.Msg(msg)
 }
 
+// migrationDirs are where the migrations live in the docker image and when running from the source tree.
+var migrationDirs = []string{"/db/migrations", "db/migrations"}
+
 func RunMigrations(host, database, port, user, password string) error {
 	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
 		user, password, host, port, database)
-	m, err := migrate.New("file:/db/migrations", connStr)
+	m, err := migrate.New("file:"+migrationDirs[0], connStr)
 	if err != nil {
-		m, err = migrate.New("file:db/migrations", connStr)
+		m, err = migrate.New("file:"+migrationDirs[1], connStr)
 		if err != nil {
 			return err
 		}
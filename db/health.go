@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/pkg/errors"
+)
+
+// PoolCheck pings the database and reports how saturated the connection pool is. A pool with every connection in
+// use is reported as down.
+func PoolCheck(pool *pgxpool.Pool) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		stat := pool.Stat()
+		saturation := 0.0
+		if stat.MaxConns() > 0 {
+			saturation = float64(stat.AcquiredConns()) / float64(stat.MaxConns())
+		}
+		details := map[string]interface{}{
+			"acquired":   stat.AcquiredConns(),
+			"idle":       stat.IdleConns(),
+			"max":        stat.MaxConns(),
+			"saturation": saturation,
+		}
+		if stat.AcquiredConns() >= stat.MaxConns() {
+			return details, errors.New("connection pool is saturated")
+		}
+
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			return details, errors.WithMessage(err, "failed to acquire connection")
+		}
+		defer conn.Release()
+		if err = conn.Conn().Ping(ctx); err != nil {
+			return details, errors.WithMessage(err, "failed to ping database")
+		}
+		return details, nil
+	}
+}
+
+// MigrationCheck verifies that the schema is at the latest migration shipped with the application and that no
+// migration was left half applied.
+func MigrationCheck(conn Conn) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		latest, err := LatestMigration()
+		if err != nil {
+			return nil, err
+		}
+
+		var version uint
+		var dirty bool
+		if err = conn.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1;`).Scan(&version, &dirty); err != nil {
+			return nil, errors.WithMessage(err, "failed to read schema version")
+		}
+
+		details := map[string]interface{}{"version": version, "latest": latest, "dirty": dirty}
+		if dirty {
+			return details, errors.Errorf("migration %d is dirty", version)
+		}
+		if version < latest {
+			return details, errors.Errorf("schema is at version %d but %d is expected", version, latest)
+		}
+		return details, nil
+	}
+}
+
+// LatestMigration returns the highest migration version found in the migrations directory.
+func LatestMigration() (uint, error) {
+	var latest uint
+	var files []string
+	var err error
+	for _, dir := range migrationDirs {
+		if files, err = readDir(dir); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return 0, errors.WithMessage(err, "failed to read migrations")
+	}
+
+	for _, name := range files {
+		v, err := strconv.ParseUint(strings.SplitN(name, "_", 2)[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if uint(v) > latest {
+			latest = uint(v)
+		}
+	}
+	return latest, nil
+}
+
+func readDir(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names, nil
+}
@@ -2,15 +2,17 @@ package db
 
 import (
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 	"time"
 )
 
 var (
-	dbLatency = prometheus.NewSummaryVec(
-		prometheus.SummaryOpts{
-			Name:       "smfg_inventory_db_latency",
-			Help:       "The latency quantiles for the given database request",
-			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+	// Histograms rather than summaries so latencies can be aggregated across replicas
+	dbLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "smfg_inventory_db_latency",
+			Help:    "The latency in milliseconds for the given database request",
+			Buckets: LatencyBuckets,
 		},
 		[]string{"func"},
 	)
@@ -32,9 +34,18 @@ var (
 	)
 )
 
+// LatencyBuckets are the histogram buckets, in milliseconds, shared by the latency metrics.
+var LatencyBuckets = []float64{0.5, 1, 2.5, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Milliseconds converts a duration to fractional milliseconds so that fast requests don't all land in the first bucket.
+func Milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
 type Metric struct {
 	funcName string
 	start time.Time
+	span trace.Span
 }
 
 func StartMetric(funcName string) *Metric {
@@ -46,7 +57,8 @@ func (m *Metric) Complete(err error) {
 	if err != nil {
 		dbErrors.With(prometheus.Labels{"func": m.funcName}).Inc()
 	}
-	dbLatency.WithLabelValues(m.funcName).Observe(float64(time.Since(m.start).Milliseconds()))
+	dbLatency.WithLabelValues(m.funcName).Observe(Milliseconds(time.Since(m.start)))
+	m.endSpan(err)
 }
 
 func init() {
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS audit_log(
+    id BIGSERIAL PRIMARY KEY,
+    action VARCHAR(50) NOT NULL,
+    principal VARCHAR(200) NOT NULL,
+    request_id VARCHAR(200) NOT NULL,
+    client_ip VARCHAR(50) NOT NULL,
+    sku VARCHAR(50) NOT NULL,
+    before JSONB,
+    after JSONB,
+    detail JSONB,
+    created timestamptz NOT NULL
+);
+
+CREATE INDEX aud_sku_idx ON audit_log (sku, created);
+CREATE INDEX aud_principal_idx ON audit_log (principal, created);
+CREATE INDEX aud_request_idx ON audit_log (request_id);
+CREATE INDEX aud_created_idx ON audit_log (created);
+
+-- The audit trail is append-only
+CREATE RULE audit_log_no_update AS ON UPDATE TO audit_log DO INSTEAD NOTHING;
+CREATE RULE audit_log_no_delete AS ON DELETE TO audit_log DO INSTEAD NOTHING;
+
+COMMIT;
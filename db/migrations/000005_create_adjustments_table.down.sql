@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS adjustments;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS adjustments(
+    id SERIAL PRIMARY KEY,
+    request_id VARCHAR(200) NOT NULL,
+    sku VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL,
+    reason VARCHAR(200) NOT NULL,
+    created timestamptz NOT NULL
+);
+
+CREATE INDEX adj_sku_idx ON adjustments (sku);
+CREATE UNIQUE INDEX adj_request_idx ON adjustments (request_id);
+
+COMMIT;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS idempotency_keys;
+
+COMMIT;
\ No newline at end of file
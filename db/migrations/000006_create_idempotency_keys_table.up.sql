@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+    operation VARCHAR(50) NOT NULL,
+    key VARCHAR(200) NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,
+    created timestamptz NOT NULL,
+    PRIMARY KEY (operation, key)
+);
+
+CREATE INDEX idk_created_idx ON idempotency_keys (created);
+
+COMMIT;
@@ -0,0 +1,3 @@
+ALTER TABLE products DROP COLUMN IF EXISTS version;
+
+COMMIT;
\ No newline at end of file
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS stock_alerts;
+
+ALTER TABLE products DROP COLUMN IF EXISTS reorder_quantity;
+ALTER TABLE products DROP COLUMN IF EXISTS safety_stock;
+ALTER TABLE products DROP COLUMN IF EXISTS reorder_point;
+
+COMMIT;
@@ -0,0 +1,16 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS reorder_point BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS safety_stock BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS reorder_quantity BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS stock_alerts(
+    sku VARCHAR(50) PRIMARY KEY,
+    level VARCHAR(20) NOT NULL,
+    available BIGINT NOT NULL,
+    reorder_point BIGINT NOT NULL,
+    safety_stock BIGINT NOT NULL,
+    reorder_quantity BIGINT NOT NULL,
+    raised timestamptz NOT NULL,
+    updated timestamptz NOT NULL
+);
+
+COMMIT;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS res_created_idx;
+DROP TABLE IF EXISTS replenishment_suggestions;
+
+COMMIT;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS replenishment_suggestions(
+    report_date DATE NOT NULL,
+    sku VARCHAR(50) NOT NULL,
+    window_days INTEGER NOT NULL,
+    average_daily_demand DOUBLE PRECISION NOT NULL,
+    demand_std_dev DOUBLE PRECISION NOT NULL,
+    lead_time_days DOUBLE PRECISION NOT NULL,
+    safety_stock BIGINT NOT NULL,
+    reorder_point BIGINT NOT NULL,
+    economic_order_quantity BIGINT NOT NULL,
+    inventory_position BIGINT NOT NULL,
+    suggested_quantity BIGINT NOT NULL,
+    calculated timestamptz NOT NULL,
+    PRIMARY KEY (report_date, sku)
+);
+
+CREATE INDEX res_created_idx ON reservations (sku, created);
+
+COMMIT;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS planned_production(
+    id SERIAL PRIMARY KEY,
+    sku VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL,
+    produced INTEGER NOT NULL DEFAULT 0,
+    due timestamptz NOT NULL,
+    created timestamptz NOT NULL
+);
+
+CREATE INDEX plan_sku_due_idx ON planned_production (sku, due);
+
+COMMIT;
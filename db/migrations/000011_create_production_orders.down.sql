@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS pe_order_idx;
+ALTER TABLE production_events DROP COLUMN IF EXISTS production_order_id;
+
+ALTER TABLE production_orders DROP COLUMN IF EXISTS updated;
+ALTER TABLE production_orders DROP COLUMN IF EXISTS status;
+ALTER TABLE production_orders RENAME COLUMN target_quantity TO quantity;
+ALTER INDEX po_sku_due_idx RENAME TO plan_sku_due_idx;
+ALTER TABLE production_orders RENAME TO planned_production;
+
+COMMIT;
@@ -0,0 +1,18 @@
+ALTER TABLE planned_production RENAME TO production_orders;
+ALTER INDEX plan_sku_due_idx RENAME TO po_sku_due_idx;
+ALTER TABLE production_orders RENAME COLUMN quantity TO target_quantity;
+ALTER TABLE production_orders ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'Planned';
+ALTER TABLE production_orders ADD COLUMN updated timestamptz;
+
+UPDATE production_orders
+   SET updated = created,
+       status = CASE WHEN produced >= target_quantity THEN 'Completed'
+                     WHEN produced > 0 THEN 'PartiallyCompleted'
+                     ELSE 'Planned' END;
+
+ALTER TABLE production_orders ALTER COLUMN updated SET NOT NULL;
+
+ALTER TABLE production_events ADD COLUMN production_order_id INTEGER REFERENCES production_orders (id);
+CREATE INDEX pe_order_idx ON production_events (production_order_id);
+
+COMMIT;
@@ -0,0 +1,7 @@
+ALTER TABLE production_events DROP COLUMN IF EXISTS bom_version;
+ALTER TABLE production_events DROP COLUMN IF EXISTS backflush;
+
+DROP TABLE IF EXISTS bom_components;
+DROP TABLE IF EXISTS boms;
+
+COMMIT;
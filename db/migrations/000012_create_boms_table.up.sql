@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS boms(
+    sku VARCHAR(50) NOT NULL,
+    version INTEGER NOT NULL,
+    created timestamptz NOT NULL,
+    PRIMARY KEY (sku, version)
+);
+
+CREATE TABLE IF NOT EXISTS bom_components(
+    sku VARCHAR(50) NOT NULL,
+    version INTEGER NOT NULL,
+    component_sku VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL,
+    PRIMARY KEY (sku, version, component_sku),
+    FOREIGN KEY (sku, version) REFERENCES boms (sku, version)
+);
+
+ALTER TABLE production_events ADD COLUMN backflush BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE production_events ADD COLUMN bom_version INTEGER;
+
+COMMIT;
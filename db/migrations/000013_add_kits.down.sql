@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS bom_component_idx;
+DROP INDEX IF EXISTS res_kit_idx;
+
+ALTER TABLE reservations DROP COLUMN IF EXISTS kit_reservation_id;
+ALTER TABLE products DROP COLUMN IF EXISTS kit;
+
+COMMIT;
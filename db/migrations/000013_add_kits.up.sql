@@ -0,0 +1,8 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS kit BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE reservations ADD COLUMN IF NOT EXISTS kit_reservation_id INTEGER REFERENCES reservations (id);
+
+CREATE INDEX res_kit_idx ON reservations (kit_reservation_id);
+CREATE INDEX bom_component_idx ON bom_components (component_sku);
+
+COMMIT;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS serial_events;
+DROP TABLE IF EXISTS serials;
+
+ALTER TABLE products DROP COLUMN IF EXISTS serialized;
+
+COMMIT;
@@ -0,0 +1,28 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS serialized BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS serials(
+    serial VARCHAR(100) PRIMARY KEY,
+    sku VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    production_event_id INTEGER REFERENCES production_events (id),
+    reservation_id INTEGER REFERENCES reservations (id),
+    created timestamptz NOT NULL,
+    updated timestamptz NOT NULL
+);
+
+CREATE INDEX serial_stock_idx ON serials (sku, status, created);
+CREATE INDEX serial_reservation_idx ON serials (reservation_id);
+
+CREATE TABLE IF NOT EXISTS serial_events(
+    id SERIAL PRIMARY KEY,
+    serial VARCHAR(100) NOT NULL REFERENCES serials (serial),
+    type VARCHAR(20) NOT NULL,
+    request_id VARCHAR(200),
+    reservation_id INTEGER,
+    requester VARCHAR(50),
+    created timestamptz NOT NULL
+);
+
+CREATE INDEX serial_event_idx ON serial_events (serial, created);
+
+COMMIT;
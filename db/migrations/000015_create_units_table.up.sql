@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS product_units(
+    sku VARCHAR(50) NOT NULL REFERENCES products (sku),
+    name VARCHAR(50) NOT NULL,
+    quantity BIGINT NOT NULL,
+    unit_of VARCHAR(50),
+    base_quantity BIGINT NOT NULL,
+    PRIMARY KEY (sku, name)
+);
+
+COMMIT;
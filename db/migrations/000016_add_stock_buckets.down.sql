@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS status_changes;
+
+ALTER TABLE production_events DROP COLUMN IF EXISTS bucket;
+
+ALTER TABLE products DROP COLUMN IF EXISTS damaged;
+ALTER TABLE products DROP COLUMN IF EXISTS quarantine;
+
+COMMIT;
@@ -0,0 +1,20 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS quarantine INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE products ADD COLUMN IF NOT EXISTS damaged INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE production_events ADD COLUMN IF NOT EXISTS bucket VARCHAR(20) NOT NULL DEFAULT 'available';
+
+CREATE TABLE IF NOT EXISTS status_changes(
+    id SERIAL PRIMARY KEY,
+    request_id VARCHAR(200) NOT NULL,
+    sku VARCHAR(50) NOT NULL,
+    from_bucket VARCHAR(20) NOT NULL,
+    to_bucket VARCHAR(20) NOT NULL,
+    quantity INTEGER NOT NULL,
+    reason VARCHAR(200) NOT NULL,
+    created timestamptz NOT NULL
+);
+
+CREATE INDEX status_change_sku_idx ON status_changes (sku);
+CREATE UNIQUE INDEX status_change_request_idx ON status_changes (request_id);
+
+COMMIT;
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS transfer_receipts;
+DROP TABLE IF EXISTS transfers;
+DROP TABLE IF EXISTS location_stock;
+DROP TABLE IF EXISTS locations;
+
+ALTER TABLE products DROP COLUMN IF EXISTS in_transit;
+
+COMMIT;
@@ -0,0 +1,54 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS in_transit INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS locations(
+    code VARCHAR(50) PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    created timestamptz NOT NULL
+);
+
+INSERT INTO locations (code, name, created) VALUES ('main', 'Main', now()) ON CONFLICT (code) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS location_stock(
+    sku VARCHAR(50) NOT NULL,
+    location VARCHAR(50) NOT NULL REFERENCES locations (code),
+    available INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (sku, location)
+);
+
+INSERT INTO location_stock (sku, location, available)
+SELECT sku, 'main', available FROM products WHERE available <> 0
+ON CONFLICT (sku, location) DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS transfers(
+    id SERIAL PRIMARY KEY,
+    request_id VARCHAR(200) NOT NULL,
+    sku VARCHAR(50) NOT NULL,
+    from_location VARCHAR(50) NOT NULL REFERENCES locations (code),
+    to_location VARCHAR(50) NOT NULL REFERENCES locations (code),
+    quantity INTEGER NOT NULL,
+    received INTEGER NOT NULL DEFAULT 0,
+    discrepancy INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL,
+    created timestamptz NOT NULL,
+    updated timestamptz NOT NULL
+);
+
+CREATE INDEX transfer_sku_idx ON transfers (sku);
+CREATE UNIQUE INDEX transfer_request_idx ON transfers (request_id);
+
+CREATE TABLE IF NOT EXISTS transfer_receipts(
+    id SERIAL PRIMARY KEY,
+    request_id VARCHAR(200) NOT NULL,
+    transfer_id INTEGER NOT NULL REFERENCES transfers (id),
+    sku VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL,
+    closes_transfer BOOLEAN NOT NULL DEFAULT false,
+    discrepancy INTEGER NOT NULL DEFAULT 0,
+    reason VARCHAR(200) NOT NULL,
+    created timestamptz NOT NULL
+);
+
+CREATE INDEX transfer_receipt_transfer_idx ON transfer_receipts (transfer_id);
+CREATE UNIQUE INDEX transfer_receipt_request_idx ON transfer_receipts (request_id);
+
+COMMIT;
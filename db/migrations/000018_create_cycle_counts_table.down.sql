@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS cycle_count_lines;
+DROP TABLE IF EXISTS cycle_counts;
+
+COMMIT;
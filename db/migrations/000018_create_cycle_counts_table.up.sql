@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS cycle_counts(
+    id SERIAL PRIMARY KEY,
+    method VARCHAR(20) NOT NULL,
+    class VARCHAR(1),
+    location VARCHAR(50),
+    status VARCHAR(20) NOT NULL,
+    created timestamptz NOT NULL,
+    updated timestamptz NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cycle_count_lines(
+    count_id INTEGER NOT NULL REFERENCES cycle_counts (id),
+    sku VARCHAR(50) NOT NULL,
+    expected INTEGER NOT NULL,
+    counted INTEGER NOT NULL DEFAULT 0,
+    variance INTEGER NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL,
+    PRIMARY KEY (count_id, sku)
+);
+
+COMMIT;
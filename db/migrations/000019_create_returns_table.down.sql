@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS return_receipts;
+DROP TABLE IF EXISTS returns;
+
+COMMIT;
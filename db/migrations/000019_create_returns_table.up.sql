@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS returns(
+    id SERIAL PRIMARY KEY,
+    request_id VARCHAR(200) NOT NULL,
+    reservation_id INTEGER NOT NULL REFERENCES reservations (id),
+    requester VARCHAR(50) NOT NULL,
+    sku VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL,
+    received INTEGER NOT NULL DEFAULT 0,
+    reason VARCHAR(200) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    created timestamptz NOT NULL,
+    updated timestamptz NOT NULL
+);
+
+CREATE INDEX return_reservation_idx ON returns (reservation_id);
+CREATE UNIQUE INDEX return_request_idx ON returns (request_id);
+
+CREATE TABLE IF NOT EXISTS return_receipts(
+    id SERIAL PRIMARY KEY,
+    request_id VARCHAR(200) NOT NULL,
+    return_id INTEGER NOT NULL REFERENCES returns (id),
+    sku VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL,
+    disposition VARCHAR(20) NOT NULL,
+    created timestamptz NOT NULL
+);
+
+CREATE INDEX return_receipt_return_idx ON return_receipts (return_id);
+CREATE UNIQUE INDEX return_receipt_request_idx ON return_receipts (request_id);
+
+COMMIT;
@@ -0,0 +1,3 @@
+ALTER TABLE reservations DROP COLUMN IF EXISTS shipped_quantity;
+
+COMMIT;
@@ -0,0 +1,3 @@
+ALTER TABLE reservations ADD COLUMN IF NOT EXISTS shipped_quantity INTEGER NOT NULL DEFAULT 0;
+
+COMMIT;
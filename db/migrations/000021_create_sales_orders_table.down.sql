@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS sales_order_lines;
+DROP TABLE IF EXISTS sales_orders;
+
+COMMIT;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS sales_orders(
+    id SERIAL PRIMARY KEY,
+    request_id VARCHAR(200) NOT NULL,
+    requester VARCHAR(50) NOT NULL,
+    policy VARCHAR(20) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    created timestamptz NOT NULL,
+    updated timestamptz NOT NULL
+);
+
+CREATE UNIQUE INDEX sales_order_request_idx ON sales_orders (request_id);
+CREATE INDEX sales_order_status_idx ON sales_orders (status);
+
+CREATE TABLE IF NOT EXISTS sales_order_lines(
+    order_id INTEGER NOT NULL REFERENCES sales_orders (id),
+    sku VARCHAR(50) NOT NULL,
+    quantity INTEGER NOT NULL,
+    reserved INTEGER NOT NULL DEFAULT 0,
+    reservation_id INTEGER REFERENCES reservations (id),
+    PRIMARY KEY (order_id, sku)
+);
+
+CREATE INDEX sales_order_line_sku_idx ON sales_order_lines (sku);
+
+COMMIT;
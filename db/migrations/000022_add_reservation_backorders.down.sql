@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS reservation_backorder_idx;
+ALTER TABLE reservations DROP COLUMN IF EXISTS estimated_fill;
+ALTER TABLE reservations DROP COLUMN IF EXISTS backordered;
+
+COMMIT;
@@ -0,0 +1,6 @@
+ALTER TABLE reservations ADD COLUMN IF NOT EXISTS backordered BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE reservations ADD COLUMN IF NOT EXISTS estimated_fill timestamptz;
+
+CREATE INDEX reservation_backorder_idx ON reservations (sku, requester) WHERE backordered AND state = 'Open';
+
+COMMIT;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS outbox(
+    id BIGSERIAL PRIMARY KEY,
+    exchange VARCHAR(200) NOT NULL,
+    routing_key VARCHAR(200) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    headers jsonb,
+    body bytea NOT NULL,
+    created timestamptz NOT NULL
+);
+
+COMMIT;
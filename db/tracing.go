@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sksmith/smfg-inventory/db"
+
+// StartSpan starts a client span for the database request being measured. Complete ends it.
+func (m *Metric) StartSpan(ctx context.Context) context.Context {
+	ctx, m.span = otel.Tracer(tracerName).Start(ctx, m.funcName,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation", m.funcName),
+		))
+	return ctx
+}
+
+func (m *Metric) endSpan(err error) {
+	if m.span == nil {
+		return
+	}
+	if err != nil {
+		m.span.RecordError(err)
+		m.span.SetStatus(codes.Error, err.Error())
+	}
+	m.span.End()
+}
@@ -0,0 +1,40 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/sksmith/smfg-inventory/api"
+)
+
+type Api struct {
+	checker *Checker
+}
+
+func NewApi(checker *Checker) *Api {
+	return &Api{checker: checker}
+}
+
+func (a *Api) ConfigureRouter(r chi.Router) {
+	r.Get("/live", a.Live)
+	r.Get("/ready", a.Ready)
+}
+
+func (rp *Report) Render(_ http.ResponseWriter, r *http.Request) error {
+	if rp.Status != Up {
+		render.Status(r, http.StatusServiceUnavailable)
+	}
+	return nil
+}
+
+// Live only reports whether the process is able to serve requests. It deliberately checks no dependencies so that
+// an outage elsewhere doesn't get every pod restarted.
+func (a *Api) Live(w http.ResponseWriter, r *http.Request) {
+	api.Render(w, r, &Report{Status: Up, Checks: []Result{}})
+}
+
+func (a *Api) Ready(w http.ResponseWriter, r *http.Request) {
+	report := a.checker.Ready(r.Context())
+	api.Render(w, r, &report)
+}
@@ -0,0 +1,132 @@
+// Package health answers liveness and readiness probes. Liveness only says the process is serving requests while
+// readiness runs a set of dependency checks and reports each one individually.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Status string
+
+const (
+	Up   Status = "up"
+	Down Status = "down"
+)
+
+// Check verifies a single dependency. It returns optional details to include in the report.
+type Check func(ctx context.Context) (map[string]interface{}, error)
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name      string                 `json:"name"`
+	Status    Status                 `json:"status"`
+	LatencyMs float64                `json:"latencyMs"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Report is the outcome of every check. It is up only if every check is up.
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs the readiness checks.
+type Checker struct {
+	timeout  time.Duration
+	stopping func() bool
+	mu       sync.Mutex
+	checks   []namedCheck
+}
+
+const DefaultTimeout = 2 * time.Second
+
+// NewChecker creates a checker that reports not ready for as long as stopping returns true.
+func NewChecker(stopping func() bool) *Checker {
+	return &Checker{timeout: DefaultTimeout, stopping: stopping}
+}
+
+// Register adds a named check to the readiness report.
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Ready runs every check concurrently, each bounded by the checker's timeout.
+func (c *Checker) Ready(ctx context.Context) Report {
+	c.mu.Lock()
+	checks := make([]namedCheck, len(c.checks))
+	copy(checks, c.checks)
+	c.mu.Unlock()
+
+	report := Report{Status: Up, Checks: make([]Result, len(checks)+1)}
+
+	report.Checks[0] = Result{Name: "lifecycle", Status: Up}
+	if c.stopping != nil && c.stopping() {
+		report.Checks[0].Status = Down
+		report.Checks[0].Error = "shutting down"
+	}
+
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func(i int, nc namedCheck) {
+			defer wg.Done()
+			report.Checks[i+1] = c.run(ctx, nc)
+		}(i, nc)
+	}
+	wg.Wait()
+
+	for _, r := range report.Checks {
+		if r.Status != Up {
+			report.Status = Down
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, nc namedCheck) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	type outcome struct {
+		details map[string]interface{}
+		err     error
+	}
+	done := make(chan outcome, 1)
+
+	start := time.Now()
+	go func() {
+		details, err := nc.check(ctx)
+		done <- outcome{details: details, err: err}
+	}()
+
+	var o outcome
+	select {
+	case o = <-done:
+	case <-ctx.Done():
+		o.err = errors.WithMessage(ctx.Err(), "check timed out")
+	}
+
+	result := Result{
+		Name:      nc.name,
+		Status:    Up,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Details:   o.details,
+	}
+	if o.err != nil {
+		result.Status = Down
+		result.Error = o.err.Error()
+	}
+	return result
+}
@@ -0,0 +1,159 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+// AlertLevel describes how low a product's available stock is compared to its thresholds.
+type AlertLevel string
+
+const (
+	AlertNone    AlertLevel = "none"
+	AlertReorder AlertLevel = "reorder"
+	AlertSafety  AlertLevel = "safety"
+)
+
+func (l AlertLevel) severity() int {
+	switch l {
+	case AlertSafety:
+		return 2
+	case AlertReorder:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// StockAlert is an entity. A product whose available stock is at or below its reorder point or below its safety
+// stock. It is also the event published whenever the level of a product changes, with PreviousLevel set.
+type StockAlert struct {
+	Sku             string     `json:"sku"`
+	Level           AlertLevel `json:"level"`
+	PreviousLevel   AlertLevel `json:"previousLevel,omitempty"`
+	Available       int64      `json:"available"`
+	ReorderPoint    int64      `json:"reorderPoint"`
+	SafetyStock     int64      `json:"safetyStock"`
+	ReorderQuantity int64      `json:"reorderQuantity"`
+	Raised          time.Time  `json:"raised"`
+	Updated         time.Time  `json:"updated"`
+}
+
+// validateThresholds checks the reorder settings of a product. A threshold of zero turns its alert off.
+func validateThresholds(p Product) error {
+	if p.ReorderPoint < 0 || p.SafetyStock < 0 || p.ReorderQuantity < 0 {
+		return validation("reorder point, safety stock and reorder quantity must not be negative")
+	}
+	if p.ReorderPoint > 0 && p.SafetyStock > p.ReorderPoint {
+		return validation("safety stock %d must not be above the reorder point %d", p.SafetyStock, p.ReorderPoint)
+	}
+	return nil
+}
+
+// thresholdLevel is the level the available stock is at, ignoring hysteresis.
+func thresholdLevel(p Product) AlertLevel {
+	if p.SafetyStock > 0 && p.Available < p.SafetyStock {
+		return AlertSafety
+	}
+	if p.ReorderPoint > 0 && p.Available <= p.ReorderPoint {
+		return AlertReorder
+	}
+	return AlertNone
+}
+
+// nextAlertLevel decides the level of a product currently alerting at current. Alerts are raised or escalated as soon
+// as stock crosses a threshold, but only step down once stock has recovered past the threshold by hysteresis percent
+// of it, so that stock moving back and forth around a threshold doesn't raise a stream of alerts.
+func nextAlertLevel(p Product, current AlertLevel, hysteresis float64) AlertLevel {
+	level := thresholdLevel(p)
+	if level.severity() >= current.severity() {
+		return level
+	}
+
+	margin := func(threshold int64) int64 {
+		return int64(math.Ceil(float64(threshold) * hysteresis / 100))
+	}
+	if current == AlertSafety && p.Available < p.SafetyStock+margin(p.SafetyStock) {
+		return AlertSafety
+	}
+	if current.severity() >= AlertReorder.severity() && p.ReorderPoint > 0 &&
+		p.Available <= p.ReorderPoint+margin(p.ReorderPoint) {
+		return AlertReorder
+	}
+	return level
+}
+
+// evaluateStock compares the product against its thresholds as part of the transaction that changed it, records the
+// new alert level and publishes a StockAlert if the level changed. Kits aren't stocked, so they never raise alerts,
+// their components do.
+func (s *service) evaluateStock(ctx context.Context, product Product, tx db.Transaction) error {
+	const funcName = "evaluateStock"
+
+	if product.Kit {
+		return nil
+	}
+
+	current, err := s.repo.GetStockAlert(ctx, product.Sku, tx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	if current.Level == "" {
+		current.Level = AlertNone
+	}
+
+	level := nextAlertLevel(product, current.Level, s.settings.Get().AlertHysteresis)
+	if level == current.Level {
+		return nil
+	}
+
+	now := time.Now()
+	alert := StockAlert{
+		Sku:             product.Sku,
+		Level:           level,
+		PreviousLevel:   current.Level,
+		Available:       product.Available,
+		ReorderPoint:    product.ReorderPoint,
+		SafetyStock:     product.SafetyStock,
+		ReorderQuantity: product.ReorderQuantity,
+		Raised:          current.Raised,
+		Updated:         now,
+	}
+	if current.Level == AlertNone {
+		alert.Raised = now
+	}
+
+	log.Info().Str("func", funcName).Str("sku", product.Sku).Str("from", string(current.Level)).
+		Str("to", string(level)).Int64("available", product.Available).Msg("stock alert level changed")
+	if level == AlertNone {
+		err = s.repo.DeleteStockAlert(ctx, product.Sku, tx)
+	} else {
+		err = s.repo.SaveStockAlert(ctx, alert, tx)
+	}
+	if err != nil {
+		return errors.WithMessage(err, "failed to save stock alert")
+	}
+
+	return s.publishAlert(ctx, alert)
+}
+
+func (s *service) publishAlert(ctx context.Context, alert StockAlert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return errors.WithMessage(err, "failed to serialize stock alert")
+	}
+	if err = s.bq.Publish(ctx, s.exchanges.Alert, body); err != nil {
+		return errors.WithMessage(err, "failed to publish stock alert")
+	}
+	return nil
+}
+
+func (s *service) GetStockAlerts(ctx context.Context, limit, offset int) ([]StockAlert, error) {
+	return s.repo.GetStockAlerts(ctx, limit, offset)
+}
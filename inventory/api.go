@@ -2,7 +2,6 @@ package inventory
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
@@ -10,6 +9,7 @@ import (
 	"github.com/sksmith/smfg-inventory/api"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -26,24 +26,97 @@ func NewApi (service Service) *Api {
 func (a *Api) ConfigureRouter(r chi.Router) {
 	r.With(api.Paginate).Get("/", a.List)
 	r.Post("/", a.Create)
+	r.With(api.Paginate).Get("/alerts", a.ListAlerts)
+	r.With(api.Paginate).Get("/replenishment", a.ListReplenishment)
+	r.With(api.Paginate).Get("/backorders", a.ListBackorders)
+	r.Get("/serial/{serial}", a.GetSerial)
+
+	r.Route("/location", func(r chi.Router) {
+		r.Get("/", a.ListLocations)
+		r.Post("/", a.CreateLocation)
+	})
+
+	r.Route("/cycleCount", func(r chi.Router) {
+		r.With(api.Paginate).Get("/", a.ListCycleCounts)
+		r.Post("/", a.CreateCycleCount)
+
+		r.Route("/{countID}", func(r chi.Router) {
+			r.Use(a.CycleCountCtx)
+			r.Get("/", a.GetCycleCount)
+			r.Post("/counts", a.RecordCounts)
+			r.Post("/approval", a.ApproveCycleCount)
+		})
+	})
+
+	r.Route("/salesOrder", func(r chi.Router) {
+		r.Post("/", a.CreateSalesOrder)
+		r.With(a.SalesOrderCtx).Get("/{orderID}", a.GetSalesOrder)
+	})
 
 	r.Route("/{sku}", func(r chi.Router) {
 		r.Use(a.ProductCtx)
+		r.Get("/", a.Get)
+		r.Put("/", a.Update)
 		r.Post("/productionEvent", a.CreateProductionEvent)
+		r.Post("/adjustment", a.CreateAdjustment)
+		r.Post("/statusChange", a.CreateStatusChange)
+		r.Get("/replenishment", a.GetReplenishment)
+		r.Get("/atp", a.GetATP)
+		r.Get("/bom", a.GetBom)
+		r.Put("/bom", a.SaveBom)
+		r.Get("/units", a.GetUnits)
+		r.Put("/units", a.SaveUnits)
+		r.Get("/location", a.GetLocationStock)
+
+		r.Route("/productionOrder", func(r chi.Router) {
+			r.Get("/", a.ListProductionOrders)
+			r.Post("/", a.CreateProductionOrder)
+
+			r.Route("/{orderID}", func(r chi.Router) {
+				r.Use(a.ProductionOrderCtx)
+				r.Get("/", a.GetProductionOrder)
+				r.Post("/status", a.ChangeProductionOrderStatus)
+			})
+		})
 
 		r.Route("/reservation", func(r chi.Router) {
 			r.Post("/", a.CreateReservation)
 
 			r.Route("/{reservationID}", func(r chi.Router) {
 				r.Use(a.ReservationCtx)
+				r.Get("/", a.GetReservation)
+				r.Patch("/", a.ChangeReservation)
 				r.Delete("/", a.CancelReservation)
 			})
 		})
+
+		r.Route("/transfer", func(r chi.Router) {
+			r.Post("/", a.ShipTransfer)
+
+			r.Route("/{transferID}", func(r chi.Router) {
+				r.Use(a.TransferCtx)
+				r.Get("/", a.GetTransfer)
+				r.Post("/receipt", a.ReceiveTransfer)
+			})
+		})
+
+		r.Route("/return", func(r chi.Router) {
+			r.Post("/", a.CreateReturn)
+
+			r.Route("/{returnID}", func(r chi.Router) {
+				r.Use(a.ReturnCtx)
+				r.Get("/", a.GetReturn)
+				r.Post("/receipt", a.ReceiveReturn)
+			})
+		})
 	})
 }
 
 type ProductResponse struct {
 	Product
+
+	// Unit is set when the quantities are shown in a unit other than the base unit
+	Unit string `json:"unit,omitempty"`
 }
 
 func NewProductResponse(product Product) *ProductResponse {
@@ -56,8 +129,87 @@ func (rd *ProductResponse) Render(_ http.ResponseWriter, _ *http.Request) error
 	return nil
 }
 
+// ETag identifies the version of the product the response was built from, and the unit its quantities are shown in so
+// that a representation in one unit is never taken for another.
+func (rd *ProductResponse) ETag() string {
+	etag := productETag(rd.Product)
+	if rd.Unit == "" {
+		return etag
+	}
+	return strings.TrimSuffix(etag, `"`) + ";" + rd.Unit + `"`
+}
+
+func (a *Api) Get(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+	resp := NewProductResponse(product)
+
+	var err error
+	if resp.Unit, err = a.inUnit(r, product.Sku, &resp.Available, &resp.Reserved); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", resp.ETag())
+	if match := r.Header.Get("If-None-Match"); match != "" && api.MatchesETag(match, resp.ETag()) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	api.Render(w, r, resp)
+}
+
+func (a *Api) Update(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	if err := checkIfMatch(r, product, true); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	data := &UpdateProductRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	updated, err := a.service.UpdateProduct(r.Context(), product, *data.Product)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	resp := NewProductResponse(updated)
+	w.Header().Set("ETag", resp.ETag())
+	api.Render(w, r, resp)
+}
+
+// checkIfMatch enforces optimistic concurrency for requests that change a product. The saved product is versioned
+// as well, so a change that races past this check is still rejected when it is persisted.
+func checkIfMatch(r *http.Request, product Product, required bool) error {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		if required {
+			return preconditionMissing("an If-Match header is required to change product %s", product.Sku)
+		}
+		return nil
+	}
+	if !api.MatchesETag(match, productETag(product)) {
+		return preconditionFailed("product %s has changed, it is now at version %d", product.Sku, product.Version)
+	}
+	return nil
+}
+
+// productETag is the entity tag of a product. A kit's available quantity is derived from its components rather than
+// stored, so it changes without the kit's version and is part of the tag as well.
+func productETag(product Product) string {
+	if product.Kit {
+		return `"` + strconv.FormatInt(product.Version, 10) + "-" + strconv.FormatInt(product.Available, 10) + `"`
+	}
+	return api.ETag(product.Version)
+}
+
 func (a *Api) List(w http.ResponseWriter, r *http.Request) {
-	limit, offset, err := getLimitAndOffset(r)
+	limit, offset, err := api.GetLimitAndOffset(r, DefaultPageLimit)
 	if err != nil {
 		api.Render(w, r, api.ErrInvalidRequest(err))
 		return
@@ -65,14 +217,132 @@ func (a *Api) List(w http.ResponseWriter, r *http.Request) {
 
 	products, err := a.service.GetAllProducts(r.Context(), limit, offset)
 	if err != nil {
-		log.Err(err).Send()
-		api.Render(w, r, api.ErrInternalServerError())
+		renderError(w, r, err)
 		return
 	}
 
 	api.RenderList(w, r, NewProductListResponse(products))
 }
 
+// ListAlerts shows the products that are currently at or below their reorder point or below their safety stock.
+func (a *Api) ListAlerts(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := api.GetLimitAndOffset(r, DefaultPageLimit)
+	if err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	alerts, err := a.service.GetStockAlerts(r.Context(), limit, offset)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	list := make([]render.Renderer, 0, len(alerts))
+	for _, alert := range alerts {
+		list = append(list, &StockAlertResponse{StockAlert: alert})
+	}
+	api.RenderList(w, r, list)
+}
+
+type StockAlertResponse struct {
+	StockAlert
+}
+
+func (s *StockAlertResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+// GetReplenishment suggests how much of the product to produce based on its recent demand.
+func (a *Api) GetReplenishment(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	rep, err := a.service.GetReplenishment(r.Context(), product)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, &ReplenishmentResponse{Replenishment: rep})
+}
+
+// ListReplenishment shows the suggestions from the latest nightly replenishment report, largest first.
+func (a *Api) ListReplenishment(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := api.GetLimitAndOffset(r, DefaultPageLimit)
+	if err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	report, err := a.service.GetReplenishmentReport(r.Context(), limit, offset)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	list := make([]render.Renderer, 0, len(report))
+	for _, rep := range report {
+		list = append(list, &ReplenishmentResponse{Replenishment: rep})
+	}
+	api.RenderList(w, r, list)
+}
+
+// ListBackorders shows what each requester is waiting on of each product and when it should be filled.
+func (a *Api) ListBackorders(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := api.GetLimitAndOffset(r, DefaultPageLimit)
+	if err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	report, err := a.service.GetBackorderReport(r.Context(), limit, offset)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	list := make([]render.Renderer, 0, len(report))
+	for _, b := range report {
+		list = append(list, &BackorderResponse{Backorder: b})
+	}
+	api.RenderList(w, r, list)
+}
+
+// GetSerial shows a serialized unit and everything that happened to it.
+func (a *Api) GetSerial(w http.ResponseWriter, r *http.Request) {
+	history, err := a.service.GetSerial(r.Context(), chi.URLParam(r, "serial"))
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, &SerialResponse{SerialHistory: history})
+}
+
+type SerialResponse struct {
+	SerialHistory
+}
+
+func (sr *SerialResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+type ReplenishmentResponse struct {
+	Replenishment
+}
+
+func (rr *ReplenishmentResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+type BackorderResponse struct {
+	Backorder
+}
+
+func (br *BackorderResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
 func (a *Api) Create(w http.ResponseWriter, r *http.Request) {
 	data := &CreateProductRequest{}
 	if err := render.Bind(r, data); err != nil {
@@ -81,8 +351,7 @@ func (a *Api) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := a.service.CreateProduct(r.Context(), *data.Product); err != nil {
-		log.Err(err).Send()
-		api.Render(w, r, api.ErrInternalServerError())
+		renderError(w, r, err)
 		return
 	}
 }
@@ -101,13 +370,10 @@ func (a *Api) ProductCtx(next http.Handler) http.Handler {
 		product, err = a.service.GetProduct(r.Context(), sku)
 
 		if err != nil {
-			// TODO Create a new custom error, don't use low level errors at this high level
-			if errors.Is(err, sql.ErrNoRows) {
-				api.Render(w, r, api.ErrNotFound)
-			} else {
+			if !errors.Is(err, ErrNotFound) {
 				log.Error().Err(err).Str("sku", sku).Msg("error acquiring product")
-				api.Render(w, r, api.ErrInternalServerError())
 			}
+			renderError(w, r, err)
 			return
 		}
 
@@ -130,6 +396,10 @@ type CreateProductRequest struct {
 	// we don't want to allow setting quantities upon creation of a product
 	ProtectedReserved int `json:"reserved"`
 	ProtectedAvailable int `json:"available"`
+	ProtectedQuarantine int `json:"quarantine"`
+	ProtectedDamaged int `json:"damaged"`
+	ProtectedInTransit int `json:"inTransit"`
+	ProtectedVersion int64 `json:"version"`
 }
 
 func (p *CreateProductRequest) Bind(_ *http.Request) error {
@@ -140,17 +410,47 @@ func (p *CreateProductRequest) Bind(_ *http.Request) error {
 	return nil
 }
 
+type UpdateProductRequest struct {
+	*Product
+
+	// quantities can't be changed directly and the sku is set through the URL
+	ProtectedSku        string `json:"sku"`
+	ProtectedReserved   int    `json:"reserved"`
+	ProtectedAvailable  int    `json:"available"`
+	ProtectedQuarantine int    `json:"quarantine"`
+	ProtectedDamaged    int    `json:"damaged"`
+	ProtectedInTransit  int    `json:"inTransit"`
+	ProtectedVersion    int64  `json:"version"`
+}
+
+func (p *UpdateProductRequest) Bind(_ *http.Request) error {
+	if p.Product == nil || p.Upc == "" || p.Name == "" {
+		return errors.New("missing required field(s)")
+	}
+
+	return nil
+}
+
 type CreateProductionEventRequest struct {
 	*ProductionEvent
 
 	ProtectedID uint64 `json:"id"`
 	ProtectedCreated time.Time `json:"created"`
+
+	// the outcome of a backflush is calculated
+	ProtectedBomVersion int              `json:"bomVersion"`
+	ProtectedConsumed   []ComponentUsage `json:"consumed"`
+	ProtectedShortages  []Shortage       `json:"shortages"`
 }
 
-func (p *CreateProductionEventRequest) Bind(_ *http.Request) error {
+func (p *CreateProductionEventRequest) Bind(r *http.Request) error {
 	if p.ProductionEvent == nil {
 		return errors.New("missing required ProductionEvent fields")
 	}
+	var err error
+	if p.RequestID, err = bindRequestID(r, p.RequestID); err != nil {
+		return err
+	}
 	if p.RequestID == "" {
 		return errors.New("requestId is required")
 	}
@@ -194,12 +494,23 @@ type ReservationRequest struct {
 
 	// Created is calculated
 	ProtectedCreated time.Time `json:"created"`
+
+	// Serials are allocated as the reservation is filled
+	ProtectedSerials []string `json:"serials"`
+
+	// Backordered and EstimatedFill are set when the reservation can't be filled straight away
+	ProtectedBackordered   bool       `json:"backordered"`
+	ProtectedEstimatedFill *time.Time `json:"estimatedFill"`
 }
 
-func (r *ReservationRequest) Bind(_ *http.Request) error {
+func (r *ReservationRequest) Bind(req *http.Request) error {
 	if r.Reservation == nil {
 		return errors.New("missing required Reservation fields")
 	}
+	var err error
+	if r.RequestID, err = bindRequestID(req, r.RequestID); err != nil {
+		return err
+	}
 	if r.Requester == "" {
 		return errors.New("requester is required")
 	}
@@ -236,8 +547,7 @@ func (a *Api) CreateProductionEvent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := a.service.Produce(r.Context(), product, data.ProductionEvent); err != nil {
-		log.Err(err).Send()
-		api.Render(w, r, api.ErrInternalServerError())
+		renderError(w, r, err)
 		return
 	}
 
@@ -247,63 +557,1161 @@ func (a *Api) CreateProductionEvent(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
-func getLimitAndOffset(r *http.Request) (limit, offset int, err error) {
-	limitStr := r.URL.Query().Get("limit")
-	offsetStr := r.URL.Query().Get("offset")
+// GetReservation shows a reservation, with its quantities in the unit given by the uom query parameter if there is one.
+func (a *Api) GetReservation(w http.ResponseWriter, r *http.Request) {
+	res := r.Context().Value("reservation").(Reservation)
 
-	limit = DefaultPageLimit
-	if limitStr != "" {
-		limit, err = strconv.Atoi(limitStr)
-		if err != nil {
-			return 0, 0, err
-		}
+	var err error
+	if res.Unit, err = a.inUnit(r, res.Sku, &res.RequestedQuantity, &res.ReservedQuantity,
+		&res.ShippedQuantity); err != nil {
+		renderError(w, r, err)
+		return
 	}
 
-	if offsetStr != "" {
-		offset, err = strconv.Atoi(offsetStr)
-		if err != nil {
-			return 0, 0, err
-		}
+	api.Render(w, r, &ReservationResponse{Reservation: &res})
+}
+
+func (a *Api) CancelReservation(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+	res := r.Context().Value("reservation").(Reservation)
+
+	if err := a.service.CancelReservation(r.Context(), product, &res); err != nil {
+		renderError(w, r, err)
+		return
 	}
 
-	return limit, offset, nil
+	api.Render(w, r, &ReservationResponse{Reservation: &res})
 }
 
-func (a *Api) CancelReservation(_ http.ResponseWriter, _ *http.Request) {
-	// Not implemented
-	return
+type ChangeReservationRequest struct {
+	RequestedQuantity *int64 `json:"requestedQuantity"`
+	Unit              string `json:"unit,omitempty"`
+}
+
+func (c *ChangeReservationRequest) Bind(_ *http.Request) error {
+	if c.RequestedQuantity == nil {
+		return errors.New("requestedQuantity is required")
+	}
+	if *c.RequestedQuantity < 1 {
+		return errors.New("requestedQuantity must be greater than zero")
+	}
+
+	return nil
+}
+
+// ChangeReservation changes the quantity requested by a reservation.
+func (a *Api) ChangeReservation(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+	res := r.Context().Value("reservation").(Reservation)
+
+	data := &ChangeReservationRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.ChangeReservation(r.Context(), product, &res, *data.RequestedQuantity, data.Unit); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, &ReservationResponse{Reservation: &res})
 }
 
 func (a *Api) ReservationCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Not implemented
-		ctx := context.WithValue(r.Context(), "reservation", nil)
+		product := r.Context().Value("product").(Product)
+
+		id, err := strconv.ParseUint(chi.URLParam(r, "reservationID"), 10, 64)
+		if err != nil {
+			api.Render(w, r, api.ErrInvalidRequest(errors.New("reservation id must be numeric")))
+			return
+		}
+
+		res, err := a.service.GetReservation(r.Context(), id)
+		if err == nil && res.Sku != product.Sku {
+			err = notFound(CodeReservationNotFound, nil, "reservation %d not found for sku %s", id, product.Sku)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Error().Err(err).Uint64("id", id).Msg("error acquiring reservation")
+			}
+			renderError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "reservation", res)
 		next.ServeHTTP(w, r.WithContext(ctx))
-		return
 	})
 }
 
-func (a *Api) CreateReservation(w http.ResponseWriter, r *http.Request) {
+type CreateAdjustmentRequest struct {
+	*Adjustment
+
+	ProtectedID      uint64    `json:"id"`
+	ProtectedSku     string    `json:"sku"`
+	ProtectedCreated time.Time `json:"created"`
+}
+
+func (p *CreateAdjustmentRequest) Bind(r *http.Request) error {
+	if p.Adjustment == nil {
+		return errors.New("missing required Adjustment fields")
+	}
+	var err error
+	if p.RequestID, err = bindRequestID(r, p.RequestID); err != nil {
+		return err
+	}
+	if p.RequestID == "" {
+		return errors.New("requestId is required")
+	}
+	if p.Quantity == 0 {
+		return errors.New("quantity must not be zero")
+	}
+	if p.Reason == "" {
+		return errors.New("reason is required")
+	}
+
+	return nil
+}
+
+type AdjustmentResponse struct {
+	*Adjustment
+}
+
+func (p *AdjustmentResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (a *Api) CreateAdjustment(w http.ResponseWriter, r *http.Request) {
 	product := r.Context().Value("product").(Product)
 
-	data := &ReservationRequest{}
+	if err := checkIfMatch(r, product, false); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	data := &CreateAdjustmentRequest{}
 	if err := render.Bind(r, data); err != nil {
 		api.Render(w, r, api.ErrInvalidRequest(err))
 		return
 	}
 
-	data.Sku = product.Sku
+	if err := a.service.Adjust(r.Context(), product, data.Adjustment); err != nil {
+		renderError(w, r, err)
+		return
+	}
 
-	err := a.service.Reserve(r.Context(), product, data.Reservation)
-	if err != nil {
-		log.Err(err).Send()
-		api.Render(w, r, api.ErrInternalServerError())
+	render.Status(r, http.StatusCreated)
+	api.Render(w, r, &AdjustmentResponse{data.Adjustment})
+}
+
+type CreateStatusChangeRequest struct {
+	*StatusChange
+
+	ProtectedID      uint64    `json:"id"`
+	ProtectedSku     string    `json:"sku"`
+	ProtectedCreated time.Time `json:"created"`
+}
+
+func (p *CreateStatusChangeRequest) Bind(r *http.Request) error {
+	if p.StatusChange == nil {
+		return errors.New("missing required StatusChange fields")
+	}
+	var err error
+	if p.RequestID, err = bindRequestID(r, p.RequestID); err != nil {
+		return err
+	}
+	if p.RequestID == "" {
+		return errors.New("requestId is required")
+	}
+	if p.Quantity < 1 {
+		return errors.New("quantity must be greater than zero")
+	}
+	if p.From == "" || p.To == "" {
+		return errors.New("from and to are required")
+	}
+	if p.Reason == "" {
+		return errors.New("reason is required")
+	}
+
+	return nil
+}
+
+type StatusChangeResponse struct {
+	*StatusChange
+}
+
+func (p *StatusChangeResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+// CreateStatusChange moves stock of the product between buckets, e.g. releasing quarantined production after QC or
+// rejecting it as damaged.
+func (a *Api) CreateStatusChange(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	if err := checkIfMatch(r, product, false); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	data := &CreateStatusChangeRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.ChangeStatus(r.Context(), product, data.StatusChange); err != nil {
+		renderError(w, r, err)
 		return
 	}
 
-	resp := &ReservationResponse{Reservation: data.Reservation}
 	render.Status(r, http.StatusCreated)
-	api.Render(w, r, resp)
+	api.Render(w, r, &StatusChangeResponse{data.StatusChange})
+}
+
+func (a *Api) CreateReservation(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	data := &ReservationRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	data.Sku = product.Sku
+
+	err := a.service.Reserve(r.Context(), product, data.Reservation)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	resp := &ReservationResponse{Reservation: data.Reservation}
+	render.Status(r, http.StatusCreated)
+	api.Render(w, r, resp)
 
 	return
-}
\ No newline at end of file
+}
+
+// GetATP shows when the quantity given by the qty query parameter can be promised.
+func (a *Api) GetATP(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	qty, err := strconv.ParseInt(r.URL.Query().Get("qty"), 10, 64)
+	if err != nil || qty < 1 {
+		api.Render(w, r, api.ErrInvalidRequest(errors.New("qty must be a number greater than zero")))
+		return
+	}
+
+	atp, err := a.service.GetATP(r.Context(), product, qty)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, &ATPResponse{ATP: atp})
+}
+
+type ATPResponse struct {
+	ATP
+}
+
+func (rd *ATPResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+type ProductionOrderRequest struct {
+	*ProductionOrder
+
+	ProtectedID       uint64    `json:"id"`
+	ProtectedSku      string    `json:"sku"`
+	ProtectedStatus   string    `json:"status"`
+	ProtectedProduced int64     `json:"produced"`
+	ProtectedCreated  time.Time `json:"created"`
+	ProtectedUpdated  time.Time `json:"updated"`
+}
+
+func (p *ProductionOrderRequest) Bind(_ *http.Request) error {
+	if p.ProductionOrder == nil {
+		return errors.New("missing required ProductionOrder fields")
+	}
+	if p.TargetQuantity < 1 {
+		return errors.New("targetQuantity must be greater than zero")
+	}
+	if p.Due.IsZero() {
+		return errors.New("due is required")
+	}
+
+	return nil
+}
+
+type ProductionOrderStatusRequest struct {
+	Status OrderStatus `json:"status"`
+}
+
+func (p *ProductionOrderStatusRequest) Bind(_ *http.Request) error {
+	if p.Status == "" {
+		return errors.New("status is required")
+	}
+
+	return nil
+}
+
+type ProductionOrderResponse struct {
+	*ProductionOrder
+	Completion float64 `json:"completion"`
+	Variance   int64   `json:"variance"`
+}
+
+func NewProductionOrderResponse(order *ProductionOrder) *ProductionOrderResponse {
+	return &ProductionOrderResponse{ProductionOrder: order, Completion: order.Completion(), Variance: order.Variance()}
+}
+
+func (p *ProductionOrderResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (a *Api) CreateProductionOrder(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	data := &ProductionOrderRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.CreateProductionOrder(r.Context(), product, data.ProductionOrder); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	api.Render(w, r, NewProductionOrderResponse(data.ProductionOrder))
+}
+
+// ListProductionOrders shows the orders for the product that are still expected to produce.
+func (a *Api) ListProductionOrders(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	orders, err := a.service.GetProductionOrders(r.Context(), product.Sku)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	list := make([]render.Renderer, 0, len(orders))
+	for i := range orders {
+		list = append(list, NewProductionOrderResponse(&orders[i]))
+	}
+	api.RenderList(w, r, list)
+}
+
+func (a *Api) GetProductionOrder(w http.ResponseWriter, r *http.Request) {
+	order := r.Context().Value("order").(ProductionOrder)
+	api.Render(w, r, NewProductionOrderResponse(&order))
+}
+
+// ChangeProductionOrderStatus releases, starts, completes or cancels a production order.
+func (a *Api) ChangeProductionOrderStatus(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+	order := r.Context().Value("order").(ProductionOrder)
+
+	data := &ProductionOrderStatusRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.ChangeProductionOrderStatus(r.Context(), product, &order, data.Status); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, NewProductionOrderResponse(&order))
+}
+
+func (a *Api) ProductionOrderCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		product := r.Context().Value("product").(Product)
+
+		id, err := strconv.ParseUint(chi.URLParam(r, "orderID"), 10, 64)
+		if err != nil {
+			api.Render(w, r, api.ErrInvalidRequest(errors.New("production order id must be numeric")))
+			return
+		}
+
+		order, err := a.service.GetProductionOrder(r.Context(), id)
+		if err == nil && order.Sku != product.Sku {
+			err = notFound(CodeOrderNotFound, nil, "production order %d not found for sku %s", id, product.Sku)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Error().Err(err).Uint64("id", id).Msg("error acquiring production order")
+			}
+			renderError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "order", order)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// GetBom shows the latest bill of materials of the product, or the one given by the version query parameter.
+func (a *Api) GetBom(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	version := 0
+	if v := r.URL.Query().Get("version"); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil || version < 1 {
+			api.Render(w, r, api.ErrInvalidRequest(errors.New("version must be a number greater than zero")))
+			return
+		}
+	}
+
+	bom, err := a.service.GetBom(r.Context(), product.Sku, version)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, &BomResponse{&bom})
+}
+
+// SaveBom replaces the bill of materials of the product with a new version.
+func (a *Api) SaveBom(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	data := &BomRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	bom := &BillOfMaterials{Components: data.Components}
+	if err := a.service.SaveBom(r.Context(), product, bom); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, &BomResponse{bom})
+}
+
+type BomRequest struct {
+	Components []BomComponent `json:"components"`
+}
+
+func (b *BomRequest) Bind(_ *http.Request) error {
+	if len(b.Components) == 0 {
+		return errors.New("components are required")
+	}
+
+	return nil
+}
+
+type BomResponse struct {
+	*BillOfMaterials
+}
+
+func (b *BomResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+// inUnit converts quantities kept in the base unit to the unit given by the uom query parameter and returns the unit
+// they are now in. Without the parameter the quantities are left as they are.
+func (a *Api) inUnit(r *http.Request, sku string, quantities ...*int64) (string, error) {
+	unit := r.URL.Query().Get("uom")
+	if unit == "" || unit == BaseUnit {
+		return "", nil
+	}
+	units, err := a.service.GetUnits(r.Context(), sku)
+	if err != nil {
+		return "", err
+	}
+	for _, q := range quantities {
+		if *q, err = units.FromBase(sku, unit, *q); err != nil {
+			return "", err
+		}
+	}
+	return unit, nil
+}
+
+// GetUnits shows the units of measure of the product.
+func (a *Api) GetUnits(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	units, err := a.service.GetUnits(r.Context(), product.Sku)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, &UnitsResponse{Sku: product.Sku, Base: BaseUnit, Units: units})
+}
+
+// SaveUnits replaces the units of measure of the product.
+func (a *Api) SaveUnits(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	data := &UnitsRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.SaveUnits(r.Context(), product, data.Units); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, &UnitsResponse{Sku: product.Sku, Base: BaseUnit, Units: data.Units})
+}
+
+type UnitsRequest struct {
+	Units Units `json:"units"`
+}
+
+func (u *UnitsRequest) Bind(_ *http.Request) error {
+	if u.Units == nil {
+		u.Units = Units{}
+	}
+
+	return nil
+}
+
+type UnitsResponse struct {
+	Sku   string `json:"sku"`
+	Base  string `json:"base"`
+	Units Units  `json:"units"`
+}
+
+func (u *UnitsResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+type LocationRequest struct {
+	*Location
+
+	ProtectedCreated time.Time `json:"created"`
+}
+
+func (p *LocationRequest) Bind(_ *http.Request) error {
+	if p.Location == nil || p.Code == "" || p.Name == "" {
+		return errors.New("missing required field(s)")
+	}
+
+	return nil
+}
+
+type LocationResponse struct {
+	*Location
+}
+
+func (p *LocationResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+type LocationStockResponse struct {
+	LocationStock
+}
+
+func (p *LocationStockResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (a *Api) ListLocations(w http.ResponseWriter, r *http.Request) {
+	locations, err := a.service.GetLocations(r.Context())
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	list := make([]render.Renderer, 0, len(locations))
+	for i := range locations {
+		list = append(list, &LocationResponse{&locations[i]})
+	}
+	api.RenderList(w, r, list)
+}
+
+// CreateLocation adds a location stock can be transferred to.
+func (a *Api) CreateLocation(w http.ResponseWriter, r *http.Request) {
+	data := &LocationRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.CreateLocation(r.Context(), data.Location); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	api.Render(w, r, &LocationResponse{data.Location})
+}
+
+// GetLocationStock shows how the available stock of the product is split between the locations.
+func (a *Api) GetLocationStock(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	stock, err := a.service.GetLocationStock(r.Context(), product)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	list := make([]render.Renderer, 0, len(stock))
+	for _, ls := range stock {
+		list = append(list, &LocationStockResponse{ls})
+	}
+	api.RenderList(w, r, list)
+}
+
+type ShipTransferRequest struct {
+	*Transfer
+
+	ProtectedID          uint64            `json:"id"`
+	ProtectedSku         string            `json:"sku"`
+	ProtectedReceived    int64             `json:"received"`
+	ProtectedDiscrepancy int64             `json:"discrepancy"`
+	ProtectedStatus      TransferStatus    `json:"status"`
+	ProtectedReceipts    []TransferReceipt `json:"receipts"`
+	ProtectedCreated     time.Time         `json:"created"`
+	ProtectedUpdated     time.Time         `json:"updated"`
+}
+
+func (p *ShipTransferRequest) Bind(r *http.Request) error {
+	if p.Transfer == nil {
+		return errors.New("missing required Transfer fields")
+	}
+	var err error
+	if p.RequestID, err = bindRequestID(r, p.RequestID); err != nil {
+		return err
+	}
+	if p.RequestID == "" {
+		return errors.New("requestId is required")
+	}
+	if p.From == "" || p.To == "" {
+		return errors.New("from and to are required")
+	}
+	if p.Quantity < 1 {
+		return errors.New("quantity must be greater than zero")
+	}
+
+	return nil
+}
+
+type TransferReceiptRequest struct {
+	*TransferReceipt
+
+	ProtectedID          uint64    `json:"id"`
+	ProtectedTransferID  uint64    `json:"transferId"`
+	ProtectedSku         string    `json:"sku"`
+	ProtectedDiscrepancy int64     `json:"discrepancy"`
+	ProtectedCreated     time.Time `json:"created"`
+}
+
+func (p *TransferReceiptRequest) Bind(r *http.Request) error {
+	if p.TransferReceipt == nil {
+		return errors.New("missing required TransferReceipt fields")
+	}
+	var err error
+	if p.RequestID, err = bindRequestID(r, p.RequestID); err != nil {
+		return err
+	}
+	if p.RequestID == "" {
+		return errors.New("requestId is required")
+	}
+	if p.Quantity < 0 || (p.Quantity == 0 && !p.Close) {
+		return errors.New("quantity must be greater than zero")
+	}
+
+	return nil
+}
+
+type TransferResponse struct {
+	*Transfer
+}
+
+func (p *TransferResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+type TransferReceiptResponse struct {
+	*TransferReceipt
+}
+
+func (p *TransferReceiptResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+// ShipTransfer sends available stock of the product from one location to another.
+func (a *Api) ShipTransfer(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	if err := checkIfMatch(r, product, false); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	data := &ShipTransferRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.ShipTransfer(r.Context(), product, data.Transfer); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	api.Render(w, r, &TransferResponse{data.Transfer})
+}
+
+func (a *Api) GetTransfer(w http.ResponseWriter, r *http.Request) {
+	transfer := r.Context().Value("transfer").(Transfer)
+	api.Render(w, r, &TransferResponse{&transfer})
+}
+
+// ReceiveTransfer takes stock of a transfer in at its destination, optionally closing it with a discrepancy.
+func (a *Api) ReceiveTransfer(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+	transfer := r.Context().Value("transfer").(Transfer)
+
+	if err := checkIfMatch(r, product, false); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	data := &TransferReceiptRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.ReceiveTransfer(r.Context(), product, &transfer, data.TransferReceipt); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	api.Render(w, r, &TransferReceiptResponse{data.TransferReceipt})
+}
+
+func (a *Api) TransferCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		product := r.Context().Value("product").(Product)
+
+		id, err := strconv.ParseUint(chi.URLParam(r, "transferID"), 10, 64)
+		if err != nil {
+			api.Render(w, r, api.ErrInvalidRequest(errors.New("transfer id must be numeric")))
+			return
+		}
+
+		transfer, err := a.service.GetTransfer(r.Context(), id)
+		if err == nil && transfer.Sku != product.Sku {
+			err = notFound(CodeTransferNotFound, nil, "transfer %d not found for sku %s", id, product.Sku)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Error().Err(err).Uint64("id", id).Msg("error acquiring transfer")
+			}
+			renderError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "transfer", transfer)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type CycleCountRequest struct {
+	Method   CountMethod `json:"method"`
+	Class    string      `json:"class"`
+	Location string      `json:"location"`
+	Size     int         `json:"size"`
+}
+
+func (c *CycleCountRequest) Bind(_ *http.Request) error {
+	if c.Method == "" {
+		return errors.New("method is required")
+	}
+
+	return nil
+}
+
+type CountsRequest struct {
+	Counts []Count `json:"counts"`
+}
+
+func (c *CountsRequest) Bind(_ *http.Request) error {
+	if len(c.Counts) == 0 {
+		return errors.New("counts are required")
+	}
+
+	return nil
+}
+
+type ApprovalRequest struct {
+	Approved *bool `json:"approved"`
+}
+
+func (c *ApprovalRequest) Bind(_ *http.Request) error {
+	if c.Approved == nil {
+		return errors.New("approved is required")
+	}
+
+	return nil
+}
+
+type CycleCountResponse struct {
+	*CycleCount
+}
+
+func (c *CycleCountResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+// CreateCycleCount makes a new count list.
+func (a *Api) CreateCycleCount(w http.ResponseWriter, r *http.Request) {
+	data := &CycleCountRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	count := &CycleCount{Method: data.Method, Class: data.Class, Location: data.Location}
+	if err := a.service.CreateCycleCount(r.Context(), count, data.Size); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	api.Render(w, r, &CycleCountResponse{count})
+}
+
+// ListCycleCounts shows the cycle counts, newest first.
+func (a *Api) ListCycleCounts(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := api.GetLimitAndOffset(r, DefaultPageLimit)
+	if err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	counts, err := a.service.GetCycleCounts(r.Context(), limit, offset)
+	if err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	list := make([]render.Renderer, 0, len(counts))
+	for i := range counts {
+		list = append(list, &CycleCountResponse{&counts[i]})
+	}
+	api.RenderList(w, r, list)
+}
+
+func (a *Api) GetCycleCount(w http.ResponseWriter, r *http.Request) {
+	count := r.Context().Value("count").(CycleCount)
+	api.Render(w, r, &CycleCountResponse{&count})
+}
+
+// RecordCounts takes the counted quantities of products on the count.
+func (a *Api) RecordCounts(w http.ResponseWriter, r *http.Request) {
+	count := r.Context().Value("count").(CycleCount)
+
+	data := &CountsRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.RecordCounts(r.Context(), &count, data.Counts); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, &CycleCountResponse{&count})
+}
+
+// ApproveCycleCount approves or rejects the variances of the count waiting for approval.
+func (a *Api) ApproveCycleCount(w http.ResponseWriter, r *http.Request) {
+	count := r.Context().Value("count").(CycleCount)
+
+	data := &ApprovalRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.ApproveCycleCount(r.Context(), &count, *data.Approved); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	api.Render(w, r, &CycleCountResponse{&count})
+}
+
+func (a *Api) CycleCountCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "countID"), 10, 64)
+		if err != nil {
+			api.Render(w, r, api.ErrInvalidRequest(errors.New("cycle count id must be numeric")))
+			return
+		}
+
+		count, err := a.service.GetCycleCount(r.Context(), id)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Error().Err(err).Uint64("id", id).Msg("error acquiring cycle count")
+			}
+			renderError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "count", count)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type CreateReturnRequest struct {
+	*Return
+
+	ProtectedID        uint64          `json:"id"`
+	ProtectedSku       string          `json:"sku"`
+	ProtectedRequester string          `json:"requester"`
+	ProtectedReceived  int64           `json:"received"`
+	ProtectedStatus    ReturnStatus    `json:"status"`
+	ProtectedReceipts  []ReturnReceipt `json:"receipts"`
+	ProtectedCreated   time.Time       `json:"created"`
+	ProtectedUpdated   time.Time       `json:"updated"`
+}
+
+func (p *CreateReturnRequest) Bind(r *http.Request) error {
+	if p.Return == nil {
+		return errors.New("missing required Return fields")
+	}
+	var err error
+	if p.RequestID, err = bindRequestID(r, p.RequestID); err != nil {
+		return err
+	}
+	if p.RequestID == "" {
+		return errors.New("requestId is required")
+	}
+	if p.ReservationID == 0 {
+		return errors.New("reservationId is required")
+	}
+	if p.Quantity < 1 {
+		return errors.New("quantity must be greater than zero")
+	}
+
+	return nil
+}
+
+type ReturnReceiptRequest struct {
+	*ReturnReceipt
+
+	ProtectedID       uint64    `json:"id"`
+	ProtectedReturnID uint64    `json:"returnId"`
+	ProtectedSku      string    `json:"sku"`
+	ProtectedCreated  time.Time `json:"created"`
+}
+
+func (p *ReturnReceiptRequest) Bind(r *http.Request) error {
+	if p.ReturnReceipt == nil {
+		return errors.New("missing required ReturnReceipt fields")
+	}
+	var err error
+	if p.RequestID, err = bindRequestID(r, p.RequestID); err != nil {
+		return err
+	}
+	if p.RequestID == "" {
+		return errors.New("requestId is required")
+	}
+	if p.Quantity < 1 {
+		return errors.New("quantity must be greater than zero")
+	}
+	if p.Disposition == "" {
+		return errors.New("disposition is required")
+	}
+
+	return nil
+}
+
+type ReturnResponse struct {
+	*Return
+}
+
+func (p *ReturnResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+type ReturnReceiptResponse struct {
+	*ReturnReceipt
+}
+
+func (p *ReturnReceiptResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+// CreateReturn authorizes goods shipped on a reservation of the product to be returned.
+func (a *Api) CreateReturn(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+
+	data := &CreateReturnRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.CreateReturn(r.Context(), product, data.Return); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	api.Render(w, r, &ReturnResponse{data.Return})
+}
+
+func (a *Api) GetReturn(w http.ResponseWriter, r *http.Request) {
+	rma := r.Context().Value("return").(Return)
+	api.Render(w, r, &ReturnResponse{&rma})
+}
+
+// ReceiveReturn takes goods back on a return with a disposition of restock, refurbish or scrap.
+func (a *Api) ReceiveReturn(w http.ResponseWriter, r *http.Request) {
+	product := r.Context().Value("product").(Product)
+	rma := r.Context().Value("return").(Return)
+
+	if err := checkIfMatch(r, product, false); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	data := &ReturnReceiptRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.ReceiveReturn(r.Context(), product, &rma, data.ReturnReceipt); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	api.Render(w, r, &ReturnReceiptResponse{data.ReturnReceipt})
+}
+
+func (a *Api) ReturnCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		product := r.Context().Value("product").(Product)
+
+		id, err := strconv.ParseUint(chi.URLParam(r, "returnID"), 10, 64)
+		if err != nil {
+			api.Render(w, r, api.ErrInvalidRequest(errors.New("return id must be numeric")))
+			return
+		}
+
+		rma, err := a.service.GetReturn(r.Context(), id)
+		if err == nil && rma.Sku != product.Sku {
+			err = notFound(CodeReturnNotFound, nil, "return %d not found for sku %s", id, product.Sku)
+		}
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Error().Err(err).Uint64("id", id).Msg("error acquiring return")
+			}
+			renderError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "return", rma)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type SalesOrderRequest struct {
+	*SalesOrder
+
+	ProtectedID      uint64           `json:"id"`
+	ProtectedStatus  SalesOrderStatus `json:"status"`
+	ProtectedCreated time.Time        `json:"created"`
+	ProtectedUpdated time.Time        `json:"updated"`
+}
+
+func (p *SalesOrderRequest) Bind(r *http.Request) error {
+	if p.SalesOrder == nil {
+		return errors.New("missing required SalesOrder fields")
+	}
+	var err error
+	if p.RequestID, err = bindRequestID(r, p.RequestID); err != nil {
+		return err
+	}
+	if p.RequestID == "" {
+		return errors.New("requestId is required")
+	}
+	if p.Requester == "" {
+		return errors.New("requester is required")
+	}
+	if len(p.Lines) == 0 {
+		return errors.New("lines are required")
+	}
+
+	return nil
+}
+
+type SalesOrderResponse struct {
+	*SalesOrder
+}
+
+func (p *SalesOrderResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+// CreateSalesOrder takes an order for several products, allocated all at once or line by line depending on its
+// policy.
+func (a *Api) CreateSalesOrder(w http.ResponseWriter, r *http.Request) {
+	data := &SalesOrderRequest{}
+	if err := render.Bind(r, data); err != nil {
+		api.Render(w, r, api.ErrInvalidRequest(err))
+		return
+	}
+
+	if err := a.service.CreateSalesOrder(r.Context(), data.SalesOrder); err != nil {
+		renderError(w, r, err)
+		return
+	}
+
+	render.Status(r, http.StatusCreated)
+	api.Render(w, r, &SalesOrderResponse{data.SalesOrder})
+}
+
+func (a *Api) GetSalesOrder(w http.ResponseWriter, r *http.Request) {
+	order := r.Context().Value("salesOrder").(SalesOrder)
+	api.Render(w, r, &SalesOrderResponse{&order})
+}
+
+func (a *Api) SalesOrderCtx(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(chi.URLParam(r, "orderID"), 10, 64)
+		if err != nil {
+			api.Render(w, r, api.ErrInvalidRequest(errors.New("sales order id must be numeric")))
+			return
+		}
+
+		order, err := a.service.GetSalesOrder(r.Context(), id)
+		if err != nil {
+			if !errors.Is(err, ErrNotFound) {
+				log.Error().Err(err).Uint64("id", id).Msg("error acquiring sales order")
+			}
+			renderError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "salesOrder", order)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,120 @@
+package inventory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+// ATPPeriod is a value object. A receipt of stock and the quantity that can be promised once it has arrived.
+type ATPPeriod struct {
+	Date       time.Time `json:"date"`
+	Receipt    int64     `json:"receipt"`
+	Cumulative int64     `json:"cumulative"`
+}
+
+// ATP is a value object. When a quantity of a product can be promised.
+type ATP struct {
+	Sku        string      `json:"sku"`
+	Quantity   int64       `json:"quantity"`
+	Available  int64       `json:"available"`
+	Ahead      int64       `json:"ahead"`
+	Promisable bool        `json:"promisable"`
+	Date       *time.Time  `json:"date,omitempty"`
+	Schedule   []ATPPeriod `json:"schedule"`
+}
+
+// CalculateATP works out when qty units of a product could be promised to a new reservation.
+//
+// A new reservation joins the open ones in allocation order, so only the outstanding quantity of the reservations
+// that would be filled before it, Ahead, competes with it for stock. Stock on hand is available now and what is left of
+// each active production order arrives on its due date, or now if it is overdue. The promise date is the first of these at which the
+// cumulative supply less Ahead covers qty. If it never does the quantity is not promisable with the known supply.
+func CalculateATP(product Product, open []Reservation, orders []ProductionOrder, qty int64, now time.Time,
+	policy string) ATP {
+
+	atp := ATP{Sku: product.Sku, Quantity: qty, Available: product.Available, Schedule: []ATPPeriod{}}
+
+	// the new reservation is the only one without an id yet
+	queue := make([]Reservation, 0, len(open)+1)
+	for _, r := range open {
+		if r.State == Open && r.ID != 0 {
+			queue = append(queue, r)
+		}
+	}
+	queue = append(queue, Reservation{Sku: product.Sku, State: Open, RequestedQuantity: qty})
+	allocationOrder(queue, policy)
+	for _, r := range queue {
+		if r.ID == 0 {
+			break
+		}
+		atp.Ahead += r.RequestedQuantity - r.ReservedQuantity
+	}
+
+	receipts := make([]ProductionOrder, 0, len(orders))
+	for _, o := range orders {
+		if o.Active() && o.Remaining() > 0 {
+			receipts = append(receipts, o)
+		}
+	}
+	sort.SliceStable(receipts, func(i, j int) bool { return receipts[i].Due.Before(receipts[j].Due) })
+
+	period := ATPPeriod{Date: now, Receipt: product.Available, Cumulative: product.Available - atp.Ahead}
+	atp.Schedule = append(atp.Schedule, period)
+	for _, o := range receipts {
+		date := o.Due
+		if date.Before(now) {
+			date = now
+		}
+		period = ATPPeriod{Date: date, Receipt: o.Remaining(), Cumulative: period.Cumulative + o.Remaining()}
+		atp.Schedule = append(atp.Schedule, period)
+	}
+
+	for _, p := range atp.Schedule {
+		if p.Cumulative >= qty {
+			date := p.Date
+			atp.Promisable = true
+			atp.Date = &date
+			break
+		}
+	}
+	return atp
+}
+
+func (s *service) GetATP(ctx context.Context, product Product, qty int64) (ATP, error) {
+	if qty < 1 {
+		return ATP{}, validation("quantity must be greater than zero")
+	}
+
+	open, err := s.openReservations(ctx, product.Sku)
+	if err != nil {
+		return ATP{}, err
+	}
+
+	orders, err := s.repo.GetSkuProductionOrders(ctx, product.Sku)
+	if err != nil {
+		return ATP{}, errors.WithMessage(err, "failed to get production orders")
+	}
+
+	return CalculateATP(product, open, orders, qty, time.Now(), s.settings.Get().AllocationPolicy), nil
+}
+
+// openReservations returns every open reservation of the product, oldest first.
+func (s *service) openReservations(ctx context.Context, sku string, txs ...db.Transaction) ([]Reservation, error) {
+	const pageSize = 100
+	open := make([]Reservation, 0)
+	for offset := 0; ; offset += pageSize {
+		page, err := s.repo.GetSkuReservationsByState(ctx, sku, Open, pageSize, offset, txs...)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		open = append(open, page...)
+		if len(page) < pageSize {
+			break
+		}
+	}
+	return open, nil
+}
@@ -0,0 +1,187 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+const (
+	BackorderCreated = "Backordered"
+	BackorderFilled  = "Filled"
+)
+
+// BackorderEvent is a value object. It tells the requester that their reservation couldn't be filled straight away and
+// when it is expected to be, and again once it has been filled.
+type BackorderEvent struct {
+	Event       string      `json:"event"`
+	Reservation Reservation `json:"reservation"`
+}
+
+// Backorder is a value object. What one requester is still waiting on of a product across their backordered
+// reservations. EstimatedFill is when the last of them is expected to be filled, or nil if scheduled production doesn't
+// cover all of them.
+type Backorder struct {
+	Sku           string     `json:"sku"`
+	Requester     string     `json:"requester"`
+	Reservations  []uint64   `json:"reservations"`
+	Quantity      int64      `json:"quantity"`
+	EstimatedFill *time.Time `json:"estimatedFill,omitempty"`
+}
+
+// FillSchedule works out when each open reservation of a product is expected to be filled. Walking the reservations in
+// allocation order, each one is filled once the supply covers what it and the reservations ahead of it still need.
+// Stock on hand is available now and what is left of each active production order arrives on its due date, or now if it
+// is overdue, as for the available to promise date. A reservation the known supply never covers has no estimate.
+func FillSchedule(product Product, open []Reservation, orders []ProductionOrder, now time.Time,
+	policy string) map[uint64]*time.Time {
+
+	queue := make([]Reservation, 0, len(open))
+	for _, r := range open {
+		if r.State == Open {
+			queue = append(queue, r)
+		}
+	}
+	allocationOrder(queue, policy)
+
+	// with nothing ahead of it the available to promise schedule is the cumulative supply
+	supply := CalculateATP(product, nil, orders, 0, now, policy).Schedule
+	schedule := make(map[uint64]*time.Time, len(queue))
+	var need int64
+	period := 0
+	for _, r := range queue {
+		need += r.RequestedQuantity - r.ReservedQuantity
+		for period < len(supply) && supply[period].Cumulative < need {
+			period++
+		}
+		if period == len(supply) {
+			schedule[r.ID] = nil
+			continue
+		}
+		date := supply[period].Date
+		schedule[r.ID] = &date
+	}
+	return schedule
+}
+
+// fillSchedule estimates when each open reservation of a product will be filled from its current stock and production
+// orders. Kits aren't produced, so kit reservations have no estimates.
+func (s *service) fillSchedule(ctx context.Context, sku string, txs ...db.Transaction) (map[uint64]*time.Time, error) {
+	product, err := s.repo.GetProduct(ctx, sku, txs...)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "failed to get product %s", sku)
+	}
+	if product.Kit {
+		return map[uint64]*time.Time{}, nil
+	}
+
+	open, err := s.openReservations(ctx, sku, txs...)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := s.repo.GetSkuProductionOrders(ctx, sku, txs...)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get production orders")
+	}
+	return FillSchedule(product, open, orders, time.Now(), s.settings.Get().AllocationPolicy), nil
+}
+
+// backorder flags a reservation that filling couldn't complete straight away as backordered, estimates when it will be
+// filled and lets the requester know. The reservations of a kit's components are left to the kit reservation.
+func (s *service) backorder(ctx context.Context, res *Reservation) error {
+	current, err := s.repo.GetReservation(ctx, res.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !current.backorderable() {
+		return nil
+	}
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if err = s.markBackordered(ctx, &current, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "failed to commit backorder")
+	}
+	res.State, res.ReservedQuantity = current.State, current.ReservedQuantity
+	res.Backordered, res.EstimatedFill = current.Backordered, current.EstimatedFill
+	return nil
+}
+
+// backorderable reservations are open ones filling couldn't complete that aren't part of a kit.
+func (r Reservation) backorderable() bool {
+	return r.State == Open && r.KitReservationID == 0 && r.ReservedQuantity < r.RequestedQuantity
+}
+
+// markBackordered flags the reservation as backordered with its estimated fill date as part of the transaction and
+// lets the requester know.
+func (s *service) markBackordered(ctx context.Context, res *Reservation, tx db.Transaction) error {
+	const funcName = "markBackordered"
+
+	schedule, err := s.fillSchedule(ctx, res.Sku, tx)
+	if err != nil {
+		return err
+	}
+	res.EstimatedFill = schedule[res.ID]
+	res.Backordered = true
+
+	log.Debug().Str("func", funcName).Uint64("reservation", res.ID).Msg("backordering reservation")
+	if err = s.repo.UpdateBackorder(ctx, res.ID, res.EstimatedFill, tx); err != nil {
+		return errors.WithMessage(err, "failed to backorder reservation")
+	}
+	return s.publishBackorder(ctx, BackorderEvent{Event: BackorderCreated, Reservation: *res})
+}
+
+// GetBackorderReport returns what each requester is waiting on of each product, by sku and requester. The fill dates
+// are estimated again from the current stock and production orders rather than the ones given when the reservations
+// were backordered, once for each product in the page.
+func (s *service) GetBackorderReport(ctx context.Context, limit, offset int) ([]Backorder, error) {
+	report, err := s.repo.GetBackorders(ctx, limit, offset)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to get backorders")
+	}
+
+	schedules := make(map[string]map[uint64]*time.Time)
+	for i, b := range report {
+		schedule, ok := schedules[b.Sku]
+		if !ok {
+			if schedule, err = s.fillSchedule(ctx, b.Sku); err != nil {
+				return nil, err
+			}
+			schedules[b.Sku] = schedule
+		}
+
+		// the group is filled when the last of its reservations is, and not at all if any of them isn't
+		for j, id := range b.Reservations {
+			estimate := schedule[id]
+			if estimate == nil {
+				report[i].EstimatedFill = nil
+				break
+			}
+			if j == 0 || estimate.After(*report[i].EstimatedFill) {
+				report[i].EstimatedFill = estimate
+			}
+		}
+	}
+	return report, nil
+}
+
+func (s *service) publishBackorder(ctx context.Context, event BackorderEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.WithMessage(err, "failed to serialize backorder")
+	}
+	if err = s.bq.Publish(ctx, s.exchanges.Backorder, body); err != nil {
+		return errors.WithMessage(err, "failed to publish backorder")
+	}
+	return nil
+}
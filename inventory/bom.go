@@ -0,0 +1,208 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+// BillOfMaterials is an entity. The components used up producing one unit of a product. Every change is saved as a
+// new version and production uses the latest one.
+type BillOfMaterials struct {
+	Sku        string         `json:"sku"`
+	Version    int            `json:"version"`
+	Components []BomComponent `json:"components"`
+	Created    time.Time      `json:"created"`
+}
+
+// BomComponent is a value object. The quantity of a component SKU needed for one unit of the parent.
+type BomComponent struct {
+	Sku      string `json:"sku"`
+	Quantity int64  `json:"quantity"`
+}
+
+// ComponentUsage is a value object. The quantity of a component consumed by a production event.
+type ComponentUsage struct {
+	Sku      string `json:"sku"`
+	Quantity int64  `json:"quantity"`
+}
+
+// Shortage is a value object. A component without enough stock available for the production that needs it.
+type Shortage struct {
+	Sku       string `json:"sku"`
+	Required  int64  `json:"required"`
+	Available int64  `json:"available"`
+	Short     int64  `json:"short"`
+}
+
+func validateBom(bom BillOfMaterials) error {
+	if len(bom.Components) == 0 {
+		return validation("a bill of materials needs at least one component")
+	}
+	seen := map[string]bool{}
+	for _, c := range bom.Components {
+		if c.Sku == "" {
+			return validation("component sku is required")
+		}
+		if c.Sku == bom.Sku {
+			return validation("product %s can't be a component of itself", bom.Sku)
+		}
+		if seen[c.Sku] {
+			return validation("component %s is listed more than once", c.Sku)
+		}
+		if c.Quantity < 1 {
+			return validation("quantity of component %s must be greater than zero", c.Sku)
+		}
+		seen[c.Sku] = true
+	}
+	return nil
+}
+
+// SaveBom stores the components as the next version of the product's bill of materials. For a kit these are the
+// components it is made up of.
+func (s *service) SaveBom(ctx context.Context, product Product, bom *BillOfMaterials) error {
+	bom.Sku = product.Sku
+	if err := validateBom(*bom); err != nil {
+		return err
+	}
+	for _, c := range bom.Components {
+		component, err := s.repo.GetProduct(ctx, c.Sku)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return validation("component %s does not exist", c.Sku)
+			}
+			return errors.WithStack(err)
+		}
+		if component.Kit {
+			return validation("component %s is a kit, kits can't be components", c.Sku)
+		}
+	}
+
+	before, err := s.repo.GetBom(ctx, product.Sku, 0)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	bom.Created = time.Now()
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = s.repo.SaveBom(ctx, bom, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save bill of materials")
+	}
+
+	var previous interface{}
+	if before.Version > 0 {
+		previous = before
+	}
+	if err = s.record(ctx, audit.UpdateBom, product.Sku, previous, bom, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// GetBom returns the given version of a product's bill of materials, or the latest one for version zero.
+func (s *service) GetBom(ctx context.Context, sku string, version int) (BillOfMaterials, error) {
+	bom, err := s.repo.GetBom(ctx, sku, version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return bom, notFound(CodeBomNotFound, err, "bill of materials for %s not found", sku)
+		}
+		return bom, errors.WithStack(err)
+	}
+	return bom, nil
+}
+
+// backflush consumes the components of the produced quantity according to the latest bill of materials, as part of
+// the production transaction. When components are short the production is rejected with the list of shortages,
+// unless the backflush policy allows stock to go negative, in which case the shortages are reported on the event.
+func (s *service) backflush(ctx context.Context, event *ProductionEvent, tx db.Transaction) ([]Product, error) {
+	const funcName = "backflush"
+
+	bom, err := s.repo.GetBom(ctx, event.Sku, 0, tx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, validation("product %s has no bill of materials to backflush", event.Sku)
+		}
+		return nil, errors.WithStack(err)
+	}
+	event.BomVersion = bom.Version
+
+	components := make([]Product, 0, len(bom.Components))
+	event.Consumed = make([]ComponentUsage, 0, len(bom.Components))
+	event.Shortages = nil
+	for _, c := range bom.Components {
+		component, err := s.repo.GetProduct(ctx, c.Sku, tx)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to get component %s", c.Sku)
+		}
+		if component.Serialized {
+			return nil, validation("component %s is serialized, its units can't be backflushed", c.Sku)
+		}
+		// components are taken from the default location, where production takes place
+		available, err := s.stockAt(ctx, c.Sku, DefaultLocation, tx)
+		if err != nil {
+			return nil, err
+		}
+		if component.Available < available {
+			available = component.Available
+		}
+		required := c.Quantity * event.Quantity
+		if available < required {
+			short := required
+			if available > 0 {
+				short -= available
+			}
+			event.Shortages = append(event.Shortages, Shortage{Sku: c.Sku, Required: required,
+				Available: available, Short: short})
+		}
+		components = append(components, component)
+		event.Consumed = append(event.Consumed, ComponentUsage{Sku: c.Sku, Quantity: required})
+	}
+
+	if len(event.Shortages) > 0 && s.settings.Get().BackflushPolicy != settings.BackflushAllowNegative {
+		return nil, componentShortage(event.Shortages, "not enough components to produce %d of %s",
+			event.Quantity, event.Sku)
+	}
+
+	for i := range components {
+		before := components[i]
+		components[i].Available -= event.Consumed[i].Quantity
+
+		log.Debug().Str("func", funcName).Str("sku", event.Sku).Str("component", components[i].Sku).
+			Int64("quantity", event.Consumed[i].Quantity).Msg("consuming component")
+		// shortages have been checked above, the stock only goes negative when the policy allows it
+		err = s.repo.AddLocationStock(ctx, components[i].Sku, DefaultLocation, -event.Consumed[i].Quantity, tx)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to take component %s from %s", components[i].Sku,
+				DefaultLocation)
+		}
+		if err = s.saveProduct(ctx, &components[i], tx); err != nil {
+			return nil, errors.WithMessagef(err, "failed to consume component %s", components[i].Sku)
+		}
+		if err = s.evaluateStock(ctx, components[i], tx); err != nil {
+			return nil, err
+		}
+		if err = s.record(ctx, audit.Backflush, components[i].Sku, before, components[i], event, tx); err != nil {
+			return nil, err
+		}
+		if err = s.publishInventory(ctx, components[i], tx); err != nil {
+			return nil, errors.WithMessage(err, "failed to publish inventory")
+		}
+	}
+	return components, nil
+}
@@ -0,0 +1,212 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+// Bucket is a status stock on hand can be in. Only available stock can be reserved.
+type Bucket string
+
+const (
+	BucketAvailable  Bucket = "available"
+	BucketQuarantine Bucket = "quarantine"
+	BucketDamaged    Bucket = "damaged"
+)
+
+// StatusChange is an entity. A quantity of a Product moved from one stock bucket to another, such as quarantined
+// production released to available after QC or rejected as damaged. Moving units of a serialized product lists their
+// serial numbers.
+type StatusChange struct {
+	ID        uint64    `json:"id"`
+	RequestID string    `json:"requestId"`
+	Sku       string    `json:"sku"`
+	From      Bucket    `json:"from"`
+	To        Bucket    `json:"to"`
+	Quantity  int64     `json:"quantity"`
+	Reason    string    `json:"reason"`
+	Serials   []string  `json:"serials,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// bucket returns the quantity of the product held in the bucket so that it can be read or changed.
+func (p *Product) bucket(b Bucket) *int64 {
+	switch b {
+	case BucketAvailable:
+		return &p.Available
+	case BucketQuarantine:
+		return &p.Quarantine
+	case BucketDamaged:
+		return &p.Damaged
+	}
+	return nil
+}
+
+// onHand is all the stock of the product held, whatever its bucket. Reserved stock is on hand until it ships.
+func (p Product) onHand() int64 {
+	return p.Available + p.Reserved + p.Quarantine + p.Damaged
+}
+
+// serialStatus is the status of the serialized units held in the bucket.
+func (b Bucket) serialStatus() SerialStatus {
+	switch b {
+	case BucketQuarantine:
+		return SerialQuarantined
+	case BucketDamaged:
+		return SerialDamaged
+	}
+	return SerialAvailable
+}
+
+func validateBucket(b Bucket) error {
+	switch b {
+	case BucketAvailable, BucketQuarantine, BucketDamaged:
+		return nil
+	}
+	return validation("bucket %q must be %s, %s or %s", b, BucketAvailable, BucketQuarantine, BucketDamaged)
+}
+
+// productionBucket is the bucket production goes to when the event doesn't say.
+func (s *service) productionBucket() Bucket {
+	if s.settings.Get().ProductionBucket == settings.ProduceToQuarantine {
+		return BucketQuarantine
+	}
+	return BucketAvailable
+}
+
+// ChangeStatus moves stock of a product between buckets. Stock moved into available is used to fill open
+// reservations.
+func (s *service) ChangeStatus(ctx context.Context, product Product, change *StatusChange) error {
+	const funcName = "ChangeStatus"
+
+	if change.RequestID == "" {
+		return validation("request id is required")
+	}
+	if change.Quantity < 1 {
+		return validation("quantity must be greater than zero")
+	}
+	if err := validateBucket(change.From); err != nil {
+		return err
+	}
+	if err := validateBucket(change.To); err != nil {
+		return err
+	}
+	if change.From == change.To {
+		return validation("stock is already %s", change.To)
+	}
+	if product.Kit {
+		return kitNotStocked(product)
+	}
+	if err := validateSerials(product, change.Serials, change.Quantity); err != nil {
+		return err
+	}
+
+	change.Sku = product.Sku
+	hash, err := requestHash(statusChangePayload{Sku: change.Sku, From: change.From, To: change.To,
+		Quantity: change.Quantity, Reason: change.Reason, Serials: change.Serials})
+	if err != nil {
+		return err
+	}
+	if err = s.checkIdempotency(ctx, OpChangeStatus, change.RequestID, hash); err != nil {
+		return err
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", change.RequestID).Msg("getting status change")
+	dbChange, err := s.repo.GetStatusChangeByRequestID(ctx, change.RequestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	if dbChange.RequestID != "" {
+		log.Debug().Str("func", funcName).Str("requestId", change.RequestID).Msg("status change already exists, returning it")
+		return copier.Copy(change, &dbChange)
+	}
+
+	before := product
+	from, to := product.bucket(change.From), product.bucket(change.To)
+	if *from < change.Quantity {
+		return insufficientStock("only %d of %s is %s, %d can't be moved", *from, product.Sku, change.From,
+			change.Quantity)
+	}
+	*from -= change.Quantity
+	*to += change.Quantity
+	change.Created = time.Now()
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", change.RequestID).Msg("persisting status change")
+	if err = s.repo.SaveStatusChange(ctx, change, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save status change")
+	}
+
+	if err = s.saveIdempotencyKey(ctx, OpChangeStatus, change.RequestID, hash, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	// quarantined and damaged stock is held at the default location, so that is where available stock changes status
+	if change.From == BucketAvailable || change.To == BucketAvailable {
+		qty := change.Quantity
+		if change.From == BucketAvailable {
+			qty = -qty
+		}
+		if err = s.bookStock(ctx, product.Sku, DefaultLocation, qty, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if product.Serialized {
+		event := SerialEvent{Type: SerialEventStatusChanged, RequestID: change.RequestID, Created: change.Created}
+		err = s.changeSerials(ctx, product.Sku, change.Serials, change.From.serialStatus(), change.To.serialStatus(),
+			event, tx)
+		if err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if err = s.saveProduct(ctx, &product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to apply status change to product")
+	}
+
+	if err = s.evaluateStock(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.ChangeStatus, product.Sku, before, product, change, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.publishInventory(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to publish inventory")
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "failed to commit status change transaction")
+	}
+	observeStock(product)
+
+	if change.To == BucketAvailable {
+		log.Debug().Str("func", funcName).Str("requestId", change.RequestID).Msg("filling reserves")
+		if _, err = s.fillReserves(ctx, product); err != nil {
+			return errors.WithMessage(err, "failed to fill reserves after status change")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,547 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/audit"
+)
+
+// CountMethod is how the products on a count list are chosen.
+type CountMethod string
+
+const (
+	CountByClass    CountMethod = "abc"
+	CountRandom     CountMethod = "random"
+	CountByLocation CountMethod = "location"
+)
+
+// CountStatus is where a cycle count is in its lifecycle.
+type CountStatus string
+
+const (
+	CountOpen            CountStatus = "Open"
+	CountPendingApproval CountStatus = "PendingApproval"
+	CountClosed          CountStatus = "Closed"
+)
+
+// LineStatus is where a single product on a count list is.
+type LineStatus string
+
+const (
+	LineUncounted     LineStatus = "Uncounted"
+	LineNeedsApproval LineStatus = "NeedsApproval"
+	LinePosted        LineStatus = "Posted"
+	LineRejected      LineStatus = "Rejected"
+)
+
+// ABC classes rank products by their share of demand. Class A products make up the first 80% of units demanded,
+// class B the next 15% and class C the rest, including products without any demand.
+const (
+	ClassA = "A"
+	ClassB = "B"
+	ClassC = "C"
+)
+
+// CycleCount is an entity. A list of products to count, with the quantity on hand expected of each as it was when the
+// list was made. Counting a product works out its variance from the expected quantity. Variances within the
+// configured threshold are posted as adjustments straight away, larger ones wait for the count to be approved. A
+// count by location only counts the stock held at its Location, any other count counts all of it.
+type CycleCount struct {
+	ID       uint64      `json:"id"`
+	Method   CountMethod `json:"method"`
+	Class    string      `json:"class,omitempty"`
+	Location string      `json:"location,omitempty"`
+	Status   CountStatus `json:"status"`
+	Lines    []CountLine `json:"lines"`
+	Created  time.Time   `json:"created"`
+	Updated  time.Time   `json:"updated"`
+}
+
+// bookedAt is the location the variances of the count are booked at. Stock found or missing on a count of every
+// location is booked where adjustments take place.
+func (c CycleCount) bookedAt() string {
+	if c.Method == CountByLocation {
+		return c.Location
+	}
+	return DefaultLocation
+}
+
+// CountLine is a value object. A product on a count list.
+type CountLine struct {
+	Sku      string     `json:"sku"`
+	Expected int64      `json:"expected"`
+	Counted  int64      `json:"counted"`
+	Variance int64      `json:"variance"`
+	Status   LineStatus `json:"status"`
+}
+
+// Count is a value object. The quantity of a product found when counting it.
+type Count struct {
+	Sku     string `json:"sku"`
+	Counted int64  `json:"counted"`
+}
+
+// ClassifyABC ranks the skus by the quantity demanded of each and assigns them their ABC class.
+func ClassifyABC(demand map[string]int64) map[string]string {
+	skus := make([]string, 0, len(demand))
+	total := int64(0)
+	for sku, qty := range demand {
+		skus = append(skus, sku)
+		total += qty
+	}
+	sort.Slice(skus, func(i, j int) bool {
+		if demand[skus[i]] != demand[skus[j]] {
+			return demand[skus[i]] > demand[skus[j]]
+		}
+		return skus[i] < skus[j]
+	})
+
+	classes := make(map[string]string, len(skus))
+	cumulative := int64(0)
+	for _, sku := range skus {
+		switch {
+		case demand[sku] == 0:
+			classes[sku] = ClassC
+		case cumulative*100 < total*80:
+			classes[sku] = ClassA
+		case cumulative*100 < total*95:
+			classes[sku] = ClassB
+		default:
+			classes[sku] = ClassC
+		}
+		cumulative += demand[sku]
+	}
+	return classes
+}
+
+// needsApproval is true when a variance is larger than the threshold, a percentage of the expected quantity.
+func needsApproval(line CountLine, threshold float64) bool {
+	variance := line.Variance
+	if variance < 0 {
+		variance = -variance
+	}
+	return float64(variance)*100 > threshold*float64(line.Expected)
+}
+
+// countable returns every product that can be counted, which leaves out kits as they aren't stocked and serialized
+// products as they are counted by serial number.
+func (s *service) countable(ctx context.Context) ([]Product, error) {
+	const pageSize = 100
+
+	products := make([]Product, 0)
+	for offset := 0; ; offset += pageSize {
+		page, err := s.repo.GetAllProducts(ctx, pageSize, offset)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, p := range page {
+			if !p.Kit && !p.Serialized {
+				products = append(products, p)
+			}
+		}
+		if len(page) < pageSize {
+			return products, nil
+		}
+	}
+}
+
+// selectByClass returns the products of the ABC class, highest demand first, going by demand over the replenishment
+// window.
+func (s *service) selectByClass(ctx context.Context, products []Product, class string, now time.Time) ([]Product, error) {
+	since := windowStart(now, replenishmentParams(s.settings.Get()))
+	until := now.UTC().Truncate(day)
+
+	demand := map[string]int64{}
+	for _, p := range products {
+		days, err := s.repo.GetDailyDemand(ctx, p.Sku, since, until)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to get demand for %s", p.Sku)
+		}
+		demand[p.Sku] = 0
+		for _, d := range days {
+			demand[p.Sku] += d.Quantity
+		}
+	}
+
+	classes := ClassifyABC(demand)
+	selected := make([]Product, 0)
+	for _, p := range products {
+		if classes[p.Sku] == class {
+			selected = append(selected, p)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool { return demand[selected[i].Sku] > demand[selected[j].Sku] })
+	return selected, nil
+}
+
+// expected returns the quantity of the product that should be on the shelf for the count. A count by location only
+// expects the stock held at its location, and only the default location holds reserved, quarantined and damaged
+// stock.
+func (s *service) expected(ctx context.Context, count CycleCount, product Product) (int64, error) {
+	if count.Method != CountByLocation {
+		return product.onHand(), nil
+	}
+	expected, err := s.stockAt(ctx, product.Sku, count.Location)
+	if err != nil {
+		return 0, err
+	}
+	if count.Location == DefaultLocation {
+		expected += product.Reserved + product.Quarantine + product.Damaged
+	}
+	return expected, nil
+}
+
+// CreateCycleCount makes a count list of products chosen by the method of the count, snapshotting the quantity on
+// hand of each. A count by location lists the products held there. A size limits the list, which it is required for a
+// random sample.
+func (s *service) CreateCycleCount(ctx context.Context, count *CycleCount, size int) error {
+	switch count.Method {
+	case CountByClass:
+		if count.Class != ClassA && count.Class != ClassB && count.Class != ClassC {
+			return validation("class must be %s, %s or %s", ClassA, ClassB, ClassC)
+		}
+		count.Location = ""
+	case CountRandom:
+		if size < 1 {
+			return validation("size of a random sample must be greater than zero")
+		}
+		count.Class, count.Location = "", ""
+	case CountByLocation:
+		if count.Location == "" {
+			return validation("location is required to count by location")
+		}
+		if _, err := s.repo.GetLocation(ctx, count.Location); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return notFound(CodeLocationNotFound, err, "location %s not found", count.Location)
+			}
+			return errors.WithStack(err)
+		}
+		count.Class = ""
+	default:
+		return validation("method must be %s, %s or %s", CountByClass, CountRandom, CountByLocation)
+	}
+	if size < 0 {
+		return validation("size must not be negative")
+	}
+
+	products, err := s.countable(ctx)
+	if err != nil {
+		return err
+	}
+	count.Created = time.Now()
+	count.Updated = count.Created
+
+	switch count.Method {
+	case CountByClass:
+		if products, err = s.selectByClass(ctx, products, count.Class, count.Created); err != nil {
+			return err
+		}
+	case CountRandom:
+		rand.Shuffle(len(products), func(i, j int) { products[i], products[j] = products[j], products[i] })
+	}
+
+	count.Status = CountOpen
+	count.Lines = make([]CountLine, 0, len(products))
+	for _, p := range products {
+		if size > 0 && len(count.Lines) == size {
+			break
+		}
+		expected, err := s.expected(ctx, *count, p)
+		if err != nil {
+			return err
+		}
+		if count.Method == CountByLocation && expected == 0 {
+			continue
+		}
+		count.Lines = append(count.Lines, CountLine{Sku: p.Sku, Expected: expected, Status: LineUncounted})
+	}
+	if len(count.Lines) == 0 {
+		return validation("there are no products to count")
+	}
+	sort.Slice(count.Lines, func(i, j int) bool { return count.Lines[i].Sku < count.Lines[j].Sku })
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = s.repo.SaveCycleCount(ctx, count, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save cycle count")
+	}
+
+	if err = s.record(ctx, audit.CreateCycleCount, "", nil, count, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (s *service) GetCycleCount(ctx context.Context, ID uint64) (CycleCount, error) {
+	count, err := s.repo.GetCycleCount(ctx, ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return count, notFound(CodeCountNotFound, err, "cycle count %d not found", ID)
+		}
+		return count, errors.WithStack(err)
+	}
+	return count, nil
+}
+
+func (s *service) GetCycleCounts(ctx context.Context, limit, offset int) ([]CycleCount, error) {
+	return s.repo.GetCycleCounts(ctx, limit, offset)
+}
+
+// RecordCounts takes the counted quantities of products on an open count. Once every product is counted the count
+// closes, unless a variance is waiting for approval.
+func (s *service) RecordCounts(ctx context.Context, count *CycleCount, counts []Count) error {
+	const funcName = "RecordCounts"
+
+	if count.Status != CountOpen {
+		return conflict(CodeInvalidTransition, "cycle count %d is %s, only open counts can be counted", count.ID,
+			count.Status)
+	}
+	lines := map[string]int{}
+	for i, l := range count.Lines {
+		lines[l.Sku] = i
+	}
+	seen := map[string]bool{}
+	for _, c := range counts {
+		i, ok := lines[c.Sku]
+		if !ok {
+			return validation("%s is not on cycle count %d", c.Sku, count.ID)
+		}
+		if seen[c.Sku] || count.Lines[i].Status != LineUncounted {
+			return conflict(CodeLineCounted, "%s has already been counted", c.Sku)
+		}
+		if c.Counted < 0 {
+			return validation("counted quantity of %s must not be negative", c.Sku)
+		}
+		seen[c.Sku] = true
+	}
+
+	before := *count
+	before.Lines = append([]CountLine(nil), count.Lines...)
+	threshold := s.settings.Get().CountThreshold
+	postings := make([]posting, 0, len(counts))
+	for _, c := range counts {
+		line := &count.Lines[lines[c.Sku]]
+		line.Counted = c.Counted
+		line.Variance = c.Counted - line.Expected
+		if needsApproval(*line, threshold) {
+			line.Status = LineNeedsApproval
+			continue
+		}
+		log.Debug().Str("func", funcName).Uint64("count", count.ID).Str("sku", c.Sku).Msg("planning variance")
+		p, err := s.planVariance(ctx, *count, line)
+		if err != nil {
+			return err
+		}
+		postings = append(postings, p)
+	}
+
+	count.Status = countStatus(count.Lines)
+	count.Updated = time.Now()
+	return s.saveCount(ctx, audit.RecordCount, before, count, postings)
+}
+
+// ApproveCycleCount posts the variances waiting for approval when approved and discards them when not, which closes
+// the count.
+func (s *service) ApproveCycleCount(ctx context.Context, count *CycleCount, approved bool) error {
+	const funcName = "ApproveCycleCount"
+
+	if count.Status != CountPendingApproval {
+		return conflict(CodeInvalidTransition, "cycle count %d is %s, only counts pending approval can be approved",
+			count.ID, count.Status)
+	}
+
+	before := *count
+	before.Lines = append([]CountLine(nil), count.Lines...)
+	postings := make([]posting, 0)
+	for i := range count.Lines {
+		line := &count.Lines[i]
+		if line.Status != LineNeedsApproval {
+			continue
+		}
+		if !approved {
+			line.Status = LineRejected
+			continue
+		}
+		log.Debug().Str("func", funcName).Uint64("count", count.ID).Str("sku", line.Sku).Msg("planning variance")
+		p, err := s.planVariance(ctx, *count, line)
+		if err != nil {
+			return err
+		}
+		postings = append(postings, p)
+	}
+
+	count.Status = CountClosed
+	count.Updated = time.Now()
+	return s.saveCount(ctx, audit.ApproveCycleCount, before, count, postings)
+}
+
+// posting is the variance of a count line as it is posted to the stock of its product.
+type posting struct {
+	line      *CountLine
+	before    Product
+	after     Product
+	available int64
+}
+
+// planVariance works out how the variance of the line is posted, marking the line posted. Stock found on the shelf is
+// available at the location counted. Stock missing is taken from what is available there and, at the default
+// location, then from damaged and quarantined stock. Reserved stock is owed to reservations and is never written off
+// by a count, a variance only it could cover is rejected.
+func (s *service) planVariance(ctx context.Context, count CycleCount, line *CountLine) (posting, error) {
+	line.Status = LinePosted
+	p := posting{line: line}
+	if line.Variance == 0 {
+		return p, nil
+	}
+
+	var err error
+	if p.before, err = s.repo.GetProduct(ctx, line.Sku); err != nil {
+		return p, errors.WithMessagef(err, "failed to get product %s", line.Sku)
+	}
+	p.after = p.before
+	if line.Variance > 0 {
+		p.available = line.Variance
+		p.after.Available += line.Variance
+		return p, nil
+	}
+
+	location := count.bookedAt()
+	held, err := s.stockAt(ctx, line.Sku, location)
+	if err != nil {
+		return p, err
+	}
+	buckets := []*int64{&held}
+	if location == DefaultLocation {
+		buckets = append(buckets, &p.after.Damaged, &p.after.Quarantine)
+	}
+	missing := -line.Variance
+	for i, b := range buckets {
+		taken := missing
+		if *b < taken {
+			taken = *b
+		}
+		if taken <= 0 {
+			continue
+		}
+		*b -= taken
+		missing -= taken
+		if i == 0 {
+			p.available = -taken
+			p.after.Available -= taken
+		}
+	}
+	if missing > 0 {
+		return p, insufficientStock("%s counted %d short at %s, %d more than the stock that isn't reserved",
+			line.Sku, -line.Variance, location, missing)
+	}
+	return p, nil
+}
+
+func countStatus(lines []CountLine) CountStatus {
+	status := CountClosed
+	for _, l := range lines {
+		switch l.Status {
+		case LineUncounted:
+			return CountOpen
+		case LineNeedsApproval:
+			status = CountPendingApproval
+		}
+	}
+	return status
+}
+
+// saveCount posts the variances and saves the count in a single transaction, then fills the reserves of the products
+// that have more stock available where reservations are filled.
+func (s *service) saveCount(ctx context.Context, action audit.Action, before CycleCount, count *CycleCount,
+	postings []posting) error {
+	const funcName = "saveCount"
+
+	location := count.bookedAt()
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for i := range postings {
+		p := &postings[i]
+		if p.line.Variance == 0 {
+			continue
+		}
+		log.Debug().Str("func", funcName).Uint64("count", count.ID).Str("sku", p.line.Sku).Msg("posting variance")
+		adj := &Adjustment{
+			RequestID: fmt.Sprintf("cycle-count/%d/%s", count.ID, p.line.Sku),
+			Sku:       p.line.Sku,
+			Quantity:  p.line.Variance,
+			Reason:    fmt.Sprintf("cycle count %d at %s", count.ID, location),
+			Created:   count.Updated,
+		}
+		if err = s.repo.SaveAdjustment(ctx, adj, tx); err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithMessagef(err, "failed to save adjustment of %s", p.line.Sku)
+		}
+		if err = s.bookStock(ctx, p.line.Sku, location, p.available, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+		if err = s.saveProduct(ctx, &p.after, tx); err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithMessagef(err, "failed to post variance of %s", p.line.Sku)
+		}
+		if err = s.evaluateStock(ctx, p.after, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+		if err = s.record(ctx, audit.Adjust, p.line.Sku, p.before, p.after, adj, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+		if err = s.publishInventory(ctx, p.after, tx); err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithMessage(err, "failed to publish inventory")
+		}
+	}
+
+	if err = s.repo.UpdateCycleCount(ctx, *count, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to update cycle count")
+	}
+
+	if err = s.record(ctx, action, "", before, count, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, p := range postings {
+		if p.line.Variance == 0 {
+			continue
+		}
+		observeStock(p.after)
+		if p.available > 0 && location == DefaultLocation {
+			log.Debug().Str("func", funcName).Uint64("count", count.ID).Str("sku", p.line.Sku).Msg("filling reserves")
+			if _, err = s.fillReserves(ctx, p.after); err != nil {
+				return errors.WithMessagef(err, "failed to fill reserves of %s after count", p.line.Sku)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package inventory
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/api"
+)
+
+// Kind classifies domain errors so that callers can react to them without knowing their underlying cause.
+type Kind string
+
+const (
+	KindNotFound            Kind = "NotFound"
+	KindConflict            Kind = "Conflict"
+	KindInsufficientStock   Kind = "InsufficientStock"
+	KindValidation          Kind = "Validation"
+	KindIdempotencyMismatch Kind = "IdempotencyMismatch"
+	KindPreconditionFailed  Kind = "PreconditionFailed"
+	KindPreconditionMissing Kind = "PreconditionMissing"
+)
+
+// Stable application error codes returned to clients alongside the http status.
+const (
+	CodeProductNotFound        = "product-not-found"
+	CodeReservationNotFound    = "reservation-not-found"
+	CodeOrderNotFound          = "production-order-not-found"
+	CodeProductExists          = "product-exists"
+	CodeDuplicateUpc           = "duplicate-upc"
+	CodeReservationNotOpen     = "reservation-not-open"
+	CodeInsufficientStock      = "insufficient-stock"
+	CodeValidation             = "validation-failed"
+	CodeIdempotencyMismatch    = "idempotency-mismatch"
+	CodeConcurrentModification = "concurrent-modification"
+	CodePreconditionFailed     = "precondition-failed"
+	CodePreconditionMissing    = "precondition-required"
+	CodeInvalidTransition      = "invalid-status-transition"
+	CodeOrderNotProducible     = "production-order-not-producible"
+	CodeBomNotFound            = "bom-not-found"
+	CodeComponentShortage      = "component-shortage"
+	CodeKitNotStocked          = "kit-not-stocked"
+	CodeComponentReservation   = "kit-component-reservation"
+	CodeDuplicateSerial        = "duplicate-serial"
+	CodeSerialNotFound         = "serial-not-found"
+	CodeSerialNotAvailable     = "serial-not-available"
+	CodeUnknownUnit            = "unknown-unit"
+	CodeFractionalQuantity     = "fractional-quantity"
+	CodeLocationNotFound       = "location-not-found"
+	CodeLocationExists         = "location-exists"
+	CodeTransferNotFound       = "transfer-not-found"
+	CodeTransferExceeded       = "transfer-quantity-exceeded"
+	CodeCountNotFound          = "cycle-count-not-found"
+	CodeLineCounted            = "cycle-count-line-counted"
+	CodeReturnNotFound         = "return-not-found"
+	CodeReturnExceeded         = "return-quantity-exceeded"
+	CodeSalesOrderNotFound     = "sales-order-not-found"
+)
+
+// Error is a domain error. Use errors.Is with one of the sentinel errors below to check its kind.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Msg     string
+	Err     error
+	Details interface{}
+}
+
+var (
+	ErrNotFound            = &Error{Kind: KindNotFound}
+	ErrConflict            = &Error{Kind: KindConflict}
+	ErrInsufficientStock   = &Error{Kind: KindInsufficientStock}
+	ErrValidation          = &Error{Kind: KindValidation}
+	ErrIdempotencyMismatch = &Error{Kind: KindIdempotencyMismatch}
+	ErrPreconditionFailed  = &Error{Kind: KindPreconditionFailed}
+)
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Msg, e.Err)
+	}
+	if e.Code == "" {
+		return string(e.Kind)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Msg)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches any domain error of the same kind, so errors.Is(err, ErrNotFound) holds for every not found error.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	return t.Kind == e.Kind && (t.Code == "" || t.Code == e.Code)
+}
+
+func newError(kind Kind, code string, err error, format string, args ...interface{}) error {
+	return &Error{Kind: kind, Code: code, Msg: fmt.Sprintf(format, args...), Err: err}
+}
+
+func notFound(code string, err error, format string, args ...interface{}) error {
+	return newError(KindNotFound, code, err, format, args...)
+}
+
+func conflict(code string, format string, args ...interface{}) error {
+	return newError(KindConflict, code, nil, format, args...)
+}
+
+func insufficientStock(format string, args ...interface{}) error {
+	return newError(KindInsufficientStock, CodeInsufficientStock, nil, format, args...)
+}
+
+// componentShortage is an insufficient stock error listing the components that were short.
+func componentShortage(shortages []Shortage, format string, args ...interface{}) error {
+	e := newError(KindInsufficientStock, CodeComponentShortage, nil, format, args...).(*Error)
+	e.Details = shortages
+	return e
+}
+
+func validation(format string, args ...interface{}) error {
+	return newError(KindValidation, CodeValidation, nil, format, args...)
+}
+
+func idempotencyMismatch(format string, args ...interface{}) error {
+	return newError(KindIdempotencyMismatch, CodeIdempotencyMismatch, nil, format, args...)
+}
+
+func preconditionFailed(format string, args ...interface{}) error {
+	return newError(KindPreconditionFailed, CodePreconditionFailed, nil, format, args...)
+}
+
+func preconditionMissing(format string, args ...interface{}) error {
+	return newError(KindPreconditionMissing, CodePreconditionMissing, nil, format, args...)
+}
+
+var statuses = map[Kind]int{
+	KindNotFound:            http.StatusNotFound,
+	KindConflict:            http.StatusConflict,
+	KindInsufficientStock:   http.StatusUnprocessableEntity,
+	KindValidation:          http.StatusBadRequest,
+	KindIdempotencyMismatch: http.StatusUnprocessableEntity,
+	KindPreconditionFailed:  http.StatusPreconditionFailed,
+	KindPreconditionMissing: http.StatusPreconditionRequired,
+}
+
+// renderError maps domain errors to their http status and stable code. Anything else is logged and hidden behind
+// an internal server error.
+func renderError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *Error
+	if errors.As(err, &e) {
+		if status, ok := statuses[e.Kind]; ok {
+			problem := api.ErrProblem(status, e.Code, e.Msg)
+			problem.Details = e.Details
+			api.Render(w, r, problem)
+			return
+		}
+	}
+
+	log.Err(err).Send()
+	api.Render(w, r, api.ErrInternalServerError())
+}
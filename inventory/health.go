@@ -0,0 +1,23 @@
+package inventory
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// Connection is implemented by queues that know whether they are connected to the broker.
+type Connection interface {
+	Connected() bool
+}
+
+// QueueCheck reports whether the queue is connected to the broker. It only looks at the state of the connection and
+// never sends anything, so probes add no traffic to the broker.
+func QueueCheck(conn Connection) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		if !conn.Connected() {
+			return nil, errors.New("not connected to the broker")
+		}
+		return nil, nil
+	}
+}
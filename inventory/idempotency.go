@@ -0,0 +1,177 @@
+package inventory
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+type Operation string
+
+const (
+	OpProduce Operation = "Produce"
+	OpReserve Operation = "Reserve"
+	OpAdjust  Operation = "Adjust"
+
+	OpChangeStatus     Operation = "ChangeStatus"
+	OpShipTransfer     Operation = "ShipTransfer"
+	OpReceiveTransfer  Operation = "ReceiveTransfer"
+	OpCreateReturn     Operation = "CreateReturn"
+	OpReceiveReturn    Operation = "ReceiveReturn"
+	OpCreateSalesOrder Operation = "CreateSalesOrder"
+)
+
+// IdempotencyKey is an entity. It remembers the payload a request id was first used with so that retries carrying a
+// different payload can be rejected instead of silently returning the original record.
+type IdempotencyKey struct {
+	Operation   Operation
+	Key         string
+	RequestHash string
+	Created     time.Time
+}
+
+type productionPayload struct {
+	Sku               string   `json:"sku"`
+	Quantity          int64    `json:"quantity"`
+	ProductionOrderID uint64   `json:"productionOrderId,omitempty"`
+	Backflush         bool     `json:"backflush,omitempty"`
+	Serials           []string `json:"serials,omitempty"`
+	Bucket            Bucket   `json:"bucket,omitempty"`
+}
+
+type reservationPayload struct {
+	Requester         string `json:"requester"`
+	Sku               string `json:"sku"`
+	RequestedQuantity int64  `json:"requestedQuantity"`
+}
+
+type adjustmentPayload struct {
+	Sku      string   `json:"sku"`
+	Quantity int64    `json:"quantity"`
+	Reason   string   `json:"reason"`
+	Serials  []string `json:"serials,omitempty"`
+}
+
+type statusChangePayload struct {
+	Sku      string   `json:"sku"`
+	From     Bucket   `json:"from"`
+	To       Bucket   `json:"to"`
+	Quantity int64    `json:"quantity"`
+	Reason   string   `json:"reason"`
+	Serials  []string `json:"serials,omitempty"`
+}
+
+type transferPayload struct {
+	Sku      string `json:"sku"`
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Quantity int64  `json:"quantity"`
+}
+
+type transferReceiptPayload struct {
+	TransferID uint64 `json:"transferId"`
+	Sku        string `json:"sku"`
+	Quantity   int64  `json:"quantity"`
+	Close      bool   `json:"close,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+type returnPayload struct {
+	Sku           string `json:"sku"`
+	ReservationID uint64 `json:"reservationId"`
+	Quantity      int64  `json:"quantity"`
+	Reason        string `json:"reason"`
+}
+
+type receiptPayload struct {
+	ReturnID    uint64      `json:"returnId"`
+	Sku         string      `json:"sku"`
+	Quantity    int64       `json:"quantity"`
+	Disposition Disposition `json:"disposition"`
+	Serials     []string    `json:"serials,omitempty"`
+}
+
+type salesOrderPayload struct {
+	Requester string                  `json:"requester"`
+	Policy    SalesOrderPolicy        `json:"policy"`
+	Lines     []salesOrderLinePayload `json:"lines"`
+}
+
+type salesOrderLinePayload struct {
+	Sku      string `json:"sku"`
+	Quantity int64  `json:"quantity"`
+}
+
+// requestHash fingerprints the fields of a request that must stay the same across retries.
+func requestHash(payload interface{}) (string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to serialize request for hashing")
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkIdempotency returns an error if the request id has been used before with a different payload.
+func (s *service) checkIdempotency(ctx context.Context, op Operation, requestID, hash string) error {
+	key, err := s.repo.GetIdempotencyKey(ctx, op, requestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	if key.Key != "" && key.RequestHash != hash {
+		return idempotencyMismatch("request id %s was already used with a different %s request", requestID, op)
+	}
+	return nil
+}
+
+func (s *service) saveIdempotencyKey(ctx context.Context, op Operation, requestID, hash string, tx db.Transaction) error {
+	key := &IdempotencyKey{Operation: op, Key: requestID, RequestHash: hash, Created: time.Now()}
+	if err := s.repo.SaveIdempotencyKey(ctx, key, tx); err != nil {
+		return errors.WithMessage(err, "failed to save idempotency key")
+	}
+	return nil
+}
+
+// bindRequestID reconciles the requestId in the body with the Idempotency-Key header. Either may be used, but when
+// both are present they have to agree.
+func bindRequestID(r *http.Request, requestID string) (string, error) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return requestID, nil
+	}
+	if requestID != "" && requestID != key {
+		return "", errors.New("requestId does not match the " + IdempotencyKeyHeader + " header")
+	}
+	return key, nil
+}
+
+// CleanupIdempotencyKeys periodically removes keys older than the ttl until the context is cancelled. Requests
+// replayed after their key has expired still return the original record but their payload is no longer verified.
+func CleanupIdempotencyKeys(ctx context.Context, repo Repository, ttl, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := repo.DeleteIdempotencyKeys(ctx, time.Now().Add(-ttl))
+			if err != nil {
+				log.Error().Err(err).Msg("failed to clean up idempotency keys")
+				continue
+			}
+			log.Debug().Int64("deleted", n).Msg("cleaned up idempotency keys")
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+func kitNotStocked(kit Product) error {
+	return conflict(CodeKitNotStocked, "%s is a kit, its stock is made up of its components", kit.Sku)
+}
+
+// KitAvailable is the number of complete kits the available stock of the components makes up. The components are
+// given in the same order as in the bill of materials.
+func KitAvailable(bom BillOfMaterials, components []Product) int64 {
+	if len(bom.Components) == 0 {
+		return 0
+	}
+	available := int64(math.MaxInt64)
+	for i, c := range bom.Components {
+		if kits := components[i].Available / c.Quantity; kits < available {
+			available = kits
+		}
+	}
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// deriveKit works out the available quantity of a kit from the latest version of its bill of materials. A kit that
+// has no components yet has nothing available.
+func (s *service) deriveKit(ctx context.Context, kit *Product, txs ...db.Transaction) error {
+	bom, err := s.repo.GetBom(ctx, kit.Sku, 0, txs...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			kit.Available = 0
+			return nil
+		}
+		return errors.WithStack(err)
+	}
+
+	components := make([]Product, 0, len(bom.Components))
+	for _, c := range bom.Components {
+		component, err := s.repo.GetProduct(ctx, c.Sku, txs...)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to get component %s", c.Sku)
+		}
+		components = append(components, component)
+	}
+	kit.Available = KitAvailable(bom, components)
+	return nil
+}
+
+// publishKits publishes the availability of every kit the sku is a component of.
+func (s *service) publishKits(ctx context.Context, sku string, tx db.Transaction) error {
+	kits, err := s.repo.GetKitsContaining(ctx, sku, tx)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to get kits containing %s", sku)
+	}
+	for _, kit := range kits {
+		if err = s.deriveKit(ctx, &kit, tx); err != nil {
+			return err
+		}
+		body, err := json.Marshal(kit)
+		if err != nil {
+			return errors.WithMessage(err, "failed to serialize message for queue")
+		}
+		if err = s.bq.Publish(ctx, s.exchanges.Inventory, body); err != nil {
+			return errors.WithMessage(err, "failed to send kit inventory update to queue")
+		}
+	}
+	return nil
+}
+
+// reserveKit saves the kit reservation together with a reservation for each of its components, fills the reservations
+// of the components from what is available and backorders what they couldn't fill, all in a single transaction. The
+// kit reservation follows its components along.
+func (s *service) reserveKit(ctx context.Context, kit Product, res *Reservation, hash string) error {
+	const funcName = "reserveKit"
+
+	bom, err := s.repo.GetBom(ctx, kit.Sku, 0)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return validation("kit %s has no components", kit.Sku)
+		}
+		return errors.WithStack(err)
+	}
+	if len(bom.Components) == 0 {
+		return validation("kit %s has no components", kit.Sku)
+	}
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Msg("saving kit reservation")
+	if err = s.repo.SaveReservation(ctx, res, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithStack(err)
+	}
+	created := *res
+
+	components := make([]Reservation, 0, len(bom.Components))
+	for _, c := range bom.Components {
+		component := Reservation{
+			Requester:         res.Requester,
+			Sku:               c.Sku,
+			State:             Open,
+			RequestedQuantity: c.Quantity * res.RequestedQuantity,
+			KitReservationID:  res.ID,
+			Created:           res.Created,
+		}
+		if res.RequestID != "" {
+			component.RequestID = fmt.Sprintf("%s/%s", res.RequestID, c.Sku)
+		}
+		log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Str("component", c.Sku).
+			Msg("saving component reservation")
+		if err = s.repo.SaveReservation(ctx, &component, tx); err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithMessagef(err, "failed to reserve component %s", c.Sku)
+		}
+		components = append(components, component)
+	}
+
+	if res.RequestID != "" {
+		if err = s.saveIdempotencyKey(ctx, OpReserve, res.RequestID, hash, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	// as with any other reservation, the components wait their turn behind the ones already open
+	filled := make([]Product, 0, len(components))
+	fills := make([][]fill, 0, len(components))
+	for i := range components {
+		component, err := s.repo.GetProduct(ctx, components[i].Sku, tx)
+		if err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithMessagef(err, "failed to get component %s", components[i].Sku)
+		}
+		log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Str("component", component.Sku).
+			Msg("filling reserves")
+		f, err := s.fillQueue(ctx, &component, tx)
+		if err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithMessagef(err, "failed to fill reserves of component %s", component.Sku)
+		}
+		filled = append(filled, component)
+		fills = append(fills, f)
+	}
+
+	// filling the components may have moved the kit reservation along
+	if *res, err = s.repo.GetReservation(ctx, res.ID, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithStack(err)
+	}
+	if res.backorderable() {
+		if err = s.markBackordered(ctx, res, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	after := kit
+	if err = s.deriveKit(ctx, &after, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+	if err = s.record(ctx, audit.Reserve, kit.Sku, kit, after, res, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	observeReservation(eventCreated, created)
+	for i := range filled {
+		observeFills(fills[i], filled[i])
+	}
+	return nil
+}
+
+// kitFill is what filling one of the component reservations of a kit did to the rest of the kit.
+type kitFill struct {
+	reservation Reservation
+	closed      []Reservation
+	components  []Product
+}
+
+func (k kitFill) observe() {
+	if k.reservation.State != Closed {
+		return
+	}
+	observeReservation(eventClosed, k.reservation)
+	for _, res := range k.closed {
+		observeReservation(eventClosed, res)
+	}
+	for _, component := range k.components {
+		observeStock(component)
+	}
+}
+
+// fillKitReservation brings a kit reservation in line with one of its component reservations being filled, as part of
+// the transaction filling it. The kits reserved are the complete sets the components have reserved between them. Once
+// every component is fully reserved the kit reservation closes together with the other component reservations, and
+// the caller closes the one it filled.
+func (s *service) fillKitReservation(ctx context.Context, filled Reservation, tx db.Transaction) (kitFill, error) {
+	fill := kitFill{}
+	kit, err := s.repo.GetReservation(ctx, filled.KitReservationID, tx)
+	if err != nil {
+		return fill, errors.WithMessagef(err, "failed to get kit reservation %d", filled.KitReservationID)
+	}
+	if kit.State != Open {
+		return fill, conflict(CodeReservationNotOpen, "kit reservation %d is %s", kit.ID, kit.State)
+	}
+
+	components, err := s.repo.GetComponentReservations(ctx, kit.ID, tx)
+	if err != nil {
+		return fill, errors.WithMessagef(err, "failed to get component reservations of %d", kit.ID)
+	}
+
+	reserved := kit.RequestedQuantity
+	for i, c := range components {
+		if c.ID == filled.ID {
+			components[i] = filled
+			c = filled
+		}
+		perKit := c.RequestedQuantity / kit.RequestedQuantity
+		if sets := c.ReservedQuantity / perKit; sets < reserved {
+			reserved = sets
+		}
+	}
+	kit.ReservedQuantity = reserved
+	if reserved == kit.RequestedQuantity {
+		kit.State = Closed
+	}
+	fill.reservation = kit
+
+	if err = s.repo.UpdateReservation(ctx, kit.ID, kit.State, kit.ReservedQuantity, tx); err != nil {
+		return fill, errors.WithStack(err)
+	}
+	if kit.State != Closed {
+		return fill, nil
+	}
+
+	for _, c := range components {
+		if c.ID == filled.ID {
+			continue
+		}
+		component, err := s.repo.GetProduct(ctx, c.Sku, tx)
+		if err != nil {
+			return fill, errors.WithMessagef(err, "failed to get component %s", c.Sku)
+		}
+		if err = s.closeReservation(&component, &c); err != nil {
+			return fill, errors.WithStack(err)
+		}
+		if err = s.repo.UpdateReservation(ctx, c.ID, c.State, c.ReservedQuantity, tx); err != nil {
+			return fill, errors.WithStack(err)
+		}
+		if component.Serialized {
+			if err = s.shipSerials(ctx, &c, tx); err != nil {
+				return fill, err
+			}
+		}
+		if err = s.saveProduct(ctx, &component, tx); err != nil {
+			return fill, errors.WithMessagef(err, "failed to save component %s", c.Sku)
+		}
+		if err = s.publishInventory(ctx, component, tx); err != nil {
+			return fill, errors.WithMessage(err, "failed to publish inventory")
+		}
+		if err = s.publishReservation(ctx, c); err != nil {
+			return fill, err
+		}
+		fill.closed = append(fill.closed, c)
+		fill.components = append(fill.components, component)
+	}
+	if err = s.publishReservation(ctx, kit); err != nil {
+		return fill, err
+	}
+	return fill, nil
+}
+
+// cancelKitReservation cancels a kit reservation together with its component reservations that are still open and
+// releases what they had reserved back to the components.
+func (s *service) cancelKitReservation(ctx context.Context, kit Product, res *Reservation) error {
+	const funcName = "cancelKitReservation"
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	reservations, err := s.repo.GetComponentReservations(ctx, res.ID, tx)
+	if err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessagef(err, "failed to get component reservations of %d", res.ID)
+	}
+
+	components := make([]Product, 0, len(reservations))
+	for _, cr := range reservations {
+		if cr.State != Open {
+			continue
+		}
+		component, err := s.repo.GetProduct(ctx, cr.Sku, tx)
+		if err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithMessagef(err, "failed to get component %s", cr.Sku)
+		}
+		component.Available += cr.ReservedQuantity
+		component.Reserved -= cr.ReservedQuantity
+
+		log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Str("component", cr.Sku).
+			Msg("cancelling component reservation")
+		if err = s.repo.UpdateReservation(ctx, cr.ID, Cancelled, cr.ReservedQuantity, tx); err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithStack(err)
+		}
+		if err = s.bookStock(ctx, cr.Sku, DefaultLocation, cr.ReservedQuantity, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+		if component.Serialized {
+			if err = s.releaseSerials(ctx, &cr, tx); err != nil {
+				rollback(ctx, tx, err)
+				return err
+			}
+		}
+		if err = s.saveProduct(ctx, &component, tx); err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithStack(err)
+		}
+		if err = s.evaluateStock(ctx, component, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+		if err = s.publishInventory(ctx, component, tx); err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithMessage(err, "failed to publish inventory")
+		}
+		components = append(components, component)
+	}
+
+	res.State = Cancelled
+	log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Msg("cancelling kit reservation")
+	if err = s.repo.UpdateReservation(ctx, res.ID, res.State, res.ReservedQuantity, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithStack(err)
+	}
+
+	after := kit
+	if err = s.deriveKit(ctx, &after, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+	if err = s.record(ctx, audit.CancelReservation, kit.Sku, kit, after, res, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	observeReservation(eventCancelled, *res)
+
+	for _, component := range components {
+		observeStock(component)
+		log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Str("component", component.Sku).
+			Msg("filling reserves")
+		if _, err = s.fillReserves(ctx, component); err != nil {
+			return errors.WithMessage(err, "failed to fill reserves after cancellation")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package inventory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultSkuMetricLimit is the number of SKUs given their own series before the rest are grouped together.
+const DefaultSkuMetricLimit = 500
+
+// otherSku labels counters for SKUs beyond the limit.
+const otherSku = "other"
+
+const (
+	eventCreated   = "created"
+	eventClosed    = "closed"
+	eventCancelled = "cancelled"
+)
+
+var (
+	unitsProduced = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "smfg_inventory_units_produced",
+			Help: "Number of units produced for the given sku",
+		},
+		[]string{"sku"},
+	)
+
+	reservationEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "smfg_inventory_reservations",
+			Help: "Number of reservations created, closed or cancelled",
+		},
+		[]string{"event"},
+	)
+
+	reservationFillLatency = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "smfg_inventory_reservation_fill_seconds",
+			Help:    "Time from a reservation being created to it being closed",
+			Buckets: []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600, 7 * 24 * 3600},
+		},
+	)
+
+	openReservations = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "smfg_inventory_open_reservations",
+			Help: "Number of reservations waiting to be filled",
+		},
+	)
+
+	oldestOpenReservation = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "smfg_inventory_oldest_open_reservation_age_seconds",
+			Help: "Age of the oldest reservation waiting to be filled",
+		},
+	)
+
+	availableUnits = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "smfg_inventory_available_units",
+			Help: "Units of the given sku available to reserve",
+		},
+		[]string{"sku"},
+	)
+
+	reservedUnits = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "smfg_inventory_reserved_units",
+			Help: "Units of the given sku held by open reservations",
+		},
+		[]string{"sku"},
+	)
+
+	skuLabels = &skuGuard{limit: DefaultSkuMetricLimit, seen: map[string]bool{}}
+)
+
+// skuGuard keeps the number of per sku series bounded. The first limit SKUs seen keep their own label.
+type skuGuard struct {
+	mu    sync.Mutex
+	limit int
+	seen  map[string]bool
+}
+
+func (g *skuGuard) allow(sku string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.seen[sku] {
+		return true
+	}
+	if len(g.seen) >= g.limit {
+		return false
+	}
+	g.seen[sku] = true
+	return true
+}
+
+// LimitSkuMetrics sets how many SKUs get their own series. Production by any further SKUs is counted under "other"
+// and their stock gauges are not reported.
+func LimitSkuMetrics(limit int) {
+	skuLabels.mu.Lock()
+	defer skuLabels.mu.Unlock()
+	skuLabels.limit = limit
+}
+
+func observeProduction(sku string, quantity int64) {
+	if !skuLabels.allow(sku) {
+		sku = otherSku
+	}
+	unitsProduced.WithLabelValues(sku).Add(float64(quantity))
+}
+
+func observeStock(product Product) {
+	if !skuLabels.allow(product.Sku) {
+		return
+	}
+	availableUnits.WithLabelValues(product.Sku).Set(float64(product.Available))
+	reservedUnits.WithLabelValues(product.Sku).Set(float64(product.Reserved))
+}
+
+func observeReservation(event string, res Reservation) {
+	reservationEvents.WithLabelValues(event).Inc()
+	if event == eventClosed && !res.Created.IsZero() {
+		reservationFillLatency.Observe(time.Since(res.Created).Seconds())
+	}
+}
+
+// ReservationBacklog is a value object. A summary of the reservations waiting to be filled.
+type ReservationBacklog struct {
+	Open   int64
+	Oldest time.Time
+}
+
+// CollectBacklogMetrics periodically refreshes the open reservation gauges until the context is cancelled.
+func CollectBacklogMetrics(ctx context.Context, repo Repository, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			backlog, err := repo.GetReservationBacklog(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("failed to collect reservation backlog")
+				continue
+			}
+			observeBacklog(backlog, time.Now())
+		}
+	}
+}
+
+func observeBacklog(backlog ReservationBacklog, now time.Time) {
+	openReservations.Set(float64(backlog.Open))
+	age := 0.0
+	if backlog.Open > 0 && !backlog.Oldest.IsZero() {
+		age = now.Sub(backlog.Oldest).Seconds()
+	}
+	oldestOpenReservation.Set(age)
+}
+
+func init() {
+	prometheus.MustRegister(unitsProduced)
+	prometheus.MustRegister(reservationEvents)
+	prometheus.MustRegister(reservationFillLatency)
+	prometheus.MustRegister(openReservations)
+	prometheus.MustRegister(oldestOpenReservation)
+	prometheus.MustRegister(availableUnits)
+	prometheus.MustRegister(reservedUnits)
+}
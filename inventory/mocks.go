@@ -4,13 +4,17 @@ import (
 	"context"
 	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
-	"github.com/sksmith/bunnyq"
+	"github.com/sksmith/smfg-inventory/broker"
 	"github.com/sksmith/smfg-inventory/db"
+	"time"
 )
 
 type MockRepo struct {
 	SaveProductionEventFunc           func(ctx context.Context, event *ProductionEvent, tx ...db.Transaction) error
 	UpdateReservationFunc             func(ctx context.Context, ID uint64, state ReserveState, qty int64, txs ...db.Transaction) error
+	UpdateRequestedQuantityFunc       func(ctx context.Context, ID uint64, requested, shipped int64, txs ...db.Transaction) error
+	UpdateBackorderFunc               func(ctx context.Context, ID uint64, estimatedFill *time.Time, txs ...db.Transaction) error
+	GetBackordersFunc                 func(ctx context.Context, limit, offset int, txs ...db.Transaction) ([]Backorder, error)
 	GetProductionEventByRequestIDFunc func(ctx context.Context, requestID string, tx ...db.Transaction) (pe ProductionEvent, err error)
 	SaveReservationFunc               func(ctx context.Context, reservation *Reservation, tx ...db.Transaction) error
 	GetSkuReservesByStateFunc         func(ctx context.Context, sku string, state ReserveState, limit, offset int, tx ...db.Transaction) ([]Reservation, error)
@@ -19,6 +23,69 @@ type MockRepo struct {
 	GetAllProductsFunc                func(ctx context.Context, limit int, offset int, tx ...db.Transaction) ([]Product, error)
 	BeginTransactionFunc              func(ctx context.Context) (db.Transaction, error)
 	GetReservationByRequestIDFunc     func(ctx context.Context, requestId string, tx ...db.Transaction) (Reservation, error)
+	GetReservationFunc                func(ctx context.Context, ID uint64, tx ...db.Transaction) (Reservation, error)
+	SaveAdjustmentFunc                func(ctx context.Context, adj *Adjustment, tx ...db.Transaction) error
+	GetAdjustmentByRequestIDFunc      func(ctx context.Context, requestID string, tx ...db.Transaction) (Adjustment, error)
+	SaveStatusChangeFunc              func(ctx context.Context, change *StatusChange, tx ...db.Transaction) error
+	GetStatusChangeByRequestIDFunc    func(ctx context.Context, requestID string, tx ...db.Transaction) (StatusChange, error)
+	GetIdempotencyKeyFunc             func(ctx context.Context, op Operation, key string, tx ...db.Transaction) (IdempotencyKey, error)
+	SaveIdempotencyKeyFunc            func(ctx context.Context, key *IdempotencyKey, tx ...db.Transaction) error
+	DeleteIdempotencyKeysFunc         func(ctx context.Context, before time.Time, tx ...db.Transaction) (int64, error)
+	GetReservationBacklogFunc         func(ctx context.Context, tx ...db.Transaction) (ReservationBacklog, error)
+	GetStockAlertFunc                 func(ctx context.Context, sku string, tx ...db.Transaction) (StockAlert, error)
+	GetStockAlertsFunc                func(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]StockAlert, error)
+	SaveStockAlertFunc                func(ctx context.Context, alert StockAlert, tx ...db.Transaction) error
+	DeleteStockAlertFunc              func(ctx context.Context, sku string, tx ...db.Transaction) error
+	GetDailyDemandFunc                func(ctx context.Context, sku string, since, until time.Time, tx ...db.Transaction) ([]DailyQuantity, error)
+	GetCompletedProductionOrdersFunc  func(ctx context.Context, sku string, since, until time.Time, tx ...db.Transaction) ([]ProductionOrder, error)
+	GetSkuBacklogFunc                 func(ctx context.Context, sku string, tx ...db.Transaction) (int64, error)
+	SaveReplenishmentFunc             func(ctx context.Context, r Replenishment, tx ...db.Transaction) error
+	GetLatestReplenishmentsFunc       func(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]Replenishment, error)
+	SaveProductionOrderFunc           func(ctx context.Context, order *ProductionOrder, tx ...db.Transaction) error
+	GetProductionOrderFunc            func(ctx context.Context, ID uint64, tx ...db.Transaction) (ProductionOrder, error)
+	GetSkuProductionOrdersFunc        func(ctx context.Context, sku string, tx ...db.Transaction) ([]ProductionOrder, error)
+	UpdateProductionOrderFunc         func(ctx context.Context, order ProductionOrder, tx ...db.Transaction) error
+	SaveBomFunc                       func(ctx context.Context, bom *BillOfMaterials, tx ...db.Transaction) error
+	GetBomFunc                        func(ctx context.Context, sku string, version int, tx ...db.Transaction) (BillOfMaterials, error)
+	GetComponentReservationsFunc      func(ctx context.Context, kitReservationID uint64, tx ...db.Transaction) ([]Reservation, error)
+	GetKitsContainingFunc             func(ctx context.Context, sku string, tx ...db.Transaction) ([]Product, error)
+	SaveSerialFunc                    func(ctx context.Context, serial Serial, tx ...db.Transaction) error
+	UpdateSerialFunc                  func(ctx context.Context, serial Serial, tx ...db.Transaction) error
+	GetSerialFunc                     func(ctx context.Context, serial string, tx ...db.Transaction) (Serial, error)
+	GetAvailableSerialsFunc           func(ctx context.Context, sku string, limit int64, tx ...db.Transaction) ([]Serial, error)
+	GetReservationSerialsFunc         func(ctx context.Context, reservationID uint64, tx ...db.Transaction) ([]Serial, error)
+	GetProductionSerialsFunc          func(ctx context.Context, productionEventID uint64, tx ...db.Transaction) ([]Serial, error)
+	SaveSerialEventFunc               func(ctx context.Context, event *SerialEvent, tx ...db.Transaction) error
+	GetSerialEventsFunc               func(ctx context.Context, serial string, tx ...db.Transaction) ([]SerialEvent, error)
+	SaveUnitsFunc                     func(ctx context.Context, sku string, units Units, tx ...db.Transaction) error
+	GetUnitsFunc                      func(ctx context.Context, sku string, tx ...db.Transaction) (Units, error)
+	SaveLocationFunc                  func(ctx context.Context, loc *Location, tx ...db.Transaction) error
+	GetLocationFunc                   func(ctx context.Context, code string, tx ...db.Transaction) (Location, error)
+	GetLocationsFunc                  func(ctx context.Context, tx ...db.Transaction) ([]Location, error)
+	GetLocationStockFunc              func(ctx context.Context, sku string, tx ...db.Transaction) ([]LocationStock, error)
+	AddLocationStockFunc              func(ctx context.Context, sku, location string, qty int64, tx ...db.Transaction) error
+	SaveTransferFunc                  func(ctx context.Context, transfer *Transfer, tx ...db.Transaction) error
+	UpdateTransferFunc                func(ctx context.Context, transfer Transfer, tx ...db.Transaction) error
+	GetTransferFunc                   func(ctx context.Context, ID uint64, tx ...db.Transaction) (Transfer, error)
+	GetTransferByRequestIDFunc        func(ctx context.Context, requestID string, tx ...db.Transaction) (Transfer, error)
+	SaveTransferReceiptFunc           func(ctx context.Context, receipt *TransferReceipt, tx ...db.Transaction) error
+	GetTransferReceiptByRequestIDFunc func(ctx context.Context, requestID string, tx ...db.Transaction) (TransferReceipt, error)
+	SaveCycleCountFunc                func(ctx context.Context, count *CycleCount, tx ...db.Transaction) error
+	UpdateCycleCountFunc              func(ctx context.Context, count CycleCount, tx ...db.Transaction) error
+	GetCycleCountFunc                 func(ctx context.Context, ID uint64, tx ...db.Transaction) (CycleCount, error)
+	GetCycleCountsFunc                func(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]CycleCount, error)
+	SaveReturnFunc                    func(ctx context.Context, rma *Return, tx ...db.Transaction) error
+	UpdateReturnFunc                  func(ctx context.Context, rma Return, tx ...db.Transaction) error
+	GetReturnFunc                     func(ctx context.Context, ID uint64, tx ...db.Transaction) (Return, error)
+	GetReturnByRequestIDFunc          func(ctx context.Context, requestID string, tx ...db.Transaction) (Return, error)
+	GetReturnedQuantityFunc           func(ctx context.Context, reservationID uint64, tx ...db.Transaction) (int64, error)
+	SaveReturnReceiptFunc             func(ctx context.Context, receipt *ReturnReceipt, tx ...db.Transaction) error
+	GetReturnReceiptByRequestIDFunc   func(ctx context.Context, requestID string, tx ...db.Transaction) (ReturnReceipt, error)
+	SaveSalesOrderFunc                func(ctx context.Context, order *SalesOrder, tx ...db.Transaction) error
+	UpdateSalesOrderFunc              func(ctx context.Context, order SalesOrder, tx ...db.Transaction) error
+	GetSalesOrderFunc                 func(ctx context.Context, ID uint64, tx ...db.Transaction) (SalesOrder, error)
+	GetSalesOrderByRequestIDFunc      func(ctx context.Context, requestID string, tx ...db.Transaction) (SalesOrder, error)
+	GetActiveSalesOrdersFunc          func(ctx context.Context, sku string, tx ...db.Transaction) ([]SalesOrder, error)
 }
 
 func (r MockRepo) SaveProductionEvent(ctx context.Context, event *ProductionEvent, tx ...db.Transaction) error {
@@ -29,6 +96,18 @@ func (r MockRepo) UpdateReservation(ctx context.Context, ID uint64, state Reserv
 	return r.UpdateReservationFunc(ctx, ID, state, qty, txs...)
 }
 
+func (r MockRepo) UpdateRequestedQuantity(ctx context.Context, ID uint64, requested, shipped int64, txs ...db.Transaction) error {
+	return r.UpdateRequestedQuantityFunc(ctx, ID, requested, shipped, txs...)
+}
+
+func (r MockRepo) UpdateBackorder(ctx context.Context, ID uint64, estimatedFill *time.Time, txs ...db.Transaction) error {
+	return r.UpdateBackorderFunc(ctx, ID, estimatedFill, txs...)
+}
+
+func (r MockRepo) GetBackorders(ctx context.Context, limit, offset int, txs ...db.Transaction) ([]Backorder, error) {
+	return r.GetBackordersFunc(ctx, limit, offset, txs...)
+}
+
 func (r MockRepo) GetProductionEventByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (pe ProductionEvent, err error) {
 	return r.GetProductionEventByRequestIDFunc(ctx, requestID, tx...)
 }
@@ -61,6 +140,258 @@ func (r MockRepo) GetReservationByRequestID(ctx context.Context, requestId strin
 	return r.GetReservationByRequestIDFunc(ctx, requestId, tx...)
 }
 
+func (r MockRepo) GetReservation(ctx context.Context, ID uint64, tx ...db.Transaction) (Reservation, error) {
+	return r.GetReservationFunc(ctx, ID, tx...)
+}
+
+func (r MockRepo) SaveAdjustment(ctx context.Context, adj *Adjustment, tx ...db.Transaction) error {
+	return r.SaveAdjustmentFunc(ctx, adj, tx...)
+}
+
+func (r MockRepo) GetAdjustmentByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (Adjustment, error) {
+	return r.GetAdjustmentByRequestIDFunc(ctx, requestID, tx...)
+}
+
+func (r MockRepo) SaveStatusChange(ctx context.Context, change *StatusChange, tx ...db.Transaction) error {
+	return r.SaveStatusChangeFunc(ctx, change, tx...)
+}
+
+func (r MockRepo) GetStatusChangeByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (StatusChange, error) {
+	return r.GetStatusChangeByRequestIDFunc(ctx, requestID, tx...)
+}
+
+func (r MockRepo) GetIdempotencyKey(ctx context.Context, op Operation, key string, tx ...db.Transaction) (IdempotencyKey, error) {
+	return r.GetIdempotencyKeyFunc(ctx, op, key, tx...)
+}
+
+func (r MockRepo) SaveIdempotencyKey(ctx context.Context, key *IdempotencyKey, tx ...db.Transaction) error {
+	return r.SaveIdempotencyKeyFunc(ctx, key, tx...)
+}
+
+func (r MockRepo) DeleteIdempotencyKeys(ctx context.Context, before time.Time, tx ...db.Transaction) (int64, error) {
+	return r.DeleteIdempotencyKeysFunc(ctx, before, tx...)
+}
+
+func (r MockRepo) GetReservationBacklog(ctx context.Context, tx ...db.Transaction) (ReservationBacklog, error) {
+	return r.GetReservationBacklogFunc(ctx, tx...)
+}
+
+func (r MockRepo) GetStockAlert(ctx context.Context, sku string, tx ...db.Transaction) (StockAlert, error) {
+	return r.GetStockAlertFunc(ctx, sku, tx...)
+}
+
+func (r MockRepo) GetStockAlerts(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]StockAlert, error) {
+	return r.GetStockAlertsFunc(ctx, limit, offset, tx...)
+}
+
+func (r MockRepo) SaveStockAlert(ctx context.Context, alert StockAlert, tx ...db.Transaction) error {
+	return r.SaveStockAlertFunc(ctx, alert, tx...)
+}
+
+func (r MockRepo) DeleteStockAlert(ctx context.Context, sku string, tx ...db.Transaction) error {
+	return r.DeleteStockAlertFunc(ctx, sku, tx...)
+}
+
+func (r MockRepo) GetDailyDemand(ctx context.Context, sku string, since, until time.Time, tx ...db.Transaction) ([]DailyQuantity, error) {
+	return r.GetDailyDemandFunc(ctx, sku, since, until, tx...)
+}
+
+func (r MockRepo) GetCompletedProductionOrders(ctx context.Context, sku string, since, until time.Time, tx ...db.Transaction) ([]ProductionOrder, error) {
+	return r.GetCompletedProductionOrdersFunc(ctx, sku, since, until, tx...)
+}
+
+func (r MockRepo) GetSkuBacklog(ctx context.Context, sku string, tx ...db.Transaction) (int64, error) {
+	return r.GetSkuBacklogFunc(ctx, sku, tx...)
+}
+
+func (r MockRepo) SaveReplenishment(ctx context.Context, rep Replenishment, tx ...db.Transaction) error {
+	return r.SaveReplenishmentFunc(ctx, rep, tx...)
+}
+
+func (r MockRepo) GetLatestReplenishments(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]Replenishment, error) {
+	return r.GetLatestReplenishmentsFunc(ctx, limit, offset, tx...)
+}
+
+func (r MockRepo) SaveProductionOrder(ctx context.Context, order *ProductionOrder, tx ...db.Transaction) error {
+	return r.SaveProductionOrderFunc(ctx, order, tx...)
+}
+
+func (r MockRepo) GetProductionOrder(ctx context.Context, ID uint64, tx ...db.Transaction) (ProductionOrder, error) {
+	return r.GetProductionOrderFunc(ctx, ID, tx...)
+}
+
+func (r MockRepo) GetSkuProductionOrders(ctx context.Context, sku string, tx ...db.Transaction) ([]ProductionOrder, error) {
+	return r.GetSkuProductionOrdersFunc(ctx, sku, tx...)
+}
+
+func (r MockRepo) UpdateProductionOrder(ctx context.Context, order ProductionOrder, tx ...db.Transaction) error {
+	return r.UpdateProductionOrderFunc(ctx, order, tx...)
+}
+
+func (r MockRepo) SaveBom(ctx context.Context, bom *BillOfMaterials, tx ...db.Transaction) error {
+	return r.SaveBomFunc(ctx, bom, tx...)
+}
+
+func (r MockRepo) GetBom(ctx context.Context, sku string, version int, tx ...db.Transaction) (BillOfMaterials, error) {
+	return r.GetBomFunc(ctx, sku, version, tx...)
+}
+
+func (r MockRepo) GetComponentReservations(ctx context.Context, kitReservationID uint64, tx ...db.Transaction) ([]Reservation, error) {
+	return r.GetComponentReservationsFunc(ctx, kitReservationID, tx...)
+}
+
+func (r MockRepo) GetKitsContaining(ctx context.Context, sku string, tx ...db.Transaction) ([]Product, error) {
+	return r.GetKitsContainingFunc(ctx, sku, tx...)
+}
+
+func (r MockRepo) SaveSerial(ctx context.Context, serial Serial, tx ...db.Transaction) error {
+	return r.SaveSerialFunc(ctx, serial, tx...)
+}
+
+func (r MockRepo) UpdateSerial(ctx context.Context, serial Serial, tx ...db.Transaction) error {
+	return r.UpdateSerialFunc(ctx, serial, tx...)
+}
+
+func (r MockRepo) GetSerial(ctx context.Context, serial string, tx ...db.Transaction) (Serial, error) {
+	return r.GetSerialFunc(ctx, serial, tx...)
+}
+
+func (r MockRepo) GetAvailableSerials(ctx context.Context, sku string, limit int64, tx ...db.Transaction) ([]Serial, error) {
+	return r.GetAvailableSerialsFunc(ctx, sku, limit, tx...)
+}
+
+func (r MockRepo) GetReservationSerials(ctx context.Context, reservationID uint64, tx ...db.Transaction) ([]Serial, error) {
+	return r.GetReservationSerialsFunc(ctx, reservationID, tx...)
+}
+
+func (r MockRepo) GetProductionSerials(ctx context.Context, productionEventID uint64, tx ...db.Transaction) ([]Serial, error) {
+	return r.GetProductionSerialsFunc(ctx, productionEventID, tx...)
+}
+
+func (r MockRepo) SaveSerialEvent(ctx context.Context, event *SerialEvent, tx ...db.Transaction) error {
+	return r.SaveSerialEventFunc(ctx, event, tx...)
+}
+
+func (r MockRepo) GetSerialEvents(ctx context.Context, serial string, tx ...db.Transaction) ([]SerialEvent, error) {
+	return r.GetSerialEventsFunc(ctx, serial, tx...)
+}
+
+func (r MockRepo) SaveUnits(ctx context.Context, sku string, units Units, tx ...db.Transaction) error {
+	return r.SaveUnitsFunc(ctx, sku, units, tx...)
+}
+
+func (r MockRepo) GetUnits(ctx context.Context, sku string, tx ...db.Transaction) (Units, error) {
+	return r.GetUnitsFunc(ctx, sku, tx...)
+}
+
+func (r MockRepo) SaveLocation(ctx context.Context, loc *Location, tx ...db.Transaction) error {
+	return r.SaveLocationFunc(ctx, loc, tx...)
+}
+
+func (r MockRepo) GetLocation(ctx context.Context, code string, tx ...db.Transaction) (Location, error) {
+	return r.GetLocationFunc(ctx, code, tx...)
+}
+
+func (r MockRepo) GetLocations(ctx context.Context, tx ...db.Transaction) ([]Location, error) {
+	return r.GetLocationsFunc(ctx, tx...)
+}
+
+func (r MockRepo) GetLocationStock(ctx context.Context, sku string, tx ...db.Transaction) ([]LocationStock, error) {
+	return r.GetLocationStockFunc(ctx, sku, tx...)
+}
+
+func (r MockRepo) AddLocationStock(ctx context.Context, sku, location string, qty int64, tx ...db.Transaction) error {
+	return r.AddLocationStockFunc(ctx, sku, location, qty, tx...)
+}
+
+func (r MockRepo) SaveTransfer(ctx context.Context, transfer *Transfer, tx ...db.Transaction) error {
+	return r.SaveTransferFunc(ctx, transfer, tx...)
+}
+
+func (r MockRepo) UpdateTransfer(ctx context.Context, transfer Transfer, tx ...db.Transaction) error {
+	return r.UpdateTransferFunc(ctx, transfer, tx...)
+}
+
+func (r MockRepo) GetTransfer(ctx context.Context, ID uint64, tx ...db.Transaction) (Transfer, error) {
+	return r.GetTransferFunc(ctx, ID, tx...)
+}
+
+func (r MockRepo) GetTransferByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (Transfer, error) {
+	return r.GetTransferByRequestIDFunc(ctx, requestID, tx...)
+}
+
+func (r MockRepo) SaveTransferReceipt(ctx context.Context, receipt *TransferReceipt, tx ...db.Transaction) error {
+	return r.SaveTransferReceiptFunc(ctx, receipt, tx...)
+}
+
+func (r MockRepo) GetTransferReceiptByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (TransferReceipt, error) {
+	return r.GetTransferReceiptByRequestIDFunc(ctx, requestID, tx...)
+}
+
+func (r MockRepo) SaveCycleCount(ctx context.Context, count *CycleCount, tx ...db.Transaction) error {
+	return r.SaveCycleCountFunc(ctx, count, tx...)
+}
+
+func (r MockRepo) UpdateCycleCount(ctx context.Context, count CycleCount, tx ...db.Transaction) error {
+	return r.UpdateCycleCountFunc(ctx, count, tx...)
+}
+
+func (r MockRepo) GetCycleCount(ctx context.Context, ID uint64, tx ...db.Transaction) (CycleCount, error) {
+	return r.GetCycleCountFunc(ctx, ID, tx...)
+}
+
+func (r MockRepo) GetCycleCounts(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]CycleCount, error) {
+	return r.GetCycleCountsFunc(ctx, limit, offset, tx...)
+}
+
+func (r MockRepo) SaveReturn(ctx context.Context, rma *Return, tx ...db.Transaction) error {
+	return r.SaveReturnFunc(ctx, rma, tx...)
+}
+
+func (r MockRepo) UpdateReturn(ctx context.Context, rma Return, tx ...db.Transaction) error {
+	return r.UpdateReturnFunc(ctx, rma, tx...)
+}
+
+func (r MockRepo) GetReturn(ctx context.Context, ID uint64, tx ...db.Transaction) (Return, error) {
+	return r.GetReturnFunc(ctx, ID, tx...)
+}
+
+func (r MockRepo) GetReturnByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (Return, error) {
+	return r.GetReturnByRequestIDFunc(ctx, requestID, tx...)
+}
+
+func (r MockRepo) GetReturnedQuantity(ctx context.Context, reservationID uint64, tx ...db.Transaction) (int64, error) {
+	return r.GetReturnedQuantityFunc(ctx, reservationID, tx...)
+}
+
+func (r MockRepo) SaveReturnReceipt(ctx context.Context, receipt *ReturnReceipt, tx ...db.Transaction) error {
+	return r.SaveReturnReceiptFunc(ctx, receipt, tx...)
+}
+
+func (r MockRepo) GetReturnReceiptByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (ReturnReceipt, error) {
+	return r.GetReturnReceiptByRequestIDFunc(ctx, requestID, tx...)
+}
+
+func (r MockRepo) SaveSalesOrder(ctx context.Context, order *SalesOrder, tx ...db.Transaction) error {
+	return r.SaveSalesOrderFunc(ctx, order, tx...)
+}
+
+func (r MockRepo) UpdateSalesOrder(ctx context.Context, order SalesOrder, tx ...db.Transaction) error {
+	return r.UpdateSalesOrderFunc(ctx, order, tx...)
+}
+
+func (r MockRepo) GetSalesOrder(ctx context.Context, ID uint64, tx ...db.Transaction) (SalesOrder, error) {
+	return r.GetSalesOrderFunc(ctx, ID, tx...)
+}
+
+func (r MockRepo) GetSalesOrderByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (SalesOrder, error) {
+	return r.GetSalesOrderByRequestIDFunc(ctx, requestID, tx...)
+}
+
+func (r MockRepo) GetActiveSalesOrders(ctx context.Context, sku string, tx ...db.Transaction) ([]SalesOrder, error) {
+	return r.GetActiveSalesOrdersFunc(ctx, sku, tx...)
+}
+
 func NewMockRepo() MockRepo {
 	return MockRepo{
 		SaveProductionEventFunc: func(ctx context.Context, event *ProductionEvent, tx ...db.Transaction) error { return nil },
@@ -68,12 +399,158 @@ func NewMockRepo() MockRepo {
 			return ProductionEvent{}, nil
 		},
 		SaveReservationFunc:       func(ctx context.Context, reservation *Reservation, tx ...db.Transaction) error { return nil },
+		UpdateRequestedQuantityFunc: func(ctx context.Context, ID uint64, requested, shipped int64, txs ...db.Transaction) error {
+			return nil
+		},
+		UpdateBackorderFunc: func(ctx context.Context, ID uint64, estimatedFill *time.Time, txs ...db.Transaction) error {
+			return nil
+		},
+		GetBackordersFunc: func(ctx context.Context, limit, offset int, txs ...db.Transaction) ([]Backorder, error) {
+			return nil, nil
+		},
 		GetSkuReservesByStateFunc: func(ctx context.Context, sku string, state ReserveState, limit, offset int, tx ...db.Transaction) ([]Reservation, error) { return nil, nil },
 		SaveProductFunc:           func(ctx context.Context, product Product, tx ...db.Transaction) error { return nil },
 		GetProductFunc:            func(ctx context.Context, sku string, tx ...db.Transaction) (Product, error) { return Product{}, nil },
 		GetAllProductsFunc:        func(ctx context.Context, limit int, offset int, tx ...db.Transaction) ([]Product, error) { return nil, nil },
 		BeginTransactionFunc:      func(ctx context.Context) (db.Transaction, error) { return MockTransaction{}, nil },
 		GetReservationByRequestIDFunc: func(ctx context.Context, requestId string, tx ...db.Transaction) (Reservation, error) {return Reservation{}, nil },
+		GetReservationFunc:            func(ctx context.Context, ID uint64, tx ...db.Transaction) (Reservation, error) { return Reservation{}, nil },
+		SaveAdjustmentFunc:            func(ctx context.Context, adj *Adjustment, tx ...db.Transaction) error { return nil },
+		GetAdjustmentByRequestIDFunc: func(ctx context.Context, requestID string, tx ...db.Transaction) (Adjustment, error) {
+			return Adjustment{}, nil
+		},
+		SaveStatusChangeFunc: func(ctx context.Context, change *StatusChange, tx ...db.Transaction) error { return nil },
+		GetStatusChangeByRequestIDFunc: func(ctx context.Context, requestID string, tx ...db.Transaction) (StatusChange, error) {
+			return StatusChange{}, nil
+		},
+		GetIdempotencyKeyFunc: func(ctx context.Context, op Operation, key string, tx ...db.Transaction) (IdempotencyKey, error) {
+			return IdempotencyKey{}, nil
+		},
+		SaveIdempotencyKeyFunc: func(ctx context.Context, key *IdempotencyKey, tx ...db.Transaction) error { return nil },
+		DeleteIdempotencyKeysFunc: func(ctx context.Context, before time.Time, tx ...db.Transaction) (int64, error) {
+			return 0, nil
+		},
+		GetReservationBacklogFunc: func(ctx context.Context, tx ...db.Transaction) (ReservationBacklog, error) {
+			return ReservationBacklog{}, nil
+		},
+		GetStockAlertFunc: func(ctx context.Context, sku string, tx ...db.Transaction) (StockAlert, error) {
+			return StockAlert{}, nil
+		},
+		GetStockAlertsFunc: func(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]StockAlert, error) {
+			return []StockAlert{}, nil
+		},
+		SaveStockAlertFunc:   func(ctx context.Context, alert StockAlert, tx ...db.Transaction) error { return nil },
+		DeleteStockAlertFunc: func(ctx context.Context, sku string, tx ...db.Transaction) error { return nil },
+		GetDailyDemandFunc: func(ctx context.Context, sku string, since, until time.Time, tx ...db.Transaction) ([]DailyQuantity, error) {
+			return []DailyQuantity{}, nil
+		},
+		GetCompletedProductionOrdersFunc: func(ctx context.Context, sku string, since, until time.Time, tx ...db.Transaction) ([]ProductionOrder, error) {
+			return []ProductionOrder{}, nil
+		},
+		GetSkuBacklogFunc:     func(ctx context.Context, sku string, tx ...db.Transaction) (int64, error) { return 0, nil },
+		SaveReplenishmentFunc: func(ctx context.Context, r Replenishment, tx ...db.Transaction) error { return nil },
+		GetLatestReplenishmentsFunc: func(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]Replenishment, error) {
+			return []Replenishment{}, nil
+		},
+		SaveProductionOrderFunc: func(ctx context.Context, order *ProductionOrder, tx ...db.Transaction) error { return nil },
+		GetProductionOrderFunc: func(ctx context.Context, ID uint64, tx ...db.Transaction) (ProductionOrder, error) {
+			return ProductionOrder{}, nil
+		},
+		GetSkuProductionOrdersFunc: func(ctx context.Context, sku string, tx ...db.Transaction) ([]ProductionOrder, error) {
+			return []ProductionOrder{}, nil
+		},
+		UpdateProductionOrderFunc: func(ctx context.Context, order ProductionOrder, tx ...db.Transaction) error { return nil },
+		SaveBomFunc:               func(ctx context.Context, bom *BillOfMaterials, tx ...db.Transaction) error { return nil },
+		GetBomFunc: func(ctx context.Context, sku string, version int, tx ...db.Transaction) (BillOfMaterials, error) {
+			return BillOfMaterials{}, nil
+		},
+		GetComponentReservationsFunc: func(ctx context.Context, kitReservationID uint64, tx ...db.Transaction) ([]Reservation, error) {
+			return nil, nil
+		},
+		GetKitsContainingFunc: func(ctx context.Context, sku string, tx ...db.Transaction) ([]Product, error) {
+			return nil, nil
+		},
+		SaveSerialFunc:   func(ctx context.Context, serial Serial, tx ...db.Transaction) error { return nil },
+		UpdateSerialFunc: func(ctx context.Context, serial Serial, tx ...db.Transaction) error { return nil },
+		GetSerialFunc: func(ctx context.Context, serial string, tx ...db.Transaction) (Serial, error) {
+			return Serial{}, nil
+		},
+		GetAvailableSerialsFunc: func(ctx context.Context, sku string, limit int64, tx ...db.Transaction) ([]Serial, error) {
+			return nil, nil
+		},
+		GetReservationSerialsFunc: func(ctx context.Context, reservationID uint64, tx ...db.Transaction) ([]Serial, error) {
+			return nil, nil
+		},
+		GetProductionSerialsFunc: func(ctx context.Context, productionEventID uint64, tx ...db.Transaction) ([]Serial, error) {
+			return nil, nil
+		},
+		SaveSerialEventFunc: func(ctx context.Context, event *SerialEvent, tx ...db.Transaction) error { return nil },
+		GetSerialEventsFunc: func(ctx context.Context, serial string, tx ...db.Transaction) ([]SerialEvent, error) {
+			return nil, nil
+		},
+		SaveUnitsFunc: func(ctx context.Context, sku string, units Units, tx ...db.Transaction) error { return nil },
+		GetUnitsFunc: func(ctx context.Context, sku string, tx ...db.Transaction) (Units, error) {
+			return nil, nil
+		},
+		SaveLocationFunc: func(ctx context.Context, loc *Location, tx ...db.Transaction) error { return nil },
+		GetLocationFunc: func(ctx context.Context, code string, tx ...db.Transaction) (Location, error) {
+			return Location{Code: code}, nil
+		},
+		GetLocationsFunc: func(ctx context.Context, tx ...db.Transaction) ([]Location, error) {
+			return nil, nil
+		},
+		GetLocationStockFunc: func(ctx context.Context, sku string, tx ...db.Transaction) ([]LocationStock, error) {
+			return nil, nil
+		},
+		AddLocationStockFunc: func(ctx context.Context, sku, location string, qty int64, tx ...db.Transaction) error {
+			return nil
+		},
+		SaveTransferFunc:   func(ctx context.Context, transfer *Transfer, tx ...db.Transaction) error { return nil },
+		UpdateTransferFunc: func(ctx context.Context, transfer Transfer, tx ...db.Transaction) error { return nil },
+		GetTransferFunc: func(ctx context.Context, ID uint64, tx ...db.Transaction) (Transfer, error) {
+			return Transfer{}, nil
+		},
+		GetTransferByRequestIDFunc: func(ctx context.Context, requestID string, tx ...db.Transaction) (Transfer, error) {
+			return Transfer{}, nil
+		},
+		SaveTransferReceiptFunc: func(ctx context.Context, receipt *TransferReceipt, tx ...db.Transaction) error { return nil },
+		GetTransferReceiptByRequestIDFunc: func(ctx context.Context, requestID string, tx ...db.Transaction) (TransferReceipt, error) {
+			return TransferReceipt{}, nil
+		},
+		SaveCycleCountFunc:   func(ctx context.Context, count *CycleCount, tx ...db.Transaction) error { return nil },
+		UpdateCycleCountFunc: func(ctx context.Context, count CycleCount, tx ...db.Transaction) error { return nil },
+		GetCycleCountFunc: func(ctx context.Context, ID uint64, tx ...db.Transaction) (CycleCount, error) {
+			return CycleCount{}, nil
+		},
+		GetCycleCountsFunc: func(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]CycleCount, error) {
+			return nil, nil
+		},
+		SaveReturnFunc:   func(ctx context.Context, rma *Return, tx ...db.Transaction) error { return nil },
+		UpdateReturnFunc: func(ctx context.Context, rma Return, tx ...db.Transaction) error { return nil },
+		GetReturnFunc: func(ctx context.Context, ID uint64, tx ...db.Transaction) (Return, error) {
+			return Return{}, nil
+		},
+		GetReturnByRequestIDFunc: func(ctx context.Context, requestID string, tx ...db.Transaction) (Return, error) {
+			return Return{}, nil
+		},
+		GetReturnedQuantityFunc: func(ctx context.Context, reservationID uint64, tx ...db.Transaction) (int64, error) {
+			return 0, nil
+		},
+		SaveReturnReceiptFunc: func(ctx context.Context, receipt *ReturnReceipt, tx ...db.Transaction) error { return nil },
+		GetReturnReceiptByRequestIDFunc: func(ctx context.Context, requestID string, tx ...db.Transaction) (ReturnReceipt, error) {
+			return ReturnReceipt{}, nil
+		},
+		SaveSalesOrderFunc:   func(ctx context.Context, order *SalesOrder, tx ...db.Transaction) error { return nil },
+		UpdateSalesOrderFunc: func(ctx context.Context, order SalesOrder, tx ...db.Transaction) error { return nil },
+		GetSalesOrderFunc: func(ctx context.Context, ID uint64, tx ...db.Transaction) (SalesOrder, error) {
+			return SalesOrder{}, nil
+		},
+		GetSalesOrderByRequestIDFunc: func(ctx context.Context, requestID string, tx ...db.Transaction) (SalesOrder, error) {
+			return SalesOrder{}, nil
+		},
+		GetActiveSalesOrdersFunc: func(ctx context.Context, sku string, tx ...db.Transaction) ([]SalesOrder, error) {
+			return nil, nil
+		},
 	}
 }
 
@@ -105,17 +582,17 @@ func (m MockTransaction) Begin(_ context.Context) (pgx.Tx, error) {
 }
 
 type MockQueue struct {
-	PublishFunc func(ctx context.Context, exchange string, body []byte, options ...bunnyq.PublishOption) error
+	PublishFunc func(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error
 }
 
 func NewMockQueue() MockQueue {
 	return MockQueue{
-		PublishFunc: func(ctx context.Context, exchange string, body []byte, options ...bunnyq.PublishOption) error {
+		PublishFunc: func(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error {
 			return nil
 		},
 	}
 }
 
-func (m MockQueue) Publish(ctx context.Context, exchange string, body []byte, options ...bunnyq.PublishOption) error {
+func (m MockQueue) Publish(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error {
 	return m.PublishFunc(ctx, exchange, body, options...)
 }
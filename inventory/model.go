@@ -6,43 +6,224 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"sort"
 	"time"
 
 	"github.com/jinzhu/copier"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
-	"github.com/sksmith/bunnyq"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/broker"
 	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/settings"
 )
 
-func NewService(repo Repository, bq Queue, invExchange, resExchange string) *service {
-	return &service{repo: repo, bq: bq, invExchange: invExchange, resExchange: resExchange}
+// Exchanges are the broker exchanges events are published to.
+type Exchanges struct {
+	Inventory   string
+	Reservation string
+	Alert       string
+	Order       string
+	Location    string
+	Return      string
+	SalesOrder  string
+	Backorder   string
+}
+
+func NewService(repo Repository, auditRepo audit.Repository, store *settings.Store, bq Queue, exchanges Exchanges) *service {
+	return &service{repo: repo, audit: auditRepo, settings: store, bq: bq, exchanges: exchanges}
 }
 
 type Queue interface {
-	Publish(ctx context.Context, exchange string, body []byte, options ...bunnyq.PublishOption) error
+	Publish(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error
 }
 
 type Service interface {
 	Produce(ctx context.Context, product Product, event *ProductionEvent) error
 	Reserve(ctx context.Context, product Product, res *Reservation) error
+	CancelReservation(ctx context.Context, product Product, res *Reservation) error
+	ChangeReservation(ctx context.Context, product Product, res *Reservation, quantity int64, unit string) error
+	Adjust(ctx context.Context, product Product, adj *Adjustment) error
+	GetReservation(ctx context.Context, ID uint64) (Reservation, error)
 	GetAllProducts(ctx context.Context, limit, offset int) ([]Product, error)
 	GetProduct(ctx context.Context, sku string) (Product, error)
 	CreateProduct(ctx context.Context, product Product) error
+	UpdateProduct(ctx context.Context, product Product, update Product) (Product, error)
+	GetStockAlerts(ctx context.Context, limit, offset int) ([]StockAlert, error)
+	GetReplenishment(ctx context.Context, product Product) (Replenishment, error)
+	GetReplenishmentReport(ctx context.Context, limit, offset int) ([]Replenishment, error)
+	RunReplenishmentReport(ctx context.Context, now time.Time) (int, error)
+	CreateProductionOrder(ctx context.Context, product Product, order *ProductionOrder) error
+	GetProductionOrder(ctx context.Context, ID uint64) (ProductionOrder, error)
+	GetProductionOrders(ctx context.Context, sku string) ([]ProductionOrder, error)
+	ChangeProductionOrderStatus(ctx context.Context, product Product, order *ProductionOrder, status OrderStatus) error
+	SaveBom(ctx context.Context, product Product, bom *BillOfMaterials) error
+	GetBom(ctx context.Context, sku string, version int) (BillOfMaterials, error)
+	GetATP(ctx context.Context, product Product, qty int64) (ATP, error)
+	GetSerial(ctx context.Context, serial string) (SerialHistory, error)
+	SaveUnits(ctx context.Context, product Product, units Units) error
+	GetUnits(ctx context.Context, sku string) (Units, error)
+	ChangeStatus(ctx context.Context, product Product, change *StatusChange) error
+	CreateLocation(ctx context.Context, loc *Location) error
+	GetLocations(ctx context.Context) ([]Location, error)
+	GetLocationStock(ctx context.Context, product Product) ([]LocationStock, error)
+	ShipTransfer(ctx context.Context, product Product, transfer *Transfer) error
+	GetTransfer(ctx context.Context, ID uint64) (Transfer, error)
+	ReceiveTransfer(ctx context.Context, product Product, transfer *Transfer, receipt *TransferReceipt) error
+	CreateCycleCount(ctx context.Context, count *CycleCount, size int) error
+	GetCycleCount(ctx context.Context, ID uint64) (CycleCount, error)
+	GetCycleCounts(ctx context.Context, limit, offset int) ([]CycleCount, error)
+	RecordCounts(ctx context.Context, count *CycleCount, counts []Count) error
+	ApproveCycleCount(ctx context.Context, count *CycleCount, approved bool) error
+	CreateReturn(ctx context.Context, product Product, rma *Return) error
+	GetReturn(ctx context.Context, ID uint64) (Return, error)
+	ReceiveReturn(ctx context.Context, product Product, rma *Return, receipt *ReturnReceipt) error
+	CreateSalesOrder(ctx context.Context, order *SalesOrder) error
+	GetSalesOrder(ctx context.Context, ID uint64) (SalesOrder, error)
+	GetBackorderReport(ctx context.Context, limit, offset int) ([]Backorder, error)
 }
 
 type service struct {
-	repo        Repository
-	bq          Queue
-	invExchange string
-	resExchange string
+	repo      Repository
+	audit     audit.Repository
+	settings  *settings.Store
+	bq        Queue
+	exchanges Exchanges
+}
+
+// reservedSkus are routed ahead of the product routes, so a product with one of them as its sku could never be reached.
+var reservedSkus = []string{"alerts", "replenishment", "serial", "location", "cycleCount", "salesOrder", "backorders"}
+
+// validateSku rejects a sku the product routes can't reach. Every product, kits included, is created through
+// CreateProduct and a sku is never changed afterwards, so that is the one place it is checked.
+func validateSku(sku string) error {
+	for _, reserved := range reservedSkus {
+		if sku == reserved {
+			return validation("%s is a reserved path of the inventory api and can't be used as a sku", sku)
+		}
+	}
+	return nil
 }
 
 func (s *service) CreateProduct(ctx context.Context, product Product) error {
-	err := s.repo.SaveProduct(ctx, product)
+	if err := validateSku(product.Sku); err != nil {
+		return err
+	}
+	if err := validateThresholds(product); err != nil {
+		return err
+	}
+	if product.Kit && product.Serialized {
+		return validation("a kit can't be serialized, its components can")
+	}
+
+	_, err := s.repo.GetProduct(ctx, product.Sku)
+	if err == nil {
+		return conflict(CodeProductExists, "product %s already exists", product.Sku)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = s.saveProduct(ctx, &product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithStack(err)
+	}
+
+	if !product.Kit {
+		if err = s.bookStock(ctx, product.Sku, DefaultLocation, product.Available, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if err = s.evaluateStock(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.CreateProduct, product.Sku, nil, product, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	observeStock(product)
+	return nil
+}
+
+// UpdateProduct changes the descriptive fields and reorder thresholds of a product. Quantities can only be changed
+// through production, reservations and adjustments.
+func (s *service) UpdateProduct(ctx context.Context, product Product, update Product) (Product, error) {
+	if err := validateThresholds(update); err != nil {
+		return product, err
+	}
+
+	before := product
+	product.Name = update.Name
+	product.Upc = update.Upc
+	product.ReorderPoint = update.ReorderPoint
+	product.SafetyStock = update.SafetyStock
+	product.ReorderQuantity = update.ReorderQuantity
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return before, errors.WithStack(err)
+	}
+
+	if err = s.saveProduct(ctx, &product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return before, err
+	}
+
+	if err = s.evaluateStock(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return before, err
+	}
+
+	if err = s.record(ctx, audit.UpdateProduct, product.Sku, before, product, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return before, err
+	}
+
+	if err = s.publishInventory(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return before, errors.WithMessage(err, "failed to publish inventory")
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return before, errors.WithStack(err)
+	}
+	observeStock(product)
+	return product, nil
+}
+
+// saveProduct persists the product and keeps its version in step with the stored one, so the same value can be
+// saved again later in the same flow.
+func (s *service) saveProduct(ctx context.Context, product *Product, tx db.Transaction) error {
+	if err := s.repo.SaveProduct(ctx, *product, tx); err != nil {
+		return err
+	}
+	product.Version++
+	return nil
+}
+
+// record writes an audit entry for the change as part of the provided transaction so that the change and its audit
+// trail are committed together.
+func (s *service) record(ctx context.Context, action audit.Action, sku string, before, after, detail interface{}, tx db.Transaction) error {
+	entry, err := audit.NewEntry(ctx, action, sku, before, after, detail)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+	if err = s.audit.SaveEntry(ctx, &entry, tx); err != nil {
+		return errors.WithMessage(err, "failed to save audit entry")
+	}
 	return nil
 }
 
@@ -51,14 +232,43 @@ func (s *service) Produce(ctx context.Context, product Product, event *Productio
 
 	log.Trace().Str("func", funcName).Str("sku", event.Sku).Str("requestId", event.RequestID).Int64("quantity", event.Quantity).Msg("producing")
 	if event == nil {
-		return errors.New("event is required")
+		return validation("event is required")
 	}
 
 	if event.RequestID == "" {
-		return errors.New("request id is required")
+		return validation("request id is required")
 	}
 	if event.Quantity < 1 {
-		return errors.New("quantity must be greater than zero")
+		return validation("quantity must be greater than zero")
+	}
+	if product.Kit {
+		return kitNotStocked(product)
+	}
+	quantity, err := s.toBase(ctx, product.Sku, event.Unit, event.Quantity)
+	if err != nil {
+		return err
+	}
+	event.Quantity, event.Unit = quantity, ""
+	bucket := event.Bucket
+	if event.Bucket == "" {
+		event.Bucket = s.productionBucket()
+	} else if event.Bucket == BucketDamaged {
+		return validation("production can't go to %s stock", BucketDamaged)
+	} else if err = validateBucket(event.Bucket); err != nil {
+		return err
+	}
+	if err = validateSerials(product, event.Serials, event.Quantity); err != nil {
+		return err
+	}
+
+	event.Sku = product.Sku
+	hash, err := requestHash(productionPayload{Sku: event.Sku, Quantity: event.Quantity,
+		ProductionOrderID: event.ProductionOrderID, Backflush: event.Backflush, Serials: event.Serials, Bucket: bucket})
+	if err != nil {
+		return err
+	}
+	if err = s.checkIdempotency(ctx, OpProduce, event.RequestID, hash); err != nil {
+		return err
 	}
 
 	log.Debug().Str("func", funcName).Str("requestId", event.RequestID).Msg("getting production event")
@@ -72,32 +282,89 @@ func (s *service) Produce(ctx context.Context, product Product, event *Productio
 		if err := copier.Copy(event, &dbEvent); err != nil {
 			return err
 		}
+		if product.Serialized {
+			units, err := s.repo.GetProductionSerials(ctx, event.ID)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			event.Serials = make([]string, 0, len(units))
+			for _, unit := range units {
+				event.Serials = append(event.Serials, unit.Serial)
+			}
+		}
 		return nil
 	}
 
-	event.Sku = product.Sku
 	event.Created = time.Now()
 
 	tx, err := s.repo.BeginTransaction(ctx)
 	if err != nil {
 		return errors.WithStack(err)
 	}
+
+	var components []Product
+	if event.Backflush {
+		log.Debug().Str("func", funcName).Str("requestId", event.RequestID).Msg("backflushing components")
+		if components, err = s.backflush(ctx, event, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
 	log.Debug().Str("func", funcName).Str("requestId", event.RequestID).Msg("persisting production event")
 	if err = s.repo.SaveProductionEvent(ctx, event, tx); err != nil {
 		rollback(ctx, tx, err)
 		return errors.WithMessage(err, "failed to save production event")
 	}
 
-	// Increase product available inventory
-	product.Available += event.Quantity
+	if err = s.saveIdempotencyKey(ctx, OpProduce, event.RequestID, hash, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if product.Serialized {
+		produced := SerialEvent{Type: SerialEventProduced, RequestID: event.RequestID, Created: event.Created}
+		err = s.addSerials(ctx, product.Sku, event.Serials, event.Bucket.serialStatus(), produced, event.ID, tx)
+		if err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if event.ProductionOrderID != 0 {
+		if err = s.applyProduction(ctx, event, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	// Increase the inventory of the bucket production goes to
+	before := product
+	*product.bucket(event.Bucket) += event.Quantity
+	if event.Bucket == BucketAvailable {
+		if err = s.bookStock(ctx, product.Sku, DefaultLocation, event.Quantity, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
 	log.Debug().Str("func", funcName).Str("requestId", event.RequestID).Msg("persisting product")
-	if err = s.repo.SaveProduct(ctx, product, tx); err != nil {
+	if err = s.saveProduct(ctx, &product, tx); err != nil {
 		rollback(ctx, tx, err)
 		return errors.WithMessage(err, "failed to add production to product")
 	}
 
+	if err = s.evaluateStock(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.Produce, product.Sku, before, product, event, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
 	log.Debug().Str("func", funcName).Str("requestId", event.RequestID).Msg("publishing inventory")
-	err = s.publishInventory(ctx, product)
+	err = s.publishInventory(ctx, product, tx)
 	if err != nil {
 		rollback(ctx, tx, err)
 		return errors.WithMessage(err, "failed to publish inventory")
@@ -107,30 +374,55 @@ func (s *service) Produce(ctx context.Context, product Product, event *Productio
 		rollback(ctx, tx, err)
 		return errors.WithMessage(err, "failed to commit production transaction")
 	}
+	observeProduction(product.Sku, event.Quantity)
+	observeStock(product)
+	for _, component := range components {
+		observeStock(component)
+	}
 
 	log.Debug().Str("func", funcName).Str("requestId", event.RequestID).Msg("filling reserves")
-	if err = s.fillReserves(ctx, product); err != nil {
-		rollback(ctx, tx, err)
+	if _, err = s.fillReserves(ctx, product); err != nil {
 		return errors.WithMessage(err, "failed to fill reserves after production")
 	}
 
 	return nil
 }
 
-func (s *service) publishInventory(ctx context.Context, product Product) error {
+// publishInventory publishes the product and then the availability of every kit it is a component of, read as part
+// of the transaction that changed it.
+func (s *service) publishInventory(ctx context.Context, product Product, tx db.Transaction) error {
 	body, err := json.Marshal(product)
 	if err != nil {
 		return errors.WithMessage(err, "failed to serialize message for queue")
 	}
-	if err = s.bq.Publish(ctx, s.invExchange, body); err != nil {
+	if err = s.bq.Publish(ctx, s.exchanges.Inventory, body); err != nil {
 		return errors.WithMessage(err, "failed to send inventory update to queue")
 	}
-	return nil
+	if product.Kit {
+		return nil
+	}
+	return s.publishKits(ctx, product.Sku, tx)
 }
 
 func (s *service) Reserve(ctx context.Context, pr Product, res *Reservation) error {
 	const funcName = "Reserve"
 
+	quantity, err := s.toBase(ctx, pr.Sku, res.Unit, res.RequestedQuantity)
+	if err != nil {
+		return err
+	}
+	res.RequestedQuantity, res.Unit = quantity, ""
+
+	hash, err := requestHash(reservationPayload{Requester: res.Requester, Sku: res.Sku, RequestedQuantity: res.RequestedQuantity})
+	if err != nil {
+		return err
+	}
+	if res.RequestID != "" {
+		if err = s.checkIdempotency(ctx, OpReserve, res.RequestID, hash); err != nil {
+			return err
+		}
+	}
+
 	log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Msg("getting reservation")
 	dbRes, err := s.repo.GetReservationByRequestID(ctx, res.RequestID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
@@ -148,106 +440,594 @@ func (s *service) Reserve(ctx context.Context, pr Product, res *Reservation) err
 	res.State = Open
 	res.Created = time.Now()
 
+	if pr.Kit {
+		return s.reserveKit(ctx, pr, res, hash)
+	}
+
 	tx, err := s.repo.BeginTransaction(ctx)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	after := pr
+	created, fills, err := s.reserve(ctx, &after, res, tx)
+	if err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if res.RequestID != "" {
+		if err = s.saveIdempotencyKey(ctx, OpReserve, res.RequestID, hash, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if err = s.record(ctx, audit.Reserve, pr.Sku, pr, after, res, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	observeReservation(eventCreated, created)
+	observeFills(fills, after)
+
+	return nil
+}
+
+// reserve saves a new reservation and fills it as part of the transaction. It waits its turn behind the reservations
+// already open and is only filled from what they leave, what isn't filled is backordered. It returns the reservation
+// as it was created along with what was filled, to be observed once the transaction has committed.
+func (s *service) reserve(ctx context.Context, product *Product, res *Reservation, tx db.Transaction) (Reservation, []fill, error) {
+	const funcName = "reserve"
+
 	log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Msg("saving reservation")
-	if err = s.repo.SaveReservation(ctx, res, tx); err != nil {
+	if err := s.repo.SaveReservation(ctx, res, tx); err != nil {
+		return Reservation{}, nil, errors.WithStack(err)
+	}
+	created := *res
+
+	log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Msg("filling reserves")
+	fills, err := s.fillQueue(ctx, product, tx)
+	if err != nil {
+		return created, nil, err
+	}
+	for _, f := range fills {
+		if f.reservation.ID == res.ID {
+			*res = f.reservation
+		}
+	}
+
+	if res.backorderable() {
+		log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Msg("backordering what wasn't filled")
+		if err = s.markBackordered(ctx, res, tx); err != nil {
+			return created, nil, err
+		}
+	}
+	return created, fills, nil
+}
+
+func (s *service) CancelReservation(ctx context.Context, product Product, res *Reservation) error {
+	const funcName = "CancelReservation"
+
+	if res.State != Open {
+		return conflict(CodeReservationNotOpen, "only open reservations can be cancelled, reservation %d is %s", res.ID, res.State)
+	}
+	if res.KitReservationID != 0 {
+		return conflict(CodeComponentReservation, "reservation %d is part of kit reservation %d, cancel the kit reservation instead",
+			res.ID, res.KitReservationID)
+	}
+	if product.Kit {
+		return s.cancelKitReservation(ctx, product, res)
+	}
+
+	before := product
+	held := res.ReservedQuantity - res.ShippedQuantity
+	product.Available += held
+	product.Reserved -= held
+	res.State = Cancelled
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Msg("cancelling reservation")
+	if err = s.repo.UpdateReservation(ctx, res.ID, res.State, res.ReservedQuantity, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithStack(err)
+	}
+
+	if err = s.bookStock(ctx, product.Sku, DefaultLocation, held, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if product.Serialized {
+		if err = s.releaseSerials(ctx, res, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if err = s.saveProduct(ctx, &product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithStack(err)
+	}
+
+	if err = s.evaluateStock(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.CancelReservation, product.Sku, before, product, res, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.publishInventory(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to publish inventory")
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	observeReservation(eventCancelled, *res)
+	observeStock(product)
+
+	log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Msg("filling reserves")
+	if _, err = s.fillReserves(ctx, product); err != nil {
+		return errors.WithMessage(err, "failed to fill reserves after cancellation")
+	}
+
+	return nil
+}
+
+// ReservationChange is the detail recorded when the requested quantity of a reservation changes.
+type ReservationChange struct {
+	ReservationID uint64 `json:"reservationId"`
+	From          int64  `json:"from"`
+	To            int64  `json:"to"`
+}
+
+// ChangeReservation changes the quantity requested by a reservation. Units reserved beyond a decreased quantity are
+// released back to available. Increasing a closed reservation reopens it for the difference, keeping track of what it
+// already shipped.
+func (s *service) ChangeReservation(ctx context.Context, product Product, res *Reservation, quantity int64, unit string) error {
+	const funcName = "ChangeReservation"
+
+	quantity, err := s.toBase(ctx, product.Sku, unit, quantity)
+	if err != nil {
+		return err
+	}
+	if quantity < 1 {
+		return validation("requested quantity must be greater than zero")
+	}
+	if res.KitReservationID != 0 {
+		return conflict(CodeComponentReservation, "reservation %d is part of kit reservation %d, change the kit reservation instead",
+			res.ID, res.KitReservationID)
+	}
+	if product.Kit {
+		return validation("kit reservations can't be changed, cancel reservation %d and reserve again", res.ID)
+	}
+	switch {
+	case res.State == Cancelled:
+		return conflict(CodeReservationNotOpen, "reservation %d is cancelled and can't be changed", res.ID)
+	case res.State == Closed && quantity < res.RequestedQuantity:
+		return conflict(CodeReservationNotOpen, "reservation %d has shipped, it can only be increased", res.ID)
+	case quantity < res.ShippedQuantity:
+		return conflict(CodeReservationNotOpen, "reservation %d has already shipped %d", res.ID, res.ShippedQuantity)
+	case quantity == res.RequestedQuantity:
+		return nil
+	}
+
+	before := product
+	change := ReservationChange{ReservationID: res.ID, From: res.RequestedQuantity, To: quantity}
+	var released int64
+	if res.State == Closed {
+		res.State = Open
+		res.ShippedQuantity = res.ReservedQuantity
+	} else if quantity < res.ReservedQuantity {
+		released = res.ReservedQuantity - quantity
+		res.ReservedQuantity = quantity
+		product.Reserved -= released
+		product.Available += released
+	}
+	res.RequestedQuantity = quantity
+	closed := res.ReservedQuantity == res.RequestedQuantity
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Msg("changing requested quantity")
+	if err = s.repo.UpdateRequestedQuantity(ctx, res.ID, res.RequestedQuantity, res.ShippedQuantity, tx); err != nil {
 		rollback(ctx, tx, err)
 		return errors.WithStack(err)
 	}
 
+	if err = s.bookStock(ctx, product.Sku, DefaultLocation, released, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if released > 0 && product.Serialized {
+		if err = s.releaseReservedSerials(ctx, res, released, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if closed {
+		if err = s.closeReservation(&product, res); err != nil {
+			rollback(ctx, tx, err)
+			return errors.WithStack(err)
+		}
+		if product.Serialized {
+			if err = s.shipSerials(ctx, res, tx); err != nil {
+				rollback(ctx, tx, err)
+				return err
+			}
+		}
+	}
+
+	if err = s.repo.UpdateReservation(ctx, res.ID, res.State, res.ReservedQuantity, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithStack(err)
+	}
+
+	if err = s.saveProduct(ctx, &product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithStack(err)
+	}
+
+	if err = s.evaluateStock(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.ChangeReservation, product.Sku, before, product, change, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.publishInventory(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to publish inventory")
+	}
+
+	if err = s.publishReservation(ctx, *res); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
 	if err = tx.Commit(ctx); err != nil {
 		return errors.WithStack(err)
 	}
+	if closed {
+		observeReservation(eventClosed, *res)
+	}
+	observeStock(product)
 
 	log.Debug().Str("func", funcName).Str("requestId", res.RequestID).Msg("filling reserves")
-	if err = s.fillReserves(ctx, pr); err != nil {
+	if _, err = s.fillReserves(ctx, product); err != nil {
+		return errors.WithMessage(err, "failed to fill reserves after changing reservation")
+	}
+
+	// filling may have reserved more of the reservation or closed it
+	filled, err := s.GetReservation(ctx, res.ID)
+	if err != nil {
+		return err
+	}
+	res.State, res.ReservedQuantity = filled.State, filled.ReservedQuantity
+	return s.backorder(ctx, res)
+}
+
+// Adjust corrects the available quantity of a product outside of production, for example after a miscount or
+// because stock was damaged. Adjustments are idempotent by request id.
+func (s *service) Adjust(ctx context.Context, product Product, adj *Adjustment) error {
+	const funcName = "Adjust"
+
+	if adj.RequestID == "" {
+		return validation("request id is required")
+	}
+	if adj.Quantity == 0 {
+		return validation("quantity must not be zero")
+	}
+	if product.Kit {
+		return kitNotStocked(product)
+	}
+	if err := validateSerials(product, adj.Serials, adj.Quantity); err != nil {
+		return err
+	}
+
+	adj.Sku = product.Sku
+	hash, err := requestHash(adjustmentPayload{Sku: adj.Sku, Quantity: adj.Quantity, Reason: adj.Reason,
+		Serials: adj.Serials})
+	if err != nil {
+		return err
+	}
+	if err = s.checkIdempotency(ctx, OpAdjust, adj.RequestID, hash); err != nil {
+		return err
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", adj.RequestID).Msg("getting adjustment")
+	dbAdj, err := s.repo.GetAdjustmentByRequestID(ctx, adj.RequestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	if dbAdj.RequestID != "" {
+		log.Debug().Str("func", funcName).Str("requestId", adj.RequestID).Msg("adjustment already exists, returning it")
+		return copier.Copy(adj, &dbAdj)
+	}
+
+	if product.Available+adj.Quantity < 0 {
+		return insufficientStock("adjustment of %d would leave %d available", adj.Quantity, product.Available+adj.Quantity)
+	}
+
+	adj.Created = time.Now()
+
+	before := product
+	product.Available += adj.Quantity
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	log.Debug().Str("func", funcName).Str("requestId", adj.RequestID).Msg("persisting adjustment")
+	if err = s.repo.SaveAdjustment(ctx, adj, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save adjustment")
+	}
+
+	if err = s.bookStock(ctx, product.Sku, DefaultLocation, adj.Quantity, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.saveIdempotencyKey(ctx, OpAdjust, adj.RequestID, hash, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if product.Serialized {
+		event := SerialEvent{Type: SerialEventAdded, RequestID: adj.RequestID, Created: adj.Created}
+		if adj.Quantity > 0 {
+			err = s.addSerials(ctx, product.Sku, adj.Serials, SerialAvailable, event, 0, tx)
+		} else {
+			event.Type = SerialEventRemoved
+			err = s.removeSerials(ctx, product.Sku, adj.Serials, event, tx)
+		}
+		if err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if err = s.saveProduct(ctx, &product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to apply adjustment to product")
+	}
+
+	if err = s.evaluateStock(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.Adjust, product.Sku, before, product, adj, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.publishInventory(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to publish inventory")
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "failed to commit adjustment transaction")
+	}
+	observeStock(product)
+
+	if adj.Quantity > 0 {
+		log.Debug().Str("func", funcName).Str("requestId", adj.RequestID).Msg("filling reserves")
+		if _, err = s.fillReserves(ctx, product); err != nil {
+			return errors.WithMessage(err, "failed to fill reserves after adjustment")
+		}
+	}
+
 	return nil
 }
 
-func (s *service) fillReserves(ctx context.Context, product Product) error {
+// allocationOrder sorts open reservations in the order they should be filled. Reservations arrive oldest first, which
+// is the fifo order.
+func allocationOrder(reservations []Reservation, policy string) {
+	if policy != settings.AllocateSmallestFirst {
+		return
+	}
+	sort.SliceStable(reservations, func(i, j int) bool {
+		return reservations[i].RequestedQuantity-reservations[i].ReservedQuantity <
+			reservations[j].RequestedQuantity-reservations[j].ReservedQuantity
+	})
+}
+
+// fillReserves fills the open reservations of the product from what is available in a single transaction, then moves
+// along the sales orders waiting on it.
+func (s *service) fillReserves(ctx context.Context, product Product) (Product, error) {
 	const funcName = "fillReserves"
 	log.Info().Str("func", funcName).Str("sku", product.Sku).Msg("filling reserves")
 
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return product, errors.WithStack(err)
+	}
+	fills, err := s.fillQueue(ctx, &product, tx)
+	if err != nil {
+		rollback(ctx, tx, err)
+		return product, err
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return product, errors.WithStack(err)
+	}
+	observeFills(fills, product)
+
+	return s.allocateSalesOrders(ctx, product)
+}
+
+// fill is what filling one open reservation did, kept to be observed once the transaction has committed.
+type fill struct {
+	reservation Reservation
+	kit         kitFill
+	closed      bool
+}
+
+func observeFills(fills []fill, product Product) {
+	for _, f := range fills {
+		if f.closed {
+			observeReservation(eventClosed, f.reservation)
+		}
+		f.kit.observe()
+	}
+	if len(fills) > 0 {
+		observeStock(product)
+	}
+}
+
+// fillQueue fills the open reservations of the product from what is available at the default location as part of the
+// transaction. They are taken in the order of the allocation policy, so stock never goes to a reservation ahead of one
+// that is waiting before it, whatever left the stock available.
+func (s *service) fillQueue(ctx context.Context, product *Product, tx db.Transaction) ([]fill, error) {
+	const funcName = "fillQueue"
+
+	fills := make([]fill, 0)
+	if product.Available <= 0 {
+		return fills, nil
+	}
+	available, err := s.stockAt(ctx, product.Sku, DefaultLocation, tx)
+	if err != nil {
+		return nil, err
+	}
+	if available > product.Available {
+		available = product.Available
+	}
+	if available <= 0 {
+		return fills, nil
+	}
+
 	log.Debug().Str("func", funcName).Str("sku", product.Sku).Msg("getting open reservations")
-	or, err := s.repo.GetSkuReservationsByState(ctx, product.Sku, Open, 100, 0)
+	open, err := s.openReservations(ctx, product.Sku, tx)
 	if err != nil {
-		return errors.WithStack(err)
+		return nil, err
 	}
-	for _, reservation := range or {
-		log.Trace().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("fulfilling reservation")
-		if product.Available == 0 {
+	allocationOrder(open, s.settings.Get().AllocationPolicy)
+	for i := range open {
+		reservation := &open[i]
+		if available <= 0 {
 			log.Trace().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("no more available inventory")
 			break
 		}
 
 		remaining := reservation.RequestedQuantity - reservation.ReservedQuantity
-		reserveAmount := remaining
-		if remaining > product.Available {
-			reserveAmount = product.Available
-		}
-		product.Available -= reserveAmount
-		product.Reserved += reserveAmount
-		reservation.ReservedQuantity += reserveAmount
-
-		closed := false
-		if reservation.ReservedQuantity == reservation.RequestedQuantity {
-			if err := s.closeReservation(&product, &reservation); err != nil {
-				return errors.WithStack(err)
-			}
-			closed = true
+		if remaining == 0 {
+			// a filled component reservation waits for the rest of its kit
+			continue
 		}
-		if closed {
-			log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("closed")
-		} else {
-			log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("still open")
+		qty := remaining
+		if remaining > available {
+			qty = available
 		}
 
-		tx, err := s.repo.BeginTransaction(ctx)
+		log.Trace().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("fulfilling reservation")
+		kit, closed, err := s.fillReservation(ctx, product, reservation, qty, tx)
 		if err != nil {
-			return errors.WithStack(err)
+			return nil, err
 		}
+		available -= qty
+		fills = append(fills, fill{reservation: *reservation, kit: kit, closed: closed})
+	}
+	return fills, nil
+}
 
-		log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("saving product")
-		err = s.repo.SaveProduct(ctx, product, tx)
-		if err != nil {
-			rollback(ctx, tx, err)
-			return errors.WithStack(err)
-		}
+// fillReservation reserves qty more of the product at the default location for an open reservation as part of the
+// transaction, closing it once it is fully reserved. It returns what filling it did to the kit it belongs to and
+// whether it closed.
+func (s *service) fillReservation(ctx context.Context, product *Product, reservation *Reservation, qty int64,
+	tx db.Transaction) (kitFill, bool, error) {
 
-		log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("updating reservation")
-		err = s.repo.UpdateReservation(ctx, reservation.ID, reservation.State, reservation.ReservedQuantity, tx)
-		if err != nil {
-			rollback(ctx, tx, err)
-			return errors.WithStack(err)
-		}
+	const funcName = "fillReservation"
 
-		log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("publishing inventory")
-		err = s.publishInventory(ctx, product)
-		if err != nil {
-			rollback(ctx, tx, err)
-			return errors.WithMessage(err, "failed to publish inventory")
+	if err := s.bookStock(ctx, product.Sku, DefaultLocation, -qty, tx); err != nil {
+		return kitFill{}, false, err
+	}
+	product.Available -= qty
+	product.Reserved += qty
+	reservation.ReservedQuantity += qty
+
+	if product.Serialized {
+		if err := s.allocateSerials(ctx, reservation, qty, tx); err != nil {
+			return kitFill{}, false, err
 		}
+	}
 
-		if closed {
-			log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("publishing reservation")
-			err := s.publishReservation(ctx, reservation)
-			if err != nil {
-				rollback(ctx, tx, err)
-				return err
+	// the reservations of a kit's components only close together, once the whole kit is reserved
+	var kit kitFill
+	var err error
+	closed := reservation.ReservedQuantity == reservation.RequestedQuantity
+	if reservation.KitReservationID != 0 {
+		log.Debug().Str("func", funcName).Str("sku", product.Sku).Uint64("kitReservation", reservation.KitReservationID).Msg("filling kit reservation")
+		if kit, err = s.fillKitReservation(ctx, *reservation, tx); err != nil {
+			return kit, false, err
+		}
+		closed = kit.reservation.State == Closed
+	}
+	if closed {
+		if err = s.closeReservation(product, reservation); err != nil {
+			return kit, false, errors.WithStack(err)
+		}
+		if product.Serialized {
+			if err = s.shipSerials(ctx, reservation, tx); err != nil {
+				return kit, false, err
 			}
 		}
-		if err = tx.Commit(ctx); err != nil {
-			return errors.WithStack(err)
+	}
+	if closed {
+		log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("closed")
+	} else {
+		log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("still open")
+	}
+
+	log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("saving product")
+	if err = s.saveProduct(ctx, product, tx); err != nil {
+		return kit, false, errors.WithStack(err)
+	}
+
+	if err = s.evaluateStock(ctx, *product, tx); err != nil {
+		return kit, false, err
+	}
+
+	log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("updating reservation")
+	err = s.repo.UpdateReservation(ctx, reservation.ID, reservation.State, reservation.ReservedQuantity, tx)
+	if err != nil {
+		return kit, false, errors.WithStack(err)
+	}
+
+	log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("publishing inventory")
+	if err = s.publishInventory(ctx, *product, tx); err != nil {
+		return kit, false, errors.WithMessage(err, "failed to publish inventory")
+	}
+
+	if closed {
+		log.Debug().Str("func", funcName).Str("sku", product.Sku).Str("reservation.RequestID", reservation.RequestID).Msg("publishing reservation")
+		if err = s.publishReservation(ctx, *reservation); err != nil {
+			return kit, false, err
 		}
 	}
-	return nil
+	return kit, closed, nil
 }
 
 func (s *service) publishReservation(ctx context.Context, reservation Reservation) error {
@@ -255,16 +1035,19 @@ func (s *service) publishReservation(ctx context.Context, reservation Reservatio
 	if err != nil {
 		return errors.WithMessage(err, "error marshalling reservation to send to queue")
 	}
-	err = s.bq.Publish(ctx, s.resExchange, body)
+	err = s.bq.Publish(ctx, s.exchanges.Reservation, body)
 	if err != nil {
 		return errors.WithMessage(err, "error publishing reservation")
 	}
+	if reservation.State == Closed && reservation.Backordered {
+		return s.publishBackorder(ctx, BackorderEvent{Event: BackorderFilled, Reservation: reservation})
+	}
 	return nil
 }
 
 func (s *service) closeReservation(product *Product, reservation *Reservation) error {
 	reservation.State = Closed
-	product.Reserved -= reservation.RequestedQuantity
+	product.Reserved -= reservation.RequestedQuantity - reservation.ShippedQuantity
 	return nil
 }
 
@@ -285,40 +1068,112 @@ func (s *service) getTx(ctx context.Context, txs ...db.Transaction) (tx db.Trans
 }
 
 func (s *service) GetAllProducts(ctx context.Context, limit, offset int) ([]Product, error) {
-	return s.repo.GetAllProducts(ctx, limit, offset)
+	products, err := s.repo.GetAllProducts(ctx, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	for i := range products {
+		if !products[i].Kit {
+			continue
+		}
+		if err = s.deriveKit(ctx, &products[i]); err != nil {
+			return nil, err
+		}
+	}
+	return products, nil
+}
+
+func (s *service) GetReservation(ctx context.Context, ID uint64) (Reservation, error) {
+	res, err := s.repo.GetReservation(ctx, ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return res, notFound(CodeReservationNotFound, err, "reservation %d not found", ID)
+		}
+		return res, errors.WithStack(err)
+	}
+	return res, nil
 }
 
 func (s *service) GetProduct(ctx context.Context, sku string) (Product, error) {
 	product, err := s.repo.GetProduct(ctx, sku)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return product, notFound(CodeProductNotFound, err, "product %s not found", sku)
+		}
 		return product, errors.WithStack(err)
 	}
+	if product.Kit {
+		if err = s.deriveKit(ctx, &product); err != nil {
+			return product, err
+		}
+	}
 	return product, nil
 }
 
-// ProductionEvent is an entity. An addition to inventory through production of a Product.
+// ProductionEvent is an entity. An addition to inventory through production of a Product, optionally reported against
+// a ProductionOrder. With Backflush set the components in the product's bill of materials are used up as well.
+// Consumed and Shortages describe the backflush and are only returned when the event is created. Production of a
+// serialized product lists the serial number of every unit produced. A Quantity given in another Unit of the product
+// is converted to its base unit when the event is created. The stock goes to the given Bucket, or the one configured
+// for production when there is none.
 type ProductionEvent struct {
+	ID                uint64           `json:"id"`
+	RequestID         string           `json:"requestID"`
+	Sku               string           `json:"sku"`
+	Quantity          int64            `json:"quantity"`
+	ProductionOrderID uint64           `json:"productionOrderId,omitempty"`
+	Backflush         bool             `json:"backflush,omitempty"`
+	BomVersion        int              `json:"bomVersion,omitempty"`
+	Consumed          []ComponentUsage `json:"consumed,omitempty"`
+	Shortages         []Shortage       `json:"shortages,omitempty"`
+	Serials           []string         `json:"serials,omitempty"`
+	Unit              string           `json:"unit,omitempty"`
+	Bucket            Bucket           `json:"bucket,omitempty"`
+	Created           time.Time        `json:"created"`
+}
+
+// Adjustment is an entity. A correction to the available inventory of a Product made outside of production. Adjusting
+// a serialized product lists the serial numbers of the units added or removed.
+type Adjustment struct {
 	ID        uint64    `json:"id"`
-	RequestID string    `json:"requestID"`
+	RequestID string    `json:"requestId"`
 	Sku       string    `json:"sku"`
 	Quantity  int64     `json:"quantity"`
+	Reason    string    `json:"reason"`
+	Serials   []string  `json:"serials,omitempty"`
 	Created   time.Time `json:"created"`
 }
 
 // Product is a value object. A SKU able to be produced by the factory.
 type Product struct {
-	Sku       string `json:"sku"`
-	Upc       string `json:"upc"`
-	Name      string `json:"name"`
-	Available int64  `json:"available"`
-	Reserved  int64  `json:"reserved"`
+	Sku  string `json:"sku"`
+	Upc  string `json:"upc"`
+	Name string `json:"name"`
+	// Available is the stock on hand that can be reserved, for a kit it is worked out from its components
+	Available       int64 `json:"available"`
+	Reserved        int64 `json:"reserved"`
+	ReorderPoint    int64 `json:"reorderPoint"`
+	SafetyStock     int64 `json:"safetyStock"`
+	ReorderQuantity int64 `json:"reorderQuantity"`
+	// Kit products are never produced or stocked themselves, they are made up of the components in their bill of
+	// materials
+	Kit bool `json:"kit"`
+	// Serialized products track every unit by its serial number
+	Serialized bool `json:"serialized"`
+	// Quarantine and Damaged are stock on hand that can't be reserved
+	Quarantine int64 `json:"quarantine"`
+	Damaged    int64 `json:"damaged"`
+	// InTransit is stock shipped between locations that hasn't been received yet
+	InTransit int64 `json:"inTransit"`
+	Version   int64 `json:"version"`
 }
 
 type ReserveState string
 
 const (
-	Open   ReserveState = "Open"
-	Closed              = "Closed"
+	Open      ReserveState = "Open"
+	Closed                 = "Closed"
+	Cancelled ReserveState = "Cancelled"
 	//None = ""
 )
 
@@ -331,5 +1186,18 @@ type Reservation struct {
 	State             ReserveState `json:"state"`
 	ReservedQuantity  int64        `json:"reservedQuantity"`
 	RequestedQuantity int64        `json:"requestedQuantity"`
-	Created           time.Time    `json:"created"`
+	// KitReservationID is the kit reservation a component reservation was made for
+	KitReservationID uint64 `json:"kitReservationId,omitempty"`
+	// ShippedQuantity is what had already shipped when a closed reservation was increased and reopened
+	ShippedQuantity int64 `json:"shippedQuantity,omitempty"`
+	// Serials are the units of a serialized product the reservation was allocated, listed once it closes
+	Serials []string `json:"serials,omitempty"`
+	// Unit is set when the quantities are in a unit other than the base unit, a RequestedQuantity given in another
+	// unit is converted to the base unit when the reservation is made
+	Unit string `json:"unit,omitempty"`
+	// Backordered reservations couldn't be filled straight away, EstimatedFill is when scheduled production is expected
+	// to fill them if there is enough of it
+	Backordered   bool       `json:"backordered,omitempty"`
+	EstimatedFill *time.Time `json:"estimatedFill,omitempty"`
+	Created       time.Time  `json:"created"`
 }
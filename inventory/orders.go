@@ -0,0 +1,245 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+// OrderStatus is where a production order is in its lifecycle.
+type OrderStatus string
+
+const (
+	OrderPlanned            OrderStatus = "Planned"
+	OrderReleased           OrderStatus = "Released"
+	OrderInProgress         OrderStatus = "InProgress"
+	OrderPartiallyCompleted OrderStatus = "PartiallyCompleted"
+	OrderCompleted          OrderStatus = "Completed"
+	OrderCancelled          OrderStatus = "Cancelled"
+)
+
+// orderTransitions are the status changes that can be requested directly. Production reported against an order
+// moves it to PartiallyCompleted or Completed on its own. Completing a partially completed order closes it short.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderPlanned:            {OrderReleased, OrderCancelled},
+	OrderReleased:           {OrderInProgress, OrderCancelled},
+	OrderInProgress:         {OrderCompleted, OrderCancelled},
+	OrderPartiallyCompleted: {OrderCompleted, OrderCancelled},
+}
+
+func canTransition(from, to OrderStatus) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ProductionOrder is an entity. A work order to produce a target quantity of a product by a due date. Production
+// events reported against the order count towards it.
+type ProductionOrder struct {
+	ID             uint64      `json:"id"`
+	Sku            string      `json:"sku"`
+	Status         OrderStatus `json:"status"`
+	TargetQuantity int64       `json:"targetQuantity"`
+	Produced       int64       `json:"produced"`
+	Due            time.Time   `json:"due"`
+	Created        time.Time   `json:"created"`
+	Updated        time.Time   `json:"updated"`
+}
+
+// Active orders are still expected to produce something.
+func (o ProductionOrder) Active() bool {
+	switch o.Status {
+	case OrderPlanned, OrderReleased, OrderInProgress, OrderPartiallyCompleted:
+		return true
+	}
+	return false
+}
+
+// Remaining is the quantity still to be produced to reach the target.
+func (o ProductionOrder) Remaining() int64 {
+	if o.Produced >= o.TargetQuantity {
+		return 0
+	}
+	return o.TargetQuantity - o.Produced
+}
+
+// Completion is the percentage of the target produced so far. It is above 100 when the order was over produced.
+func (o ProductionOrder) Completion() float64 {
+	if o.TargetQuantity == 0 {
+		return 0
+	}
+	return float64(o.Produced) * 100 / float64(o.TargetQuantity)
+}
+
+// Variance is how far production is over, when positive, or under, when negative, the target.
+func (o ProductionOrder) Variance() int64 {
+	return o.Produced - o.TargetQuantity
+}
+
+// ProductionOrderEvent is published whenever the status of a production order changes.
+type ProductionOrderEvent struct {
+	ProductionOrder
+	PreviousStatus OrderStatus `json:"previousStatus,omitempty"`
+	Completion     float64     `json:"completion"`
+	Variance       int64       `json:"variance"`
+}
+
+func (s *service) CreateProductionOrder(ctx context.Context, product Product, order *ProductionOrder) error {
+	if product.Kit {
+		return kitNotStocked(product)
+	}
+	if order.TargetQuantity < 1 {
+		return validation("target quantity must be greater than zero")
+	}
+	if order.Due.IsZero() {
+		return validation("due date is required")
+	}
+
+	order.Sku = product.Sku
+	order.Status = OrderPlanned
+	order.Produced = 0
+	order.Created = time.Now()
+	order.Updated = order.Created
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = s.repo.SaveProductionOrder(ctx, order, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save production order")
+	}
+
+	if err = s.record(ctx, audit.CreateProductionOrder, product.Sku, nil, order, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.publishOrder(ctx, *order, ""); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (s *service) GetProductionOrder(ctx context.Context, ID uint64) (ProductionOrder, error) {
+	order, err := s.repo.GetProductionOrder(ctx, ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return order, notFound(CodeOrderNotFound, err, "production order %d not found", ID)
+		}
+		return order, errors.WithStack(err)
+	}
+	return order, nil
+}
+
+func (s *service) GetProductionOrders(ctx context.Context, sku string) ([]ProductionOrder, error) {
+	return s.repo.GetSkuProductionOrders(ctx, sku)
+}
+
+// ChangeProductionOrderStatus moves the order to the requested status if its lifecycle allows it.
+func (s *service) ChangeProductionOrderStatus(ctx context.Context, product Product, order *ProductionOrder, status OrderStatus) error {
+	const funcName = "ChangeProductionOrderStatus"
+
+	if !canTransition(order.Status, status) {
+		return conflict(CodeInvalidTransition, "production order %d can't go from %s to %s", order.ID, order.Status,
+			status)
+	}
+
+	before := *order
+	order.Status = status
+	order.Updated = time.Now()
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Debug().Str("func", funcName).Uint64("order", order.ID).Str("from", string(before.Status)).
+		Str("to", string(status)).Msg("changing production order status")
+	if err = s.repo.UpdateProductionOrder(ctx, *order, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to update production order")
+	}
+
+	if err = s.record(ctx, audit.ChangeProductionOrder, product.Sku, before, order, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.publishOrder(ctx, *order, before.Status); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// applyProduction counts a production event towards the order it was reported against. Production can be reported
+// once an order has been released and keeps being counted after it is completed, as over production.
+func (s *service) applyProduction(ctx context.Context, event *ProductionEvent, tx db.Transaction) error {
+	order, err := s.repo.GetProductionOrder(ctx, event.ProductionOrderID, tx)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return notFound(CodeOrderNotFound, err, "production order %d not found", event.ProductionOrderID)
+		}
+		return errors.WithStack(err)
+	}
+	if order.Sku != event.Sku {
+		return notFound(CodeOrderNotFound, nil, "production order %d not found for sku %s", order.ID, event.Sku)
+	}
+	if order.Status == OrderPlanned || order.Status == OrderCancelled {
+		return conflict(CodeOrderNotProducible, "production order %d is %s, only released orders can be produced",
+			order.ID, order.Status)
+	}
+
+	previous := order.Status
+	order.Produced += event.Quantity
+	order.Updated = event.Created
+	if order.Produced >= order.TargetQuantity {
+		order.Status = OrderCompleted
+	} else {
+		order.Status = OrderPartiallyCompleted
+	}
+
+	if err = s.repo.UpdateProductionOrder(ctx, order, tx); err != nil {
+		return errors.WithMessage(err, "failed to update production order")
+	}
+	if order.Status == previous {
+		return nil
+	}
+	return s.publishOrder(ctx, order, previous)
+}
+
+func (s *service) publishOrder(ctx context.Context, order ProductionOrder, previous OrderStatus) error {
+	body, err := json.Marshal(ProductionOrderEvent{
+		ProductionOrder: order,
+		PreviousStatus:  previous,
+		Completion:      order.Completion(),
+		Variance:        order.Variance(),
+	})
+	if err != nil {
+		return errors.WithMessage(err, "failed to serialize production order")
+	}
+	if err = s.bq.Publish(ctx, s.exchanges.Order, body); err != nil {
+		return errors.WithMessage(err, "failed to publish production order")
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package inventory
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+const day = 24 * time.Hour
+
+// DailyQuantity is a value object. The total quantity of something on a single day.
+type DailyQuantity struct {
+	Day      time.Time `json:"day"`
+	Quantity int64     `json:"quantity"`
+}
+
+// ReplenishmentParams tune how replenishment suggestions are calculated.
+type ReplenishmentParams struct {
+	// WindowDays is how many days of demand history are considered.
+	WindowDays int
+	// DefaultLeadTimeDays is used when no production orders were completed in the window to measure the lead time by.
+	DefaultLeadTimeDays float64
+	// ServiceLevelZ is the number of standard deviations of demand covered by safety stock, 1.65 covers 95% of days.
+	ServiceLevelZ float64
+	// OrderCost is the fixed cost of a production run and HoldingCost the cost of holding a unit for a year.
+	OrderCost   float64
+	HoldingCost float64
+}
+
+func replenishmentParams(s settings.Settings) ReplenishmentParams {
+	return ReplenishmentParams{
+		WindowDays:          s.ReplenishmentWindowDays,
+		DefaultLeadTimeDays: s.DefaultLeadTimeDays,
+		ServiceLevelZ:       s.ServiceLevelZ,
+		OrderCost:           s.OrderCost,
+		HoldingCost:         s.HoldingCost,
+	}
+}
+
+// Replenishment is a value object. A suggestion of how much of a product to produce.
+type Replenishment struct {
+	Sku                   string    `json:"sku"`
+	WindowDays            int       `json:"windowDays"`
+	AverageDailyDemand    float64   `json:"averageDailyDemand"`
+	DemandStdDev          float64   `json:"demandStdDev"`
+	LeadTimeDays          float64   `json:"leadTimeDays"`
+	SafetyStock           int64     `json:"safetyStock"`
+	ReorderPoint          int64     `json:"reorderPoint"`
+	EconomicOrderQuantity int64     `json:"economicOrderQuantity"`
+	InventoryPosition     int64     `json:"inventoryPosition"`
+	SuggestedQuantity     int64     `json:"suggestedQuantity"`
+	Calculated            time.Time `json:"calculated"`
+}
+
+// windowStart is the first day of demand history considered at now. The window covers whole days and ends at the
+// start of the current day, so the same history always gives the same result regardless of the time it is run.
+func windowStart(now time.Time, p ReplenishmentParams) time.Time {
+	today := now.UTC().Truncate(day)
+	return today.Add(-time.Duration(p.WindowDays) * day)
+}
+
+// CalculateReplenishment suggests how much of a product to produce.
+//
+// Demand is the quantity requested by reservations on each day of the window, days without any counting as zero. The
+// lead time is the average number of days production orders completed in the window took from being created to being
+// completed. Safety stock covers ServiceLevelZ standard
+// deviations of demand over the lead time, unless the product has its own safety stock. Once the inventory position,
+// available stock less the quantity still owed to open reservations, is at or below the reorder point the suggestion
+// brings it back up to the reorder point plus one lot. The lot is the product's reorder quantity if it has one and
+// the economic order quantity otherwise.
+func CalculateReplenishment(product Product, backlog int64, demand []DailyQuantity, completed []ProductionOrder,
+	now time.Time, p ReplenishmentParams) Replenishment {
+
+	r := Replenishment{Sku: product.Sku, WindowDays: p.WindowDays, Calculated: now}
+	start := windowStart(now, p)
+
+	if p.WindowDays > 0 {
+		daily := make([]float64, p.WindowDays)
+		for _, d := range demand {
+			i := int(d.Day.UTC().Truncate(day).Sub(start) / day)
+			if i >= 0 && i < p.WindowDays {
+				daily[i] += float64(d.Quantity)
+			}
+		}
+
+		var total float64
+		for _, q := range daily {
+			total += q
+		}
+		r.AverageDailyDemand = total / float64(p.WindowDays)
+
+		var variance float64
+		for _, q := range daily {
+			variance += (q - r.AverageDailyDemand) * (q - r.AverageDailyDemand)
+		}
+		r.DemandStdDev = math.Sqrt(variance / float64(p.WindowDays))
+	}
+
+	r.LeadTimeDays = leadTime(completed, start, now.UTC().Truncate(day), p.DefaultLeadTimeDays)
+
+	r.SafetyStock = product.SafetyStock
+	if r.SafetyStock == 0 {
+		r.SafetyStock = ceil(p.ServiceLevelZ * r.DemandStdDev * math.Sqrt(r.LeadTimeDays))
+	}
+	r.ReorderPoint = ceil(r.AverageDailyDemand*r.LeadTimeDays) + r.SafetyStock
+
+	if r.AverageDailyDemand > 0 && p.HoldingCost > 0 {
+		r.EconomicOrderQuantity = ceil(math.Sqrt(2 * r.AverageDailyDemand * 365 * p.OrderCost / p.HoldingCost))
+	}
+	lot := r.EconomicOrderQuantity
+	if product.ReorderQuantity > 0 {
+		lot = product.ReorderQuantity
+	}
+
+	r.InventoryPosition = product.Available - backlog
+	if r.InventoryPosition <= r.ReorderPoint && (lot > 0 || r.InventoryPosition < 0) {
+		r.SuggestedQuantity = r.ReorderPoint + lot - r.InventoryPosition
+	}
+	return r
+}
+
+// leadTime is the average number of days the production orders completed between since and until took from being
+// created to being completed, or the default if none were.
+func leadTime(orders []ProductionOrder, since, until time.Time, defaultDays float64) float64 {
+	var total time.Duration
+	completed := 0
+	for _, o := range orders {
+		if o.Status != OrderCompleted || o.Updated.Before(since) || !o.Updated.Before(until) || !o.Updated.After(o.Created) {
+			continue
+		}
+		total += o.Updated.Sub(o.Created)
+		completed++
+	}
+	if completed == 0 {
+		return defaultDays
+	}
+	return total.Hours() / 24 / float64(completed)
+}
+
+func ceil(f float64) int64 {
+	return int64(math.Ceil(f))
+}
+
+// GetReplenishment suggests how much of the product to produce. Kits are never produced, their components are.
+func (s *service) GetReplenishment(ctx context.Context, product Product) (Replenishment, error) {
+	if product.Kit {
+		return Replenishment{}, kitNotStocked(product)
+	}
+	return s.replenishment(ctx, product, time.Now())
+}
+
+func (s *service) replenishment(ctx context.Context, product Product, now time.Time) (Replenishment, error) {
+	p := replenishmentParams(s.settings.Get())
+	since := windowStart(now, p)
+	until := now.UTC().Truncate(day)
+
+	demand, err := s.repo.GetDailyDemand(ctx, product.Sku, since, until)
+	if err != nil {
+		return Replenishment{}, errors.WithMessage(err, "failed to get demand history")
+	}
+	completed, err := s.repo.GetCompletedProductionOrders(ctx, product.Sku, since, until)
+	if err != nil {
+		return Replenishment{}, errors.WithMessage(err, "failed to get production history")
+	}
+	backlog, err := s.repo.GetSkuBacklog(ctx, product.Sku)
+	if err != nil {
+		return Replenishment{}, errors.WithMessage(err, "failed to get reservation backlog")
+	}
+
+	return CalculateReplenishment(product, backlog, demand, completed, now, p), nil
+}
+
+// RunReplenishmentReport calculates and stores a suggestion for every product other than kits, returning how many were
+// stored.
+func (s *service) RunReplenishmentReport(ctx context.Context, now time.Time) (int, error) {
+	const funcName = "RunReplenishmentReport"
+	const pageSize = 100
+
+	count := 0
+	for offset := 0; ; offset += pageSize {
+		products, err := s.repo.GetAllProducts(ctx, pageSize, offset)
+		if err != nil {
+			return count, errors.WithStack(err)
+		}
+		for _, product := range products {
+			if product.Kit {
+				continue
+			}
+			r, err := s.replenishment(ctx, product, now)
+			if err != nil {
+				return count, err
+			}
+			if err = s.repo.SaveReplenishment(ctx, r); err != nil {
+				return count, errors.WithMessage(err, "failed to save replenishment suggestion")
+			}
+			count++
+		}
+		if len(products) < pageSize {
+			break
+		}
+	}
+
+	log.Info().Str("func", funcName).Int("products", count).Msg("replenishment report complete")
+	return count, nil
+}
+
+func (s *service) GetReplenishmentReport(ctx context.Context, limit, offset int) ([]Replenishment, error) {
+	return s.repo.GetLatestReplenishments(ctx, limit, offset)
+}
+
+// ScheduleReplenishmentReport runs the report once a day at the given hour, UTC, until the context is cancelled.
+func ScheduleReplenishmentReport(ctx context.Context, service Service, hour int) {
+	for {
+		now := time.Now().UTC()
+		next := now.Truncate(day).Add(time.Duration(hour) * time.Hour)
+		if !next.After(now) {
+			next = next.Add(day)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+			if _, err := service.RunReplenishmentReport(ctx, time.Now()); err != nil {
+				log.Error().Err(err).Msg("failed to run replenishment report")
+			}
+		}
+	}
+}
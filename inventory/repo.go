@@ -3,9 +3,11 @@ package inventory
 import (
 	"context"
 	"database/sql"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pgx/v4"
 	"github.com/pkg/errors"
 	"github.com/sksmith/smfg-inventory/db"
+	"time"
 )
 
 type Repository interface {
@@ -13,8 +15,74 @@ type Repository interface {
 	GetProductionEventByRequestID(ctx context.Context, requestID string, tx ...db.Transaction)  (pe ProductionEvent, err error)
 	SaveReservation(ctx context.Context, reservation *Reservation, tx ...db.Transaction) error
 	UpdateReservation(ctx context.Context, ID uint64, state ReserveState, qty int64, txs ...db.Transaction) error
+	UpdateRequestedQuantity(ctx context.Context, ID uint64, requested, shipped int64, txs ...db.Transaction) error
+	UpdateBackorder(ctx context.Context, ID uint64, estimatedFill *time.Time, txs ...db.Transaction) error
+	GetBackorders(ctx context.Context, limit, offset int, txs ...db.Transaction) ([]Backorder, error)
 	GetSkuReservationsByState(ctx context.Context, sku string, state ReserveState, limit, offset int, tx ...db.Transaction) ([]Reservation, error)
+	GetComponentReservations(ctx context.Context, kitReservationID uint64, tx ...db.Transaction) ([]Reservation, error)
 	GetReservationByRequestID(ctx context.Context, requestId string, tx ...db.Transaction) (Reservation, error)
+	GetReservation(ctx context.Context, ID uint64, tx ...db.Transaction) (Reservation, error)
+	SaveAdjustment(ctx context.Context, adj *Adjustment, tx ...db.Transaction) error
+	GetAdjustmentByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (Adjustment, error)
+	SaveStatusChange(ctx context.Context, change *StatusChange, tx ...db.Transaction) error
+	GetStatusChangeByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (StatusChange, error)
+	GetIdempotencyKey(ctx context.Context, op Operation, key string, tx ...db.Transaction) (IdempotencyKey, error)
+	SaveIdempotencyKey(ctx context.Context, key *IdempotencyKey, tx ...db.Transaction) error
+	DeleteIdempotencyKeys(ctx context.Context, before time.Time, tx ...db.Transaction) (int64, error)
+	GetReservationBacklog(ctx context.Context, tx ...db.Transaction) (ReservationBacklog, error)
+	GetStockAlert(ctx context.Context, sku string, tx ...db.Transaction) (StockAlert, error)
+	GetStockAlerts(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]StockAlert, error)
+	SaveStockAlert(ctx context.Context, alert StockAlert, tx ...db.Transaction) error
+	DeleteStockAlert(ctx context.Context, sku string, tx ...db.Transaction) error
+	GetDailyDemand(ctx context.Context, sku string, since, until time.Time, tx ...db.Transaction) ([]DailyQuantity, error)
+	GetCompletedProductionOrders(ctx context.Context, sku string, since, until time.Time, tx ...db.Transaction) ([]ProductionOrder, error)
+	GetSkuBacklog(ctx context.Context, sku string, tx ...db.Transaction) (int64, error)
+	SaveReplenishment(ctx context.Context, r Replenishment, tx ...db.Transaction) error
+	GetLatestReplenishments(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]Replenishment, error)
+	SaveProductionOrder(ctx context.Context, order *ProductionOrder, tx ...db.Transaction) error
+	GetProductionOrder(ctx context.Context, ID uint64, tx ...db.Transaction) (ProductionOrder, error)
+	GetSkuProductionOrders(ctx context.Context, sku string, tx ...db.Transaction) ([]ProductionOrder, error)
+	UpdateProductionOrder(ctx context.Context, order ProductionOrder, tx ...db.Transaction) error
+	SaveBom(ctx context.Context, bom *BillOfMaterials, tx ...db.Transaction) error
+	GetBom(ctx context.Context, sku string, version int, tx ...db.Transaction) (BillOfMaterials, error)
+	GetKitsContaining(ctx context.Context, sku string, tx ...db.Transaction) ([]Product, error)
+	SaveSerial(ctx context.Context, serial Serial, tx ...db.Transaction) error
+	UpdateSerial(ctx context.Context, serial Serial, tx ...db.Transaction) error
+	GetSerial(ctx context.Context, serial string, tx ...db.Transaction) (Serial, error)
+	GetAvailableSerials(ctx context.Context, sku string, limit int64, tx ...db.Transaction) ([]Serial, error)
+	GetReservationSerials(ctx context.Context, reservationID uint64, tx ...db.Transaction) ([]Serial, error)
+	GetProductionSerials(ctx context.Context, productionEventID uint64, tx ...db.Transaction) ([]Serial, error)
+	SaveSerialEvent(ctx context.Context, event *SerialEvent, tx ...db.Transaction) error
+	GetSerialEvents(ctx context.Context, serial string, tx ...db.Transaction) ([]SerialEvent, error)
+	SaveUnits(ctx context.Context, sku string, units Units, tx ...db.Transaction) error
+	GetUnits(ctx context.Context, sku string, tx ...db.Transaction) (Units, error)
+	SaveLocation(ctx context.Context, loc *Location, tx ...db.Transaction) error
+	GetLocation(ctx context.Context, code string, tx ...db.Transaction) (Location, error)
+	GetLocations(ctx context.Context, tx ...db.Transaction) ([]Location, error)
+	GetLocationStock(ctx context.Context, sku string, tx ...db.Transaction) ([]LocationStock, error)
+	AddLocationStock(ctx context.Context, sku, location string, qty int64, tx ...db.Transaction) error
+	SaveTransfer(ctx context.Context, transfer *Transfer, tx ...db.Transaction) error
+	UpdateTransfer(ctx context.Context, transfer Transfer, tx ...db.Transaction) error
+	GetTransfer(ctx context.Context, ID uint64, tx ...db.Transaction) (Transfer, error)
+	GetTransferByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (Transfer, error)
+	SaveTransferReceipt(ctx context.Context, receipt *TransferReceipt, tx ...db.Transaction) error
+	GetTransferReceiptByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (TransferReceipt, error)
+	SaveCycleCount(ctx context.Context, count *CycleCount, tx ...db.Transaction) error
+	UpdateCycleCount(ctx context.Context, count CycleCount, tx ...db.Transaction) error
+	GetCycleCount(ctx context.Context, ID uint64, tx ...db.Transaction) (CycleCount, error)
+	GetCycleCounts(ctx context.Context, limit, offset int, tx ...db.Transaction) ([]CycleCount, error)
+	SaveReturn(ctx context.Context, rma *Return, tx ...db.Transaction) error
+	UpdateReturn(ctx context.Context, rma Return, tx ...db.Transaction) error
+	GetReturn(ctx context.Context, ID uint64, tx ...db.Transaction) (Return, error)
+	GetReturnByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (Return, error)
+	GetReturnedQuantity(ctx context.Context, reservationID uint64, tx ...db.Transaction) (int64, error)
+	SaveReturnReceipt(ctx context.Context, receipt *ReturnReceipt, tx ...db.Transaction) error
+	GetReturnReceiptByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (ReturnReceipt, error)
+	SaveSalesOrder(ctx context.Context, order *SalesOrder, tx ...db.Transaction) error
+	UpdateSalesOrder(ctx context.Context, order SalesOrder, tx ...db.Transaction) error
+	GetSalesOrder(ctx context.Context, ID uint64, tx ...db.Transaction) (SalesOrder, error)
+	GetSalesOrderByRequestID(ctx context.Context, requestID string, tx ...db.Transaction) (SalesOrder, error)
+	GetActiveSalesOrders(ctx context.Context, sku string, tx ...db.Transaction) ([]SalesOrder, error)
 	SaveProduct(ctx context.Context, product Product, tx ...db.Transaction) error
 	GetProduct(ctx context.Context, sku string, tx ...db.Transaction) (Product, error)
 	GetAllProducts(ctx context.Context, limit int, offset int, tx ...db.Transaction) ([]Product, error)
@@ -31,45 +99,77 @@ func NewPostgresRepo(conn db.Conn) Repository {
 	}
 }
 
+// SaveProduct updates the product if it is still at the version it was read at, or creates it if it doesn't exist.
+// Either way the stored version ends up one higher than product.Version.
 func (d *dbRepo) SaveProduct(ctx context.Context, product Product, txs ...db.Transaction) error {
 	m := db.StartMetric("SaveProduct")
+	ctx = m.StartSpan(ctx)
 	tx := d.conn
 	if len(txs) > 0 {
 		tx = txs[0]
 	}
 	ct, err := tx.Exec(ctx,`
 		UPDATE products
-           SET upc = $2, name = $3, available = $4, reserved = $5
-         WHERE sku = $1;`,
-		product.Sku, product.Upc, product.Name, product.Available, product.Reserved)
+           SET upc = $2, name = $3, available = $4, reserved = $5, version = version + 1,
+               reorder_point = $7, safety_stock = $8, reorder_quantity = $9, quarantine = $10, damaged = $11,
+               in_transit = $12
+         WHERE sku = $1 AND version = $6;`,
+		product.Sku, product.Upc, product.Name, product.Available, product.Reserved, product.Version,
+		product.ReorderPoint, product.SafetyStock, product.ReorderQuantity, product.Quarantine, product.Damaged,
+		product.InTransit)
 	if err != nil {
-		m.Complete(nil)
-		return errors.WithStack(err)
+		m.Complete(err)
+		return productError(product, err)
 	}
 	if ct.RowsAffected() == 0 {
-		_, err := tx.Exec(ctx,`
-		INSERT INTO products (sku, upc, name, available, reserved)
-                      VALUES ($1, $2, $3, $4, $5);`,
-			product.Sku, product.Upc, product.Name, product.Available, product.Reserved)
+		ct, err = tx.Exec(ctx,`
+		INSERT INTO products (sku, upc, name, available, reserved, version, reorder_point, safety_stock, reorder_quantity,
+		                      kit, serialized, quarantine, damaged, in_transit)
+                      VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+                 ON CONFLICT (sku) DO NOTHING;`,
+			product.Sku, product.Upc, product.Name, product.Available, product.Reserved, product.Version+1,
+			product.ReorderPoint, product.SafetyStock, product.ReorderQuantity, product.Kit, product.Serialized,
+			product.Quarantine, product.Damaged, product.InTransit)
 		m.Complete(err)
 		if err != nil {
-			return err
+			return productError(product, err)
 		}
+		if ct.RowsAffected() == 0 {
+			return conflict(CodeConcurrentModification, "product %s was modified since version %d was read", product.Sku, product.Version)
+		}
+		return nil
 	}
 	m.Complete(nil)
 	return nil
 }
 
+const uniqueViolation = "23505"
+
+// productError translates constraint violations on the products table into domain errors.
+func productError(product Product, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return conflict(CodeDuplicateUpc, "upc %s is already used by another product", product.Upc)
+	}
+	return errors.WithStack(err)
+}
+
 func (d *dbRepo) GetProduct(ctx context.Context, sku string, txs ...db.Transaction) (Product, error) {
 	m := db.StartMetric("GetProduct")
+	ctx = m.StartSpan(ctx)
 	tx := d.conn
 	if len(txs) > 0 {
 		tx = txs[0]
 	}
 
 	product := Product{}
-	err := tx.QueryRow(ctx, `SELECT sku, upc, name, available, reserved FROM products WHERE sku = $1`, sku).
-		Scan(&product.Sku, &product.Upc, &product.Name, &product.Available, &product.Reserved)
+	err := tx.QueryRow(ctx, `
+		SELECT sku, upc, name, available, reserved, version, reorder_point, safety_stock, reorder_quantity, kit,
+		       serialized, quarantine, damaged, in_transit
+		  FROM products WHERE sku = $1`, sku).
+		Scan(&product.Sku, &product.Upc, &product.Name, &product.Available, &product.Reserved, &product.Version,
+			&product.ReorderPoint, &product.SafetyStock, &product.ReorderQuantity, &product.Kit, &product.Serialized,
+			&product.Quarantine, &product.Damaged, &product.InTransit)
 
 	if err != nil {
 		m.Complete(err)
@@ -85,6 +185,7 @@ func (d *dbRepo) GetProduct(ctx context.Context, sku string, txs ...db.Transacti
 
 func (d *dbRepo) GetAllProducts(ctx context.Context, limit int, offset int, txs ...db.Transaction) ([]Product, error) {
 	m := db.StartMetric("GetAllProducts")
+	ctx = m.StartSpan(ctx)
 	tx := d.conn
 	if len(txs) > 0 {
 		tx = txs[0]
@@ -92,7 +193,9 @@ func (d *dbRepo) GetAllProducts(ctx context.Context, limit int, offset int, txs
 
 	products := make([]Product, 0)
 	rows, err := tx.Query(ctx,
-		`SELECT sku, upc, name, available, reserved FROM products ORDER BY sku LIMIT $1 OFFSET $2;`,
+		`SELECT sku, upc, name, available, reserved, version, reorder_point, safety_stock, reorder_quantity, kit,
+		        serialized, quarantine, damaged, in_transit
+		   FROM products ORDER BY sku LIMIT $1 OFFSET $2;`,
 		limit, offset)
 	if err != nil {
 		m.Complete(err)
@@ -102,7 +205,9 @@ func (d *dbRepo) GetAllProducts(ctx context.Context, limit int, offset int, txs
 
 	for rows.Next() {
 		product := Product{}
-		err = rows.Scan(&product.Sku, &product.Upc, &product.Name, &product.Available, &product.Reserved)
+		err = rows.Scan(&product.Sku, &product.Upc, &product.Name, &product.Available, &product.Reserved, &product.Version,
+			&product.ReorderPoint, &product.SafetyStock, &product.ReorderQuantity, &product.Kit, &product.Serialized,
+			&product.Quarantine, &product.Damaged, &product.InTransit)
 		if err != nil {
 			m.Complete(err)
 			if err == pgx.ErrNoRows {
@@ -119,14 +224,18 @@ func (d *dbRepo) GetAllProducts(ctx context.Context, limit int, offset int, txs
 
 func (d *dbRepo) GetProductionEventByRequestID(ctx context.Context, requestID string, txs ...db.Transaction) (pe ProductionEvent, err error) {
 	m := db.StartMetric("GetProductionEventByRequestID")
+	ctx = m.StartSpan(ctx)
 	tx := d.conn
 	if len(txs) > 0 {
 		tx = txs[0]
 	}
 
 	pe = ProductionEvent{}
-	err = tx.QueryRow(ctx, `SELECT id, request_id, sku, quantity, created FROM production_events WHERE request_id = $1`, requestID).
-		Scan(&pe.ID, &pe.RequestID, &pe.Sku, &pe.Quantity, &pe.Created)
+	err = tx.QueryRow(ctx, `SELECT id, request_id, sku, quantity, coalesce(production_order_id, 0), backflush,
+	                               coalesce(bom_version, 0), bucket, created
+	                          FROM production_events WHERE request_id = $1`, requestID).
+		Scan(&pe.ID, &pe.RequestID, &pe.Sku, &pe.Quantity, &pe.ProductionOrderID, &pe.Backflush, &pe.BomVersion,
+			&pe.Bucket, &pe.Created)
 
 	if err != nil {
 		m.Complete(err)
@@ -142,14 +251,17 @@ func (d *dbRepo) GetProductionEventByRequestID(ctx context.Context, requestID st
 
 func (d *dbRepo) SaveProductionEvent(ctx context.Context, event *ProductionEvent, txs ...db.Transaction) error {
 	m := db.StartMetric("SaveProductionEvent")
+	ctx = m.StartSpan(ctx)
 	tx := d.conn
 	if len(txs) > 0 {
 		tx = txs[0]
 	}
-	insert := `INSERT INTO production_events (request_id, sku, quantity, created)
-			       VALUES ($1, $2, $3, $4) RETURNING id;`
+	insert := `INSERT INTO production_events (request_id, sku, quantity, production_order_id, backflush, bom_version,
+	                                          bucket, created)
+			       VALUES ($1, $2, $3, nullif($4, 0), $5, nullif($6, 0), $7, $8) RETURNING id;`
 
-	err := tx.QueryRow(ctx, insert, event.RequestID, event.Sku, event.Quantity, event.Created).Scan(&event.ID)
+	err := tx.QueryRow(ctx, insert, event.RequestID, event.Sku, event.Quantity, int64(event.ProductionOrderID),
+		event.Backflush, event.BomVersion, event.Bucket, event.Created).Scan(&event.ID)
 	if err != nil {
 		m.Complete(err)
 		if err == pgx.ErrNoRows {
@@ -161,15 +273,21 @@ func (d *dbRepo) SaveProductionEvent(ctx context.Context, event *ProductionEvent
 	return nil
 }
 
+const reservationColumns = `id, request_id, requester, sku, state, reserved_quantity, requested_quantity, created,
+	coalesce(kit_reservation_id, 0), shipped_quantity, backordered, estimated_fill`
+
 func (d *dbRepo) SaveReservation(ctx context.Context, r *Reservation, txs ...db.Transaction) error {
 	m := db.StartMetric("SaveReservation")
+	ctx = m.StartSpan(ctx)
 	tx := d.conn
 	if len(txs) > 0 {
 		tx = txs[0]
 	}
-	insert := `INSERT INTO reservations (request_id, requester, sku, state, reserved_quantity, requested_quantity, created)
-                      VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
-	err := tx.QueryRow(ctx, insert, r.RequestID, r.Requester, r.Sku, r.State, r.ReservedQuantity, r.RequestedQuantity, r.Created).Scan(&r.ID)
+	insert := `INSERT INTO reservations (request_id, requester, sku, state, reserved_quantity, requested_quantity, created,
+	                                     kit_reservation_id)
+                      VALUES ($1, $2, $3, $4, $5, $6, $7, nullif($8, 0)) RETURNING id;`
+	err := tx.QueryRow(ctx, insert, r.RequestID, r.Requester, r.Sku, r.State, r.ReservedQuantity, r.RequestedQuantity, r.Created,
+		int64(r.KitReservationID)).Scan(&r.ID)
 	if err != nil {
 		m.Complete(err)
 		if err == pgx.ErrNoRows {
@@ -183,6 +301,7 @@ func (d *dbRepo) SaveReservation(ctx context.Context, r *Reservation, txs ...db.
 
 func (d *dbRepo) UpdateReservation(ctx context.Context, ID uint64, state ReserveState, qty int64, txs ...db.Transaction) error {
 	m := db.StartMetric("UpdateReservation")
+	ctx = m.StartSpan(ctx)
 	tx := d.conn
 	if len(txs) > 0 {
 		tx = txs[0]
@@ -197,8 +316,89 @@ func (d *dbRepo) UpdateReservation(ctx context.Context, ID uint64, state Reserve
 	return nil
 }
 
+func (d *dbRepo) UpdateRequestedQuantity(ctx context.Context, ID uint64, requested, shipped int64, txs ...db.Transaction) error {
+	m := db.StartMetric("UpdateRequestedQuantity")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+	_, err := tx.Exec(ctx, `UPDATE reservations SET requested_quantity = $2, shipped_quantity = $3 WHERE id = $1;`,
+		ID, requested, shipped)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+// UpdateBackorder flags a reservation as backordered with the date it is expected to be filled, if known.
+func (d *dbRepo) UpdateBackorder(ctx context.Context, ID uint64, estimatedFill *time.Time, txs ...db.Transaction) error {
+	m := db.StartMetric("UpdateBackorder")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+	_, err := tx.Exec(ctx, `UPDATE reservations SET backordered = true, estimated_fill = $2 WHERE id = $1;`,
+		ID, estimatedFill)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+// GetBackorders returns what each requester is waiting on of each product across their open backordered reservations,
+// by sku and requester. The reservations of each are oldest first.
+func (d *dbRepo) GetBackorders(ctx context.Context, limit, offset int, txs ...db.Transaction) ([]Backorder, error) {
+	m := db.StartMetric("GetBackorders")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	backorders := make([]Backorder, 0)
+	rows, err := tx.Query(ctx,
+		`SELECT sku, requester, array_agg(id::bigint ORDER BY created, id),
+                    sum(requested_quantity - reserved_quantity)
+               FROM reservations
+              WHERE state = $1 AND backordered
+           GROUP BY sku, requester
+           ORDER BY sku, requester
+              LIMIT $2 OFFSET $3;`,
+		Open, limit, offset)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b := Backorder{}
+		ids := make([]int64, 0)
+		err = rows.Scan(&b.Sku, &b.Requester, &ids, &b.Quantity)
+		if err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		b.Reservations = make([]uint64, 0, len(ids))
+		for _, id := range ids {
+			b.Reservations = append(b.Reservations, uint64(id))
+		}
+		backorders = append(backorders, b)
+	}
+
+	m.Complete(nil)
+	return backorders, nil
+}
+
 func (d *dbRepo) GetSkuReservationsByState(ctx context.Context, sku string, state ReserveState, limit, offset int, txs ...db.Transaction) ([]Reservation, error) {
 	m := db.StartMetric("GetSkuOpenReserves")
+	ctx = m.StartSpan(ctx)
 	tx := d.conn
 	if len(txs) > 0 {
 		tx = txs[0]
@@ -206,7 +406,7 @@ func (d *dbRepo) GetSkuReservationsByState(ctx context.Context, sku string, stat
 
 	reservations := make([]Reservation, 0)
 	rows, err := tx.Query(ctx,
-		`SELECT id, request_id, requester, sku, state, reserved_quantity, requested_quantity, created
+		`SELECT `+reservationColumns+`
                FROM reservations
               WHERE sku = $1 AND state = $2
            ORDER BY created ASC LIMIT $3 OFFSET $4;`,
@@ -219,7 +419,7 @@ func (d *dbRepo) GetSkuReservationsByState(ctx context.Context, sku string, stat
 
 	for rows.Next() {
 		r := Reservation{}
-		err = rows.Scan(&r.ID, &r.RequestID, &r.Requester, &r.Sku, &r.State, &r.ReservedQuantity, &r.RequestedQuantity, &r.Created)
+		err = rows.Scan(&r.ID, &r.RequestID, &r.Requester, &r.Sku, &r.State, &r.ReservedQuantity, &r.RequestedQuantity, &r.Created, &r.KitReservationID, &r.ShippedQuantity, &r.Backordered, &r.EstimatedFill)
 		if err != nil {
 			m.Complete(err)
 			return nil, err
@@ -231,8 +431,46 @@ func (d *dbRepo) GetSkuReservationsByState(ctx context.Context, sku string, stat
 	return reservations, nil
 }
 
+// GetComponentReservations returns the reservations of the components of a kit made for the kit reservation.
+func (d *dbRepo) GetComponentReservations(ctx context.Context, kitReservationID uint64, txs ...db.Transaction) ([]Reservation, error) {
+	m := db.StartMetric("GetComponentReservations")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	reservations := make([]Reservation, 0)
+	rows, err := tx.Query(ctx,
+		`SELECT `+reservationColumns+`
+               FROM reservations
+              WHERE kit_reservation_id = $1
+           ORDER BY sku;`,
+		kitReservationID)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := Reservation{}
+		err = rows.Scan(&r.ID, &r.RequestID, &r.Requester, &r.Sku, &r.State, &r.ReservedQuantity, &r.RequestedQuantity,
+			&r.Created, &r.KitReservationID, &r.ShippedQuantity, &r.Backordered, &r.EstimatedFill)
+		if err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		reservations = append(reservations, r)
+	}
+
+	m.Complete(nil)
+	return reservations, nil
+}
+
 func (d *dbRepo) GetReservationByRequestID(ctx context.Context, requestId string, txs ...db.Transaction) (Reservation, error) {
 	m := db.StartMetric("GetReservationByRequestID")
+	ctx = m.StartSpan(ctx)
 	tx := d.conn
 	if len(txs) > 0 {
 		tx = txs[0]
@@ -240,10 +478,10 @@ func (d *dbRepo) GetReservationByRequestID(ctx context.Context, requestId string
 
 	r := Reservation{}
 	err := tx.QueryRow(ctx,
-		`SELECT id, request_id, requester, sku, state, reserved_quantity, requested_quantity, created
+		`SELECT `+reservationColumns+`
                FROM reservations
               WHERE request_id = $1;`,
-		requestId).Scan(&r.ID, &r.RequestID, &r.Requester, &r.Sku, &r.State, &r.ReservedQuantity, &r.RequestedQuantity, &r.Created)
+		requestId).Scan(&r.ID, &r.RequestID, &r.Requester, &r.Sku, &r.State, &r.ReservedQuantity, &r.RequestedQuantity, &r.Created, &r.KitReservationID, &r.ShippedQuantity, &r.Backordered, &r.EstimatedFill)
 	if err != nil {
 		m.Complete(err)
 		if err == pgx.ErrNoRows {
@@ -256,8 +494,1715 @@ func (d *dbRepo) GetReservationByRequestID(ctx context.Context, requestId string
 	return r, nil
 }
 
-func (d *dbRepo) BeginTransaction(ctx context.Context) (db.Transaction, error) {
-	tx, err := d.conn.Begin(ctx)
+func (d *dbRepo) GetReservation(ctx context.Context, ID uint64, txs ...db.Transaction) (Reservation, error) {
+	m := db.StartMetric("GetReservation")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	r := Reservation{}
+	err := tx.QueryRow(ctx,
+		`SELECT `+reservationColumns+`
+               FROM reservations
+              WHERE id = $1;`,
+		ID).Scan(&r.ID, &r.RequestID, &r.Requester, &r.Sku, &r.State, &r.ReservedQuantity, &r.RequestedQuantity, &r.Created, &r.KitReservationID, &r.ShippedQuantity, &r.Backordered, &r.EstimatedFill)
+	if err != nil {
+		m.Complete(err)
+		if err == pgx.ErrNoRows {
+			return r, errors.WithStack(sql.ErrNoRows)
+		}
+		return r, errors.WithStack(err)
+	}
+
+	m.Complete(nil)
+	return r, nil
+}
+
+func (d *dbRepo) SaveAdjustment(ctx context.Context, adj *Adjustment, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveAdjustment")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+	insert := `INSERT INTO adjustments (request_id, sku, quantity, reason, created)
+                    VALUES ($1, $2, $3, $4, $5) RETURNING id;`
+
+	err := tx.QueryRow(ctx, insert, adj.RequestID, adj.Sku, adj.Quantity, adj.Reason, adj.Created).Scan(&adj.ID)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+func (d *dbRepo) GetAdjustmentByRequestID(ctx context.Context, requestID string, txs ...db.Transaction) (Adjustment, error) {
+	m := db.StartMetric("GetAdjustmentByRequestID")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	adj := Adjustment{}
+	err := tx.QueryRow(ctx, `SELECT id, request_id, sku, quantity, reason, created FROM adjustments WHERE request_id = $1`, requestID).
+		Scan(&adj.ID, &adj.RequestID, &adj.Sku, &adj.Quantity, &adj.Reason, &adj.Created)
+	if err != nil {
+		m.Complete(err)
+		if err == pgx.ErrNoRows {
+			return adj, errors.WithStack(sql.ErrNoRows)
+		}
+		return adj, errors.WithStack(err)
+	}
+
+	m.Complete(nil)
+	return adj, nil
+}
+
+func (d *dbRepo) SaveStatusChange(ctx context.Context, change *StatusChange, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveStatusChange")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	err := tx.QueryRow(ctx, `
+		INSERT INTO status_changes (request_id, sku, from_bucket, to_bucket, quantity, reason, created)
+		     VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`,
+		change.RequestID, change.Sku, change.From, change.To, change.Quantity, change.Reason, change.Created).
+		Scan(&change.ID)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+func (d *dbRepo) GetStatusChangeByRequestID(ctx context.Context, requestID string, txs ...db.Transaction) (StatusChange, error) {
+	m := db.StartMetric("GetStatusChangeByRequestID")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	c := StatusChange{}
+	err := tx.QueryRow(ctx, `
+		SELECT id, request_id, sku, from_bucket, to_bucket, quantity, reason, created
+		  FROM status_changes WHERE request_id = $1;`, requestID).
+		Scan(&c.ID, &c.RequestID, &c.Sku, &c.From, &c.To, &c.Quantity, &c.Reason, &c.Created)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			m.Complete(nil)
+			return c, errors.WithStack(sql.ErrNoRows)
+		}
+		m.Complete(err)
+		return c, errors.WithStack(err)
+	}
+
+	m.Complete(nil)
+	return c, nil
+}
+
+func (d *dbRepo) GetIdempotencyKey(ctx context.Context, op Operation, key string, txs ...db.Transaction) (IdempotencyKey, error) {
+	m := db.StartMetric("GetIdempotencyKey")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	k := IdempotencyKey{}
+	err := tx.QueryRow(ctx,
+		`SELECT operation, key, request_hash, created FROM idempotency_keys WHERE operation = $1 AND key = $2`,
+		op, key).Scan(&k.Operation, &k.Key, &k.RequestHash, &k.Created)
+	if err != nil {
+		m.Complete(err)
+		if err == pgx.ErrNoRows {
+			return k, errors.WithStack(sql.ErrNoRows)
+		}
+		return k, errors.WithStack(err)
+	}
+
+	m.Complete(nil)
+	return k, nil
+}
+
+func (d *dbRepo) SaveIdempotencyKey(ctx context.Context, k *IdempotencyKey, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveIdempotencyKey")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx,
+		`INSERT INTO idempotency_keys (operation, key, request_hash, created) VALUES ($1, $2, $3, $4);`,
+		k.Operation, k.Key, k.RequestHash, k.Created)
+	m.Complete(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *dbRepo) DeleteIdempotencyKeys(ctx context.Context, before time.Time, txs ...db.Transaction) (int64, error) {
+	m := db.StartMetric("DeleteIdempotencyKeys")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	ct, err := tx.Exec(ctx, `DELETE FROM idempotency_keys WHERE created < $1;`, before)
+	m.Complete(err)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return ct.RowsAffected(), nil
+}
+
+func (d *dbRepo) GetReservationBacklog(ctx context.Context, txs ...db.Transaction) (ReservationBacklog, error) {
+	m := db.StartMetric("GetReservationBacklog")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	backlog := ReservationBacklog{}
+	var oldest *time.Time
+	err := tx.QueryRow(ctx, `SELECT count(*), min(created) FROM reservations WHERE state = $1;`, Open).
+		Scan(&backlog.Open, &oldest)
+	m.Complete(err)
+	if err != nil {
+		return backlog, errors.WithStack(err)
+	}
+	if oldest != nil {
+		backlog.Oldest = *oldest
+	}
+	return backlog, nil
+}
+
+const stockAlertColumns = `sku, level, available, reorder_point, safety_stock, reorder_quantity, raised, updated`
+
+func (d *dbRepo) GetStockAlert(ctx context.Context, sku string, txs ...db.Transaction) (StockAlert, error) {
+	m := db.StartMetric("GetStockAlert")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	a := StockAlert{}
+	err := tx.QueryRow(ctx, `SELECT `+stockAlertColumns+` FROM stock_alerts WHERE sku = $1;`, sku).
+		Scan(&a.Sku, &a.Level, &a.Available, &a.ReorderPoint, &a.SafetyStock, &a.ReorderQuantity, &a.Raised, &a.Updated)
+	m.Complete(err)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return a, errors.WithStack(sql.ErrNoRows)
+		}
+		return a, errors.WithStack(err)
+	}
+	return a, nil
+}
+
+func (d *dbRepo) GetStockAlerts(ctx context.Context, limit, offset int, txs ...db.Transaction) ([]StockAlert, error) {
+	m := db.StartMetric("GetStockAlerts")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	alerts := make([]StockAlert, 0)
+	rows, err := tx.Query(ctx,
+		`SELECT `+stockAlertColumns+` FROM stock_alerts ORDER BY raised ASC, sku LIMIT $1 OFFSET $2;`,
+		limit, offset)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := StockAlert{}
+		err = rows.Scan(&a.Sku, &a.Level, &a.Available, &a.ReorderPoint, &a.SafetyStock, &a.ReorderQuantity, &a.Raised, &a.Updated)
+		if err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		alerts = append(alerts, a)
+	}
+
+	m.Complete(nil)
+	return alerts, nil
+}
+
+func (d *dbRepo) SaveStockAlert(ctx context.Context, a StockAlert, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveStockAlert")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO stock_alerts (`+stockAlertColumns+`)
+		     VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (sku) DO UPDATE
+		        SET level = $2, available = $3, reorder_point = $4, safety_stock = $5, reorder_quantity = $6,
+		            updated = $8;`,
+		a.Sku, a.Level, a.Available, a.ReorderPoint, a.SafetyStock, a.ReorderQuantity, a.Raised, a.Updated)
+	m.Complete(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *dbRepo) DeleteStockAlert(ctx context.Context, sku string, txs ...db.Transaction) error {
+	m := db.StartMetric("DeleteStockAlert")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `DELETE FROM stock_alerts WHERE sku = $1;`, sku)
+	m.Complete(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// GetDailyDemand totals the quantity requested by reservations on each UTC day in [since, until). Cancelled
+// reservations are still counted since they were demand at the time.
+func (d *dbRepo) GetDailyDemand(ctx context.Context, sku string, since, until time.Time, txs ...db.Transaction) ([]DailyQuantity, error) {
+	m := db.StartMetric("GetDailyDemand")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	demand := make([]DailyQuantity, 0)
+	rows, err := tx.Query(ctx, `
+		SELECT date_trunc('day', created AT TIME ZONE 'UTC') AS day, sum(requested_quantity)
+		  FROM reservations
+		 WHERE sku = $1 AND created >= $2 AND created < $3
+	  GROUP BY day
+	  ORDER BY day;`,
+		sku, since, until)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		dq := DailyQuantity{}
+		if err = rows.Scan(&dq.Day, &dq.Quantity); err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		dq.Day = time.Date(dq.Day.Year(), dq.Day.Month(), dq.Day.Day(), 0, 0, 0, 0, time.UTC)
+		demand = append(demand, dq)
+	}
+
+	m.Complete(nil)
+	return demand, nil
+}
+
+// GetCompletedProductionOrders returns the production orders for the sku that were completed in the window.
+func (d *dbRepo) GetCompletedProductionOrders(ctx context.Context, sku string, since, until time.Time, txs ...db.Transaction) ([]ProductionOrder, error) {
+	m := db.StartMetric("GetCompletedProductionOrders")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	orders := make([]ProductionOrder, 0)
+	rows, err := tx.Query(ctx,
+		`SELECT `+productionOrderColumns+`
+               FROM production_orders
+              WHERE sku = $1 AND status = $2 AND updated >= $3 AND updated < $4
+           ORDER BY updated ASC, id ASC;`,
+		sku, OrderCompleted, since, until)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		o := ProductionOrder{}
+		if err = rows.Scan(&o.ID, &o.Sku, &o.Status, &o.TargetQuantity, &o.Produced, &o.Due, &o.Created, &o.Updated); err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		orders = append(orders, o)
+	}
+
+	m.Complete(nil)
+	return orders, nil
+}
+
+// GetSkuBacklog is the quantity open reservations for the sku are still waiting on.
+func (d *dbRepo) GetSkuBacklog(ctx context.Context, sku string, txs ...db.Transaction) (int64, error) {
+	m := db.StartMetric("GetSkuBacklog")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	var backlog int64
+	err := tx.QueryRow(ctx, `
+		SELECT coalesce(sum(requested_quantity - reserved_quantity), 0)
+		  FROM reservations
+		 WHERE sku = $1 AND state = $2;`,
+		sku, Open).Scan(&backlog)
+	m.Complete(err)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return backlog, nil
+}
+
+const replenishmentColumns = `sku, window_days, average_daily_demand, demand_std_dev, lead_time_days, safety_stock,
+	reorder_point, economic_order_quantity, inventory_position, suggested_quantity, calculated`
+
+func (d *dbRepo) SaveReplenishment(ctx context.Context, r Replenishment, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveReplenishment")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO replenishment_suggestions (report_date, `+replenishmentColumns+`)
+		     VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (report_date, sku) DO UPDATE
+		        SET window_days = $3, average_daily_demand = $4, demand_std_dev = $5, lead_time_days = $6,
+		            safety_stock = $7, reorder_point = $8, economic_order_quantity = $9, inventory_position = $10,
+		            suggested_quantity = $11, calculated = $12;`,
+		r.Calculated.UTC().Truncate(day), r.Sku, r.WindowDays, r.AverageDailyDemand, r.DemandStdDev, r.LeadTimeDays,
+		r.SafetyStock, r.ReorderPoint, r.EconomicOrderQuantity, r.InventoryPosition, r.SuggestedQuantity, r.Calculated)
+	m.Complete(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *dbRepo) GetLatestReplenishments(ctx context.Context, limit, offset int, txs ...db.Transaction) ([]Replenishment, error) {
+	m := db.StartMetric("GetLatestReplenishments")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	suggestions := make([]Replenishment, 0)
+	rows, err := tx.Query(ctx, `
+		SELECT `+replenishmentColumns+`
+		  FROM replenishment_suggestions
+		 WHERE report_date = (SELECT max(report_date) FROM replenishment_suggestions)
+	  ORDER BY suggested_quantity DESC, sku
+		 LIMIT $1 OFFSET $2;`,
+		limit, offset)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := Replenishment{}
+		err = rows.Scan(&r.Sku, &r.WindowDays, &r.AverageDailyDemand, &r.DemandStdDev, &r.LeadTimeDays, &r.SafetyStock,
+			&r.ReorderPoint, &r.EconomicOrderQuantity, &r.InventoryPosition, &r.SuggestedQuantity, &r.Calculated)
+		if err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		suggestions = append(suggestions, r)
+	}
+
+	m.Complete(nil)
+	return suggestions, nil
+}
+
+func (d *dbRepo) SaveProductionOrder(ctx context.Context, order *ProductionOrder, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveProductionOrder")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+	insert := `INSERT INTO production_orders (sku, status, target_quantity, produced, due, created, updated)
+                    VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
+
+	err := tx.QueryRow(ctx, insert, order.Sku, order.Status, order.TargetQuantity, order.Produced, order.Due,
+		order.Created, order.Updated).Scan(&order.ID)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+const productionOrderColumns = `id, sku, status, target_quantity, produced, due, created, updated`
+
+func (d *dbRepo) GetProductionOrder(ctx context.Context, ID uint64, txs ...db.Transaction) (ProductionOrder, error) {
+	m := db.StartMetric("GetProductionOrder")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	o := ProductionOrder{}
+	err := tx.QueryRow(ctx, `SELECT `+productionOrderColumns+` FROM production_orders WHERE id = $1`, ID).
+		Scan(&o.ID, &o.Sku, &o.Status, &o.TargetQuantity, &o.Produced, &o.Due, &o.Created, &o.Updated)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			m.Complete(nil)
+			return o, errors.WithStack(sql.ErrNoRows)
+		}
+		m.Complete(err)
+		return o, errors.WithStack(err)
+	}
+
+	m.Complete(nil)
+	return o, nil
+}
+
+// GetSkuProductionOrders returns the orders for the sku that are still expected to produce, earliest due first.
+func (d *dbRepo) GetSkuProductionOrders(ctx context.Context, sku string, txs ...db.Transaction) ([]ProductionOrder, error) {
+	m := db.StartMetric("GetSkuProductionOrders")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	orders := make([]ProductionOrder, 0)
+	rows, err := tx.Query(ctx,
+		`SELECT `+productionOrderColumns+`
+               FROM production_orders
+              WHERE sku = $1 AND status NOT IN ($2, $3)
+           ORDER BY due ASC, id ASC;`,
+		sku, OrderCompleted, OrderCancelled)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		o := ProductionOrder{}
+		if err = rows.Scan(&o.ID, &o.Sku, &o.Status, &o.TargetQuantity, &o.Produced, &o.Due, &o.Created, &o.Updated); err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		orders = append(orders, o)
+	}
+
+	m.Complete(nil)
+	return orders, nil
+}
+
+func (d *dbRepo) UpdateProductionOrder(ctx context.Context, order ProductionOrder, txs ...db.Transaction) error {
+	m := db.StartMetric("UpdateProductionOrder")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `UPDATE production_orders SET status = $2, produced = $3, updated = $4 WHERE id = $1;`,
+		order.ID, order.Status, order.Produced, order.Updated)
+	m.Complete(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// SaveBom stores the bill of materials as the next version for its sku and sets the version it was given.
+func (d *dbRepo) SaveBom(ctx context.Context, bom *BillOfMaterials, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveBom")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	err := tx.QueryRow(ctx, `
+		INSERT INTO boms (sku, version, created)
+		     SELECT $1, coalesce(max(version), 0) + 1, $2 FROM boms WHERE sku = $1
+		  RETURNING version;`,
+		bom.Sku, bom.Created).Scan(&bom.Version)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+
+	for _, c := range bom.Components {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO bom_components (sku, version, component_sku, quantity)
+			     VALUES ($1, $2, $3, $4);`,
+			bom.Sku, bom.Version, c.Sku, c.Quantity)
+		if err != nil {
+			m.Complete(err)
+			return errors.WithStack(err)
+		}
+	}
+
+	m.Complete(nil)
+	return nil
+}
+
+// GetBom returns the given version of the bill of materials for the sku, or the latest for version zero.
+func (d *dbRepo) GetBom(ctx context.Context, sku string, version int, txs ...db.Transaction) (BillOfMaterials, error) {
+	m := db.StartMetric("GetBom")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	bom := BillOfMaterials{}
+	err := tx.QueryRow(ctx, `
+		SELECT sku, version, created FROM boms
+		 WHERE sku = $1 AND ($2 = 0 OR version = $2)
+	  ORDER BY version DESC LIMIT 1;`,
+		sku, version).Scan(&bom.Sku, &bom.Version, &bom.Created)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			m.Complete(nil)
+			return bom, errors.WithStack(sql.ErrNoRows)
+		}
+		m.Complete(err)
+		return bom, errors.WithStack(err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT component_sku, quantity FROM bom_components
+		 WHERE sku = $1 AND version = $2
+	  ORDER BY component_sku;`,
+		bom.Sku, bom.Version)
+	if err != nil {
+		m.Complete(err)
+		return bom, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	bom.Components = make([]BomComponent, 0)
+	for rows.Next() {
+		c := BomComponent{}
+		if err = rows.Scan(&c.Sku, &c.Quantity); err != nil {
+			m.Complete(err)
+			return bom, errors.WithStack(err)
+		}
+		bom.Components = append(bom.Components, c)
+	}
+
+	m.Complete(nil)
+	return bom, nil
+}
+
+// GetKitsContaining returns the kits whose latest bill of materials has the sku as a component.
+func (d *dbRepo) GetKitsContaining(ctx context.Context, sku string, txs ...db.Transaction) ([]Product, error) {
+	m := db.StartMetric("GetKitsContaining")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	kits := make([]Product, 0)
+	rows, err := tx.Query(ctx, `
+		SELECT p.sku, p.upc, p.name, p.available, p.reserved, p.version, p.reorder_point, p.safety_stock,
+		       p.reorder_quantity, p.kit, p.serialized, p.quarantine, p.damaged, p.in_transit
+		  FROM products p
+		  JOIN bom_components c ON c.sku = p.sku
+		 WHERE p.kit AND c.component_sku = $1
+		   AND c.version = (SELECT max(version) FROM boms b WHERE b.sku = p.sku)
+	  ORDER BY p.sku;`,
+		sku)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := Product{}
+		err = rows.Scan(&p.Sku, &p.Upc, &p.Name, &p.Available, &p.Reserved, &p.Version, &p.ReorderPoint,
+			&p.SafetyStock, &p.ReorderQuantity, &p.Kit, &p.Serialized, &p.Quarantine, &p.Damaged,
+			&p.InTransit)
+		if err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		kits = append(kits, p)
+	}
+
+	m.Complete(nil)
+	return kits, nil
+}
+
+// SaveSerial registers a new serial number. Serial numbers are unique across all products.
+func (d *dbRepo) SaveSerial(ctx context.Context, serial Serial, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveSerial")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO serials (serial, sku, status, production_event_id, reservation_id, created, updated)
+		     VALUES ($1, $2, $3, nullif($4, 0), nullif($5, 0), $6, $7);`,
+		serial.Serial, serial.Sku, serial.Status, int64(serial.ProductionEventID), int64(serial.ReservationID),
+		serial.Created, serial.Updated)
+	m.Complete(err)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return conflict(CodeDuplicateSerial, "serial %s already exists", serial.Serial)
+		}
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *dbRepo) UpdateSerial(ctx context.Context, serial Serial, txs ...db.Transaction) error {
+	m := db.StartMetric("UpdateSerial")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `UPDATE serials SET status = $2, reservation_id = nullif($3, 0), updated = $4 WHERE serial = $1;`,
+		serial.Serial, serial.Status, int64(serial.ReservationID), serial.Updated)
+	m.Complete(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+const serialColumns = `serial, sku, status, coalesce(production_event_id, 0), coalesce(reservation_id, 0), created,
+	updated`
+
+func (d *dbRepo) GetSerial(ctx context.Context, serial string, txs ...db.Transaction) (Serial, error) {
+	m := db.StartMetric("GetSerial")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	s := Serial{}
+	err := tx.QueryRow(ctx, `SELECT `+serialColumns+` FROM serials WHERE serial = $1;`, serial).
+		Scan(&s.Serial, &s.Sku, &s.Status, &s.ProductionEventID, &s.ReservationID, &s.Created, &s.Updated)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			m.Complete(nil)
+			return s, errors.WithStack(sql.ErrNoRows)
+		}
+		m.Complete(err)
+		return s, errors.WithStack(err)
+	}
+
+	m.Complete(nil)
+	return s, nil
+}
+
+// GetAvailableSerials returns up to limit of the sku's serials that are in stock and not reserved, oldest first.
+func (d *dbRepo) GetAvailableSerials(ctx context.Context, sku string, limit int64, txs ...db.Transaction) ([]Serial, error) {
+	return d.getSerials(ctx, "GetAvailableSerials", `
+		SELECT `+serialColumns+` FROM serials
+		 WHERE sku = $1 AND status = $2
+	  ORDER BY created, serial LIMIT $3;`,
+		txs, sku, SerialAvailable, limit)
+}
+
+func (d *dbRepo) GetReservationSerials(ctx context.Context, reservationID uint64, txs ...db.Transaction) ([]Serial, error) {
+	return d.getSerials(ctx, "GetReservationSerials",
+		`SELECT `+serialColumns+` FROM serials WHERE reservation_id = $1 ORDER BY serial;`,
+		txs, int64(reservationID))
+}
+
+func (d *dbRepo) GetProductionSerials(ctx context.Context, productionEventID uint64, txs ...db.Transaction) ([]Serial, error) {
+	return d.getSerials(ctx, "GetProductionSerials",
+		`SELECT `+serialColumns+` FROM serials WHERE production_event_id = $1 ORDER BY serial;`,
+		txs, int64(productionEventID))
+}
+
+func (d *dbRepo) getSerials(ctx context.Context, name, query string, txs []db.Transaction, args ...interface{}) ([]Serial, error) {
+	m := db.StartMetric(name)
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	serials := make([]Serial, 0)
+	rows, err := tx.Query(ctx, query, args...)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := Serial{}
+		err = rows.Scan(&s.Serial, &s.Sku, &s.Status, &s.ProductionEventID, &s.ReservationID, &s.Created, &s.Updated)
+		if err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		serials = append(serials, s)
+	}
+
+	m.Complete(nil)
+	return serials, nil
+}
+
+func (d *dbRepo) SaveSerialEvent(ctx context.Context, event *SerialEvent, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveSerialEvent")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	err := tx.QueryRow(ctx, `
+		INSERT INTO serial_events (serial, type, request_id, reservation_id, requester, created)
+		     VALUES ($1, $2, nullif($3, ''), nullif($4, 0), nullif($5, ''), $6) RETURNING id;`,
+		event.Serial, event.Type, event.RequestID, int64(event.ReservationID), event.Requester, event.Created).
+		Scan(&event.ID)
+	m.Complete(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// GetSerialEvents returns the history of a serial, oldest first.
+func (d *dbRepo) GetSerialEvents(ctx context.Context, serial string, txs ...db.Transaction) ([]SerialEvent, error) {
+	m := db.StartMetric("GetSerialEvents")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	events := make([]SerialEvent, 0)
+	rows, err := tx.Query(ctx, `
+		SELECT id, serial, type, coalesce(request_id, ''), coalesce(reservation_id, 0), coalesce(requester, ''), created
+		  FROM serial_events
+		 WHERE serial = $1
+	  ORDER BY created, id;`,
+		serial)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := SerialEvent{}
+		if err = rows.Scan(&e.ID, &e.Serial, &e.Type, &e.RequestID, &e.ReservationID, &e.Requester, &e.Created); err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		events = append(events, e)
+	}
+
+	m.Complete(nil)
+	return events, nil
+}
+
+// SaveUnits replaces the units of measure of the sku.
+func (d *dbRepo) SaveUnits(ctx context.Context, sku string, units Units, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveUnits")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `DELETE FROM product_units WHERE sku = $1;`, sku)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+
+	for _, u := range units {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO product_units (sku, name, quantity, unit_of, base_quantity)
+			     VALUES ($1, $2, $3, nullif($4, ''), $5);`,
+			sku, u.Name, u.Quantity, u.Of, u.Base)
+		if err != nil {
+			m.Complete(err)
+			return errors.WithStack(err)
+		}
+	}
+
+	m.Complete(nil)
+	return nil
+}
+
+// GetUnits returns the units of measure of the sku, smallest first.
+func (d *dbRepo) GetUnits(ctx context.Context, sku string, txs ...db.Transaction) (Units, error) {
+	m := db.StartMetric("GetUnits")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT name, quantity, coalesce(unit_of, ''), base_quantity FROM product_units
+		 WHERE sku = $1
+	  ORDER BY base_quantity, name;`,
+		sku)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	units := make(Units, 0)
+	for rows.Next() {
+		u := UnitOfMeasure{}
+		if err = rows.Scan(&u.Name, &u.Quantity, &u.Of, &u.Base); err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		units = append(units, u)
+	}
+
+	m.Complete(nil)
+	return units, nil
+}
+
+func (d *dbRepo) SaveLocation(ctx context.Context, loc *Location, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveLocation")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `INSERT INTO locations (code, name, created) VALUES ($1, $2, $3);`,
+		loc.Code, loc.Name, loc.Created)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+func (d *dbRepo) GetLocation(ctx context.Context, code string, txs ...db.Transaction) (Location, error) {
+	m := db.StartMetric("GetLocation")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	loc := Location{}
+	err := tx.QueryRow(ctx, `SELECT code, name, created FROM locations WHERE code = $1;`, code).
+		Scan(&loc.Code, &loc.Name, &loc.Created)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			m.Complete(nil)
+			return loc, errors.WithStack(sql.ErrNoRows)
+		}
+		m.Complete(err)
+		return loc, errors.WithStack(err)
+	}
+
+	m.Complete(nil)
+	return loc, nil
+}
+
+func (d *dbRepo) GetLocations(ctx context.Context, txs ...db.Transaction) ([]Location, error) {
+	m := db.StartMetric("GetLocations")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	locations := make([]Location, 0)
+	rows, err := tx.Query(ctx, `SELECT code, name, created FROM locations ORDER BY code;`)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		loc := Location{}
+		if err = rows.Scan(&loc.Code, &loc.Name, &loc.Created); err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		locations = append(locations, loc)
+	}
+
+	m.Complete(nil)
+	return locations, nil
+}
+
+// GetLocationStock returns the stock of the sku held at each location it has been booked at.
+func (d *dbRepo) GetLocationStock(ctx context.Context, sku string, txs ...db.Transaction) ([]LocationStock, error) {
+	m := db.StartMetric("GetLocationStock")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	stock := make([]LocationStock, 0)
+	rows, err := tx.Query(ctx, `SELECT sku, location, available FROM location_stock WHERE sku = $1 ORDER BY location;`,
+		sku)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ls := LocationStock{}
+		if err = rows.Scan(&ls.Sku, &ls.Location, &ls.Available); err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		stock = append(stock, ls)
+	}
+
+	m.Complete(nil)
+	return stock, nil
+}
+
+// AddLocationStock changes the stock of the sku held at the location by qty, which is negative to take stock away.
+func (d *dbRepo) AddLocationStock(ctx context.Context, sku, location string, qty int64, txs ...db.Transaction) error {
+	m := db.StartMetric("AddLocationStock")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `
+		INSERT INTO location_stock (sku, location, available) VALUES ($1, $2, $3)
+		ON CONFLICT (sku, location) DO UPDATE SET available = location_stock.available + EXCLUDED.available;`,
+		sku, location, qty)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+func (d *dbRepo) SaveTransfer(ctx context.Context, transfer *Transfer, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveTransfer")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	err := tx.QueryRow(ctx, `
+		INSERT INTO transfers (request_id, sku, from_location, to_location, quantity, received, discrepancy, status,
+		                       created, updated)
+		     VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;`,
+		transfer.RequestID, transfer.Sku, transfer.From, transfer.To, transfer.Quantity, transfer.Received,
+		transfer.Discrepancy, transfer.Status, transfer.Created, transfer.Updated).Scan(&transfer.ID)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+func (d *dbRepo) UpdateTransfer(ctx context.Context, transfer Transfer, txs ...db.Transaction) error {
+	m := db.StartMetric("UpdateTransfer")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `
+		UPDATE transfers SET received = $2, discrepancy = $3, status = $4, updated = $5 WHERE id = $1;`,
+		transfer.ID, transfer.Received, transfer.Discrepancy, transfer.Status, transfer.Updated)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+const transferColumns = `id, request_id, sku, from_location, to_location, quantity, received, discrepancy, status,
+		       created, updated`
+
+func (d *dbRepo) GetTransfer(ctx context.Context, ID uint64, txs ...db.Transaction) (Transfer, error) {
+	m := db.StartMetric("GetTransfer")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	transfer, err := d.getTransfer(ctx, tx, `SELECT `+transferColumns+` FROM transfers WHERE id = $1;`, ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		m.Complete(err)
+		return transfer, err
+	}
+	m.Complete(nil)
+	return transfer, err
+}
+
+func (d *dbRepo) GetTransferByRequestID(ctx context.Context, requestID string, txs ...db.Transaction) (Transfer, error) {
+	m := db.StartMetric("GetTransferByRequestID")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	transfer, err := d.getTransfer(ctx, tx, `SELECT `+transferColumns+` FROM transfers WHERE request_id = $1;`,
+		requestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		m.Complete(err)
+		return transfer, err
+	}
+	m.Complete(nil)
+	return transfer, err
+}
+
+const transferReceiptColumns = `id, request_id, transfer_id, sku, quantity, closes_transfer, discrepancy, reason,
+		       created`
+
+// getTransfer reads a single transfer and its receipts.
+func (d *dbRepo) getTransfer(ctx context.Context, tx db.Conn, query string, arg interface{}) (Transfer, error) {
+	transfer := Transfer{}
+	err := tx.QueryRow(ctx, query, arg).Scan(&transfer.ID, &transfer.RequestID, &transfer.Sku, &transfer.From,
+		&transfer.To, &transfer.Quantity, &transfer.Received, &transfer.Discrepancy, &transfer.Status,
+		&transfer.Created, &transfer.Updated)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return transfer, errors.WithStack(sql.ErrNoRows)
+		}
+		return transfer, errors.WithStack(err)
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT `+transferReceiptColumns+` FROM transfer_receipts WHERE transfer_id = $1 ORDER BY id;`, transfer.ID)
+	if err != nil {
+		return transfer, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	transfer.Receipts = make([]TransferReceipt, 0)
+	for rows.Next() {
+		r := TransferReceipt{}
+		err = rows.Scan(&r.ID, &r.RequestID, &r.TransferID, &r.Sku, &r.Quantity, &r.Close, &r.Discrepancy,
+			&r.Reason, &r.Created)
+		if err != nil {
+			return transfer, errors.WithStack(err)
+		}
+		transfer.Receipts = append(transfer.Receipts, r)
+	}
+	return transfer, nil
+}
+
+func (d *dbRepo) SaveTransferReceipt(ctx context.Context, receipt *TransferReceipt, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveTransferReceipt")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	err := tx.QueryRow(ctx, `
+		INSERT INTO transfer_receipts (request_id, transfer_id, sku, quantity, closes_transfer, discrepancy, reason,
+		                               created)
+		     VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;`,
+		receipt.RequestID, int64(receipt.TransferID), receipt.Sku, receipt.Quantity, receipt.Close,
+		receipt.Discrepancy, receipt.Reason, receipt.Created).Scan(&receipt.ID)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+func (d *dbRepo) GetTransferReceiptByRequestID(ctx context.Context, requestID string, txs ...db.Transaction) (TransferReceipt, error) {
+	m := db.StartMetric("GetTransferReceiptByRequestID")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	r := TransferReceipt{}
+	err := tx.QueryRow(ctx, `SELECT `+transferReceiptColumns+` FROM transfer_receipts WHERE request_id = $1;`,
+		requestID).
+		Scan(&r.ID, &r.RequestID, &r.TransferID, &r.Sku, &r.Quantity, &r.Close, &r.Discrepancy, &r.Reason,
+			&r.Created)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			m.Complete(nil)
+			return r, errors.WithStack(sql.ErrNoRows)
+		}
+		m.Complete(err)
+		return r, errors.WithStack(err)
+	}
+
+	m.Complete(nil)
+	return r, nil
+}
+
+func (d *dbRepo) SaveCycleCount(ctx context.Context, count *CycleCount, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveCycleCount")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	err := tx.QueryRow(ctx, `
+		INSERT INTO cycle_counts (method, class, location, status, created, updated)
+		     VALUES ($1, nullif($2, ''), nullif($3, ''), $4, $5, $6) RETURNING id;`,
+		count.Method, count.Class, count.Location, count.Status, count.Created, count.Updated).Scan(&count.ID)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+
+	for _, l := range count.Lines {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO cycle_count_lines (count_id, sku, expected, counted, variance, status)
+			     VALUES ($1, $2, $3, $4, $5, $6);`,
+			count.ID, l.Sku, l.Expected, l.Counted, l.Variance, l.Status)
+		if err != nil {
+			m.Complete(err)
+			return errors.WithStack(err)
+		}
+	}
+
+	m.Complete(nil)
+	return nil
+}
+
+func (d *dbRepo) UpdateCycleCount(ctx context.Context, count CycleCount, txs ...db.Transaction) error {
+	m := db.StartMetric("UpdateCycleCount")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `UPDATE cycle_counts SET status = $2, updated = $3 WHERE id = $1;`,
+		count.ID, count.Status, count.Updated)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+
+	for _, l := range count.Lines {
+		_, err = tx.Exec(ctx, `
+			UPDATE cycle_count_lines SET counted = $3, variance = $4, status = $5
+			 WHERE count_id = $1 AND sku = $2;`,
+			count.ID, l.Sku, l.Counted, l.Variance, l.Status)
+		if err != nil {
+			m.Complete(err)
+			return errors.WithStack(err)
+		}
+	}
+
+	m.Complete(nil)
+	return nil
+}
+
+const cycleCountColumns = `id, method, coalesce(class, ''), coalesce(location, ''), status, created, updated`
+
+func (d *dbRepo) GetCycleCount(ctx context.Context, ID uint64, txs ...db.Transaction) (CycleCount, error) {
+	m := db.StartMetric("GetCycleCount")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	c := CycleCount{}
+	err := tx.QueryRow(ctx, `SELECT `+cycleCountColumns+` FROM cycle_counts WHERE id = $1;`, ID).
+		Scan(&c.ID, &c.Method, &c.Class, &c.Location, &c.Status, &c.Created, &c.Updated)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			m.Complete(nil)
+			return c, errors.WithStack(sql.ErrNoRows)
+		}
+		m.Complete(err)
+		return c, errors.WithStack(err)
+	}
+
+	if c.Lines, err = d.getCountLines(ctx, tx, c.ID); err != nil {
+		m.Complete(err)
+		return c, err
+	}
+
+	m.Complete(nil)
+	return c, nil
+}
+
+// GetCycleCounts returns a page of cycle counts, newest first.
+func (d *dbRepo) GetCycleCounts(ctx context.Context, limit, offset int, txs ...db.Transaction) ([]CycleCount, error) {
+	m := db.StartMetric("GetCycleCounts")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT `+cycleCountColumns+` FROM cycle_counts
+	  ORDER BY id DESC LIMIT $1 OFFSET $2;`,
+		limit, offset)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+
+	counts := make([]CycleCount, 0)
+	for rows.Next() {
+		c := CycleCount{}
+		if err = rows.Scan(&c.ID, &c.Method, &c.Class, &c.Location, &c.Status, &c.Created, &c.Updated); err != nil {
+			rows.Close()
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		counts = append(counts, c)
+	}
+	rows.Close()
+
+	for i := range counts {
+		if counts[i].Lines, err = d.getCountLines(ctx, tx, counts[i].ID); err != nil {
+			m.Complete(err)
+			return nil, err
+		}
+	}
+
+	m.Complete(nil)
+	return counts, nil
+}
+
+func (d *dbRepo) getCountLines(ctx context.Context, tx db.Conn, countID uint64) ([]CountLine, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT sku, expected, counted, variance, status FROM cycle_count_lines
+		 WHERE count_id = $1
+	  ORDER BY sku;`,
+		countID)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	lines := make([]CountLine, 0)
+	for rows.Next() {
+		l := CountLine{}
+		if err = rows.Scan(&l.Sku, &l.Expected, &l.Counted, &l.Variance, &l.Status); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		lines = append(lines, l)
+	}
+	return lines, nil
+}
+
+func (d *dbRepo) SaveReturn(ctx context.Context, rma *Return, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveReturn")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	err := tx.QueryRow(ctx, `
+		INSERT INTO returns (request_id, reservation_id, requester, sku, quantity, received, reason, status, created,
+		                     updated)
+		     VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id;`,
+		rma.RequestID, int64(rma.ReservationID), rma.Requester, rma.Sku, rma.Quantity, rma.Received, rma.Reason,
+		rma.Status, rma.Created, rma.Updated).Scan(&rma.ID)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+func (d *dbRepo) UpdateReturn(ctx context.Context, rma Return, txs ...db.Transaction) error {
+	m := db.StartMetric("UpdateReturn")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `UPDATE returns SET received = $2, status = $3, updated = $4 WHERE id = $1;`,
+		rma.ID, rma.Received, rma.Status, rma.Updated)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+const returnColumns = `id, request_id, reservation_id, requester, sku, quantity, received, reason, status, created,
+		       updated`
+
+func (d *dbRepo) GetReturn(ctx context.Context, ID uint64, txs ...db.Transaction) (Return, error) {
+	m := db.StartMetric("GetReturn")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	rma, err := d.getReturn(ctx, tx, `SELECT `+returnColumns+` FROM returns WHERE id = $1;`, ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		m.Complete(err)
+		return rma, err
+	}
+	m.Complete(nil)
+	return rma, err
+}
+
+func (d *dbRepo) GetReturnByRequestID(ctx context.Context, requestID string, txs ...db.Transaction) (Return, error) {
+	m := db.StartMetric("GetReturnByRequestID")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	rma, err := d.getReturn(ctx, tx, `SELECT `+returnColumns+` FROM returns WHERE request_id = $1;`, requestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		m.Complete(err)
+		return rma, err
+	}
+	m.Complete(nil)
+	return rma, err
+}
+
+// getReturn reads a single return and its receipts.
+func (d *dbRepo) getReturn(ctx context.Context, tx db.Conn, query string, arg interface{}) (Return, error) {
+	rma := Return{}
+	err := tx.QueryRow(ctx, query, arg).Scan(&rma.ID, &rma.RequestID, &rma.ReservationID, &rma.Requester, &rma.Sku,
+		&rma.Quantity, &rma.Received, &rma.Reason, &rma.Status, &rma.Created, &rma.Updated)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return rma, errors.WithStack(sql.ErrNoRows)
+		}
+		return rma, errors.WithStack(err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, request_id, return_id, sku, quantity, disposition, created FROM return_receipts
+		 WHERE return_id = $1
+	  ORDER BY id;`,
+		rma.ID)
+	if err != nil {
+		return rma, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	rma.Receipts = make([]ReturnReceipt, 0)
+	for rows.Next() {
+		r := ReturnReceipt{}
+		if err = rows.Scan(&r.ID, &r.RequestID, &r.ReturnID, &r.Sku, &r.Quantity, &r.Disposition, &r.Created); err != nil {
+			return rma, errors.WithStack(err)
+		}
+		rma.Receipts = append(rma.Receipts, r)
+	}
+	return rma, nil
+}
+
+// GetReturnedQuantity returns the quantity authorized for return across every return against the reservation.
+func (d *dbRepo) GetReturnedQuantity(ctx context.Context, reservationID uint64, txs ...db.Transaction) (int64, error) {
+	m := db.StartMetric("GetReturnedQuantity")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	var quantity int64
+	err := tx.QueryRow(ctx, `SELECT coalesce(sum(quantity), 0) FROM returns WHERE reservation_id = $1;`,
+		int64(reservationID)).Scan(&quantity)
+	if err != nil {
+		m.Complete(err)
+		return 0, errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return quantity, nil
+}
+
+func (d *dbRepo) SaveReturnReceipt(ctx context.Context, receipt *ReturnReceipt, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveReturnReceipt")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	err := tx.QueryRow(ctx, `
+		INSERT INTO return_receipts (request_id, return_id, sku, quantity, disposition, created)
+		     VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`,
+		receipt.RequestID, int64(receipt.ReturnID), receipt.Sku, receipt.Quantity, receipt.Disposition,
+		receipt.Created).Scan(&receipt.ID)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+	m.Complete(nil)
+	return nil
+}
+
+func (d *dbRepo) GetReturnReceiptByRequestID(ctx context.Context, requestID string, txs ...db.Transaction) (ReturnReceipt, error) {
+	m := db.StartMetric("GetReturnReceiptByRequestID")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	r := ReturnReceipt{}
+	err := tx.QueryRow(ctx, `
+		SELECT id, request_id, return_id, sku, quantity, disposition, created
+		  FROM return_receipts WHERE request_id = $1;`, requestID).
+		Scan(&r.ID, &r.RequestID, &r.ReturnID, &r.Sku, &r.Quantity, &r.Disposition, &r.Created)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			m.Complete(nil)
+			return r, errors.WithStack(sql.ErrNoRows)
+		}
+		m.Complete(err)
+		return r, errors.WithStack(err)
+	}
+
+	m.Complete(nil)
+	return r, nil
+}
+
+func (d *dbRepo) SaveSalesOrder(ctx context.Context, order *SalesOrder, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveSalesOrder")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	err := tx.QueryRow(ctx, `
+		INSERT INTO sales_orders (request_id, requester, policy, status, created, updated)
+		     VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`,
+		order.RequestID, order.Requester, order.Policy, order.Status, order.Created, order.Updated).Scan(&order.ID)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+
+	for _, l := range order.Lines {
+		_, err = tx.Exec(ctx, `
+			INSERT INTO sales_order_lines (order_id, sku, quantity, reserved, reservation_id)
+			     VALUES ($1, $2, $3, $4, nullif($5, 0));`,
+			order.ID, l.Sku, l.Quantity, l.Reserved, int64(l.ReservationID))
+		if err != nil {
+			m.Complete(err)
+			return errors.WithStack(err)
+		}
+	}
+
+	m.Complete(nil)
+	return nil
+}
+
+func (d *dbRepo) UpdateSalesOrder(ctx context.Context, order SalesOrder, txs ...db.Transaction) error {
+	m := db.StartMetric("UpdateSalesOrder")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `UPDATE sales_orders SET status = $2, updated = $3 WHERE id = $1;`,
+		order.ID, order.Status, order.Updated)
+	if err != nil {
+		m.Complete(err)
+		return errors.WithStack(err)
+	}
+
+	for _, l := range order.Lines {
+		_, err = tx.Exec(ctx, `
+			UPDATE sales_order_lines SET reserved = $3, reservation_id = nullif($4, 0)
+			 WHERE order_id = $1 AND sku = $2;`,
+			order.ID, l.Sku, l.Reserved, int64(l.ReservationID))
+		if err != nil {
+			m.Complete(err)
+			return errors.WithStack(err)
+		}
+	}
+
+	m.Complete(nil)
+	return nil
+}
+
+const salesOrderColumns = `id, request_id, requester, policy, status, created, updated`
+
+func (d *dbRepo) GetSalesOrder(ctx context.Context, ID uint64, txs ...db.Transaction) (SalesOrder, error) {
+	m := db.StartMetric("GetSalesOrder")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	order, err := d.getSalesOrder(ctx, tx, `SELECT `+salesOrderColumns+` FROM sales_orders WHERE id = $1;`, ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		m.Complete(err)
+		return order, err
+	}
+	m.Complete(nil)
+	return order, err
+}
+
+func (d *dbRepo) GetSalesOrderByRequestID(ctx context.Context, requestID string, txs ...db.Transaction) (SalesOrder, error) {
+	m := db.StartMetric("GetSalesOrderByRequestID")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	order, err := d.getSalesOrder(ctx, tx, `SELECT `+salesOrderColumns+` FROM sales_orders WHERE request_id = $1;`,
+		requestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		m.Complete(err)
+		return order, err
+	}
+	m.Complete(nil)
+	return order, err
+}
+
+// GetActiveSalesOrders returns the sales orders with a line for the sku that aren't fully allocated yet, oldest first.
+func (d *dbRepo) GetActiveSalesOrders(ctx context.Context, sku string, txs ...db.Transaction) ([]SalesOrder, error) {
+	m := db.StartMetric("GetActiveSalesOrders")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT o.id FROM sales_orders o
+		  JOIN sales_order_lines l ON l.order_id = o.id
+		 WHERE l.sku = $1 AND o.status <> $2
+	  ORDER BY o.id;`,
+		sku, SalesOrderAllocated)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	ids := make([]uint64, 0)
+	for rows.Next() {
+		var id uint64
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	orders := make([]SalesOrder, 0, len(ids))
+	for _, id := range ids {
+		order, err := d.getSalesOrder(ctx, tx, `SELECT `+salesOrderColumns+` FROM sales_orders WHERE id = $1;`, id)
+		if err != nil {
+			m.Complete(err)
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+
+	m.Complete(nil)
+	return orders, nil
+}
+
+// getSalesOrder reads a single sales order and its lines.
+func (d *dbRepo) getSalesOrder(ctx context.Context, tx db.Conn, query string, arg interface{}) (SalesOrder, error) {
+	order := SalesOrder{}
+	err := tx.QueryRow(ctx, query, arg).Scan(&order.ID, &order.RequestID, &order.Requester, &order.Policy,
+		&order.Status, &order.Created, &order.Updated)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return order, errors.WithStack(sql.ErrNoRows)
+		}
+		return order, errors.WithStack(err)
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT sku, quantity, reserved, coalesce(reservation_id, 0) FROM sales_order_lines
+		 WHERE order_id = $1
+	  ORDER BY sku;`,
+		order.ID)
+	if err != nil {
+		return order, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	order.Lines = make([]SalesOrderLine, 0)
+	for rows.Next() {
+		l := SalesOrderLine{}
+		if err = rows.Scan(&l.Sku, &l.Quantity, &l.Reserved, &l.ReservationID); err != nil {
+			return order, errors.WithStack(err)
+		}
+		order.Lines = append(order.Lines, l)
+	}
+	return order, nil
+}
+
+func (d *dbRepo) BeginTransaction(ctx context.Context) (db.Transaction, error) {
+	m := db.StartMetric("BeginTransaction")
+	ctx = m.StartSpan(ctx)
+	tx, err := d.conn.Begin(ctx)
+	m.Complete(err)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,388 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+// ReturnStatus is how much of a return has been received.
+type ReturnStatus string
+
+const (
+	ReturnAuthorized        ReturnStatus = "Authorized"
+	ReturnPartiallyReceived ReturnStatus = "PartiallyReceived"
+	ReturnReceived          ReturnStatus = "Received"
+)
+
+// Disposition is what is done with returned goods once received.
+type Disposition string
+
+const (
+	DispositionRestock   Disposition = "restock"
+	DispositionRefurbish Disposition = "refurbish"
+	DispositionScrap     Disposition = "scrap"
+)
+
+// Return is an entity. A return merchandise authorization (RMA) for a quantity of a product shipped on a reservation
+// to come back from its requester. Goods are received against it in one or more receipts.
+type Return struct {
+	ID            uint64          `json:"id"`
+	RequestID     string          `json:"requestId"`
+	ReservationID uint64          `json:"reservationId"`
+	Requester     string          `json:"requester"`
+	Sku           string          `json:"sku"`
+	Quantity      int64           `json:"quantity"`
+	Received      int64           `json:"received"`
+	Reason        string          `json:"reason"`
+	Status        ReturnStatus    `json:"status"`
+	Receipts      []ReturnReceipt `json:"receipts"`
+	Created       time.Time       `json:"created"`
+	Updated       time.Time       `json:"updated"`
+}
+
+// ReturnReceipt is an entity. A quantity of goods received back on a return and what was done with them. Restocked
+// goods are available again, refurbished goods are quarantined until they pass QC and scrapped goods are written off.
+// Receiving units of a serialized product lists their serial numbers.
+type ReturnReceipt struct {
+	ID          uint64      `json:"id"`
+	RequestID   string      `json:"requestId"`
+	ReturnID    uint64      `json:"returnId"`
+	Sku         string      `json:"sku"`
+	Quantity    int64       `json:"quantity"`
+	Disposition Disposition `json:"disposition"`
+	Serials     []string    `json:"serials,omitempty"`
+	Created     time.Time   `json:"created"`
+}
+
+// ReturnEvent is published when a return is authorized and each time goods are received on it.
+type ReturnEvent struct {
+	Return
+	Receipt *ReturnReceipt `json:"receipt,omitempty"`
+}
+
+// bucket is where received goods are put, nil when they are written off.
+func (d Disposition) bucket() *Bucket {
+	var b Bucket
+	switch d {
+	case DispositionRestock:
+		b = BucketAvailable
+	case DispositionRefurbish:
+		b = BucketQuarantine
+	default:
+		return nil
+	}
+	return &b
+}
+
+func (d Disposition) serialStatus() SerialStatus {
+	if b := d.bucket(); b != nil {
+		return b.serialStatus()
+	}
+	return SerialRemoved
+}
+
+func validateDisposition(d Disposition) error {
+	switch d {
+	case DispositionRestock, DispositionRefurbish, DispositionScrap:
+		return nil
+	}
+	return validation("disposition %q must be %s, %s or %s", d, DispositionRestock, DispositionRefurbish,
+		DispositionScrap)
+}
+
+func (r Return) status() ReturnStatus {
+	switch {
+	case r.Received == 0:
+		return ReturnAuthorized
+	case r.Received < r.Quantity:
+		return ReturnPartiallyReceived
+	}
+	return ReturnReceived
+}
+
+// CreateReturn authorizes the return of a quantity of a product shipped on a reservation. The quantities of every
+// return against a reservation can't add up to more than it shipped.
+func (s *service) CreateReturn(ctx context.Context, product Product, rma *Return) error {
+	const funcName = "CreateReturn"
+
+	if rma.RequestID == "" {
+		return validation("request id is required")
+	}
+	if rma.ReservationID == 0 {
+		return validation("reservation id is required")
+	}
+	if rma.Quantity < 1 {
+		return validation("quantity must be greater than zero")
+	}
+	if product.Kit {
+		return kitNotStocked(product)
+	}
+
+	rma.Sku = product.Sku
+	hash, err := requestHash(returnPayload{Sku: rma.Sku, ReservationID: rma.ReservationID, Quantity: rma.Quantity,
+		Reason: rma.Reason})
+	if err != nil {
+		return err
+	}
+	if err = s.checkIdempotency(ctx, OpCreateReturn, rma.RequestID, hash); err != nil {
+		return err
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", rma.RequestID).Msg("getting return")
+	dbReturn, err := s.repo.GetReturnByRequestID(ctx, rma.RequestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	if dbReturn.RequestID != "" {
+		log.Debug().Str("func", funcName).Str("requestId", rma.RequestID).Msg("return already exists, returning it")
+		return copier.Copy(rma, &dbReturn)
+	}
+
+	res, err := s.GetReservation(ctx, rma.ReservationID)
+	if err != nil {
+		return err
+	}
+	if res.Sku != product.Sku {
+		return notFound(CodeReservationNotFound, nil, "reservation %d not found for sku %s", res.ID, product.Sku)
+	}
+	shipped := res.ShippedQuantity
+	if res.State == Closed {
+		shipped = res.RequestedQuantity
+	}
+	if shipped == 0 {
+		return conflict(CodeInvalidTransition, "reservation %d is %s and hasn't shipped anything to return", res.ID,
+			res.State)
+	}
+	returned, err := s.repo.GetReturnedQuantity(ctx, res.ID)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if returned+rma.Quantity > shipped {
+		return conflict(CodeReturnExceeded, "%d of the %d shipped on reservation %d are already being returned",
+			returned, shipped, res.ID)
+	}
+
+	rma.Requester = res.Requester
+	rma.Received = 0
+	rma.Status = ReturnAuthorized
+	rma.Receipts = make([]ReturnReceipt, 0)
+	rma.Created = time.Now()
+	rma.Updated = rma.Created
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", rma.RequestID).Msg("persisting return")
+	if err = s.repo.SaveReturn(ctx, rma, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save return")
+	}
+
+	if err = s.saveIdempotencyKey(ctx, OpCreateReturn, rma.RequestID, hash, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.CreateReturn, product.Sku, nil, rma, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.publishReturn(ctx, ReturnEvent{Return: *rma}); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "failed to commit return transaction")
+	}
+	return nil
+}
+
+// GetReturn returns a return with its receipts.
+func (s *service) GetReturn(ctx context.Context, ID uint64) (Return, error) {
+	rma, err := s.repo.GetReturn(ctx, ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rma, notFound(CodeReturnNotFound, err, "return %d not found", ID)
+		}
+		return rma, errors.WithStack(err)
+	}
+	return rma, nil
+}
+
+// ReceiveReturn takes goods back on a return and puts them where their disposition says. Restocked goods are used to
+// fill open reservations.
+func (s *service) ReceiveReturn(ctx context.Context, product Product, rma *Return, receipt *ReturnReceipt) error {
+	const funcName = "ReceiveReturn"
+
+	if receipt.RequestID == "" {
+		return validation("request id is required")
+	}
+	if receipt.Quantity < 1 {
+		return validation("quantity must be greater than zero")
+	}
+	if err := validateDisposition(receipt.Disposition); err != nil {
+		return err
+	}
+	if rma.Sku != product.Sku {
+		return validation("return %d is for %s, not %s", rma.ID, rma.Sku, product.Sku)
+	}
+	if err := validateSerials(product, receipt.Serials, receipt.Quantity); err != nil {
+		return err
+	}
+
+	receipt.ReturnID = rma.ID
+	receipt.Sku = product.Sku
+	hash, err := requestHash(receiptPayload{ReturnID: receipt.ReturnID, Sku: receipt.Sku, Quantity: receipt.Quantity,
+		Disposition: receipt.Disposition, Serials: receipt.Serials})
+	if err != nil {
+		return err
+	}
+	if err = s.checkIdempotency(ctx, OpReceiveReturn, receipt.RequestID, hash); err != nil {
+		return err
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", receipt.RequestID).Msg("getting return receipt")
+	dbReceipt, err := s.repo.GetReturnReceiptByRequestID(ctx, receipt.RequestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	if dbReceipt.RequestID != "" {
+		log.Debug().Str("func", funcName).Str("requestId", receipt.RequestID).Msg("return receipt already exists, returning it")
+		return copier.Copy(receipt, &dbReceipt)
+	}
+
+	if rma.Received+receipt.Quantity > rma.Quantity {
+		return conflict(CodeReturnExceeded, "%d of the %d authorized on return %d have already been received",
+			rma.Received, rma.Quantity, rma.ID)
+	}
+
+	before := product
+	if b := receipt.Disposition.bucket(); b != nil {
+		*product.bucket(*b) += receipt.Quantity
+	}
+	receipt.Created = time.Now()
+	rma.Received += receipt.Quantity
+	rma.Status = rma.status()
+	rma.Updated = receipt.Created
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", receipt.RequestID).Msg("persisting return receipt")
+	if err = s.repo.SaveReturnReceipt(ctx, receipt, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save return receipt")
+	}
+
+	if err = s.saveIdempotencyKey(ctx, OpReceiveReturn, receipt.RequestID, hash, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.repo.UpdateReturn(ctx, *rma, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to update return")
+	}
+
+	if b := receipt.Disposition.bucket(); b != nil && *b == BucketAvailable {
+		if err = s.bookStock(ctx, product.Sku, DefaultLocation, receipt.Quantity, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if product.Serialized {
+		if err = s.returnSerials(ctx, rma, receipt, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if err = s.saveProduct(ctx, &product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to apply return to product")
+	}
+
+	if err = s.evaluateStock(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.ReceiveReturn, product.Sku, before, product, receipt, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.publishInventory(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to publish inventory")
+	}
+
+	rma.Receipts = append(rma.Receipts, *receipt)
+	if err = s.publishReturn(ctx, ReturnEvent{Return: *rma, Receipt: receipt}); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "failed to commit return receipt transaction")
+	}
+	observeStock(product)
+
+	if receipt.Disposition == DispositionRestock {
+		log.Debug().Str("func", funcName).Str("requestId", receipt.RequestID).Msg("filling reserves")
+		if _, err = s.fillReserves(ctx, product); err != nil {
+			return errors.WithMessage(err, "failed to fill reserves after return")
+		}
+	}
+
+	return nil
+}
+
+// returnSerials brings back the units of a serialized product that were shipped on the reservation of the return.
+func (s *service) returnSerials(ctx context.Context, rma *Return, receipt *ReturnReceipt, tx db.Transaction) error {
+	event := SerialEvent{Type: SerialEventReturned, RequestID: receipt.RequestID, ReservationID: rma.ReservationID,
+		Requester: rma.Requester, Created: receipt.Created}
+	for _, serial := range receipt.Serials {
+		unit, err := s.repo.GetSerial(ctx, serial, tx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return notFound(CodeSerialNotFound, err, "serial %s not found", serial)
+			}
+			return errors.WithStack(err)
+		}
+		if unit.Sku != rma.Sku || unit.Status != SerialShipped || unit.ReservationID != rma.ReservationID {
+			return conflict(CodeSerialNotAvailable, "serial %s was not shipped on reservation %d", serial,
+				rma.ReservationID)
+		}
+		if err = s.moveSerial(ctx, unit, receipt.Disposition.serialStatus(), 0, event, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) publishReturn(ctx context.Context, event ReturnEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.WithMessage(err, "failed to serialize return")
+	}
+	if err = s.bq.Publish(ctx, s.exchanges.Return, body); err != nil {
+		return errors.WithMessage(err, "failed to publish return")
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/audit"
+)
+
+// SalesOrderPolicy is how the lines of a sales order are allocated.
+type SalesOrderPolicy string
+
+const (
+	AllOrNothing SalesOrderPolicy = "all-or-nothing"
+	ShipPartial  SalesOrderPolicy = "ship-partial"
+)
+
+// SalesOrderStatus is how much of a sales order has been allocated.
+type SalesOrderStatus string
+
+const (
+	SalesOrderPending            SalesOrderStatus = "Pending"
+	SalesOrderPartiallyAllocated SalesOrderStatus = "PartiallyAllocated"
+	SalesOrderAllocated          SalesOrderStatus = "Allocated"
+)
+
+// SalesOrder is an entity. A customer order for several products at once, each line reserved for the requester. An
+// all-or-nothing order holds no stock until every line can be filled and then reserves them all in one transaction. A
+// ship-partial order reserves each line on its own as stock allows.
+type SalesOrder struct {
+	ID        uint64           `json:"id"`
+	RequestID string           `json:"requestId"`
+	Requester string           `json:"requester"`
+	Policy    SalesOrderPolicy `json:"policy"`
+	Status    SalesOrderStatus `json:"status"`
+	Lines     []SalesOrderLine `json:"lines"`
+	Created   time.Time        `json:"created"`
+	Updated   time.Time        `json:"updated"`
+}
+
+// SalesOrderLine is a value object. The quantity of one product on a sales order and the reservation made for it.
+type SalesOrderLine struct {
+	Sku           string `json:"sku"`
+	Quantity      int64  `json:"quantity"`
+	Reserved      int64  `json:"reserved"`
+	ReservationID uint64 `json:"reservationId,omitempty"`
+}
+
+// CreateSalesOrder takes a sales order and allocates as much of it as its policy allows.
+func (s *service) CreateSalesOrder(ctx context.Context, order *SalesOrder) error {
+	const funcName = "CreateSalesOrder"
+
+	if order.RequestID == "" {
+		return validation("request id is required")
+	}
+	if order.Requester == "" {
+		return validation("requester is required")
+	}
+	if order.Policy != AllOrNothing && order.Policy != ShipPartial {
+		return validation("policy must be %s or %s", AllOrNothing, ShipPartial)
+	}
+	if len(order.Lines) == 0 {
+		return validation("a sales order needs at least one line")
+	}
+	payload := salesOrderPayload{Requester: order.Requester, Policy: order.Policy}
+	seen := map[string]bool{}
+	for _, line := range order.Lines {
+		if line.Sku == "" {
+			return validation("sku of every line is required")
+		}
+		if seen[line.Sku] {
+			return validation("%s is on the order more than once", line.Sku)
+		}
+		if line.Quantity < 1 {
+			return validation("quantity of %s must be greater than zero", line.Sku)
+		}
+		seen[line.Sku] = true
+		payload.Lines = append(payload.Lines, salesOrderLinePayload{Sku: line.Sku, Quantity: line.Quantity})
+	}
+
+	hash, err := requestHash(payload)
+	if err != nil {
+		return err
+	}
+	if err = s.checkIdempotency(ctx, OpCreateSalesOrder, order.RequestID, hash); err != nil {
+		return err
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", order.RequestID).Msg("getting sales order")
+	dbOrder, err := s.repo.GetSalesOrderByRequestID(ctx, order.RequestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	if dbOrder.RequestID != "" {
+		log.Debug().Str("func", funcName).Str("requestId", order.RequestID).Msg("sales order already exists, returning it")
+		return copier.Copy(order, &dbOrder)
+	}
+
+	products := make([]Product, len(order.Lines))
+	for i, line := range order.Lines {
+		if products[i], err = s.GetProduct(ctx, line.Sku); err != nil {
+			return err
+		}
+		if products[i].Kit {
+			return validation("%s is a kit, kits are reserved on their own", line.Sku)
+		}
+		order.Lines[i].Reserved, order.Lines[i].ReservationID = 0, 0
+	}
+	order.Status = SalesOrderPending
+	order.Created = time.Now()
+	order.Updated = order.Created
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// the lines of a ship-partial order are reserved along with it, so that it is never saved waiting on stock it
+	// already holds and no line is left reserved for an order that wasn't saved
+	var created []Reservation
+	var fills [][]fill
+	var filled []Product
+	if order.Policy == ShipPartial {
+		reservations := make([]Reservation, len(order.Lines))
+		for i := range order.Lines {
+			line, res := &order.Lines[i], &reservations[i]
+			product, err := s.repo.GetProduct(ctx, line.Sku, tx)
+			if err != nil {
+				rollback(ctx, tx, err)
+				return errors.WithMessagef(err, "failed to get product %s", line.Sku)
+			}
+			before := product
+			*res = Reservation{
+				RequestID:         fmt.Sprintf("sales-order/%s/%s", order.RequestID, line.Sku),
+				Requester:         order.Requester,
+				Sku:               line.Sku,
+				State:             Open,
+				RequestedQuantity: line.Quantity,
+				Created:           order.Created,
+			}
+			log.Debug().Str("func", funcName).Str("requestId", order.RequestID).Str("sku", line.Sku).Msg("reserving line")
+			c, f, err := s.reserve(ctx, &product, res, tx)
+			if err != nil {
+				rollback(ctx, tx, err)
+				return errors.WithMessagef(err, "failed to reserve %s", line.Sku)
+			}
+			if err = s.record(ctx, audit.Reserve, product.Sku, before, product, res, tx); err != nil {
+				rollback(ctx, tx, err)
+				return err
+			}
+			line.Reserved, line.ReservationID = res.ReservedQuantity, res.ID
+			created, fills, filled = append(created, c), append(fills, f), append(filled, product)
+		}
+		order.Status = allocationStatus(len(order.Lines), reservations)
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", order.RequestID).Msg("persisting sales order")
+	if err = s.repo.SaveSalesOrder(ctx, order, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save sales order")
+	}
+
+	if err = s.saveIdempotencyKey(ctx, OpCreateSalesOrder, order.RequestID, hash, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.CreateSalesOrder, "", nil, order, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if order.Status == SalesOrderAllocated {
+		if err = s.publishSalesOrder(ctx, *order); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "failed to commit sales order transaction")
+	}
+	for i := range created {
+		observeReservation(eventCreated, created[i])
+		observeFills(fills[i], filled[i])
+	}
+
+	if order.Policy == AllOrNothing {
+		if _, err = s.allocateSalesOrder(ctx, order); err != nil && !errors.Is(err, ErrConflict) {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSalesOrder returns a sales order with its lines.
+func (s *service) GetSalesOrder(ctx context.Context, ID uint64) (SalesOrder, error) {
+	order, err := s.repo.GetSalesOrder(ctx, ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return order, notFound(CodeSalesOrderNotFound, err, "sales order %d not found", ID)
+		}
+		return order, errors.WithStack(err)
+	}
+	return order, nil
+}
+
+// allocateSalesOrder reserves every line of a pending all-or-nothing order in one transaction, provided there is
+// enough available stock of every product on it. It returns whether the order was allocated. Stock taken by something
+// else in the meantime fails the transaction as a conflict and leaves the order pending.
+func (s *service) allocateSalesOrder(ctx context.Context, order *SalesOrder) (bool, error) {
+	const funcName = "allocateSalesOrder"
+
+	products := make([]Product, len(order.Lines))
+	for i, line := range order.Lines {
+		p, err := s.repo.GetProduct(ctx, line.Sku)
+		if err != nil {
+			return false, errors.WithMessagef(err, "failed to get product %s", line.Sku)
+		}
+		available, err := s.stockAt(ctx, line.Sku, DefaultLocation)
+		if err != nil {
+			return false, err
+		}
+		if p.Available < line.Quantity || available < line.Quantity {
+			log.Debug().Str("func", funcName).Uint64("salesOrder", order.ID).Str("sku", line.Sku).Msg("not enough stock")
+			return false, nil
+		}
+		products[i] = p
+	}
+	before := append([]Product(nil), products...)
+	reservations := make([]Reservation, len(order.Lines))
+	now := time.Now()
+
+	// the order is only changed once the allocation is committed
+	allocated := *order
+	allocated.Lines = append([]SalesOrderLine(nil), order.Lines...)
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	for i := range allocated.Lines {
+		line, product, res := &allocated.Lines[i], &products[i], &reservations[i]
+		*res = Reservation{
+			RequestID:         fmt.Sprintf("sales-order/%d/%s", order.ID, line.Sku),
+			Requester:         order.Requester,
+			Sku:               line.Sku,
+			State:             Open,
+			RequestedQuantity: line.Quantity,
+			Created:           now,
+		}
+		log.Debug().Str("func", funcName).Uint64("salesOrder", order.ID).Str("sku", line.Sku).Msg("reserving line")
+		if err = s.repo.SaveReservation(ctx, res, tx); err != nil {
+			rollback(ctx, tx, err)
+			return false, errors.WithStack(err)
+		}
+
+		if err = s.bookStock(ctx, line.Sku, DefaultLocation, -line.Quantity, tx); err != nil {
+			rollback(ctx, tx, err)
+			if errors.Is(err, ErrInsufficientStock) {
+				return false, conflict(CodeConcurrentModification, "stock of %s was taken while allocating sales order %d",
+					line.Sku, order.ID)
+			}
+			return false, err
+		}
+		product.Available -= line.Quantity
+		product.Reserved += line.Quantity
+		res.ReservedQuantity = line.Quantity
+		if product.Serialized {
+			if err = s.allocateSerials(ctx, res, line.Quantity, tx); err != nil {
+				rollback(ctx, tx, err)
+				return false, err
+			}
+		}
+		if err = s.closeReservation(product, res); err != nil {
+			rollback(ctx, tx, err)
+			return false, errors.WithStack(err)
+		}
+		if product.Serialized {
+			if err = s.shipSerials(ctx, res, tx); err != nil {
+				rollback(ctx, tx, err)
+				return false, err
+			}
+		}
+		if err = s.repo.UpdateReservation(ctx, res.ID, res.State, res.ReservedQuantity, tx); err != nil {
+			rollback(ctx, tx, err)
+			return false, errors.WithStack(err)
+		}
+
+		if err = s.saveProduct(ctx, product, tx); err != nil {
+			rollback(ctx, tx, err)
+			return false, err
+		}
+		if err = s.evaluateStock(ctx, *product, tx); err != nil {
+			rollback(ctx, tx, err)
+			return false, err
+		}
+		if err = s.publishInventory(ctx, *product, tx); err != nil {
+			rollback(ctx, tx, err)
+			return false, errors.WithMessage(err, "failed to publish inventory")
+		}
+		if err = s.publishReservation(ctx, *res); err != nil {
+			rollback(ctx, tx, err)
+			return false, err
+		}
+		line.Reserved, line.ReservationID = res.ReservedQuantity, res.ID
+	}
+
+	allocated.Status = SalesOrderAllocated
+	allocated.Updated = now
+	if err = s.repo.UpdateSalesOrder(ctx, allocated, tx); err != nil {
+		rollback(ctx, tx, err)
+		return false, errors.WithMessage(err, "failed to update sales order")
+	}
+
+	if err = s.record(ctx, audit.AllocateSalesOrder, "", before, products, allocated, tx); err != nil {
+		rollback(ctx, tx, err)
+		return false, err
+	}
+
+	if err = s.publishSalesOrder(ctx, allocated); err != nil {
+		rollback(ctx, tx, err)
+		return false, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return false, errors.WithMessage(err, "failed to commit sales order allocation")
+	}
+	*order = allocated
+	for i := range reservations {
+		observeReservation(eventCreated, reservations[i])
+		observeReservation(eventClosed, reservations[i])
+		observeStock(products[i])
+	}
+	return true, nil
+}
+
+// refreshLines brings the lines and status of a ship-partial order in line with their reservations.
+func (s *service) refreshLines(ctx context.Context, order *SalesOrder) error {
+	reservations := make([]Reservation, 0, len(order.Lines))
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		if line.ReservationID == 0 {
+			continue
+		}
+		res, err := s.repo.GetReservation(ctx, line.ReservationID)
+		if err != nil {
+			return errors.WithMessagef(err, "failed to get reservation %d", line.ReservationID)
+		}
+		line.Reserved = res.ReservedQuantity
+		reservations = append(reservations, res)
+	}
+	order.Status = allocationStatus(len(order.Lines), reservations)
+	return nil
+}
+
+// allocationStatus is the status of a ship-partial order with the given number of lines and the reservations made for
+// them.
+func allocationStatus(lines int, reservations []Reservation) SalesOrderStatus {
+	allocated, closed := false, 0
+	for _, res := range reservations {
+		if res.ReservedQuantity > 0 {
+			allocated = true
+		}
+		if res.State == Closed {
+			closed++
+		}
+	}
+
+	switch {
+	case closed == lines:
+		return SalesOrderAllocated
+	case allocated:
+		return SalesOrderPartiallyAllocated
+	default:
+		return SalesOrderPending
+	}
+}
+
+// updateSalesOrder saves a ship-partial order whose reservations have been filled, publishing it once it is
+// allocated.
+func (s *service) updateSalesOrder(ctx context.Context, order *SalesOrder) error {
+	before := *order
+	before.Lines = append([]SalesOrderLine(nil), order.Lines...)
+	if err := s.refreshLines(ctx, order); err != nil {
+		return err
+	}
+	changed := order.Status != before.Status
+	for i := range order.Lines {
+		changed = changed || order.Lines[i].Reserved != before.Lines[i].Reserved
+	}
+	if !changed {
+		return nil
+	}
+	order.Updated = time.Now()
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = s.repo.UpdateSalesOrder(ctx, *order, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to update sales order")
+	}
+
+	if order.Status == SalesOrderAllocated {
+		if err = s.record(ctx, audit.AllocateSalesOrder, "", before, order, nil, tx); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+		if err = s.publishSalesOrder(ctx, *order); err != nil {
+			rollback(ctx, tx, err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "failed to commit sales order update")
+	}
+	return nil
+}
+
+// allocateSalesOrders moves the sales orders waiting on a product along once its open reservations have been filled.
+// Pending all-or-nothing orders are allocated if they now can be, and ship-partial orders pick up what their
+// reservations were filled with. The product is returned as it is afterwards.
+func (s *service) allocateSalesOrders(ctx context.Context, product Product) (Product, error) {
+	const funcName = "allocateSalesOrders"
+
+	orders, err := s.repo.GetActiveSalesOrders(ctx, product.Sku)
+	if err != nil {
+		return product, errors.WithMessagef(err, "failed to get sales orders of %s", product.Sku)
+	}
+
+	allocated := false
+	for i := range orders {
+		order := &orders[i]
+		if order.Policy == ShipPartial {
+			if err = s.updateSalesOrder(ctx, order); err != nil {
+				return product, err
+			}
+			continue
+		}
+
+		ok, err := s.allocateSalesOrder(ctx, order)
+		if errors.Is(err, ErrConflict) {
+			log.Warn().Err(err).Str("func", funcName).Uint64("salesOrder", order.ID).Msg("stock changed while allocating, leaving the order pending")
+			continue
+		}
+		if err != nil {
+			return product, err
+		}
+		allocated = allocated || ok
+	}
+	if !allocated {
+		return product, nil
+	}
+
+	if product, err = s.repo.GetProduct(ctx, product.Sku); err != nil {
+		return product, errors.WithStack(err)
+	}
+	return product, nil
+}
+
+func (s *service) publishSalesOrder(ctx context.Context, order SalesOrder) error {
+	body, err := json.Marshal(order)
+	if err != nil {
+		return errors.WithMessage(err, "failed to serialize sales order")
+	}
+	if err = s.bq.Publish(ctx, s.exchanges.SalesOrder, body); err != nil {
+		return errors.WithMessage(err, "failed to publish sales order")
+	}
+	return nil
+}
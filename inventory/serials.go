@@ -0,0 +1,237 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+// SerialStatus is where a serialized unit is.
+type SerialStatus string
+
+const (
+	SerialAvailable   SerialStatus = "Available"
+	SerialQuarantined SerialStatus = "Quarantined"
+	SerialDamaged     SerialStatus = "Damaged"
+	SerialReserved    SerialStatus = "Reserved"
+	SerialShipped     SerialStatus = "Shipped"
+	SerialRemoved     SerialStatus = "Removed"
+)
+
+// SerialEventType is something that happened to a serialized unit.
+type SerialEventType string
+
+const (
+	SerialEventProduced      SerialEventType = "Produced"
+	SerialEventAdded         SerialEventType = "Added"
+	SerialEventStatusChanged SerialEventType = "StatusChanged"
+	SerialEventReserved      SerialEventType = "Reserved"
+	SerialEventReleased      SerialEventType = "Released"
+	SerialEventShipped       SerialEventType = "Shipped"
+	SerialEventReturned      SerialEventType = "Returned"
+	SerialEventRemoved       SerialEventType = "Removed"
+)
+
+// Serial is an entity. A single unit of a serialized product, known by its serial number.
+type Serial struct {
+	Serial            string       `json:"serial"`
+	Sku               string       `json:"sku"`
+	Status            SerialStatus `json:"status"`
+	ProductionEventID uint64       `json:"productionEventId,omitempty"`
+	ReservationID     uint64       `json:"reservationId,omitempty"`
+	Created           time.Time    `json:"created"`
+	Updated           time.Time    `json:"updated"`
+}
+
+// SerialEvent is an entity. An entry in the history of a serialized unit, referring to the production event or
+// adjustment by request id, or to the reservation, that caused it.
+type SerialEvent struct {
+	ID            uint64          `json:"id"`
+	Serial        string          `json:"serial"`
+	Type          SerialEventType `json:"type"`
+	RequestID     string          `json:"requestId,omitempty"`
+	ReservationID uint64          `json:"reservationId,omitempty"`
+	Requester     string          `json:"requester,omitempty"`
+	Created       time.Time       `json:"created"`
+}
+
+// SerialHistory is a value object. A serialized unit together with everything that happened to it.
+type SerialHistory struct {
+	Serial
+	History []SerialEvent `json:"history"`
+}
+
+// validateSerials checks the serial numbers given for a quantity of a product. Serialized products need exactly one
+// serial number per unit and other products none at all.
+func validateSerials(product Product, serials []string, quantity int64) error {
+	if !product.Serialized {
+		if len(serials) > 0 {
+			return validation("product %s is not serialized", product.Sku)
+		}
+		return nil
+	}
+	if quantity < 0 {
+		quantity = -quantity
+	}
+	if int64(len(serials)) != quantity {
+		return validation("product %s is serialized, %d serials are needed but %d were given", product.Sku, quantity,
+			len(serials))
+	}
+	seen := map[string]bool{}
+	for _, serial := range serials {
+		if serial == "" {
+			return validation("serials must not be blank")
+		}
+		if seen[serial] {
+			return conflict(CodeDuplicateSerial, "serial %s is given more than once", serial)
+		}
+		seen[serial] = true
+	}
+	return nil
+}
+
+// addSerials registers new units of a product with the given status.
+func (s *service) addSerials(ctx context.Context, sku string, serials []string, status SerialStatus, event SerialEvent, productionEventID uint64, tx db.Transaction) error {
+	for _, serial := range serials {
+		unit := Serial{Serial: serial, Sku: sku, Status: status, ProductionEventID: productionEventID,
+			Created: event.Created, Updated: event.Created}
+		if err := s.repo.SaveSerial(ctx, unit, tx); err != nil {
+			return err
+		}
+		event.Serial = serial
+		if err := s.repo.SaveSerialEvent(ctx, &event, tx); err != nil {
+			return errors.WithMessagef(err, "failed to save history of serial %s", serial)
+		}
+	}
+	return nil
+}
+
+// removeSerials takes available units of a product out of stock.
+func (s *service) removeSerials(ctx context.Context, sku string, serials []string, event SerialEvent, tx db.Transaction) error {
+	return s.changeSerials(ctx, sku, serials, SerialAvailable, SerialRemoved, event, tx)
+}
+
+// changeSerials moves units of a product that all have the from status to the to status.
+func (s *service) changeSerials(ctx context.Context, sku string, serials []string, from, to SerialStatus, event SerialEvent, tx db.Transaction) error {
+	for _, serial := range serials {
+		unit, err := s.repo.GetSerial(ctx, serial, tx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return notFound(CodeSerialNotFound, err, "serial %s not found", serial)
+			}
+			return errors.WithStack(err)
+		}
+		if unit.Sku != sku || unit.Status != from {
+			return conflict(CodeSerialNotAvailable, "serial %s is not a %s unit of %s", serial, from, sku)
+		}
+		if err = s.moveSerial(ctx, unit, to, 0, event, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allocateSerials assigns the oldest available units of the product to the reservation as it is filled.
+func (s *service) allocateSerials(ctx context.Context, res *Reservation, quantity int64, tx db.Transaction) error {
+	units, err := s.repo.GetAvailableSerials(ctx, res.Sku, quantity, tx)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to get available serials of %s", res.Sku)
+	}
+	if int64(len(units)) < quantity {
+		return errors.Errorf("only %d of the %d available units of %s have a serial", len(units), quantity, res.Sku)
+	}
+	event := SerialEvent{Type: SerialEventReserved, ReservationID: res.ID, Requester: res.Requester, Created: time.Now()}
+	for _, unit := range units {
+		if err = s.moveSerial(ctx, unit, SerialReserved, res.ID, event, tx); err != nil {
+			return err
+		}
+		res.Serials = append(res.Serials, unit.Serial)
+	}
+	return nil
+}
+
+// shipSerials marks the units of a reservation that closed as shipped to its requester.
+func (s *service) shipSerials(ctx context.Context, res *Reservation, tx db.Transaction) error {
+	return s.moveReservationSerials(ctx, res, SerialShipped,
+		SerialEvent{Type: SerialEventShipped, ReservationID: res.ID, Requester: res.Requester, Created: time.Now()}, tx)
+}
+
+// releaseSerials puts the units of a cancelled reservation back in stock.
+func (s *service) releaseSerials(ctx context.Context, res *Reservation, tx db.Transaction) error {
+	return s.moveReservationSerials(ctx, res, SerialAvailable,
+		SerialEvent{Type: SerialEventReleased, ReservationID: res.ID, Requester: res.Requester, Created: time.Now()}, tx)
+}
+
+// releaseReservedSerials puts the last units reserved for a reservation back in stock when it no longer needs them.
+func (s *service) releaseReservedSerials(ctx context.Context, res *Reservation, quantity int64, tx db.Transaction) error {
+	units, err := s.repo.GetReservationSerials(ctx, res.ID, tx)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to get serials of reservation %d", res.ID)
+	}
+	event := SerialEvent{Type: SerialEventReleased, ReservationID: res.ID, Requester: res.Requester, Created: time.Now()}
+	for i := len(units) - 1; i >= 0 && quantity > 0; i-- {
+		if units[i].Status != SerialReserved {
+			continue
+		}
+		if err = s.moveSerial(ctx, units[i], SerialAvailable, 0, event, tx); err != nil {
+			return err
+		}
+		quantity--
+	}
+	return nil
+}
+
+func (s *service) moveReservationSerials(ctx context.Context, res *Reservation, status SerialStatus, event SerialEvent, tx db.Transaction) error {
+	units, err := s.repo.GetReservationSerials(ctx, res.ID, tx)
+	if err != nil {
+		return errors.WithMessagef(err, "failed to get serials of reservation %d", res.ID)
+	}
+	reservationID := res.ID
+	if status == SerialAvailable {
+		reservationID = 0
+	}
+	res.Serials = make([]string, 0, len(units))
+	for _, unit := range units {
+		// units a reopened reservation shipped before it was increased stay shipped
+		if unit.Status != SerialShipped {
+			if err = s.moveSerial(ctx, unit, status, reservationID, event, tx); err != nil {
+				return err
+			}
+		}
+		res.Serials = append(res.Serials, unit.Serial)
+	}
+	return nil
+}
+
+func (s *service) moveSerial(ctx context.Context, unit Serial, status SerialStatus, reservationID uint64, event SerialEvent, tx db.Transaction) error {
+	unit.Status = status
+	unit.ReservationID = reservationID
+	unit.Updated = event.Created
+	if err := s.repo.UpdateSerial(ctx, unit, tx); err != nil {
+		return errors.WithMessagef(err, "failed to update serial %s", unit.Serial)
+	}
+	event.Serial = unit.Serial
+	if err := s.repo.SaveSerialEvent(ctx, &event, tx); err != nil {
+		return errors.WithMessagef(err, "failed to save history of serial %s", unit.Serial)
+	}
+	return nil
+}
+
+// GetSerial returns a serialized unit with its history.
+func (s *service) GetSerial(ctx context.Context, serial string) (SerialHistory, error) {
+	unit, err := s.repo.GetSerial(ctx, serial)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return SerialHistory{}, notFound(CodeSerialNotFound, err, "serial %s not found", serial)
+		}
+		return SerialHistory{}, errors.WithStack(err)
+	}
+	events, err := s.repo.GetSerialEvents(ctx, serial)
+	if err != nil {
+		return SerialHistory{}, errors.WithStack(err)
+	}
+	return SerialHistory{Serial: unit, History: events}, nil
+}
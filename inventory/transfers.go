@@ -0,0 +1,474 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jinzhu/copier"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+// DefaultLocation is where production, reservations and adjustments take place, and where reserved, quarantined and
+// damaged stock is held. Every other location only holds available stock, brought there by transfers.
+const DefaultLocation = "main"
+
+// TransferStatus is how much of a transfer has arrived at its destination.
+type TransferStatus string
+
+const (
+	TransferInTransit         TransferStatus = "InTransit"
+	TransferPartiallyReceived TransferStatus = "PartiallyReceived"
+	TransferReceived          TransferStatus = "Received"
+)
+
+// Location is an entity. A site available stock can be held at and transferred between.
+type Location struct {
+	Code    string    `json:"code"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+// LocationStock is a value object. The available stock of a product held at one location. The available stock of the
+// product is what is held at all of its locations.
+type LocationStock struct {
+	Sku       string `json:"sku"`
+	Location  string `json:"location"`
+	Available int64  `json:"available"`
+}
+
+// Transfer is an entity. A quantity of a Product shipped from one location to another. It is in transit from the
+// moment it ships until it is received at its destination in one or more receipts.
+type Transfer struct {
+	ID          uint64            `json:"id"`
+	RequestID   string            `json:"requestId"`
+	Sku         string            `json:"sku"`
+	From        string            `json:"from"`
+	To          string            `json:"to"`
+	Quantity    int64             `json:"quantity"`
+	Received    int64             `json:"received"`
+	Discrepancy int64             `json:"discrepancy"`
+	Status      TransferStatus    `json:"status"`
+	Receipts    []TransferReceipt `json:"receipts"`
+	Created     time.Time         `json:"created"`
+	Updated     time.Time         `json:"updated"`
+}
+
+// TransferReceipt is an entity. A quantity of a transfer that arrived at its destination. A receipt that closes the
+// transfer writes off whatever hasn't arrived as its Discrepancy.
+type TransferReceipt struct {
+	ID          uint64    `json:"id"`
+	RequestID   string    `json:"requestId"`
+	TransferID  uint64    `json:"transferId"`
+	Sku         string    `json:"sku"`
+	Quantity    int64     `json:"quantity"`
+	Close       bool      `json:"close,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Discrepancy int64     `json:"discrepancy"`
+	Created     time.Time `json:"created"`
+}
+
+func (t Transfer) status() TransferStatus {
+	switch {
+	case t.Received+t.Discrepancy >= t.Quantity:
+		return TransferReceived
+	case t.Received > 0:
+		return TransferPartiallyReceived
+	}
+	return TransferInTransit
+}
+
+// CreateLocation adds a location stock can be transferred to.
+func (s *service) CreateLocation(ctx context.Context, loc *Location) error {
+	if loc.Code == "" || loc.Name == "" {
+		return validation("code and name are required")
+	}
+
+	_, err := s.repo.GetLocation(ctx, loc.Code)
+	if err == nil {
+		return conflict(CodeLocationExists, "location %s already exists", loc.Code)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	loc.Created = time.Now()
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = s.repo.SaveLocation(ctx, loc, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save location")
+	}
+
+	if err = s.record(ctx, audit.CreateLocation, "", nil, loc, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "failed to commit location transaction")
+	}
+	return nil
+}
+
+func (s *service) GetLocations(ctx context.Context) ([]Location, error) {
+	locations, err := s.repo.GetLocations(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return locations, nil
+}
+
+// GetLocationStock returns the available stock of the product at every location.
+func (s *service) GetLocationStock(ctx context.Context, product Product) ([]LocationStock, error) {
+	return s.locationStock(ctx, product)
+}
+
+// locationStock returns the available stock of the product held at each location, including those holding none.
+func (s *service) locationStock(ctx context.Context, product Product, txs ...db.Transaction) ([]LocationStock, error) {
+	locations, err := s.repo.GetLocations(ctx, txs...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	held, err := s.repo.GetLocationStock(ctx, product.Sku, txs...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	available := make(map[string]int64)
+	for _, ls := range held {
+		available[ls.Location] = ls.Available
+	}
+
+	stock := make([]LocationStock, 0, len(locations))
+	for _, loc := range locations {
+		stock = append(stock, LocationStock{Sku: product.Sku, Location: loc.Code, Available: available[loc.Code]})
+	}
+	return stock, nil
+}
+
+// stockAt returns the available stock of the sku held at the location.
+func (s *service) stockAt(ctx context.Context, sku, location string, txs ...db.Transaction) (int64, error) {
+	held, err := s.repo.GetLocationStock(ctx, sku, txs...)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	for _, ls := range held {
+		if ls.Location == location {
+			return ls.Available, nil
+		}
+	}
+	return 0, nil
+}
+
+// bookStock changes the available stock of the sku held at the location by qty as part of the transaction. Every
+// change to the available stock of a product is booked at the location it happens at, and stock can't be taken from a
+// location that doesn't hold it, whatever is available elsewhere.
+func (s *service) bookStock(ctx context.Context, sku, location string, qty int64, tx db.Transaction) error {
+	if qty == 0 {
+		return nil
+	}
+	if qty < 0 {
+		held, err := s.stockAt(ctx, sku, location, tx)
+		if err != nil {
+			return err
+		}
+		if held+qty < 0 {
+			return insufficientStock("only %d of %s is available at %s, %d can't be taken", held, sku, location, -qty)
+		}
+	}
+	if err := s.repo.AddLocationStock(ctx, sku, location, qty, tx); err != nil {
+		return errors.WithMessagef(err, "failed to book stock of %s at %s", sku, location)
+	}
+	return nil
+}
+
+// ShipTransfer sends available stock of the product from one location to another. The stock is in transit, and can't
+// be reserved, until it is received.
+func (s *service) ShipTransfer(ctx context.Context, product Product, transfer *Transfer) error {
+	const funcName = "ShipTransfer"
+
+	if transfer.RequestID == "" {
+		return validation("request id is required")
+	}
+	if transfer.Quantity < 1 {
+		return validation("quantity must be greater than zero")
+	}
+	if transfer.From == "" || transfer.To == "" {
+		return validation("from and to locations are required")
+	}
+	if transfer.From == transfer.To {
+		return validation("stock is already at %s", transfer.To)
+	}
+	if product.Kit {
+		return kitNotStocked(product)
+	}
+	if product.Serialized {
+		return validation("serialized product %s can't be transferred, its units aren't tracked by location",
+			product.Sku)
+	}
+
+	transfer.Sku = product.Sku
+	hash, err := requestHash(transferPayload{Sku: transfer.Sku, From: transfer.From, To: transfer.To,
+		Quantity: transfer.Quantity})
+	if err != nil {
+		return err
+	}
+	if err = s.checkIdempotency(ctx, OpShipTransfer, transfer.RequestID, hash); err != nil {
+		return err
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", transfer.RequestID).Msg("getting transfer")
+	dbTransfer, err := s.repo.GetTransferByRequestID(ctx, transfer.RequestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	if dbTransfer.RequestID != "" {
+		log.Debug().Str("func", funcName).Str("requestId", transfer.RequestID).Msg("transfer already exists, returning it")
+		return copier.Copy(transfer, &dbTransfer)
+	}
+
+	for _, code := range []string{transfer.From, transfer.To} {
+		if _, err = s.repo.GetLocation(ctx, code); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return notFound(CodeLocationNotFound, err, "location %s not found", code)
+			}
+			return errors.WithStack(err)
+		}
+	}
+
+	available, err := s.stockAt(ctx, product.Sku, transfer.From)
+	if err != nil {
+		return err
+	}
+	if available < transfer.Quantity {
+		return insufficientStock("only %d of %s is available at %s, %d can't be transferred", available,
+			product.Sku, transfer.From, transfer.Quantity)
+	}
+
+	before := product
+	product.Available -= transfer.Quantity
+	product.InTransit += transfer.Quantity
+	transfer.Received = 0
+	transfer.Discrepancy = 0
+	transfer.Status = TransferInTransit
+	transfer.Receipts = make([]TransferReceipt, 0)
+	transfer.Created = time.Now()
+	transfer.Updated = transfer.Created
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", transfer.RequestID).Msg("persisting transfer")
+	if err = s.repo.SaveTransfer(ctx, transfer, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save transfer")
+	}
+
+	if err = s.saveIdempotencyKey(ctx, OpShipTransfer, transfer.RequestID, hash, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.bookStock(ctx, product.Sku, transfer.From, -transfer.Quantity, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.saveProduct(ctx, &product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to apply transfer to product")
+	}
+
+	if err = s.evaluateStock(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.ShipTransfer, product.Sku, before, product, transfer, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.publishTransfer(ctx, product, *transfer, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "failed to commit transfer transaction")
+	}
+	observeStock(product)
+	return nil
+}
+
+// GetTransfer returns a transfer with its receipts.
+func (s *service) GetTransfer(ctx context.Context, ID uint64) (Transfer, error) {
+	transfer, err := s.repo.GetTransfer(ctx, ID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return transfer, notFound(CodeTransferNotFound, err, "transfer %d not found", ID)
+		}
+		return transfer, errors.WithStack(err)
+	}
+	return transfer, nil
+}
+
+// ReceiveTransfer takes stock of a transfer in at its destination, where it is available again and used to fill open
+// reservations. Closing the transfer records whatever hasn't arrived as a discrepancy and writes it off.
+func (s *service) ReceiveTransfer(ctx context.Context, product Product, transfer *Transfer,
+	receipt *TransferReceipt) error {
+	const funcName = "ReceiveTransfer"
+
+	if receipt.RequestID == "" {
+		return validation("request id is required")
+	}
+	if receipt.Quantity < 0 || (receipt.Quantity == 0 && !receipt.Close) {
+		return validation("quantity must be greater than zero")
+	}
+	if transfer.Sku != product.Sku {
+		return validation("transfer %d is for %s, not %s", transfer.ID, transfer.Sku, product.Sku)
+	}
+
+	receipt.TransferID = transfer.ID
+	receipt.Sku = product.Sku
+	hash, err := requestHash(transferReceiptPayload{TransferID: receipt.TransferID, Sku: receipt.Sku,
+		Quantity: receipt.Quantity, Close: receipt.Close, Reason: receipt.Reason})
+	if err != nil {
+		return err
+	}
+	if err = s.checkIdempotency(ctx, OpReceiveTransfer, receipt.RequestID, hash); err != nil {
+		return err
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", receipt.RequestID).Msg("getting transfer receipt")
+	dbReceipt, err := s.repo.GetTransferReceiptByRequestID(ctx, receipt.RequestID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(err)
+	}
+	if dbReceipt.RequestID != "" {
+		log.Debug().Str("func", funcName).Str("requestId", receipt.RequestID).Msg("transfer receipt already exists, returning it")
+		return copier.Copy(receipt, &dbReceipt)
+	}
+
+	if transfer.Status == TransferReceived {
+		return conflict(CodeInvalidTransition, "transfer %d has already been received", transfer.ID)
+	}
+	if transfer.Received+receipt.Quantity > transfer.Quantity {
+		return conflict(CodeTransferExceeded, "%d of the %d shipped on transfer %d have already been received",
+			transfer.Received, transfer.Quantity, transfer.ID)
+	}
+
+	receipt.Discrepancy = 0
+	if receipt.Close {
+		receipt.Discrepancy = transfer.Quantity - transfer.Received - receipt.Quantity
+		if receipt.Discrepancy > 0 && receipt.Reason == "" {
+			return validation("reason is required to close transfer %d with %d missing", transfer.ID,
+				receipt.Discrepancy)
+		}
+	}
+
+	before := product
+	product.InTransit -= receipt.Quantity + receipt.Discrepancy
+	product.Available += receipt.Quantity
+	transfer.Received += receipt.Quantity
+	transfer.Discrepancy = receipt.Discrepancy
+	receipt.Created = time.Now()
+	transfer.Status = transfer.status()
+	transfer.Updated = receipt.Created
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	log.Debug().Str("func", funcName).Str("requestId", receipt.RequestID).Msg("persisting transfer receipt")
+	if err = s.repo.SaveTransferReceipt(ctx, receipt, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save transfer receipt")
+	}
+
+	if err = s.saveIdempotencyKey(ctx, OpReceiveTransfer, receipt.RequestID, hash, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.repo.UpdateTransfer(ctx, *transfer, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to update transfer")
+	}
+
+	if err = s.bookStock(ctx, product.Sku, transfer.To, receipt.Quantity, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.saveProduct(ctx, &product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to apply transfer receipt to product")
+	}
+
+	if err = s.evaluateStock(ctx, product, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = s.record(ctx, audit.ReceiveTransfer, product.Sku, before, product, receipt, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	transfer.Receipts = append(transfer.Receipts, *receipt)
+	if err = s.publishTransfer(ctx, product, *transfer, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithMessage(err, "failed to commit transfer receipt transaction")
+	}
+	observeStock(product)
+
+	if receipt.Quantity > 0 {
+		log.Debug().Str("func", funcName).Str("requestId", receipt.RequestID).Msg("filling reserves")
+		if _, err = s.fillReserves(ctx, product); err != nil {
+			return errors.WithMessage(err, "failed to fill reserves after transfer")
+		}
+	}
+
+	return nil
+}
+
+// publishTransfer publishes the product's inventory and its stock at both ends of the transfer.
+func (s *service) publishTransfer(ctx context.Context, product Product, transfer Transfer, tx db.Transaction) error {
+	if err := s.publishInventory(ctx, product, tx); err != nil {
+		return errors.WithMessage(err, "failed to publish inventory")
+	}
+
+	stock, err := s.locationStock(ctx, product, tx)
+	if err != nil {
+		return err
+	}
+	for _, ls := range stock {
+		if ls.Location != transfer.From && ls.Location != transfer.To {
+			continue
+		}
+		body, err := json.Marshal(ls)
+		if err != nil {
+			return errors.WithMessage(err, "failed to serialize location stock")
+		}
+		if err = s.bq.Publish(ctx, s.exchanges.Location, body); err != nil {
+			return errors.WithMessage(err, "failed to publish location stock")
+		}
+	}
+	return nil
+}
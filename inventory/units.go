@@ -0,0 +1,172 @@
+package inventory
+
+import (
+	"context"
+	"math"
+
+	"github.com/pkg/errors"
+	"github.com/sksmith/smfg-inventory/audit"
+)
+
+// BaseUnit is the unit stock is kept in. Every other unit of measure of a product is a whole number of it.
+const BaseUnit = "each"
+
+// UnitOfMeasure is a value object. A unit a product is counted in, defined as a quantity of another of its units or,
+// without one, of the base unit. Base is the number of base units it works out to.
+type UnitOfMeasure struct {
+	Name     string `json:"name"`
+	Quantity int64  `json:"quantity"`
+	Of       string `json:"of,omitempty"`
+	Base     int64  `json:"base"`
+}
+
+// Units is the packaging hierarchy of a product.
+type Units []UnitOfMeasure
+
+func unknownUnit(sku, unit string) error {
+	return newError(KindValidation, CodeUnknownUnit, nil, "product %s has no unit of measure %s", sku, unit)
+}
+
+// factor is the number of base units in one of the unit.
+func (u Units) factor(sku, unit string) (int64, error) {
+	if unit == "" || unit == BaseUnit {
+		return 1, nil
+	}
+	for _, uom := range u {
+		if uom.Name == unit {
+			return uom.Base, nil
+		}
+	}
+	return 0, unknownUnit(sku, unit)
+}
+
+// ToBase converts a quantity of the unit to the base unit.
+func (u Units) ToBase(sku, unit string, quantity int64) (int64, error) {
+	factor, err := u.factor(sku, unit)
+	if err != nil {
+		return 0, err
+	}
+	if quantity > math.MaxInt64/factor || quantity < math.MinInt64/factor {
+		return 0, validation("%d %s of %s is too large a quantity", quantity, unit, sku)
+	}
+	return quantity * factor, nil
+}
+
+// FromBase converts a quantity in the base unit to the unit. Quantities that are not a whole number of the unit are
+// rejected rather than rounded.
+func (u Units) FromBase(sku, unit string, quantity int64) (int64, error) {
+	factor, err := u.factor(sku, unit)
+	if err != nil {
+		return 0, err
+	}
+	if quantity%factor != 0 {
+		return 0, newError(KindValidation, CodeFractionalQuantity, nil,
+			"%d %s of %s is not a whole number of %s, one is %d %s", quantity, BaseUnit, sku, unit, factor, BaseUnit)
+	}
+	return quantity / factor, nil
+}
+
+// resolveUnits validates the units of measure of a product and works out the base quantity of each.
+func resolveUnits(units Units) error {
+	defs := map[string]int{}
+	for i, uom := range units {
+		if uom.Name == "" {
+			return validation("unit name is required")
+		}
+		if uom.Name == BaseUnit {
+			return validation("%s is the base unit, it can't be redefined", BaseUnit)
+		}
+		if _, ok := defs[uom.Name]; ok {
+			return validation("unit %s is listed more than once", uom.Name)
+		}
+		if uom.Quantity < 1 {
+			return validation("quantity of unit %s must be greater than zero", uom.Name)
+		}
+		defs[uom.Name] = i
+	}
+
+	var resolve func(name string, seen map[string]bool) (int64, error)
+	resolve = func(name string, seen map[string]bool) (int64, error) {
+		if name == "" || name == BaseUnit {
+			return 1, nil
+		}
+		i, ok := defs[name]
+		if !ok {
+			return 0, validation("unit %s is not defined", name)
+		}
+		if seen[name] {
+			return 0, validation("unit %s is defined in terms of itself", name)
+		}
+		seen[name] = true
+		of, err := resolve(units[i].Of, seen)
+		if err != nil {
+			return 0, err
+		}
+		if units[i].Quantity > math.MaxInt64/of {
+			return 0, validation("unit %s is too large", name)
+		}
+		return units[i].Quantity * of, nil
+	}
+
+	for i := range units {
+		base, err := resolve(units[i].Name, map[string]bool{})
+		if err != nil {
+			return err
+		}
+		units[i].Base = base
+	}
+	return nil
+}
+
+// toBase converts a quantity given in one of the product's units of measure to its base unit.
+func (s *service) toBase(ctx context.Context, sku, unit string, quantity int64) (int64, error) {
+	if unit == "" || unit == BaseUnit {
+		return quantity, nil
+	}
+	units, err := s.repo.GetUnits(ctx, sku)
+	if err != nil {
+		return 0, errors.WithMessagef(err, "failed to get units of measure of %s", sku)
+	}
+	return units.ToBase(sku, unit, quantity)
+}
+
+// SaveUnits replaces the units of measure of the product.
+func (s *service) SaveUnits(ctx context.Context, product Product, units Units) error {
+	if err := resolveUnits(units); err != nil {
+		return err
+	}
+
+	before, err := s.repo.GetUnits(ctx, product.Sku)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tx, err := s.repo.BeginTransaction(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err = s.repo.SaveUnits(ctx, product.Sku, units, tx); err != nil {
+		rollback(ctx, tx, err)
+		return errors.WithMessage(err, "failed to save units of measure")
+	}
+
+	if err = s.record(ctx, audit.UpdateUnits, product.Sku, before, units, nil, tx); err != nil {
+		rollback(ctx, tx, err)
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// GetUnits returns the units of measure of a product other than its base unit.
+func (s *service) GetUnits(ctx context.Context, sku string) (Units, error) {
+	units, err := s.repo.GetUnits(ctx, sku)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return units, nil
+}
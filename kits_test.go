@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/inventory"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+// newKitRepo holds KitSKU made of two of CompA and one of CompB.
+func newKitRepo(compA, compB int64) *memRepo {
+	m := newMemRepo(
+		inventory.Product{Sku: "KitSKU", Upc: "7777777777", Name: "Kit", Kit: true},
+		inventory.Product{Sku: "CompA", Upc: "5555555555", Name: "Component A", Available: compA},
+		inventory.Product{Sku: "CompB", Upc: "6666666666", Name: "Component B", Available: compB},
+	)
+	m.boms["KitSKU"] = inventory.BillOfMaterials{Sku: "KitSKU", Version: 1, Components: []inventory.BomComponent{
+		{Sku: "CompA", Quantity: 2},
+		{Sku: "CompB", Quantity: 1},
+	}}
+	return m
+}
+
+// kitsPublished returns the availability of the kit in each inventory message published for it.
+func kitsPublished(t *testing.T, q *memQueue) []int64 {
+	t.Helper()
+	var published []inventory.Product
+	q.published(t, testExchanges.Inventory, &published)
+	available := make([]int64, 0)
+	for _, p := range published {
+		if p.Sku == "KitSKU" {
+			available = append(available, p.Available)
+		}
+	}
+	return available
+}
+
+func TestKitAvailable(t *testing.T) {
+	tests := []struct {
+		name      string
+		compA     int64
+		compB     int64
+		available int64
+	}{
+		{"short of the second component", 21, 8, 8},
+		{"short of the first component", 9, 8, 4},
+		{"without one component", 20, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newKitRepo(test.compA, test.compB)
+			ts := httptest.NewServer(testRouter(newMemQueue().queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			kit := inventory.Product{}
+			if res := call(t, ts, http.MethodGet, "/inventory/v1/KitSKU", nil, &kit); res.StatusCode != http.StatusOK {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, http.StatusOK)
+			}
+			if !kit.Kit || kit.Available != test.available {
+				t.Errorf("kit got=%v/%d want=%v/%d", kit.Kit, kit.Available, true, test.available)
+			}
+		})
+	}
+}
+
+func TestKitComponentChange(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		body      interface{}
+		status    int
+		published []int64
+		stale     bool
+	}{
+		{
+			name:   "a kit isn't stocked itself",
+			url:    "/inventory/v1/KitSKU/productionEvent",
+			body:   inventory.ProductionEvent{RequestID: "KitRID", Quantity: 1},
+			status: http.StatusConflict,
+		},
+		{
+			name:      "more of the short component republishes the kit",
+			url:       "/inventory/v1/CompB/adjustment",
+			body:      inventory.Adjustment{RequestID: "KitAdjRID", Quantity: 10, Reason: "found"},
+			status:    http.StatusCreated,
+			published: []int64{10},
+			stale:     true,
+		},
+		{
+			name:      "more of the plentiful component",
+			url:       "/inventory/v1/CompA/adjustment",
+			body:      inventory.Adjustment{RequestID: "KitAdjRID", Quantity: 2, Reason: "found"},
+			status:    http.StatusCreated,
+			published: []int64{8},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newKitRepo(21, 8)
+			q := newMemQueue()
+			ts := httptest.NewServer(testRouter(q.queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			etag := call(t, ts, http.MethodGet, "/inventory/v1/KitSKU", nil, nil).Header.Get("ETag")
+			if res := call(t, ts, http.MethodPost, test.url, test.body, nil); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if got := kitsPublished(t, q); len(got) != len(test.published) || (len(got) > 0 && got[0] != test.published[0]) {
+				t.Errorf("published kit availability got=%v want=%v", got, test.published)
+			}
+
+			// the kit itself is unchanged, but a cached copy of it is stale once its availability changes
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/inventory/v1/KitSKU", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("If-None-Match", etag)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = res.Body.Close()
+			if stale := res.StatusCode == http.StatusOK && res.Header.Get("ETag") != etag; stale != test.stale {
+				t.Errorf("kit after the change got=%d etag=%s stale=%v want stale=%v", res.StatusCode,
+					res.Header.Get("ETag"), stale, test.stale)
+			}
+		})
+	}
+}
+
+func TestReserveKit(t *testing.T) {
+	tests := []struct {
+		name       string
+		compA      int64
+		compB      int64
+		state      inventory.ReserveState
+		reserved   int64
+		components map[string][2]int64
+	}{
+		{
+			name:       "short of a component",
+			compA:      20,
+			compB:      3,
+			state:      inventory.Open,
+			reserved:   3,
+			components: map[string][2]int64{"CompA": {10, 10}, "CompB": {5, 3}},
+		},
+		{
+			name:       "in stock",
+			compA:      20,
+			compB:      10,
+			state:      inventory.Closed,
+			reserved:   5,
+			components: map[string][2]int64{"CompA": {10, 10}, "CompB": {5, 5}},
+		},
+		{
+			name:       "out of stock",
+			state:      inventory.Open,
+			components: map[string][2]int64{"CompA": {10, 0}, "CompB": {5, 0}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newKitRepo(test.compA, test.compB)
+			ts := httptest.NewServer(testRouter(newMemQueue().queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			kitRes := inventory.Reservation{}
+			r := inventory.Reservation{RequestID: "KitResRID", Requester: "KitRequester", RequestedQuantity: 5}
+			if res := call(t, ts, http.MethodPost, "/inventory/v1/KitSKU/reservation", r, &kitRes); res.StatusCode != http.StatusCreated {
+				t.Fatalf("reserve status got=%d want=%d", res.StatusCode, http.StatusCreated)
+			}
+			if kitRes.State != test.state || kitRes.ReservedQuantity != test.reserved {
+				t.Errorf("kit reservation got=%s/%d want=%s/%d", kitRes.State, kitRes.ReservedQuantity, test.state,
+					test.reserved)
+			}
+
+			components := m.findReservations(func(r inventory.Reservation) bool { return r.KitReservationID == kitRes.ID })
+			if len(components) != len(test.components) {
+				t.Fatalf("component reservations got=%d want=%d", len(components), len(test.components))
+			}
+			for _, c := range components {
+				if q := test.components[c.Sku]; c.RequestedQuantity != q[0] || c.ReservedQuantity != q[1] {
+					t.Errorf("%s reservation got=%d/%d want=%d/%d", c.Sku, c.RequestedQuantity, c.ReservedQuantity,
+						q[0], q[1])
+				}
+			}
+		})
+	}
+}
+
+func TestChangeKitReservation(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		sku       string
+		url       string
+		body      interface{}
+		status    int
+		state     inventory.ReserveState
+		available [2]int64
+	}{
+		{
+			name:      "a component reservation can only be cancelled through its kit",
+			method:    http.MethodDelete,
+			sku:       "CompB",
+			status:    http.StatusConflict,
+			state:     inventory.Open,
+			available: [2]int64{10, 0},
+		},
+		{
+			name:      "component stock fills the kit",
+			method:    http.MethodPost,
+			url:       "/inventory/v1/CompB/adjustment",
+			body:      inventory.Adjustment{RequestID: "KitFillRID", Quantity: 2, Reason: "found"},
+			status:    http.StatusCreated,
+			state:     inventory.Closed,
+			available: [2]int64{10, 0},
+		},
+		{
+			name:      "cancel the kit",
+			method:    http.MethodDelete,
+			sku:       "KitSKU",
+			status:    http.StatusOK,
+			state:     inventory.Cancelled,
+			available: [2]int64{20, 3},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newKitRepo(20, 3)
+			ts := httptest.NewServer(testRouter(newMemQueue().queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			kitRes := inventory.Reservation{}
+			r := inventory.Reservation{RequestID: "KitResRID", Requester: "KitRequester", RequestedQuantity: 5}
+			if res := call(t, ts, http.MethodPost, "/inventory/v1/KitSKU/reservation", r, &kitRes); res.StatusCode != http.StatusCreated {
+				t.Fatalf("reserve status got=%d want=%d", res.StatusCode, http.StatusCreated)
+			}
+
+			url := test.url
+			if url == "" {
+				id := kitRes.ID
+				if test.sku != "KitSKU" {
+					id = m.findReservations(func(r inventory.Reservation) bool { return r.Sku == test.sku })[0].ID
+				}
+				url = "/inventory/v1/" + test.sku + "/reservation/" + strconv.FormatUint(id, 10)
+			}
+			if res := call(t, ts, test.method, url, test.body, nil); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+
+			if got := m.reservations[kitRes.ID]; got.State != test.state {
+				t.Errorf("kit reservation got=%s want=%s", got.State, test.state)
+			}
+			if test.state == inventory.Cancelled {
+				for _, r := range m.reservations {
+					if r.State != inventory.Cancelled {
+						t.Errorf("reservation %d %s state got=%s want=%s", r.ID, r.Sku, r.State, inventory.Cancelled)
+					}
+				}
+			}
+			if a, b := m.products["CompA"], m.products["CompB"]; a.Available != test.available[0] ||
+				b.Available != test.available[1] {
+				t.Errorf("components available got=%d/%d want=%d/%d", a.Available, b.Available, test.available[0],
+					test.available[1])
+			}
+		})
+	}
+}
+
+func TestKitsNotReplenished(t *testing.T) {
+	m := newKitRepo(20, 3)
+	replenished := make([]string, 0)
+	m.repo.SaveReplenishmentFunc = func(ctx context.Context, r inventory.Replenishment, tx ...db.Transaction) error {
+		replenished = append(replenished, r.Sku)
+		return nil
+	}
+	alerted := make([]string, 0)
+	m.repo.SaveStockAlertFunc = func(ctx context.Context, alert inventory.StockAlert, tx ...db.Transaction) error {
+		alerted = append(alerted, alert.Sku)
+		return nil
+	}
+
+	q := newMemQueue()
+	store := settings.NewStore(settings.Settings{AllocationPolicy: settings.AllocateFifo})
+	ts := httptest.NewServer(testRouterWithSettings(q.queue, m.repo, audit.NewMockRepo(), store))
+	defer ts.Close()
+
+	if res := call(t, ts, http.MethodGet, "/inventory/v1/KitSKU/replenishment", nil, nil); res.StatusCode != http.StatusConflict {
+		t.Errorf("kit replenishment status got=%d want=%d", res.StatusCode, http.StatusConflict)
+	}
+
+	service := inventory.NewService(m.repo, audit.NewMockRepo(), store, q.queue, testExchanges)
+	if n, err := service.RunReplenishmentReport(context.Background(), time.Now()); err != nil || n != 2 {
+		t.Errorf("replenishment report got=%d err=%v want=%d", n, err, 2)
+	}
+	if len(replenished) != 2 || replenished[0] != "CompA" || replenished[1] != "CompB" {
+		t.Errorf("replenished got=%v want=%v", replenished, []string{"CompA", "CompB"})
+	}
+
+	// a kit with a reorder point above what its components make up still doesn't raise an alert
+	etag := call(t, ts, http.MethodGet, "/inventory/v1/KitSKU", nil, nil).Header.Get("ETag")
+	update := m.products["KitSKU"]
+	update.ReorderPoint = 100
+	data, err := json.Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, ts.URL+"/inventory/v1/KitSKU", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", etag)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("update kit status got=%d want=%d", res.StatusCode, http.StatusOK)
+	}
+	if len(alerted) != 0 {
+		t.Errorf("alerts got=%v want none", alerted)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// Exit codes reported by the process so that orchestrators can tell a clean stop from a failure.
+const (
+	exitOK              = 0
+	exitStartupFailure  = 1
+	exitServerFailure   = 2
+	exitShutdownFailure = 3
+)
+
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// lifecycle owns everything that has to be stopped when the application exits. Background workers share a context
+// that is cancelled on shutdown, and shutdown hooks run in the reverse order of their registration so that
+// dependencies are released after the things that use them.
+type lifecycle struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	workers  context.Context
+	stop     context.CancelFunc
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	hooks    []shutdownHook
+	stopping bool
+}
+
+// newLifecycle creates a lifecycle whose context is cancelled when the process receives SIGINT or SIGTERM.
+func newLifecycle() *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	workers, stop := context.WithCancel(context.Background())
+	l := &lifecycle{ctx: ctx, cancel: cancel, workers: workers, stop: stop}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			log.Info().Str("signal", sig.String()).Msg("received signal, shutting down")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+
+	return l
+}
+
+// Context is cancelled as soon as shutdown has been requested.
+func (l *lifecycle) Context() context.Context {
+	return l.ctx
+}
+
+// Stopping reports whether the application has started shutting down.
+func (l *lifecycle) Stopping() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stopping
+}
+
+// Go runs a background worker until shutdown. The worker must return once its context is cancelled.
+func (l *lifecycle) Go(name string, fn func(ctx context.Context)) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		fn(l.workers)
+		log.Debug().Str("worker", name).Msg("worker stopped")
+	}()
+}
+
+// OnShutdown registers a hook to run during shutdown.
+func (l *lifecycle) OnShutdown(name string, fn func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// StopWorkers cancels the background workers and waits for them to return. It is meant to be registered as a
+// shutdown hook so that workers stop at the right point in the shutdown order.
+func (l *lifecycle) StopWorkers(ctx context.Context) error {
+	l.stop()
+
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.WithMessage(ctx.Err(), "background workers did not stop in time")
+	}
+}
+
+// Shutdown runs every hook within the timeout. All hooks are run even if some fail, and the first failure is
+// returned.
+func (l *lifecycle) Shutdown(timeout time.Duration) error {
+	l.mu.Lock()
+	l.stopping = true
+	hooks := l.hooks
+	l.mu.Unlock()
+	l.cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var first error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		start := time.Now()
+		log.Info().Str("component", h.name).Msg("stopping")
+		if err := h.fn(ctx); err != nil {
+			log.Error().Err(err).Str("component", h.name).Msg("failed to stop cleanly")
+			if first == nil {
+				first = errors.WithMessagef(err, "failed to stop %s", h.name)
+			}
+			continue
+		}
+		log.Info().Str("component", h.name).Dur("duration", time.Since(start)).Msg("stopped")
+	}
+
+	return first
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLifecycleShutdown(t *testing.T) {
+	lc := newLifecycle()
+
+	var order []string
+	workerStopped := false
+
+	lc.OnShutdown("database", func(_ context.Context) error {
+		order = append(order, "database")
+		return nil
+	})
+	lc.OnShutdown("rabbitmq", func(_ context.Context) error {
+		order = append(order, "rabbitmq")
+		return errors.New("broker went away")
+	})
+	lc.OnShutdown("background workers", func(ctx context.Context) error {
+		order = append(order, "background workers")
+		return lc.StopWorkers(ctx)
+	})
+	lc.OnShutdown("http server", func(_ context.Context) error {
+		order = append(order, "http server")
+		return nil
+	})
+	lc.Go("test worker", func(ctx context.Context) {
+		<-ctx.Done()
+		workerStopped = true
+	})
+
+	if lc.Stopping() {
+		t.Errorf("stopping got=%t want=%t", true, false)
+	}
+
+	err := lc.Shutdown(time.Second)
+	if err == nil {
+		t.Errorf("a failing hook should fail the shutdown")
+	}
+
+	want := []string{"http server", "background workers", "rabbitmq", "database"}
+	if len(order) != len(want) {
+		t.Fatalf("hooks run got=%v want=%v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Errorf("hook %d got=%s want=%s", i, order[i], want[i])
+		}
+	}
+	if !workerStopped {
+		t.Errorf("workers should be stopped during shutdown")
+	}
+	if !lc.Stopping() {
+		t.Errorf("stopping got=%t want=%t", false, true)
+	}
+	if lc.Context().Err() == nil {
+		t.Errorf("the lifecycle context should be cancelled on shutdown")
+	}
+}
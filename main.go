@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"os/signal"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -15,17 +15,26 @@ import (
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	"github.com/sksmith/bunnyq"
 	"github.com/sksmith/smfg-inventory/admin"
 	"github.com/sksmith/smfg-inventory/api"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/broker"
 	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/health"
 	"github.com/sksmith/smfg-inventory/inventory"
+	"github.com/sksmith/smfg-inventory/outbox"
+	"github.com/sksmith/smfg-inventory/settings"
+	"github.com/sksmith/smfg-inventory/tracing"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
 	AppName = "smfg-inventory"
+
+	idempotencyCleanupInterval = time.Hour
+	backlogMetricsInterval     = 30 * time.Second
+	outboxRelayInterval        = 5 * time.Second
 )
 
 var (
@@ -35,20 +44,38 @@ var (
 
 	dbPool *pgxpool.Pool
 	config *AppConfig
-
-	configUrl    = os.Getenv("SMFG_CONFIG_SERVER_URL")
-	configBranch = os.Getenv("SMFG_CONFIG_SERVER_BRANCH")
-	profile      = os.Getenv("SMFG_PROFILE")
 )
 
 func main() {
-	ctx := context.Background()
+	if len(os.Args) > 2 && os.Args[1] == "config" && os.Args[2] == "print" {
+		os.Exit(printConfig(os.Args[3:]))
+	}
+	os.Exit(run(os.Args[1:]))
+}
+
+// printConfig shows the effective configuration without starting the application.
+func printConfig(args []string) int {
+	c, err := LoadConfigs(args)
+	if c != nil {
+		c.Print(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitStartupFailure
+	}
+	return exitOK
+}
 
+// run starts the application and blocks until it is told to stop or the http server fails. Shutdown drains in-flight
+// requests first, then stops background workers, then relays whatever is left in the outbox, then closes the broker
+// and the database pool and finally flushes any buffered traces.
+func run(args []string) int {
 	var err error
 	log.Info().Msg("loading configurations...")
-	config, err = LoadConfigs(configUrl, configBranch, profile)
+	config, err = LoadConfigs(args)
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to load configurations")
+		log.Error().Err(err).Msg("failed to load configurations")
+		return exitStartupFailure
 	}
 	log.Info().Msg("configuring logging...")
 	configLogging()
@@ -56,75 +83,150 @@ func main() {
 	config.Revision = "2"
 	printLogHeader(config)
 
+	lc := newLifecycle()
+
+	log.Info().Msg("configuring tracing...")
+	shutdownTracing, err := tracing.Init(lc.Context(), tracing.Config{
+		Exporter:       config.TraceExporter,
+		Endpoint:       config.TraceEndpoint,
+		Insecure:       config.TraceInsecure,
+		File:           config.TraceFile,
+		SampleRatio:    config.TraceSampleRatio,
+		ServiceName:    AppName,
+		ServiceVersion: AppVersion,
+	})
+	if err != nil {
+		log.Error().Err(err).Msg("failed to configure tracing")
+		return exitStartupFailure
+	}
+	lc.OnShutdown("tracing", shutdownTracing)
+
+	store := settings.NewStore(config.RuntimeSettings())
+	store.Subscribe(func(c settings.Change) {
+		log.Info().Strs("changed", c.Changed).Interface("settings", c.New).Msg("runtime settings changed")
+		if c.New.LogLevel != c.Old.LogLevel {
+			setLogLevel(c.New.LogLevel)
+		}
+	})
+
 	log.Info().Msg("connecting to the database...")
-	configDatabase(ctx)
+	if err = configDatabase(lc.Context()); err != nil {
+		log.Error().Err(err).Msg("failed to connect to the database")
+		return exitStartupFailure
+	}
+	if dbPool != nil {
+		lc.OnShutdown("database", func(_ context.Context) error {
+			dbPool.Close()
+			return nil
+		})
+	}
 	repo := inventory.NewPostgresRepo(dbPool)
+	auditRepo := audit.NewPostgresRepo(dbPool)
 
 	log.Info().Msg("connecting to rabbitmq...")
-	queue := rabbit()
+	queue := broker.Dial(broker.Address{
+		User: config.QUser,
+		Pass: config.QPass,
+		Host: config.QHost,
+		Port: strconv.Itoa(config.QPort),
+	})
+	lc.OnShutdown("rabbitmq", queue.Close)
+
+	// Messages go through the outbox when there is a database to keep them in. It is flushed once everything that
+	// publishes has stopped and before the broker is closed.
+	var publisher broker.Publisher = queue
+	outboxRepo := outbox.NewPostgresRepo(dbPool)
+	if dbPool != nil {
+		box := outbox.New(outboxRepo, queue)
+		publisher = box
+		lc.OnShutdown("outbox", box.Flush)
+		lc.Go("outbox relay", func(ctx context.Context) {
+			box.Relay(ctx, outboxRelayInterval)
+		})
+	}
+
+	lc.OnShutdown("background workers", lc.StopWorkers)
+	lc.Go("idempotency cleanup", func(ctx context.Context) {
+		inventory.CleanupIdempotencyKeys(ctx, repo, config.IdempotencyTTL, idempotencyCleanupInterval)
+	})
+	inventory.LimitSkuMetrics(config.MetricSkuLimit)
+	lc.Go("backlog metrics", func(ctx context.Context) {
+		inventory.CollectBacklogMetrics(ctx, repo, backlogMetricsInterval)
+	})
+	lc.Go("config reload", func(ctx context.Context) {
+		watchConfig(ctx, args, store)
+	})
+
+	exchanges := inventory.Exchanges{
+		Inventory:   config.QInventoryExchange,
+		Reservation: config.QReservationExchange,
+		Alert:       config.QAlertExchange,
+		Order:       config.QOrderExchange,
+		Location:    config.QLocationExchange,
+		Return:      config.QReturnExchange,
+		SalesOrder:  config.QSalesOrderExchange,
+		Backorder:   config.QBackorderExchange,
+	}
+	reportService := inventory.NewService(repo, auditRepo, store, tracing.NewQueue(publisher), exchanges)
+	lc.Go("replenishment report", func(ctx context.Context) {
+		inventory.ScheduleReplenishmentReport(ctx, reportService, config.ReplenishReportHour)
+	})
+
+	checker := health.NewChecker(lc.Stopping)
+	if dbPool != nil {
+		checker.Register("database", db.PoolCheck(dbPool))
+		checker.Register("migrations", db.MigrationCheck(dbPool))
+		checker.Register("outbox", outbox.BacklogCheck(outboxRepo, int64(config.OutboxMaxBacklog), config.OutboxMaxAge))
+	}
+	checker.Register("broker", inventory.QueueCheck(queue))
 
 	log.Info().Msg("configuring router...")
-	r := configureRouter(queue, repo, config.QInventoryExchange, config.QReservationExchange)
+	r := configureRouter(tracing.NewQueue(publisher), repo, auditRepo, store, checker, exchanges)
 
 	log.Info().Msg("generating configurations...")
 	if config.GenerateRoutes {
 		createRouteDocs(r)
 	}
 
-	log.Info().Str("port", config.Port).Msg("listening")
-	log.Fatal().Err(http.ListenAndServe(":"+config.Port, r))
-}
-
-func rabbit() inventory.Queue {
-	var queue inventory.Queue
-	osChannel := make(chan os.Signal, 1)
-	signal.Notify(osChannel, os.Kill)
-
-	for {
-		queue = bunnyq.New(context.Background(),
-			bunnyq.Address{
-				User: config.QUser,
-				Pass: config.QPass,
-				Host: config.QHost,
-				Port: config.QPort,
-			},
-			osChannel,
-			bunnyq.LogHandler(logger{}),
-		)
+	srv := &http.Server{Addr: ":" + strconv.Itoa(config.Port), Handler: r}
+	lc.OnShutdown("http server", srv.Shutdown)
+	lc.OnShutdown("readiness", func(ctx context.Context) error {
+		// Readiness is already failing, give the load balancer time to notice before we stop accepting requests
+		select {
+		case <-time.After(config.ShutdownDelay):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
 
-		break
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Info().Int("port", config.Port).Msg("listening")
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			serveErr <- err
+		}
+	}()
+
+	exitCode := exitOK
+	select {
+	case <-lc.Context().Done():
+	case err = <-serveErr:
+		log.Error().Err(err).Msg("http server failed")
+		exitCode = exitServerFailure
 	}
 
-	return queue
-}
-
-type logger struct {
-}
+	log.Info().Dur("timeout", config.ShutdownTimeout).Msg("shutting down...")
+	if err = lc.Shutdown(config.ShutdownTimeout); err != nil {
+		log.Error().Err(err).Msg("shutdown did not complete cleanly")
+		if exitCode == exitOK {
+			exitCode = exitShutdownFailure
+		}
+		return exitCode
+	}
 
-func (l logger) Log(ctx context.Context, level bunnyq.LogLevel, msg string, data map[string]interface{}) {
-	var evt *zerolog.Event
-	switch level {
-	case bunnyq.LogLevelTrace:
-		evt = log.Trace()
-	case bunnyq.LogLevelDebug:
-		evt = log.Debug()
-	case bunnyq.LogLevelInfo:
-		evt = log.Info()
-	case bunnyq.LogLevelWarn:
-		evt = log.Warn()
-	case bunnyq.LogLevelError:
-		evt = log.Error()
-	case bunnyq.LogLevelNone:
-		evt = log.Info()
-	default:
-		evt = log.Info()
-	}
-
-	for k, v := range data {
-		evt.Interface(k, v)
-	}
-
-	evt.Msg(msg)
+	log.Info().Msg("shutdown complete")
+	return exitCode
 }
 
 func printLogHeader(c *AppConfig) {
@@ -132,8 +234,8 @@ func printLogHeader(c *AppConfig) {
 		log.Info().Msg("=============================================")
 		log.Info().Msg(fmt.Sprintf("    Application: %s", AppName))
 		log.Info().Msg(fmt.Sprintf("       Revision: %s", c.Revision))
-		log.Info().Msg(fmt.Sprintf("        Profile: %s", profile))
-		log.Info().Msg(fmt.Sprintf("  Config Server: %s - %s", configUrl, configBranch))
+		log.Info().Msg(fmt.Sprintf("        Profile: %s", c.Profile))
+		log.Info().Msg(fmt.Sprintf("  Config Server: %s - %s", c.ConfigServerUrl, c.ConfigServerBranch))
 		log.Info().Msg(fmt.Sprintf("    Tag Version: %s", AppVersion))
 		log.Info().Msg(fmt.Sprintf("   Sha1 Version: %s", Sha1Version))
 		log.Info().Msg(fmt.Sprintf("     Build Time: %s", BuildTime))
@@ -144,14 +246,16 @@ func printLogHeader(c *AppConfig) {
 			Str("version", AppVersion).
 			Str("sha1ver", Sha1Version).
 			Str("build-time", BuildTime).
-			Str("profile", profile).
-			Str("config-url", configUrl).
-			Str("config-branch", configBranch).
+			Str("profile", c.Profile).
+			Str("config-url", c.ConfigServerUrl).
+			Str("config-branch", c.ConfigServerBranch).
 			Send()
 	}
 }
 
-func configDatabase(ctx context.Context) {
+// configDatabase runs migrations and connects to the database, retrying until it succeeds or the context is
+// cancelled.
+func configDatabase(ctx context.Context) error {
 	if !config.InMemoryDb {
 		var err error
 
@@ -161,48 +265,66 @@ func configDatabase(ctx context.Context) {
 			if err = db.RunMigrations(
 				config.DbHost,
 				config.DbName,
-				config.DbPort,
+				strconv.Itoa(config.DbPort),
 				config.DbUser,
 				config.DbPass); err != nil {
 				log.Warn().Err(err).Msg("error executing migrations")
 			}
 		}
 
-		connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		connStr := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 			config.DbHost, config.DbPort, config.DbUser, config.DbPass, config.DbName)
 
 		for {
 			dbPool, err = db.ConnectDb(ctx, connStr)
 			if err != nil {
 				log.Error().Err(err).Msg("failed to create connection pool... retrying")
-				time.Sleep(1 * time.Second)
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(1 * time.Second):
+				}
 				continue
 			}
 			break
 		}
 	}
+	return nil
 }
 
-func configureRouter(queue inventory.Queue, repo inventory.Repository, invExchange, resExchange string) chi.Router {
+func configureRouter(queue inventory.Queue, repo inventory.Repository, auditRepo audit.Repository, store *settings.Store,
+	checker *health.Checker, exchanges inventory.Exchanges) chi.Router {
 	r := chi.NewRouter()
 
+	limiter := api.NewRateLimiter(store.Get().RateLimit, store.Get().RateBurst)
+	store.Subscribe(func(c settings.Change) {
+		if c.New.RateLimit != c.Old.RateLimit || c.New.RateBurst != c.Old.RateBurst {
+			limiter.Set(c.New.RateLimit, c.New.RateBurst)
+		}
+	})
+
 	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
+	r.Use(api.TracingMiddleware)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.URLFormat)
 	r.Use(api.MetricsMiddleware)
 	r.Use(render.SetContentType(render.ContentTypeJSON))
 	r.Use(api.LoggingMiddleware)
+	r.Use(audit.Middleware)
 
 	r.Handle("/inventory/metrics", promhttp.Handler())
-	r.Route("/inventory/v1", inventoryApi(queue, repo, invExchange, resExchange))
-	r.Mount("/inventory/admin", admin.Router())
+	r.Route("/inventory/health", health.NewApi(checker).ConfigureRouter)
+	r.With(limiter.Middleware).Route("/inventory/v1", inventoryApi(queue, repo, auditRepo, store, exchanges))
+	r.Mount("/inventory/admin", admin.Router(audit.NewApi(auditRepo), settings.NewApi(store)))
 
 	return r
 }
 
-func inventoryApi(queue inventory.Queue, repo inventory.Repository, invExchange, resExchange string) func(r chi.Router) {
+func inventoryApi(queue inventory.Queue, repo inventory.Repository, auditRepo audit.Repository, store *settings.Store,
+	exchanges inventory.Exchanges) func(r chi.Router) {
 	return func(r chi.Router) {
-		service := inventory.NewService(repo, queue, invExchange, resExchange)
+		service := inventory.NewService(repo, auditRepo, store, queue, exchanges)
 		invApi := inventory.NewApi(service)
 		invApi.ConfigureRouter(r)
 	}
@@ -223,9 +345,13 @@ func configLogging() {
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	}
 
-	level, err := zerolog.ParseLevel(config.LogLevel)
+	setLogLevel(config.LogLevel)
+}
+
+func setLogLevel(l string) {
+	level, err := zerolog.ParseLevel(l)
 	if err != nil {
-		log.Warn().Str("loglevel", config.LogLevel).Err(err).Msg("defaulting to info")
+		log.Warn().Str("loglevel", l).Err(err).Msg("defaulting to info")
 		level = zerolog.InfoLevel
 	}
 	log.Info().Str("loglevel", level.String()).Msg("setting log level")
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/broker"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/inventory"
+)
+
+// memRepo keeps what the inventory service saves in memory so that a test can check the state a request leaves
+// behind. Its repo is a mock repository wired to the maps, a test overrides any of its functions it needs to.
+type memRepo struct {
+	repo             inventory.MockRepo
+	products         map[string]inventory.Product
+	reservations     map[uint64]inventory.Reservation
+	events           map[string]inventory.ProductionEvent
+	adjustments      map[string]inventory.Adjustment
+	changes          map[string]inventory.StatusChange
+	productionOrders map[uint64]inventory.ProductionOrder
+	boms             map[string]inventory.BillOfMaterials
+	units            map[string]inventory.Units
+	serials          map[string]inventory.Serial
+	serialEvents     map[string][]inventory.SerialEvent
+	counts           map[uint64]inventory.CycleCount
+	returns          map[uint64]inventory.Return
+	returnReceipts   map[string]inventory.ReturnReceipt
+	salesOrders      map[uint64]inventory.SalesOrder
+	locations        map[string]inventory.Location
+	locationStock    map[string]map[string]int64
+	transfers        map[uint64]inventory.Transfer
+	transferReceipts map[string]inventory.TransferReceipt
+}
+
+// newMemRepo returns an in memory repository holding the products, with their available stock at the main location.
+func newMemRepo(products ...inventory.Product) *memRepo {
+	m := &memRepo{
+		repo:             inventory.NewMockRepo(),
+		products:         map[string]inventory.Product{},
+		reservations:     map[uint64]inventory.Reservation{},
+		events:           map[string]inventory.ProductionEvent{},
+		adjustments:      map[string]inventory.Adjustment{},
+		changes:          map[string]inventory.StatusChange{},
+		productionOrders: map[uint64]inventory.ProductionOrder{},
+		boms:             map[string]inventory.BillOfMaterials{},
+		units:            map[string]inventory.Units{},
+		serials:          map[string]inventory.Serial{},
+		serialEvents:     map[string][]inventory.SerialEvent{},
+		counts:           map[uint64]inventory.CycleCount{},
+		returns:          map[uint64]inventory.Return{},
+		returnReceipts:   map[string]inventory.ReturnReceipt{},
+		salesOrders:      map[uint64]inventory.SalesOrder{},
+		locations: map[string]inventory.Location{
+			inventory.DefaultLocation: {Code: inventory.DefaultLocation, Name: "Main"},
+		},
+		locationStock:    map[string]map[string]int64{},
+		transfers:        map[uint64]inventory.Transfer{},
+		transferReceipts: map[string]inventory.TransferReceipt{},
+	}
+	for _, p := range products {
+		m.products[p.Sku] = p
+		if p.Available != 0 {
+			m.locationStock[p.Sku] = map[string]int64{inventory.DefaultLocation: p.Available}
+		}
+	}
+	m.wireProducts()
+	m.wireReservations()
+	m.wireProduction()
+	m.wireSerials()
+	m.wireCounts()
+	m.wireReturns()
+	m.wireSalesOrders()
+	m.wireTransfers()
+	return m
+}
+
+// addReservations stores the reservations as they are, ids included.
+func (m *memRepo) addReservations(reservations ...inventory.Reservation) {
+	for _, r := range reservations {
+		m.reservations[r.ID] = r
+	}
+}
+
+// findReservations returns the reservations that match, by id.
+func (m *memRepo) findReservations(match func(r inventory.Reservation) bool) []inventory.Reservation {
+	found := make([]inventory.Reservation, 0)
+	for _, r := range m.reservations {
+		if match(r) {
+			found = append(found, r)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ID < found[j].ID })
+	return found
+}
+
+func (m *memRepo) wireProducts() {
+	m.repo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		p, ok := m.products[sku]
+		if !ok {
+			return p, sql.ErrNoRows
+		}
+		return p, nil
+	}
+	// like the database, a product is only saved over the version it was read at
+	m.repo.SaveProductFunc = func(ctx context.Context, p inventory.Product, tx ...db.Transaction) error {
+		if stored, ok := m.products[p.Sku]; ok && stored.Version != p.Version {
+			return &inventory.Error{Kind: inventory.KindConflict, Code: inventory.CodeConcurrentModification,
+				Msg: "product " + p.Sku + " was modified since it was read"}
+		}
+		p.Version++
+		m.products[p.Sku] = p
+		return nil
+	}
+	m.repo.GetAllProductsFunc = func(ctx context.Context, limit int, offset int, tx ...db.Transaction) ([]inventory.Product, error) {
+		list := make([]inventory.Product, 0, len(m.products))
+		for _, p := range m.products {
+			list = append(list, p)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Sku < list[j].Sku })
+		if offset >= len(list) {
+			return []inventory.Product{}, nil
+		}
+		return list[offset:], nil
+	}
+	m.repo.GetBomFunc = func(ctx context.Context, sku string, version int, tx ...db.Transaction) (inventory.BillOfMaterials, error) {
+		bom, ok := m.boms[sku]
+		if !ok {
+			return bom, sql.ErrNoRows
+		}
+		return bom, nil
+	}
+	m.repo.SaveBomFunc = func(ctx context.Context, bom *inventory.BillOfMaterials, tx ...db.Transaction) error {
+		bom.Version = m.boms[bom.Sku].Version + 1
+		m.boms[bom.Sku] = *bom
+		return nil
+	}
+	m.repo.GetKitsContainingFunc = func(ctx context.Context, sku string, tx ...db.Transaction) ([]inventory.Product, error) {
+		kits := make([]inventory.Product, 0)
+		for _, bom := range m.boms {
+			for _, c := range bom.Components {
+				if kit := m.products[bom.Sku]; c.Sku == sku && kit.Kit {
+					kits = append(kits, kit)
+				}
+			}
+		}
+		return kits, nil
+	}
+	m.repo.SaveUnitsFunc = func(ctx context.Context, sku string, units inventory.Units, tx ...db.Transaction) error {
+		m.units[sku] = units
+		return nil
+	}
+	m.repo.GetUnitsFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Units, error) {
+		return m.units[sku], nil
+	}
+}
+
+func (m *memRepo) wireReservations() {
+	m.repo.SaveReservationFunc = func(ctx context.Context, r *inventory.Reservation, tx ...db.Transaction) error {
+		r.ID = uint64(len(m.reservations) + 1)
+		m.reservations[r.ID] = *r
+		return nil
+	}
+	m.repo.GetReservationFunc = func(ctx context.Context, ID uint64, tx ...db.Transaction) (inventory.Reservation, error) {
+		r, ok := m.reservations[ID]
+		if !ok {
+			return r, sql.ErrNoRows
+		}
+		return r, nil
+	}
+	m.repo.GetReservationByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (inventory.Reservation, error) {
+		found := m.findReservations(func(r inventory.Reservation) bool { return r.RequestID == requestID })
+		if len(found) == 0 {
+			return inventory.Reservation{}, sql.ErrNoRows
+		}
+		return found[0], nil
+	}
+	m.repo.GetSkuReservesByStateFunc = func(ctx context.Context, sku string, state inventory.ReserveState, limit, offset int, tx ...db.Transaction) ([]inventory.Reservation, error) {
+		return m.findReservations(func(r inventory.Reservation) bool { return r.Sku == sku && r.State == state }), nil
+	}
+	m.repo.GetComponentReservationsFunc = func(ctx context.Context, kitReservationID uint64, tx ...db.Transaction) ([]inventory.Reservation, error) {
+		return m.findReservations(func(r inventory.Reservation) bool { return r.KitReservationID == kitReservationID }), nil
+	}
+	m.repo.UpdateReservationFunc = func(ctx context.Context, ID uint64, state inventory.ReserveState, qty int64, txs ...db.Transaction) error {
+		r := m.reservations[ID]
+		r.State = state
+		r.ReservedQuantity = qty
+		m.reservations[ID] = r
+		return nil
+	}
+	m.repo.UpdateRequestedQuantityFunc = func(ctx context.Context, ID uint64, requested, shipped int64, txs ...db.Transaction) error {
+		r := m.reservations[ID]
+		r.RequestedQuantity = requested
+		r.ShippedQuantity = shipped
+		m.reservations[ID] = r
+		return nil
+	}
+	m.repo.UpdateBackorderFunc = func(ctx context.Context, ID uint64, estimatedFill *time.Time, txs ...db.Transaction) error {
+		r := m.reservations[ID]
+		r.Backordered = true
+		r.EstimatedFill = estimatedFill
+		m.reservations[ID] = r
+		return nil
+	}
+	m.repo.GetBackordersFunc = func(ctx context.Context, limit, offset int, txs ...db.Transaction) ([]inventory.Backorder, error) {
+		backordered := m.findReservations(func(r inventory.Reservation) bool {
+			return r.State == inventory.Open && r.Backordered
+		})
+		sort.SliceStable(backordered, func(i, j int) bool {
+			if backordered[i].Sku != backordered[j].Sku {
+				return backordered[i].Sku < backordered[j].Sku
+			}
+			return backordered[i].Requester < backordered[j].Requester
+		})
+		list := make([]inventory.Backorder, 0)
+		for _, r := range backordered {
+			n := len(list) - 1
+			if n < 0 || list[n].Sku != r.Sku || list[n].Requester != r.Requester {
+				list = append(list, inventory.Backorder{Sku: r.Sku, Requester: r.Requester, Reservations: []uint64{}})
+				n++
+			}
+			list[n].Reservations = append(list[n].Reservations, r.ID)
+			list[n].Quantity += r.RequestedQuantity - r.ReservedQuantity
+		}
+		if offset >= len(list) {
+			return []inventory.Backorder{}, nil
+		}
+		list = list[offset:]
+		if limit > 0 && limit < len(list) {
+			list = list[:limit]
+		}
+		return list, nil
+	}
+}
+
+func (m *memRepo) wireProduction() {
+	m.repo.SaveProductionEventFunc = func(ctx context.Context, event *inventory.ProductionEvent, tx ...db.Transaction) error {
+		event.ID = uint64(len(m.events) + 1)
+		m.events[event.RequestID] = *event
+		return nil
+	}
+	m.repo.GetProductionEventByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (inventory.ProductionEvent, error) {
+		event, ok := m.events[requestID]
+		if !ok {
+			return event, sql.ErrNoRows
+		}
+		return event, nil
+	}
+	m.repo.SaveAdjustmentFunc = func(ctx context.Context, adj *inventory.Adjustment, tx ...db.Transaction) error {
+		m.adjustments[adj.RequestID] = *adj
+		return nil
+	}
+	m.repo.GetAdjustmentByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (inventory.Adjustment, error) {
+		adj, ok := m.adjustments[requestID]
+		if !ok {
+			return adj, sql.ErrNoRows
+		}
+		return adj, nil
+	}
+	m.repo.SaveStatusChangeFunc = func(ctx context.Context, change *inventory.StatusChange, tx ...db.Transaction) error {
+		m.changes[change.RequestID] = *change
+		return nil
+	}
+	m.repo.GetStatusChangeByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (inventory.StatusChange, error) {
+		change, ok := m.changes[requestID]
+		if !ok {
+			return change, sql.ErrNoRows
+		}
+		return change, nil
+	}
+	m.repo.SaveProductionOrderFunc = func(ctx context.Context, order *inventory.ProductionOrder, tx ...db.Transaction) error {
+		order.ID = uint64(len(m.productionOrders) + 1)
+		m.productionOrders[order.ID] = *order
+		return nil
+	}
+	m.repo.UpdateProductionOrderFunc = func(ctx context.Context, order inventory.ProductionOrder, tx ...db.Transaction) error {
+		m.productionOrders[order.ID] = order
+		return nil
+	}
+	m.repo.GetProductionOrderFunc = func(ctx context.Context, ID uint64, tx ...db.Transaction) (inventory.ProductionOrder, error) {
+		order, ok := m.productionOrders[ID]
+		if !ok {
+			return order, sql.ErrNoRows
+		}
+		return order, nil
+	}
+	m.repo.GetSkuProductionOrdersFunc = func(ctx context.Context, sku string, tx ...db.Transaction) ([]inventory.ProductionOrder, error) {
+		orders := make([]inventory.ProductionOrder, 0)
+		for _, o := range m.productionOrders {
+			if o.Sku == sku && o.Status != inventory.OrderCompleted && o.Status != inventory.OrderCancelled {
+				orders = append(orders, o)
+			}
+		}
+		sort.Slice(orders, func(i, j int) bool { return orders[i].ID < orders[j].ID })
+		sort.SliceStable(orders, func(i, j int) bool { return orders[i].Due.Before(orders[j].Due) })
+		return orders, nil
+	}
+}
+
+func (m *memRepo) wireSerials() {
+	m.repo.SaveSerialFunc = func(ctx context.Context, serial inventory.Serial, tx ...db.Transaction) error {
+		if _, ok := m.serials[serial.Serial]; ok {
+			return &inventory.Error{Kind: inventory.KindConflict, Code: inventory.CodeDuplicateSerial}
+		}
+		m.serials[serial.Serial] = serial
+		return nil
+	}
+	m.repo.UpdateSerialFunc = func(ctx context.Context, serial inventory.Serial, tx ...db.Transaction) error {
+		m.serials[serial.Serial] = serial
+		return nil
+	}
+	m.repo.GetSerialFunc = func(ctx context.Context, serial string, tx ...db.Transaction) (inventory.Serial, error) {
+		s, ok := m.serials[serial]
+		if !ok {
+			return s, sql.ErrNoRows
+		}
+		return s, nil
+	}
+	find := func(match func(s inventory.Serial) bool) []inventory.Serial {
+		found := make([]inventory.Serial, 0)
+		for _, s := range m.serials {
+			if match(s) {
+				found = append(found, s)
+			}
+		}
+		sort.Slice(found, func(i, j int) bool { return found[i].Serial < found[j].Serial })
+		return found
+	}
+	m.repo.GetAvailableSerialsFunc = func(ctx context.Context, sku string, limit int64, tx ...db.Transaction) ([]inventory.Serial, error) {
+		available := find(func(s inventory.Serial) bool { return s.Sku == sku && s.Status == inventory.SerialAvailable })
+		if int64(len(available)) > limit {
+			available = available[:limit]
+		}
+		return available, nil
+	}
+	m.repo.GetReservationSerialsFunc = func(ctx context.Context, reservationID uint64, tx ...db.Transaction) ([]inventory.Serial, error) {
+		return find(func(s inventory.Serial) bool { return s.ReservationID == reservationID }), nil
+	}
+	m.repo.GetProductionSerialsFunc = func(ctx context.Context, productionEventID uint64, tx ...db.Transaction) ([]inventory.Serial, error) {
+		return find(func(s inventory.Serial) bool { return s.ProductionEventID == productionEventID }), nil
+	}
+	m.repo.SaveSerialEventFunc = func(ctx context.Context, event *inventory.SerialEvent, tx ...db.Transaction) error {
+		m.serialEvents[event.Serial] = append(m.serialEvents[event.Serial], *event)
+		return nil
+	}
+	m.repo.GetSerialEventsFunc = func(ctx context.Context, serial string, tx ...db.Transaction) ([]inventory.SerialEvent, error) {
+		return m.serialEvents[serial], nil
+	}
+}
+
+func (m *memRepo) wireCounts() {
+	m.repo.SaveCycleCountFunc = func(ctx context.Context, count *inventory.CycleCount, tx ...db.Transaction) error {
+		count.ID = uint64(len(m.counts) + 1)
+		m.storeCount(*count)
+		return nil
+	}
+	m.repo.UpdateCycleCountFunc = func(ctx context.Context, count inventory.CycleCount, tx ...db.Transaction) error {
+		m.storeCount(count)
+		return nil
+	}
+	m.repo.GetCycleCountFunc = func(ctx context.Context, ID uint64, tx ...db.Transaction) (inventory.CycleCount, error) {
+		count, ok := m.counts[ID]
+		if !ok {
+			return count, sql.ErrNoRows
+		}
+		count.Lines = append([]inventory.CountLine(nil), count.Lines...)
+		return count, nil
+	}
+}
+
+// storeCount keeps a copy of the count so that changes to the caller's lines aren't saved until it is updated.
+func (m *memRepo) storeCount(count inventory.CycleCount) {
+	count.Lines = append([]inventory.CountLine(nil), count.Lines...)
+	m.counts[count.ID] = count
+}
+
+func (m *memRepo) wireReturns() {
+	m.repo.SaveReturnFunc = func(ctx context.Context, rma *inventory.Return, tx ...db.Transaction) error {
+		rma.ID = uint64(len(m.returns) + 1)
+		m.returns[rma.ID] = *rma
+		return nil
+	}
+	m.repo.UpdateReturnFunc = func(ctx context.Context, rma inventory.Return, tx ...db.Transaction) error {
+		m.returns[rma.ID] = rma
+		return nil
+	}
+	m.repo.GetReturnFunc = func(ctx context.Context, ID uint64, tx ...db.Transaction) (inventory.Return, error) {
+		rma, ok := m.returns[ID]
+		if !ok {
+			return rma, sql.ErrNoRows
+		}
+		return rma, nil
+	}
+	m.repo.GetReturnByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (inventory.Return, error) {
+		for _, rma := range m.returns {
+			if rma.RequestID == requestID {
+				return rma, nil
+			}
+		}
+		return inventory.Return{}, sql.ErrNoRows
+	}
+	m.repo.GetReturnedQuantityFunc = func(ctx context.Context, reservationID uint64, tx ...db.Transaction) (int64, error) {
+		var quantity int64
+		for _, rma := range m.returns {
+			if rma.ReservationID == reservationID {
+				quantity += rma.Quantity
+			}
+		}
+		return quantity, nil
+	}
+	m.repo.SaveReturnReceiptFunc = func(ctx context.Context, receipt *inventory.ReturnReceipt, tx ...db.Transaction) error {
+		receipt.ID = uint64(len(m.returnReceipts) + 1)
+		m.returnReceipts[receipt.RequestID] = *receipt
+		return nil
+	}
+	m.repo.GetReturnReceiptByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (inventory.ReturnReceipt, error) {
+		receipt, ok := m.returnReceipts[requestID]
+		if !ok {
+			return receipt, sql.ErrNoRows
+		}
+		return receipt, nil
+	}
+}
+
+func (m *memRepo) wireSalesOrders() {
+	m.repo.SaveSalesOrderFunc = func(ctx context.Context, order *inventory.SalesOrder, tx ...db.Transaction) error {
+		order.ID = uint64(len(m.salesOrders) + 1)
+		m.storeSalesOrder(*order)
+		return nil
+	}
+	m.repo.UpdateSalesOrderFunc = func(ctx context.Context, order inventory.SalesOrder, tx ...db.Transaction) error {
+		m.storeSalesOrder(order)
+		return nil
+	}
+	m.repo.GetSalesOrderFunc = func(ctx context.Context, ID uint64, tx ...db.Transaction) (inventory.SalesOrder, error) {
+		order, ok := m.salesOrders[ID]
+		if !ok {
+			return order, sql.ErrNoRows
+		}
+		order.Lines = append([]inventory.SalesOrderLine(nil), order.Lines...)
+		return order, nil
+	}
+	m.repo.GetSalesOrderByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (inventory.SalesOrder, error) {
+		for _, order := range m.salesOrders {
+			if order.RequestID == requestID {
+				order.Lines = append([]inventory.SalesOrderLine(nil), order.Lines...)
+				return order, nil
+			}
+		}
+		return inventory.SalesOrder{}, sql.ErrNoRows
+	}
+	m.repo.GetActiveSalesOrdersFunc = func(ctx context.Context, sku string, tx ...db.Transaction) ([]inventory.SalesOrder, error) {
+		list := make([]inventory.SalesOrder, 0)
+		for _, order := range m.salesOrders {
+			if order.Status == inventory.SalesOrderAllocated {
+				continue
+			}
+			for _, l := range order.Lines {
+				if l.Sku == sku {
+					order.Lines = append([]inventory.SalesOrderLine(nil), order.Lines...)
+					list = append(list, order)
+					break
+				}
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+		return list, nil
+	}
+}
+
+// storeSalesOrder keeps a copy of the order so that changes to the caller's lines aren't saved until it is updated.
+func (m *memRepo) storeSalesOrder(order inventory.SalesOrder) {
+	order.Lines = append([]inventory.SalesOrderLine(nil), order.Lines...)
+	m.salesOrders[order.ID] = order
+}
+
+func (m *memRepo) wireTransfers() {
+	m.repo.SaveLocationFunc = func(ctx context.Context, loc *inventory.Location, tx ...db.Transaction) error {
+		m.locations[loc.Code] = *loc
+		return nil
+	}
+	m.repo.GetLocationFunc = func(ctx context.Context, code string, tx ...db.Transaction) (inventory.Location, error) {
+		loc, ok := m.locations[code]
+		if !ok {
+			return loc, sql.ErrNoRows
+		}
+		return loc, nil
+	}
+	m.repo.GetLocationsFunc = func(ctx context.Context, tx ...db.Transaction) ([]inventory.Location, error) {
+		list := make([]inventory.Location, 0, len(m.locations))
+		for _, loc := range m.locations {
+			list = append(list, loc)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Code < list[j].Code })
+		return list, nil
+	}
+	m.repo.GetLocationStockFunc = func(ctx context.Context, sku string, tx ...db.Transaction) ([]inventory.LocationStock, error) {
+		stock := make([]inventory.LocationStock, 0)
+		for code, available := range m.locationStock[sku] {
+			stock = append(stock, inventory.LocationStock{Sku: sku, Location: code, Available: available})
+		}
+		sort.Slice(stock, func(i, j int) bool { return stock[i].Location < stock[j].Location })
+		return stock, nil
+	}
+	m.repo.AddLocationStockFunc = func(ctx context.Context, sku, location string, qty int64, tx ...db.Transaction) error {
+		if m.locationStock[sku] == nil {
+			m.locationStock[sku] = map[string]int64{}
+		}
+		m.locationStock[sku][location] += qty
+		return nil
+	}
+	m.repo.SaveTransferFunc = func(ctx context.Context, transfer *inventory.Transfer, tx ...db.Transaction) error {
+		transfer.ID = uint64(len(m.transfers) + 1)
+		m.transfers[transfer.ID] = *transfer
+		return nil
+	}
+	m.repo.UpdateTransferFunc = func(ctx context.Context, transfer inventory.Transfer, tx ...db.Transaction) error {
+		transfer.Receipts = m.transfers[transfer.ID].Receipts
+		m.transfers[transfer.ID] = transfer
+		return nil
+	}
+	m.repo.GetTransferFunc = func(ctx context.Context, ID uint64, tx ...db.Transaction) (inventory.Transfer, error) {
+		transfer, ok := m.transfers[ID]
+		if !ok {
+			return transfer, sql.ErrNoRows
+		}
+		return transfer, nil
+	}
+	m.repo.GetTransferByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (inventory.Transfer, error) {
+		for _, transfer := range m.transfers {
+			if transfer.RequestID == requestID {
+				return transfer, nil
+			}
+		}
+		return inventory.Transfer{}, sql.ErrNoRows
+	}
+	m.repo.SaveTransferReceiptFunc = func(ctx context.Context, receipt *inventory.TransferReceipt, tx ...db.Transaction) error {
+		receipt.ID = uint64(len(m.transferReceipts) + 1)
+		m.transferReceipts[receipt.RequestID] = *receipt
+		transfer := m.transfers[receipt.TransferID]
+		transfer.Receipts = append(append([]inventory.TransferReceipt(nil), transfer.Receipts...), *receipt)
+		m.transfers[receipt.TransferID] = transfer
+		return nil
+	}
+	m.repo.GetTransferReceiptByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (inventory.TransferReceipt, error) {
+		receipt, ok := m.transferReceipts[requestID]
+		if !ok {
+			return receipt, sql.ErrNoRows
+		}
+		return receipt, nil
+	}
+}
+
+// memQueue keeps every message published, by exchange.
+type memQueue struct {
+	queue    inventory.MockQueue
+	messages map[string][][]byte
+}
+
+func newMemQueue() *memQueue {
+	q := &memQueue{queue: inventory.NewMockQueue(), messages: map[string][][]byte{}}
+	q.queue.PublishFunc = func(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error {
+		q.messages[exchange] = append(q.messages[exchange], body)
+		return nil
+	}
+	return q
+}
+
+// published decodes the messages published to the exchange into v, a pointer to a slice.
+func (q *memQueue) published(t *testing.T, exchange string, v interface{}) {
+	t.Helper()
+	list := bytes.Join(q.messages[exchange], []byte(","))
+	if err := json.Unmarshal(append(append([]byte("["), list...), ']'), v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// call sends body to the test server as json and decodes a successful response into v if it is given. The response
+// is returned with its body already closed.
+func call(t *testing.T, ts *httptest.Server, method, url string, body, v interface{}) *http.Response {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, ts.URL+url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if v != nil && res.StatusCode < 300 {
+		if err = json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res
+}
+
+func TestProductModified(t *testing.T) {
+	tests := []struct {
+		name      string
+		stale     bool
+		status    int
+		available int64
+		version   int64
+	}{
+		{name: "saved over the version read", status: http.StatusCreated, available: 15, version: 4},
+		{name: "modified since it was read", stale: true, status: http.StatusConflict, available: 10, version: 4},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newMemRepo(inventory.Product{Sku: "VersionSKU", Upc: "6666666666", Name: "Versioned",
+				Available: 10, Version: 3})
+			get := m.repo.GetProductFunc
+			m.repo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+				p, err := get(ctx, sku, tx...)
+				if test.stale && err == nil {
+					stored := m.products[sku]
+					stored.Version++
+					m.products[sku] = stored
+				}
+				return p, err
+			}
+			ts := httptest.NewServer(testRouter(newMemQueue().queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			res := call(t, ts, http.MethodPost, "/inventory/v1/VersionSKU/productionEvent",
+				inventory.ProductionEvent{RequestID: "VersionRID", Quantity: 5}, nil)
+			if res.StatusCode != test.status {
+				t.Errorf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if p := m.products["VersionSKU"]; p.Available != test.available || p.Version != test.version {
+				t.Errorf("got available=%d version=%d want=%d/%d", p.Available, p.Version, test.available,
+					test.version)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/broker"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/inventory"
+)
+
+func TestProductionOrderLifecycle(t *testing.T) {
+	var stored inventory.ProductionOrder
+	mockRepo := inventory.NewMockRepo()
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return testProducts[0], nil
+	}
+	mockRepo.GetProductionEventByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (pe inventory.ProductionEvent, err error) {
+		return pe, sql.ErrNoRows
+	}
+	mockRepo.SaveProductionOrderFunc = func(ctx context.Context, order *inventory.ProductionOrder, tx ...db.Transaction) error {
+		order.ID = 7
+		stored = *order
+		return nil
+	}
+	mockRepo.GetProductionOrderFunc = func(ctx context.Context, ID uint64, tx ...db.Transaction) (inventory.ProductionOrder, error) {
+		if ID != stored.ID {
+			return inventory.ProductionOrder{}, sql.ErrNoRows
+		}
+		return stored, nil
+	}
+	mockRepo.UpdateProductionOrderFunc = func(ctx context.Context, order inventory.ProductionOrder, tx ...db.Transaction) error {
+		stored = order
+		return nil
+	}
+
+	var published []inventory.ProductionOrderEvent
+	mockQueue := inventory.NewMockQueue()
+	mockQueue.PublishFunc = func(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error {
+		if exchange != testExchanges.Order {
+			return nil
+		}
+		event := inventory.ProductionOrderEvent{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatal(err)
+		}
+		published = append(published, event)
+		return nil
+	}
+
+	ts := httptest.NewServer(testRouter(mockQueue, mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+	base := ts.URL + "/inventory/v1/TestOneSKU/productionOrder"
+
+	post := func(url string, body interface{}) *http.Response {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.Post(url, "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		return res
+	}
+	produce := func(requestID string, qty int64) *http.Response {
+		return post(ts.URL+"/inventory/v1/TestOneSKU/productionEvent",
+			inventory.ProductionEvent{RequestID: requestID, Quantity: qty, ProductionOrderID: 7})
+	}
+	status := func(s inventory.OrderStatus) *http.Response {
+		return post(fmt.Sprintf("%s/%d/status", base, 7), inventory.ProductionOrderStatusRequest{Status: s})
+	}
+
+	res := post(base, inventory.ProductionOrder{TargetQuantity: 10, Due: time.Now().Add(24 * time.Hour)})
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("create status got=%d want=%d", res.StatusCode, http.StatusCreated)
+	}
+	if stored.Status != inventory.OrderPlanned || stored.Sku != "TestOneSKU" {
+		t.Errorf("created order got=%+v", stored)
+	}
+
+	if res = produce("OrderRID1", 6); res.StatusCode != http.StatusConflict {
+		t.Errorf("produce planned order status got=%d want=%d", res.StatusCode, http.StatusConflict)
+	}
+	if res = status(inventory.OrderCompleted); res.StatusCode != http.StatusConflict {
+		t.Errorf("complete planned order status got=%d want=%d", res.StatusCode, http.StatusConflict)
+	}
+	if res = status(inventory.OrderReleased); res.StatusCode != http.StatusOK {
+		t.Errorf("release status got=%d want=%d", res.StatusCode, http.StatusOK)
+	}
+
+	if res = produce("OrderRID2", 6); res.StatusCode != http.StatusCreated {
+		t.Errorf("produce status got=%d want=%d", res.StatusCode, http.StatusCreated)
+	}
+	if stored.Status != inventory.OrderPartiallyCompleted || stored.Produced != 6 {
+		t.Errorf("order after first production got=%s/%d want=%s/%d", stored.Status, stored.Produced,
+			inventory.OrderPartiallyCompleted, 6)
+	}
+	if res = produce("OrderRID3", 6); res.StatusCode != http.StatusCreated {
+		t.Errorf("produce status got=%d want=%d", res.StatusCode, http.StatusCreated)
+	}
+	if stored.Status != inventory.OrderCompleted || stored.Produced != 12 {
+		t.Errorf("order after second production got=%s/%d want=%s/%d", stored.Status, stored.Produced,
+			inventory.OrderCompleted, 12)
+	}
+
+	want := []inventory.OrderStatus{inventory.OrderPlanned, inventory.OrderReleased, inventory.OrderPartiallyCompleted,
+		inventory.OrderCompleted}
+	if len(published) != len(want) {
+		t.Fatalf("published events got=%d want=%d", len(published), len(want))
+	}
+	for i, s := range want {
+		if published[i].Status != s {
+			t.Errorf("published[%d] status got=%s want=%s", i, published[i].Status, s)
+		}
+	}
+	last := published[len(published)-1]
+	if last.PreviousStatus != inventory.OrderPartiallyCompleted {
+		t.Errorf("previous status got=%s want=%s", last.PreviousStatus, inventory.OrderPartiallyCompleted)
+	}
+	if last.Completion != 120 || last.Variance != 2 {
+		t.Errorf("completion got=%f/%d want=%d/%d", last.Completion, last.Variance, 120, 2)
+	}
+}
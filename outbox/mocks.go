@@ -0,0 +1,41 @@
+package outbox
+
+import (
+	"context"
+
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+type MockRepo struct {
+	SaveMessageFunc        func(ctx context.Context, msg *Message, tx ...db.Transaction) error
+	GetPendingMessagesFunc func(ctx context.Context, limit int, tx ...db.Transaction) ([]Message, error)
+	DeleteMessageFunc      func(ctx context.Context, ID uint64, tx ...db.Transaction) error
+	GetBacklogFunc         func(ctx context.Context, tx ...db.Transaction) (Backlog, error)
+}
+
+func (r MockRepo) SaveMessage(ctx context.Context, msg *Message, tx ...db.Transaction) error {
+	return r.SaveMessageFunc(ctx, msg, tx...)
+}
+
+func (r MockRepo) GetPendingMessages(ctx context.Context, limit int, tx ...db.Transaction) ([]Message, error) {
+	return r.GetPendingMessagesFunc(ctx, limit, tx...)
+}
+
+func (r MockRepo) DeleteMessage(ctx context.Context, ID uint64, tx ...db.Transaction) error {
+	return r.DeleteMessageFunc(ctx, ID, tx...)
+}
+
+func (r MockRepo) GetBacklog(ctx context.Context, tx ...db.Transaction) (Backlog, error) {
+	return r.GetBacklogFunc(ctx, tx...)
+}
+
+func NewMockRepo() MockRepo {
+	return MockRepo{
+		SaveMessageFunc: func(ctx context.Context, msg *Message, tx ...db.Transaction) error { return nil },
+		GetPendingMessagesFunc: func(ctx context.Context, limit int, tx ...db.Transaction) ([]Message, error) {
+			return nil, nil
+		},
+		DeleteMessageFunc: func(ctx context.Context, ID uint64, tx ...db.Transaction) error { return nil },
+		GetBacklogFunc:    func(ctx context.Context, tx ...db.Transaction) (Backlog, error) { return Backlog{}, nil },
+	}
+}
@@ -0,0 +1,152 @@
+// Package outbox keeps published messages in the database until they have been relayed to the broker. Publishing
+// only stores the message, so a broker outage doesn't hold up requests, and a message is only removed once the
+// broker has taken it, so nothing published is lost across a restart.
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/broker"
+)
+
+// relayBatch is the most messages read from the outbox at a time.
+const relayBatch = 100
+
+// Message is an entity. A message waiting to be relayed to the broker.
+type Message struct {
+	ID          uint64                 `json:"id"`
+	Exchange    string                 `json:"exchange"`
+	RoutingKey  string                 `json:"routingKey"`
+	ContentType string                 `json:"contentType"`
+	Headers     map[string]interface{} `json:"headers,omitempty"`
+	Body        []byte                 `json:"body"`
+	Created     time.Time              `json:"created"`
+}
+
+// Backlog is a value object. How far behind the relay is.
+type Backlog struct {
+	Pending int64      `json:"pending"`
+	Oldest  *time.Time `json:"oldest,omitempty"`
+}
+
+// Outbox stores published messages and relays them to the broker in the order they were published.
+type Outbox struct {
+	repo      Repository
+	publisher broker.Publisher
+	wake      chan struct{}
+}
+
+func New(repo Repository, publisher broker.Publisher) *Outbox {
+	return &Outbox{repo: repo, publisher: publisher, wake: make(chan struct{}, 1)}
+}
+
+// Publish stores the message for the relay to send and wakes it up.
+func (o *Outbox) Publish(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error {
+	m := broker.NewMessage(body, options...)
+	msg := &Message{
+		Exchange:    exchange,
+		RoutingKey:  m.RoutingKey,
+		ContentType: m.ContentType,
+		Headers:     m.Headers,
+		Body:        m.Body,
+		Created:     time.Now(),
+	}
+	if err := o.repo.SaveMessage(ctx, msg); err != nil {
+		return errors.WithMessage(err, "failed to save message to the outbox")
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Relay sends stored messages to the broker as soon as they are published, and retries every interval, until the
+// context is cancelled.
+func (o *Outbox) Relay(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := o.relay(ctx); err != nil {
+			log.Warn().Err(err).Msg("failed to relay the outbox, retrying")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush relays every stored message, stopping at the first one the broker doesn't take. It is meant for shutdown,
+// after everything that publishes has stopped.
+func (o *Outbox) Flush(ctx context.Context) error {
+	for {
+		n, err := o.relay(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+}
+
+// relay sends one batch of messages oldest first and returns how many were sent. It stops at the first failure so
+// that messages are never sent out of order.
+func (o *Outbox) relay(ctx context.Context) (int, error) {
+	pending, err := o.repo.GetPendingMessages(ctx, relayBatch)
+	if err != nil {
+		return 0, errors.WithMessage(err, "failed to read the outbox")
+	}
+
+	for i, msg := range pending {
+		if err = ctx.Err(); err != nil {
+			return i, err
+		}
+		options := []broker.PublishOption{broker.RoutingKey(msg.RoutingKey), broker.ContentType(msg.ContentType)}
+		if len(msg.Headers) > 0 {
+			options = append(options, broker.Headers(msg.Headers))
+		}
+		if err = o.publisher.Publish(ctx, msg.Exchange, msg.Body, options...); err != nil {
+			return i, errors.WithMessagef(err, "failed to relay message %d", msg.ID)
+		}
+		if err = o.repo.DeleteMessage(ctx, msg.ID); err != nil {
+			return i, errors.WithMessagef(err, "failed to remove relayed message %d", msg.ID)
+		}
+	}
+	return len(pending), nil
+}
+
+// BacklogCheck reports how many messages are waiting in the outbox and how long the oldest has waited. The outbox is
+// reported as down once either goes over its limit, a limit of zero is not checked.
+func BacklogCheck(repo Repository, maxPending int64, maxAge time.Duration) func(ctx context.Context) (map[string]interface{}, error) {
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		backlog, err := repo.GetBacklog(ctx)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to read the outbox backlog")
+		}
+
+		details := map[string]interface{}{"pending": backlog.Pending}
+		var age time.Duration
+		if backlog.Oldest != nil {
+			age = time.Since(*backlog.Oldest)
+			details["oldestSeconds"] = age.Seconds()
+		}
+		if maxPending > 0 && backlog.Pending > maxPending {
+			return details, errors.Errorf("%d messages are waiting in the outbox, more than %d", backlog.Pending,
+				maxPending)
+		}
+		if maxAge > 0 && age > maxAge {
+			return details, errors.Errorf("oldest message has waited %s in the outbox, longer than %s",
+				age.Round(time.Second), maxAge)
+		}
+		return details, nil
+	}
+}
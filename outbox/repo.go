@@ -0,0 +1,131 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sksmith/smfg-inventory/db"
+)
+
+type Repository interface {
+	SaveMessage(ctx context.Context, msg *Message, tx ...db.Transaction) error
+	GetPendingMessages(ctx context.Context, limit int, tx ...db.Transaction) ([]Message, error)
+	DeleteMessage(ctx context.Context, ID uint64, tx ...db.Transaction) error
+	GetBacklog(ctx context.Context, tx ...db.Transaction) (Backlog, error)
+}
+
+type dbRepo struct {
+	conn db.Conn
+}
+
+func NewPostgresRepo(conn db.Conn) Repository {
+	return &dbRepo{
+		conn: conn,
+	}
+}
+
+func (d *dbRepo) SaveMessage(ctx context.Context, msg *Message, txs ...db.Transaction) error {
+	m := db.StartMetric("SaveOutboxMessage")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	var headers interface{}
+	if len(msg.Headers) > 0 {
+		raw, err := json.Marshal(msg.Headers)
+		if err != nil {
+			m.Complete(err)
+			return errors.WithMessage(err, "failed to serialize message headers")
+		}
+		headers = string(raw)
+	}
+
+	insert := `INSERT INTO outbox (exchange, routing_key, content_type, headers, body, created)
+                    VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`
+
+	err := tx.QueryRow(ctx, insert, msg.Exchange, msg.RoutingKey, msg.ContentType, headers, msg.Body,
+		msg.Created).Scan(&msg.ID)
+	m.Complete(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *dbRepo) GetPendingMessages(ctx context.Context, limit int, txs ...db.Transaction) ([]Message, error) {
+	m := db.StartMetric("GetPendingOutboxMessages")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	rows, err := tx.Query(ctx,
+		`SELECT id, exchange, routing_key, content_type, headers, body, created FROM outbox ORDER BY id LIMIT $1;`,
+		limit)
+	if err != nil {
+		m.Complete(err)
+		return nil, errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	msgs := make([]Message, 0)
+	for rows.Next() {
+		msg := Message{}
+		var headers []byte
+		err = rows.Scan(&msg.ID, &msg.Exchange, &msg.RoutingKey, &msg.ContentType, &headers, &msg.Body, &msg.Created)
+		if err != nil {
+			m.Complete(err)
+			return nil, errors.WithStack(err)
+		}
+		if len(headers) > 0 {
+			if err = json.Unmarshal(headers, &msg.Headers); err != nil {
+				m.Complete(err)
+				return nil, errors.WithMessagef(err, "failed to read the headers of message %d", msg.ID)
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+
+	m.Complete(nil)
+	return msgs, nil
+}
+
+func (d *dbRepo) DeleteMessage(ctx context.Context, ID uint64, txs ...db.Transaction) error {
+	m := db.StartMetric("DeleteOutboxMessage")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	_, err := tx.Exec(ctx, `DELETE FROM outbox WHERE id = $1;`, ID)
+	m.Complete(err)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (d *dbRepo) GetBacklog(ctx context.Context, txs ...db.Transaction) (Backlog, error) {
+	m := db.StartMetric("GetOutboxBacklog")
+	ctx = m.StartSpan(ctx)
+	tx := d.conn
+	if len(txs) > 0 {
+		tx = txs[0]
+	}
+
+	backlog := Backlog{}
+	var oldest *time.Time
+	err := tx.QueryRow(ctx, `SELECT count(*), min(created) FROM outbox;`).Scan(&backlog.Pending, &oldest)
+	m.Complete(err)
+	if err != nil {
+		return backlog, errors.WithStack(err)
+	}
+	backlog.Oldest = oldest
+	return backlog, nil
+}
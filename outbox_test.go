@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sksmith/smfg-inventory/broker"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/outbox"
+	"github.com/streadway/amqp"
+)
+
+// newMemOutbox keeps the outbox in memory and relays it on channel.
+func newMemOutbox(channel broker.Channel) (map[uint64]outbox.Message, *outbox.Outbox) {
+	stored := map[uint64]outbox.Message{}
+	var next uint64
+	repo := outbox.NewMockRepo()
+	repo.SaveMessageFunc = func(ctx context.Context, msg *outbox.Message, tx ...db.Transaction) error {
+		next++
+		msg.ID = next
+		stored[msg.ID] = *msg
+		return nil
+	}
+	repo.GetPendingMessagesFunc = func(ctx context.Context, limit int, tx ...db.Transaction) ([]outbox.Message, error) {
+		pending := make([]outbox.Message, 0)
+		for _, msg := range stored {
+			pending = append(pending, msg)
+		}
+		sort.Slice(pending, func(i, j int) bool { return pending[i].ID < pending[j].ID })
+		if len(pending) > limit {
+			pending = pending[:limit]
+		}
+		return pending, nil
+	}
+	repo.DeleteMessageFunc = func(ctx context.Context, ID uint64, tx ...db.Transaction) error {
+		delete(stored, ID)
+		return nil
+	}
+	return stored, outbox.New(repo, broker.NewClient(channel))
+}
+
+// flakyChannel refuses every message after the first accepted ones.
+type flakyChannel struct {
+	recordingChannel
+	accept int
+}
+
+func (c *flakyChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	if len(c.published) >= c.accept {
+		return errors.New("broker is down")
+	}
+	return c.recordingChannel.Publish(exchange, key, mandatory, immediate, msg)
+}
+
+func TestOutboxFlush(t *testing.T) {
+	tests := []struct {
+		name    string
+		accept  int
+		fails   bool
+		sent    []string
+		pending int
+	}{
+		{name: "broker takes everything", accept: 3, sent: []string{"one", "two", "three"}},
+		{name: "broker goes down", accept: 1, fails: true, sent: []string{"one"}, pending: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			channel := &flakyChannel{accept: test.accept}
+			stored, box := newMemOutbox(channel)
+			ctx := context.Background()
+			for _, body := range []string{"one", "two", "three"} {
+				err := box.Publish(ctx, "inventory.fanout", []byte(body),
+					broker.Headers(map[string]interface{}{"traceparent": "trace-" + body}))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(channel.published) != 0 {
+				t.Fatalf("published before relaying got=%d want=%d", len(channel.published), 0)
+			}
+
+			if err := box.Flush(ctx); (err != nil) != test.fails {
+				t.Fatalf("flush error got=%v want failure=%t", err, test.fails)
+			}
+			if len(channel.published) != len(test.sent) {
+				t.Fatalf("sent got=%d want=%d", len(channel.published), len(test.sent))
+			}
+			for i, body := range test.sent {
+				msg := channel.published[i]
+				if string(msg.Body) != body || msg.Headers["traceparent"] != "trace-"+body ||
+					msg.ContentType != "application/json" {
+					t.Errorf("sent[%d] got=%s %v %s want=%s with its headers", i, msg.Body, msg.Headers,
+						msg.ContentType, body)
+				}
+			}
+			if len(stored) != test.pending {
+				t.Errorf("left in the outbox got=%d want=%d", len(stored), test.pending)
+			}
+		})
+	}
+}
+
+func TestBacklogCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		backlog outbox.Backlog
+		down    bool
+	}{
+		{name: "empty", backlog: outbox.Backlog{}},
+		{name: "keeping up", backlog: outbox.Backlog{Pending: 10, Oldest: ago(time.Second)}},
+		{name: "too many waiting", backlog: outbox.Backlog{Pending: 11, Oldest: ago(time.Second)}, down: true},
+		{name: "waited too long", backlog: outbox.Backlog{Pending: 1, Oldest: ago(2 * time.Minute)}, down: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := outbox.NewMockRepo()
+			repo.GetBacklogFunc = func(ctx context.Context, tx ...db.Transaction) (outbox.Backlog, error) {
+				return test.backlog, nil
+			}
+
+			details, err := outbox.BacklogCheck(repo, 10, time.Minute)(context.Background())
+			if (err != nil) != test.down {
+				t.Errorf("error got=%v want down=%t", err, test.down)
+			}
+			if details["pending"] != test.backlog.Pending {
+				t.Errorf("pending got=%v want=%d", details["pending"], test.backlog.Pending)
+			}
+		})
+	}
+}
+
+func ago(d time.Duration) *time.Time {
+	t := time.Now().Add(-d)
+	return &t
+}
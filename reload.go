@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+const configFilePollInterval = 5 * time.Second
+
+// watchConfig reloads the configuration whenever the config file changes and on every config server refresh, then
+// applies the runtime settings. A configuration that fails validation is rejected and the current settings are kept.
+func watchConfig(ctx context.Context, args []string, store *settings.Store) {
+	var poll, refresh <-chan time.Time
+	if config.ConfigFile != "" {
+		t := time.NewTicker(configFilePollInterval)
+		defer t.Stop()
+		poll = t.C
+	}
+	if config.ConfigServerUrl != "" && config.ConfigRefresh > 0 {
+		t := time.NewTicker(config.ConfigRefresh)
+		defer t.Stop()
+		refresh = t.C
+	}
+
+	modTime := fileModTime(config.ConfigFile)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll:
+			m := fileModTime(config.ConfigFile)
+			if m.Equal(modTime) {
+				continue
+			}
+			modTime = m
+			log.Info().Str("file", config.ConfigFile).Msg("config file changed")
+		case <-refresh:
+		}
+
+		reloadConfig(args, config, store)
+	}
+}
+
+// reloadConfig loads the configuration again and applies the runtime settings. Anything else that changed is reported
+// since it only takes effect after a restart.
+func reloadConfig(args []string, running *AppConfig, store *settings.Store) error {
+	next, err := loadConfigs(args, true)
+	if err != nil {
+		log.Error().Err(err).Msg("rejected configuration reload, keeping current settings")
+		return err
+	}
+
+	if keys := running.restartRequired(next); len(keys) > 0 {
+		log.Warn().Strs("settings", keys).Msg("changed settings require a restart to take effect")
+	}
+	store.Update(next.RuntimeSettings())
+	return nil
+}
+
+func fileModTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/inventory"
+	"github.com/sksmith/smfg-inventory/settings"
+)
+
+var testReplenishmentParams = inventory.ReplenishmentParams{
+	WindowDays:          10,
+	DefaultLeadTimeDays: 4,
+	ServiceLevelZ:       1.65,
+	OrderCost:           50,
+	HoldingCost:         1,
+}
+
+func dailyDemand(first time.Time, quantities ...int64) []inventory.DailyQuantity {
+	demand := make([]inventory.DailyQuantity, 0, len(quantities))
+	for i, q := range quantities {
+		demand = append(demand, inventory.DailyQuantity{Day: first.AddDate(0, 0, i), Quantity: q})
+	}
+	return demand
+}
+
+// completedOrder is a production order created at created that took days to complete.
+func completedOrder(created time.Time, days int) inventory.ProductionOrder {
+	return inventory.ProductionOrder{Status: inventory.OrderCompleted, Created: created,
+		Updated: created.AddDate(0, 0, days)}
+}
+
+func TestCalculateReplenishment(t *testing.T) {
+	now := time.Date(2021, 3, 11, 15, 0, 0, 0, time.UTC)
+	first := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		product   inventory.Product
+		backlog   int64
+		demand    []inventory.DailyQuantity
+		completed []inventory.ProductionOrder
+		want      inventory.Replenishment
+	}{
+		{
+			name:    "steady demand below reorder point",
+			product: inventory.Product{Sku: "Steady", Available: 50},
+			backlog: 20,
+			demand: append(dailyDemand(first, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10),
+				// outside the window, the day before it starts and today
+				inventory.DailyQuantity{Day: first.AddDate(0, 0, -1), Quantity: 1000},
+				inventory.DailyQuantity{Day: now, Quantity: 1000}),
+			completed: []inventory.ProductionOrder{
+				completedOrder(time.Date(2021, 2, 27, 9, 0, 0, 0, time.UTC), 7),
+				completedOrder(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), 8),
+				completedOrder(time.Date(2021, 3, 3, 12, 0, 0, 0, time.UTC), 6),
+				// completed before the window starts
+				completedOrder(time.Date(2021, 1, 20, 9, 0, 0, 0, time.UTC), 30),
+			},
+			want: inventory.Replenishment{
+				AverageDailyDemand:    10,
+				LeadTimeDays:          7,
+				ReorderPoint:          70,
+				EconomicOrderQuantity: 605,
+				InventoryPosition:     30,
+				SuggestedQuantity:     645,
+			},
+		},
+		{
+			name:    "variable demand above reorder point",
+			product: inventory.Product{Sku: "Variable", Available: 200, ReorderQuantity: 100},
+			demand:  dailyDemand(first, 0, 20, 0, 20, 0, 20, 0, 20, 0, 20),
+			completed: []inventory.ProductionOrder{{Status: inventory.OrderCancelled,
+				Created: time.Date(2021, 3, 2, 9, 0, 0, 0, time.UTC), Updated: time.Date(2021, 3, 6, 9, 0, 0, 0, time.UTC)}},
+			want: inventory.Replenishment{
+				AverageDailyDemand:    10,
+				DemandStdDev:          10,
+				LeadTimeDays:          4,
+				SafetyStock:           33,
+				ReorderPoint:          73,
+				EconomicOrderQuantity: 605,
+				InventoryPosition:     200,
+			},
+		},
+		{
+			name:    "product thresholds",
+			product: inventory.Product{Sku: "Thresholds", Available: 5, SafetyStock: 15, ReorderQuantity: 40},
+			demand:  dailyDemand(first.AddDate(0, 0, 5), 4, 4, 4, 4, 4),
+			want: inventory.Replenishment{
+				AverageDailyDemand:    2,
+				DemandStdDev:          2,
+				LeadTimeDays:          4,
+				SafetyStock:           15,
+				ReorderPoint:          23,
+				EconomicOrderQuantity: 271,
+				InventoryPosition:     5,
+				SuggestedQuantity:     58,
+			},
+		},
+		{
+			name:    "no demand",
+			product: inventory.Product{Sku: "Idle", Available: 0},
+			want:    inventory.Replenishment{LeadTimeDays: 4},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := inventory.CalculateReplenishment(test.product, test.backlog, test.demand, test.completed, now,
+				testReplenishmentParams)
+
+			want := test.want
+			want.Sku = test.product.Sku
+			want.WindowDays = testReplenishmentParams.WindowDays
+			want.Calculated = now
+			if got != want {
+				t.Errorf("replenishment\n got=%+v\nwant=%+v", got, want)
+			}
+
+			// the same history gives the same suggestion at any time of the day
+			later := inventory.CalculateReplenishment(test.product, test.backlog, test.demand, test.completed,
+				now.Add(8*time.Hour), testReplenishmentParams)
+			later.Calculated = now
+			if later != got {
+				t.Errorf("replenishment later in the day\n got=%+v\nwant=%+v", later, got)
+			}
+		})
+	}
+}
+
+func TestGetReplenishment(t *testing.T) {
+	var since, until time.Time
+	mockRepo := inventory.NewMockRepo()
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return testProducts[0], nil
+	}
+	mockRepo.GetDailyDemandFunc = func(ctx context.Context, sku string, s, u time.Time, tx ...db.Transaction) ([]inventory.DailyQuantity, error) {
+		since, until = s, u
+		return dailyDemand(s, 10, 10, 10, 10, 10, 10, 10, 10, 10, 10), nil
+	}
+	mockRepo.GetSkuBacklogFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (int64, error) {
+		return 20, nil
+	}
+
+	store := settings.NewStore(settings.Settings{
+		AllocationPolicy:        settings.AllocateFifo,
+		ReplenishmentWindowDays: testReplenishmentParams.WindowDays,
+		DefaultLeadTimeDays:     testReplenishmentParams.DefaultLeadTimeDays,
+		ServiceLevelZ:           testReplenishmentParams.ServiceLevelZ,
+		OrderCost:               testReplenishmentParams.OrderCost,
+		HoldingCost:             testReplenishmentParams.HoldingCost,
+	})
+	ts := httptest.NewServer(testRouterWithSettings(inventory.NewMockQueue(), mockRepo, audit.NewMockRepo(), store))
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/inventory/v1/TestOneSKU/replenishment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status got=%d want=%d", res.StatusCode, http.StatusOK)
+	}
+	if got := until.Sub(since); got != 10*24*time.Hour {
+		t.Errorf("demand window got=%s want=%s", got, 10*24*time.Hour)
+	}
+
+	got := inventory.Replenishment{}
+	if err = json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Sku != testProducts[0].Sku {
+		t.Errorf("sku got=%s want=%s", got.Sku, testProducts[0].Sku)
+	}
+	if got.AverageDailyDemand != 10 {
+		t.Errorf("average daily demand got=%f want=%d", got.AverageDailyDemand, 10)
+	}
+	if got.InventoryPosition != 30 {
+		t.Errorf("inventory position got=%d want=%d", got.InventoryPosition, 30)
+	}
+	if got.SuggestedQuantity != 40+605-30 {
+		t.Errorf("suggested quantity got=%d want=%d", got.SuggestedQuantity, 40+605-30)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/inventory"
+)
+
+func TestChangeReservation(t *testing.T) {
+	tests := []struct {
+		name        string
+		stock       inventory.Product
+		reservation inventory.Reservation
+		waiting     bool
+		body        map[string]interface{}
+		status      int
+		state       inventory.ReserveState
+		reserved    int64
+		shipped     int64
+		available   int64
+		stockRes    int64
+	}{
+		{
+			name:        "decrease below reserved closes it and fills the next",
+			stock:       inventory.Product{Reserved: 4},
+			reservation: inventory.Reservation{State: inventory.Open, RequestedQuantity: 10, ReservedQuantity: 4},
+			waiting:     true,
+			body:        map[string]interface{}{"requestedQuantity": 2},
+			status:      http.StatusOK,
+			state:       inventory.Closed,
+			reserved:    2,
+			stockRes:    2,
+		},
+		{
+			name:        "decrease open",
+			stock:       inventory.Product{Reserved: 2},
+			reservation: inventory.Reservation{State: inventory.Open, RequestedQuantity: 5, ReservedQuantity: 2},
+			body:        map[string]interface{}{"requestedQuantity": 1},
+			status:      http.StatusOK,
+			state:       inventory.Closed,
+			reserved:    1,
+			available:   1,
+		},
+		{
+			name:        "increase closed reopens it",
+			stock:       inventory.Product{Available: 1},
+			reservation: inventory.Reservation{State: inventory.Closed, RequestedQuantity: 3, ReservedQuantity: 3},
+			body:        map[string]interface{}{"requestedQuantity": 5},
+			status:      http.StatusOK,
+			state:       inventory.Open,
+			reserved:    4,
+			shipped:     3,
+			stockRes:    1,
+		},
+		{
+			name:  "below shipped",
+			stock: inventory.Product{Reserved: 1},
+			reservation: inventory.Reservation{State: inventory.Open, RequestedQuantity: 5, ReservedQuantity: 4,
+				ShippedQuantity: 3},
+			body:     map[string]interface{}{"requestedQuantity": 2},
+			status:   http.StatusConflict,
+			stockRes: 1,
+		},
+		{
+			name:        "decrease closed",
+			reservation: inventory.Reservation{State: inventory.Closed, RequestedQuantity: 2, ReservedQuantity: 2},
+			body:        map[string]interface{}{"requestedQuantity": 1},
+			status:      http.StatusConflict,
+		},
+		{
+			name:        "cancelled",
+			reservation: inventory.Reservation{State: inventory.Cancelled, RequestedQuantity: 2},
+			body:        map[string]interface{}{"requestedQuantity": 7},
+			status:      http.StatusConflict,
+		},
+		{
+			name:        "missing quantity",
+			reservation: inventory.Reservation{State: inventory.Open, RequestedQuantity: 2},
+			body:        map[string]interface{}{},
+			status:      http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.stock.Sku, test.stock.Upc, test.stock.Name = "ChangeSKU", "7777777777", "Changed"
+			m := newMemRepo(test.stock)
+			seeded := test.reservation
+			seeded.ID, seeded.RequestID, seeded.Requester, seeded.Sku = 1, "ChangeRID1", "First", "ChangeSKU"
+			m.addReservations(seeded)
+			if test.waiting {
+				m.addReservations(inventory.Reservation{ID: 2, RequestID: "ChangeRID2", Requester: "Second",
+					Sku: "ChangeSKU", State: inventory.Open, RequestedQuantity: 5})
+			}
+
+			var changes []inventory.ReservationChange
+			mockAudit := audit.NewMockRepo()
+			mockAudit.SaveEntryFunc = func(ctx context.Context, entry *audit.Entry, tx ...db.Transaction) error {
+				if entry.Action != audit.ChangeReservation {
+					return nil
+				}
+				change := inventory.ReservationChange{}
+				if err := json.Unmarshal(entry.Detail, &change); err != nil {
+					t.Fatal(err)
+				}
+				changes = append(changes, change)
+				return nil
+			}
+			q := newMemQueue()
+			ts := httptest.NewServer(testRouter(q.queue, m.repo, mockAudit))
+			defer ts.Close()
+
+			got := inventory.Reservation{}
+			res := call(t, ts, http.MethodPatch, "/inventory/v1/ChangeSKU/reservation/1", test.body, &got)
+			if res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if product := m.products["ChangeSKU"]; product.Available != test.available || product.Reserved != test.stockRes {
+				t.Errorf("product got=%d/%d want=%d/%d", product.Available, product.Reserved, test.available,
+					test.stockRes)
+			}
+			if test.status != http.StatusOK {
+				if len(changes) != 0 {
+					t.Errorf("audited changes got=%+v want none", changes)
+				}
+				return
+			}
+			if got.State != test.state || got.ReservedQuantity != test.reserved {
+				t.Errorf("response got=%s/%d want=%s/%d", got.State, got.ReservedQuantity, test.state, test.reserved)
+			}
+			requested := int64(test.body["requestedQuantity"].(int))
+			if r := m.reservations[1]; r.RequestedQuantity != requested || r.ShippedQuantity != test.shipped {
+				t.Errorf("reservation got=%d/%d want=%d/%d", r.RequestedQuantity, r.ShippedQuantity, requested,
+					test.shipped)
+			}
+			if len(changes) != 1 || changes[0].From != seeded.RequestedQuantity || changes[0].To != requested {
+				t.Errorf("audited changes got=%+v want %d to %d", changes, seeded.RequestedQuantity, requested)
+			}
+			var published []inventory.Reservation
+			q.published(t, testExchanges.Reservation, &published)
+			if len(published) == 0 {
+				t.Errorf("published reservations got none want the changed reservation")
+			}
+		})
+	}
+}
+
+func TestCancelChangedReservation(t *testing.T) {
+	tests := []struct {
+		name        string
+		stock       inventory.Product
+		reservation inventory.Reservation
+		available   int64
+	}{
+		{
+			name:        "open",
+			stock:       inventory.Product{Reserved: 2},
+			reservation: inventory.Reservation{State: inventory.Open, RequestedQuantity: 5, ReservedQuantity: 2},
+			available:   2,
+		},
+		{
+			name:  "reopened only releases what it hasn't shipped",
+			stock: inventory.Product{Reserved: 1},
+			reservation: inventory.Reservation{State: inventory.Open, RequestedQuantity: 5, ReservedQuantity: 4,
+				ShippedQuantity: 3},
+			available: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.stock.Sku, test.stock.Upc, test.stock.Name = "ChangeSKU", "7777777777", "Changed"
+			m := newMemRepo(test.stock)
+			seeded := test.reservation
+			seeded.ID, seeded.RequestID, seeded.Requester, seeded.Sku = 1, "ChangeRID1", "First", "ChangeSKU"
+			m.addReservations(seeded)
+			ts := httptest.NewServer(testRouter(newMemQueue().queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			r := call(t, ts, http.MethodDelete, "/inventory/v1/ChangeSKU/reservation/1", nil, nil)
+			if product := m.products["ChangeSKU"]; r.StatusCode != http.StatusOK || product.Available != test.available ||
+				product.Reserved != 0 {
+				t.Errorf("cancel got status=%d available=%d reserved=%d want=%d/%d/%d", r.StatusCode,
+					product.Available, product.Reserved, http.StatusOK, test.available, 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/inventory"
+)
+
+// newReturnRepo holds a shipped reservation and one waiting on stock of the same product.
+func newReturnRepo() *memRepo {
+	m := newMemRepo(inventory.Product{Sku: "ReturnSKU", Upc: "6666666666", Name: "Returned"})
+	m.addReservations(
+		inventory.Reservation{ID: 1, RequestID: "ShippedRID", Requester: "ReturnRequester", Sku: "ReturnSKU",
+			State: inventory.Closed, RequestedQuantity: 5, ReservedQuantity: 5},
+		inventory.Reservation{ID: 2, RequestID: "WaitingRID", Requester: "WaitingRequester", Sku: "ReturnSKU",
+			State: inventory.Open, RequestedQuantity: 3},
+	)
+	return m
+}
+
+func TestCreateReturn(t *testing.T) {
+	tests := []struct {
+		name     string
+		returned int64
+		rma      inventory.Return
+		status   int
+	}{
+		{
+			name:   "shipped reservation",
+			rma:    inventory.Return{RequestID: "RmaRID", ReservationID: 1, Quantity: 5, Reason: "wrong size"},
+			status: http.StatusCreated,
+		},
+		{
+			name:     "more than shipped",
+			returned: 5,
+			rma:      inventory.Return{RequestID: "RmaRID", ReservationID: 1, Quantity: 1, Reason: "again"},
+			status:   http.StatusConflict,
+		},
+		{
+			name:   "open reservation",
+			rma:    inventory.Return{RequestID: "RmaRID", ReservationID: 2, Quantity: 1, Reason: "not shipped"},
+			status: http.StatusConflict,
+		},
+		{
+			name:   "unknown reservation",
+			rma:    inventory.Return{RequestID: "RmaRID", ReservationID: 9, Quantity: 1, Reason: "unknown"},
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newReturnRepo()
+			if test.returned > 0 {
+				m.returns[1] = inventory.Return{ID: 1, RequestID: "ReturnedRID", ReservationID: 1,
+					Quantity: test.returned, Status: inventory.ReturnAuthorized}
+			}
+			q := newMemQueue()
+			ts := httptest.NewServer(testRouter(q.queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			got := inventory.Return{}
+			res := call(t, ts, http.MethodPost, "/inventory/v1/ReturnSKU/return", test.rma, &got)
+			if res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if test.status != http.StatusCreated {
+				return
+			}
+			if got.Requester != "ReturnRequester" || got.Status != inventory.ReturnAuthorized {
+				t.Errorf("return got=%s/%s want=%s/%s", got.Requester, got.Status, "ReturnRequester",
+					inventory.ReturnAuthorized)
+			}
+			var published []inventory.ReturnEvent
+			q.published(t, testExchanges.Return, &published)
+			if len(published) != 1 {
+				t.Errorf("published events got=%d want=%d", len(published), 1)
+			}
+		})
+	}
+}
+
+func TestReceiveReturn(t *testing.T) {
+	tests := []struct {
+		name       string
+		returnID   uint64
+		received   int64
+		receipt    inventory.ReturnReceipt
+		status     int
+		available  int64
+		quarantine int64
+		rmaStatus  inventory.ReturnStatus
+		waiting    int64
+	}{
+		{
+			name:      "restock fills the waiting reservation",
+			returnID:  1,
+			receipt:   inventory.ReturnReceipt{RequestID: "ReceiptRID", Quantity: 3, Disposition: inventory.DispositionRestock},
+			status:    http.StatusCreated,
+			rmaStatus: inventory.ReturnPartiallyReceived,
+			waiting:   3,
+		},
+		{
+			name:       "refurbish",
+			returnID:   1,
+			received:   3,
+			receipt:    inventory.ReturnReceipt{RequestID: "ReceiptRID", Quantity: 1, Disposition: inventory.DispositionRefurbish},
+			status:     http.StatusCreated,
+			quarantine: 1,
+			rmaStatus:  inventory.ReturnPartiallyReceived,
+		},
+		{
+			name:      "unknown disposition",
+			returnID:  1,
+			received:  3,
+			receipt:   inventory.ReturnReceipt{RequestID: "ReceiptRID", Quantity: 1, Disposition: "resell"},
+			status:    http.StatusBadRequest,
+			rmaStatus: inventory.ReturnPartiallyReceived,
+		},
+		{
+			name:      "scrap the last one",
+			returnID:  1,
+			received:  4,
+			receipt:   inventory.ReturnReceipt{RequestID: "ReceiptRID", Quantity: 1, Disposition: inventory.DispositionScrap},
+			status:    http.StatusCreated,
+			rmaStatus: inventory.ReturnReceived,
+		},
+		{
+			name:      "more than authorized",
+			returnID:  1,
+			received:  5,
+			receipt:   inventory.ReturnReceipt{RequestID: "ReceiptRID", Quantity: 1, Disposition: inventory.DispositionRestock},
+			status:    http.StatusConflict,
+			rmaStatus: inventory.ReturnReceived,
+		},
+		{
+			name:      "unknown return",
+			returnID:  2,
+			receipt:   inventory.ReturnReceipt{RequestID: "ReceiptRID", Quantity: 1, Disposition: inventory.DispositionRestock},
+			status:    http.StatusNotFound,
+			rmaStatus: inventory.ReturnAuthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newReturnRepo()
+			rma := inventory.Return{ID: 1, RequestID: "RmaRID", ReservationID: 1, Requester: "ReturnRequester",
+				Sku: "ReturnSKU", Quantity: 5, Received: test.received, Reason: "wrong size"}
+			rma.Status = inventory.ReturnAuthorized
+			if test.received == rma.Quantity {
+				rma.Status = inventory.ReturnReceived
+			} else if test.received > 0 {
+				rma.Status = inventory.ReturnPartiallyReceived
+			}
+			m.returns[1] = rma
+			q := newMemQueue()
+			ts := httptest.NewServer(testRouter(q.queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			url := "/inventory/v1/ReturnSKU/return/" + strconv.FormatUint(test.returnID, 10) + "/receipt"
+			if res := call(t, ts, http.MethodPost, url, test.receipt, nil); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			product := m.products["ReturnSKU"]
+			if product.Available != test.available || product.Quarantine != test.quarantine {
+				t.Errorf("available/quarantine got=%d/%d want=%d/%d", product.Available, product.Quarantine,
+					test.available, test.quarantine)
+			}
+			if got := m.returns[1].Status; got != test.rmaStatus {
+				t.Errorf("return status got=%s want=%s", got, test.rmaStatus)
+			}
+			if r := m.reservations[2]; r.ReservedQuantity != test.waiting {
+				t.Errorf("waiting reservation reserved got=%d want=%d", r.ReservedQuantity, test.waiting)
+			}
+
+			var published []inventory.ReturnEvent
+			q.published(t, testExchanges.Return, &published)
+			if test.status != http.StatusCreated {
+				if len(published) != 0 {
+					t.Errorf("published events got=%d want=%d", len(published), 0)
+				}
+				return
+			}
+			if len(published) != 1 {
+				t.Fatalf("published events got=%d want=%d", len(published), 1)
+			}
+			event := published[0]
+			if event.Receipt == nil || event.Receipt.Disposition != test.receipt.Disposition ||
+				event.Received != test.received+test.receipt.Quantity || event.Status != test.rmaStatus {
+				t.Errorf("event got=%+v want the %s receipt", event, test.receipt.Disposition)
+			}
+		})
+	}
+}
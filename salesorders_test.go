@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/inventory"
+)
+
+func newSalesOrderRepo() *memRepo {
+	return newMemRepo(
+		inventory.Product{Sku: "SoA", Upc: "8888888881", Name: "Order A", Available: 5},
+		inventory.Product{Sku: "SoB", Upc: "8888888882", Name: "Order B"},
+		inventory.Product{Sku: "SoKit", Upc: "8888888883", Name: "Order Kit", Kit: true},
+	)
+}
+
+func orderLines(quantities ...interface{}) []inventory.SalesOrderLine {
+	l := make([]inventory.SalesOrderLine, 0)
+	for i := 0; i < len(quantities); i += 2 {
+		l = append(l, inventory.SalesOrderLine{Sku: quantities[i].(string), Quantity: int64(quantities[i+1].(int))})
+	}
+	return l
+}
+
+var (
+	wholeOrder = inventory.SalesOrder{RequestID: "SoRID1", Requester: "Whole", Policy: inventory.AllOrNothing,
+		Lines: orderLines("SoA", 2, "SoB", 2)}
+	partialOrder = inventory.SalesOrder{RequestID: "SoRID2", Requester: "Partial", Policy: inventory.ShipPartial,
+		Lines: orderLines("SoA", 1, "SoB", 1)}
+)
+
+func TestCreateSalesOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		order      inventory.SalesOrder
+		status     int
+		want       inventory.SalesOrderStatus
+		availableA int64
+	}{
+		{
+			name:       "all or nothing short of one sku holds nothing",
+			order:      wholeOrder,
+			status:     http.StatusCreated,
+			want:       inventory.SalesOrderPending,
+			availableA: 5,
+		},
+		{
+			name:       "ship partial takes what it can",
+			order:      partialOrder,
+			status:     http.StatusCreated,
+			want:       inventory.SalesOrderPartiallyAllocated,
+			availableA: 4,
+		},
+		{
+			name: "duplicate sku",
+			order: inventory.SalesOrder{RequestID: "SoRID3", Requester: "Twice", Policy: inventory.AllOrNothing,
+				Lines: orderLines("SoA", 1, "SoA", 1)},
+			status:     http.StatusBadRequest,
+			availableA: 5,
+		},
+		{
+			name: "unknown policy",
+			order: inventory.SalesOrder{RequestID: "SoRID4", Requester: "Whenever", Policy: "backorder",
+				Lines: orderLines("SoA", 1)},
+			status:     http.StatusBadRequest,
+			availableA: 5,
+		},
+		{
+			name: "kit",
+			order: inventory.SalesOrder{RequestID: "SoRID5", Requester: "Kit", Policy: inventory.AllOrNothing,
+				Lines: orderLines("SoKit", 1)},
+			status:     http.StatusBadRequest,
+			availableA: 5,
+		},
+		{
+			name: "unknown sku",
+			order: inventory.SalesOrder{RequestID: "SoRID6", Requester: "Unknown", Policy: inventory.AllOrNothing,
+				Lines: orderLines("SoZ", 1)},
+			status:     http.StatusNotFound,
+			availableA: 5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newSalesOrderRepo()
+			q := newMemQueue()
+			ts := httptest.NewServer(testRouter(q.queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			got := inventory.SalesOrder{}
+			if res := call(t, ts, http.MethodPost, "/inventory/v1/salesOrder", test.order, &got); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if got.Status != test.want {
+				t.Errorf("order status got=%s want=%s", got.Status, test.want)
+			}
+			if a, b := m.products["SoA"], m.products["SoB"]; a.Available != test.availableA || b.Available != 0 {
+				t.Errorf("available got=%d/%d want=%d/%d", a.Available, b.Available, test.availableA, 0)
+			}
+			var published []inventory.SalesOrder
+			q.published(t, testExchanges.SalesOrder, &published)
+			if len(published) != 0 {
+				t.Errorf("published before allocation got=%d want=%d", len(published), 0)
+			}
+		})
+	}
+}
+
+func TestCreateSalesOrderInOneTransaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		saveErr error
+		status  int
+	}{
+		{name: "committed together", status: http.StatusCreated},
+		{name: "order not saved", saveErr: errors.New("connection lost"), status: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newSalesOrderRepo()
+			var txs []*recordingTx
+			m.repo.BeginTransactionFunc = func(ctx context.Context) (db.Transaction, error) {
+				tx := &recordingTx{}
+				txs = append(txs, tx)
+				return tx, nil
+			}
+			save := m.repo.SaveSalesOrderFunc
+			m.repo.SaveSalesOrderFunc = func(ctx context.Context, order *inventory.SalesOrder, tx ...db.Transaction) error {
+				if test.saveErr != nil {
+					return test.saveErr
+				}
+				return save(ctx, order, tx...)
+			}
+			ts := httptest.NewServer(testRouter(inventory.NewMockQueue(), m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			if res := call(t, ts, http.MethodPost, "/inventory/v1/salesOrder", partialOrder, nil); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if len(txs) != 1 || txs[0].committed == (test.saveErr != nil) || txs[0].rolledBack == (test.saveErr == nil) {
+				t.Errorf("transactions got=%d committed=%v want 1 committed=%v", len(txs),
+					len(txs) > 0 && txs[0].committed, test.saveErr == nil)
+			}
+		})
+	}
+}
+
+func TestAllocateSalesOrders(t *testing.T) {
+	tests := []struct {
+		name       string
+		produced   []int64
+		whole      inventory.SalesOrderStatus
+		partial    inventory.SalesOrderStatus
+		availableA int64
+		availableB int64
+		published  []uint64
+	}{
+		{
+			name:       "the open reservation of the ship partial order fills first",
+			produced:   []int64{2},
+			whole:      inventory.SalesOrderPending,
+			partial:    inventory.SalesOrderAllocated,
+			availableA: 4,
+			availableB: 1,
+			published:  []uint64{2},
+		},
+		{
+			name:       "the all or nothing order allocates every line at once",
+			produced:   []int64{2, 1},
+			whole:      inventory.SalesOrderAllocated,
+			partial:    inventory.SalesOrderAllocated,
+			availableA: 2,
+			published:  []uint64{2, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newSalesOrderRepo()
+			q := newMemQueue()
+			ts := httptest.NewServer(testRouter(q.queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			for _, order := range []inventory.SalesOrder{wholeOrder, partialOrder} {
+				if res := call(t, ts, http.MethodPost, "/inventory/v1/salesOrder", order, nil); res.StatusCode != http.StatusCreated {
+					t.Fatalf("create %s status got=%d want=%d", order.RequestID, res.StatusCode, http.StatusCreated)
+				}
+			}
+			for i, quantity := range test.produced {
+				event := inventory.ProductionEvent{RequestID: "SoProduce" + strconv.Itoa(i), Quantity: quantity}
+				res := call(t, ts, http.MethodPost, "/inventory/v1/SoB/productionEvent", event, nil)
+				if res.StatusCode != http.StatusCreated {
+					t.Fatalf("produce %d status got=%d want=%d", quantity, res.StatusCode, http.StatusCreated)
+				}
+			}
+
+			if whole, partial := m.salesOrders[1].Status, m.salesOrders[2].Status; whole != test.whole || partial != test.partial {
+				t.Errorf("order status got=%s/%s want=%s/%s", whole, partial, test.whole, test.partial)
+			}
+			if a, b := m.products["SoA"], m.products["SoB"]; a.Available != test.availableA || b.Available != test.availableB ||
+				a.Reserved != 0 || b.Reserved != 0 {
+				t.Errorf("available got=%d/%d reserved=%d/%d want=%d/%d reserved=%d/%d", a.Available, b.Available,
+					a.Reserved, b.Reserved, test.availableA, test.availableB, 0, 0)
+			}
+			for _, order := range m.salesOrders {
+				if order.Status != inventory.SalesOrderAllocated {
+					continue
+				}
+				for _, l := range order.Lines {
+					r := m.reservations[l.ReservationID]
+					if l.Reserved != l.Quantity || r.State != inventory.Closed || r.Sku != l.Sku {
+						t.Errorf("order %d %s line got reserved=%d reservation=%+v want %d closed", order.ID, l.Sku,
+							l.Reserved, r, l.Quantity)
+					}
+				}
+			}
+
+			var published []inventory.SalesOrder
+			q.published(t, testExchanges.SalesOrder, &published)
+			ids := make([]uint64, 0)
+			for _, order := range published {
+				ids = append(ids, order.ID)
+			}
+			if len(ids) != len(test.published) {
+				t.Fatalf("published orders got=%v want=%v", ids, test.published)
+			}
+			for i := range ids {
+				if ids[i] != test.published[i] {
+					t.Errorf("published orders got=%v want=%v", ids, test.published)
+				}
+			}
+		})
+	}
+}
+
+func TestGetSalesOrder(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{name: "known", id: "1", status: http.StatusOK},
+		{name: "unknown", id: "9", status: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newSalesOrderRepo()
+			m.salesOrders[1] = inventory.SalesOrder{ID: 1, RequestID: "SoRID1", Requester: "Whole",
+				Policy: inventory.AllOrNothing, Status: inventory.SalesOrderPending, Lines: orderLines("SoA", 2)}
+			ts := httptest.NewServer(testRouter(newMemQueue().queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			got := inventory.SalesOrder{}
+			if res := call(t, ts, http.MethodGet, "/inventory/v1/salesOrder/"+test.id, nil, &got); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if test.status == http.StatusOK && (got.ID != 1 || len(got.Lines) != 1) {
+				t.Errorf("order got=%+v want order 1 with its line", got)
+			}
+		})
+	}
+}
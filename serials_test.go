@@ -0,0 +1,159 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/inventory"
+)
+
+func newSerialRepo() *memRepo {
+	return newMemRepo(inventory.Product{Sku: "SerialSKU", Upc: "8888888888", Name: "Serialized", Serialized: true})
+}
+
+func TestProduceSerials(t *testing.T) {
+	tests := []struct {
+		name     string
+		produced []string
+		event    inventory.ProductionEvent
+		status   int
+		serials  int
+	}{
+		{
+			name:    "serials produced",
+			event:   inventory.ProductionEvent{RequestID: "SerialRID", Quantity: 3, Serials: []string{"SN-1", "SN-2", "SN-3"}},
+			status:  http.StatusCreated,
+			serials: 3,
+		},
+		{
+			name:    "count must equal quantity",
+			event:   inventory.ProductionEvent{RequestID: "SerialRID", Quantity: 2, Serials: []string{"SN-4"}},
+			status:  http.StatusBadRequest,
+			serials: 0,
+		},
+		{
+			name:    "duplicate in the event",
+			event:   inventory.ProductionEvent{RequestID: "SerialRID", Quantity: 2, Serials: []string{"SN-4", "SN-4"}},
+			status:  http.StatusConflict,
+			serials: 0,
+		},
+		{
+			// the in memory repo has no transaction to roll back, so SN-4 is left behind
+			name:     "duplicate of a produced serial",
+			produced: []string{"SN-1"},
+			event:    inventory.ProductionEvent{RequestID: "SerialRID", Quantity: 2, Serials: []string{"SN-4", "SN-1"}},
+			status:   http.StatusConflict,
+			serials:  2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newSerialRepo()
+			for _, sn := range test.produced {
+				m.serials[sn] = inventory.Serial{Serial: sn, Sku: "SerialSKU", Status: inventory.SerialAvailable}
+			}
+			ts := httptest.NewServer(testRouter(newMemQueue().queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			res := call(t, ts, http.MethodPost, "/inventory/v1/SerialSKU/productionEvent", test.event, nil)
+			if res.StatusCode != test.status {
+				t.Errorf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if len(m.serials) != test.serials {
+				t.Errorf("serials got=%d want=%d", len(m.serials), test.serials)
+			}
+		})
+	}
+}
+
+func TestSerialHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		serial   string
+		status   int
+		want     inventory.SerialStatus
+		reserved bool
+		history  []inventory.SerialEventType
+	}{
+		{
+			name:     "shipped",
+			serial:   "SN-2",
+			status:   http.StatusOK,
+			want:     inventory.SerialShipped,
+			reserved: true,
+			history: []inventory.SerialEventType{inventory.SerialEventProduced, inventory.SerialEventReserved,
+				inventory.SerialEventShipped},
+		},
+		{
+			name:    "still available",
+			serial:  "SN-3",
+			status:  http.StatusOK,
+			want:    inventory.SerialAvailable,
+			history: []inventory.SerialEventType{inventory.SerialEventProduced},
+		},
+		{
+			name:   "unknown",
+			serial: "SN-9",
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newSerialRepo()
+			q := newMemQueue()
+			ts := httptest.NewServer(testRouter(q.queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			event := inventory.ProductionEvent{RequestID: "SerialRID", Quantity: 3,
+				Serials: []string{"SN-1", "SN-2", "SN-3"}}
+			res := call(t, ts, http.MethodPost, "/inventory/v1/SerialSKU/productionEvent", event, nil)
+			if res.StatusCode != http.StatusCreated {
+				t.Fatalf("produce status got=%d want=%d", res.StatusCode, http.StatusCreated)
+			}
+			reservation := inventory.Reservation{RequestID: "SerialResRID", Requester: "SerialRequester",
+				RequestedQuantity: 2}
+			res = call(t, ts, http.MethodPost, "/inventory/v1/SerialSKU/reservation", reservation, nil)
+			if res.StatusCode != http.StatusCreated {
+				t.Fatalf("reserve status got=%d want=%d", res.StatusCode, http.StatusCreated)
+			}
+			var published []inventory.Reservation
+			q.published(t, testExchanges.Reservation, &published)
+			closed := published[len(published)-1]
+			if closed.State != inventory.Closed || len(closed.Serials) != 2 || closed.Serials[0] != "SN-1" ||
+				closed.Serials[1] != "SN-2" {
+				t.Fatalf("closed reservation got=%s %v want=%s %v", closed.State, closed.Serials, inventory.Closed,
+					[]string{"SN-1", "SN-2"})
+			}
+
+			history := inventory.SerialHistory{}
+			if res := call(t, ts, http.MethodGet, "/inventory/v1/serial/"+test.serial, nil, &history); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			var reservationID uint64
+			if test.reserved {
+				reservationID = closed.ID
+			}
+			if history.Status != test.want || history.ReservationID != reservationID {
+				t.Errorf("serial got=%s/%d want=%s/%d", history.Status, history.ReservationID, test.want, reservationID)
+			}
+			if len(history.History) != len(test.history) {
+				t.Fatalf("history got=%d want=%d", len(history.History), len(test.history))
+			}
+			for i, e := range test.history {
+				if history.History[i].Type != e {
+					t.Errorf("history[%d] got=%s want=%s", i, history.History[i].Type, e)
+				}
+			}
+			if last := history.History[len(history.History)-1]; test.reserved && last.Requester != "SerialRequester" {
+				t.Errorf("shipped to got=%s want=%s", last.Requester, "SerialRequester")
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package settings
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/sksmith/smfg-inventory/api"
+)
+
+type Api struct {
+	store *Store
+}
+
+func NewApi(store *Store) *Api {
+	return &Api{store: store}
+}
+
+func (a *Api) ConfigureRouter(r chi.Router) {
+	r.Get("/", a.Get)
+}
+
+type SettingsResponse struct {
+	Settings
+}
+
+func (s *SettingsResponse) Render(_ http.ResponseWriter, _ *http.Request) error {
+	return nil
+}
+
+func (a *Api) Get(w http.ResponseWriter, r *http.Request) {
+	api.Render(w, r, &SettingsResponse{a.store.Get()})
+}
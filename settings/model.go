@@ -0,0 +1,138 @@
+// Package settings holds the configuration that can safely change while the application is running. Values are
+// swapped atomically and every change is announced to subscribers.
+package settings
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	AllocateFifo          = "fifo"
+	AllocateSmallestFirst = "smallest-first"
+)
+
+// Backflush policies decide what happens when production needs more of a component than is available.
+const (
+	BackflushReject        = "reject"
+	BackflushAllowNegative = "allow-negative"
+)
+
+// Production buckets are where newly produced stock goes until it is moved, either straight to available or into
+// quarantine awaiting QC release.
+const (
+	ProduceToAvailable  = "available"
+	ProduceToQuarantine = "quarantine"
+)
+
+// Settings is a value object. A consistent snapshot of the runtime settings.
+type Settings struct {
+	LogLevel         string  `json:"logLevel"`
+	AllocationPolicy string  `json:"allocationPolicy"`
+	RateLimit        float64 `json:"rateLimit"`
+	RateBurst        int     `json:"rateBurst"`
+	AlertHysteresis  float64 `json:"alertHysteresis"`
+	BackflushPolicy  string  `json:"backflushPolicy"`
+	ProductionBucket string  `json:"productionBucket"`
+	CountThreshold   float64 `json:"countThreshold"`
+
+	ReplenishmentWindowDays int     `json:"replenishmentWindowDays"`
+	DefaultLeadTimeDays     float64 `json:"defaultLeadTimeDays"`
+	ServiceLevelZ           float64 `json:"serviceLevelZ"`
+	OrderCost               float64 `json:"orderCost"`
+	HoldingCost             float64 `json:"holdingCost"`
+}
+
+// Change describes an update to the settings along with the names of the settings that changed.
+type Change struct {
+	Old     Settings `json:"old"`
+	New     Settings `json:"new"`
+	Changed []string `json:"changed"`
+}
+
+type Store struct {
+	value       atomic.Value
+	mu          sync.Mutex
+	subscribers []func(Change)
+}
+
+func NewStore(initial Settings) *Store {
+	s := &Store{}
+	s.value.Store(initial)
+	return s
+}
+
+// Get returns the current settings. It is safe to call from any goroutine.
+func (s *Store) Get() Settings {
+	return s.value.Load().(Settings)
+}
+
+// Subscribe registers a function to be called with every change. Subscribers are called in order of registration
+// on the goroutine making the update.
+func (s *Store) Subscribe(fn func(Change)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers = append(s.subscribers, fn)
+}
+
+// Update replaces the settings and notifies subscribers if anything changed.
+func (s *Store) Update(next Settings) Change {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change := Change{Old: s.Get(), New: next}
+	change.Changed = diff(change.Old, change.New)
+	if len(change.Changed) == 0 {
+		return change
+	}
+
+	s.value.Store(next)
+	for _, fn := range s.subscribers {
+		fn(change)
+	}
+	return change
+}
+
+func diff(a, b Settings) []string {
+	var changed []string
+	if a.LogLevel != b.LogLevel {
+		changed = append(changed, "logLevel")
+	}
+	if a.AllocationPolicy != b.AllocationPolicy {
+		changed = append(changed, "allocationPolicy")
+	}
+	if a.RateLimit != b.RateLimit {
+		changed = append(changed, "rateLimit")
+	}
+	if a.RateBurst != b.RateBurst {
+		changed = append(changed, "rateBurst")
+	}
+	if a.AlertHysteresis != b.AlertHysteresis {
+		changed = append(changed, "alertHysteresis")
+	}
+	if a.BackflushPolicy != b.BackflushPolicy {
+		changed = append(changed, "backflushPolicy")
+	}
+	if a.ProductionBucket != b.ProductionBucket {
+		changed = append(changed, "productionBucket")
+	}
+	if a.CountThreshold != b.CountThreshold {
+		changed = append(changed, "countThreshold")
+	}
+	if a.ReplenishmentWindowDays != b.ReplenishmentWindowDays {
+		changed = append(changed, "replenishmentWindowDays")
+	}
+	if a.DefaultLeadTimeDays != b.DefaultLeadTimeDays {
+		changed = append(changed, "defaultLeadTimeDays")
+	}
+	if a.ServiceLevelZ != b.ServiceLevelZ {
+		changed = append(changed, "serviceLevelZ")
+	}
+	if a.OrderCost != b.OrderCost {
+		changed = append(changed, "orderCost")
+	}
+	if a.HoldingCost != b.HoldingCost {
+		changed = append(changed, "holdingCost")
+	}
+	return changed
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sksmith/smfg-inventory/broker"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sksmith/smfg-inventory/tracing"
+
+// Publisher matches the broker client used by the services.
+type Publisher interface {
+	Publish(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error
+}
+
+// Queue wraps a Publisher, recording a producer span for every message and injecting the trace context into its
+// headers.
+type Queue struct {
+	next Publisher
+}
+
+func NewQueue(next Publisher) *Queue {
+	return &Queue{next: next}
+}
+
+func (q *Queue) Publish(ctx context.Context, exchange string, body []byte, options ...broker.PublishOption) error {
+	ctx, span := otel.Tracer(tracerName).Start(ctx, exchange+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination", exchange),
+			attribute.String("messaging.destination_kind", "topic"),
+			attribute.Int("messaging.message_payload_size_bytes", len(body)),
+		))
+	defer span.End()
+
+	headers := map[string]interface{}{}
+	Inject(ctx, headers)
+	err := q.next.Publish(ctx, exchange, body, append(options, broker.Headers(headers))...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// Consumer wraps a handler of messages from a queue, recording a consumer span for every delivery that continues the
+// trace found in its headers. The handler is given a context carrying the span.
+func Consumer(queue string, handler func(ctx context.Context, delivery amqp.Delivery)) func(delivery amqp.Delivery) {
+	return func(delivery amqp.Delivery) {
+		ctx := Extract(context.Background(), delivery.Headers)
+		ctx, span := otel.Tracer(tracerName).Start(ctx, queue+" receive",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				attribute.String("messaging.system", "rabbitmq"),
+				attribute.String("messaging.source", queue),
+				attribute.String("messaging.source_kind", "queue"),
+				attribute.String("messaging.operation", "receive"),
+				attribute.Int("messaging.message_payload_size_bytes", len(delivery.Body)),
+			))
+		defer span.End()
+		handler(ctx, delivery)
+	}
+}
+
+// Inject writes the trace context of ctx into message headers.
+func Inject(ctx context.Context, headers map[string]interface{}) {
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+}
+
+// Extract returns a context carrying the trace context found in message headers, for consumers to start their spans
+// from.
+func Extract(ctx context.Context, headers map[string]interface{}) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier(headers))
+}
+
+// headerCarrier adapts amqp message headers to the propagation.TextMapCarrier interface.
+type headerCarrier map[string]interface{}
+
+func (h headerCarrier) Get(key string) string {
+	v, ok := h[key]
+	if !ok {
+		return ""
+	}
+	switch s := v.(type) {
+	case string:
+		return s
+	case []byte:
+		return string(s)
+	default:
+		return fmt.Sprint(s)
+	}
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h[key] = value
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
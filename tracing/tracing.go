@@ -0,0 +1,88 @@
+// Package tracing configures OpenTelemetry and carries W3C trace context across the broker.
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+const (
+	ExporterNone   = "none"
+	ExporterOtlp   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Config decides where spans are sent.
+type Config struct {
+	Exporter       string
+	Endpoint       string
+	Insecure       bool
+	File           string
+	SampleRatio    float64
+	ServiceName    string
+	ServiceVersion string
+}
+
+// Init installs the global tracer provider and the W3C propagators. The returned function flushes any buffered spans
+// and should be called on shutdown. With ExporterNone spans are not recorded but incoming trace context is still
+// passed along to anything published.
+func Init(ctx context.Context, c Config) (func(ctx context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch c.Exporter {
+	case ExporterNone, "":
+		return func(ctx context.Context) error { return nil }, nil
+	case ExporterOtlp:
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(c.Endpoint)}
+		if c.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exp, err := otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to create otlp exporter")
+		}
+		exporter = exp
+	case ExporterStdout:
+		exporter = NewJSONExporter(nopCloser{os.Stdout})
+	case ExporterFile:
+		f, err := os.OpenFile(c.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "failed to open trace file %s", c.File)
+		}
+		exporter = NewJSONExporter(f)
+	default:
+		return nil, errors.Errorf("unknown trace exporter %q", c.Exporter)
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String(c.ServiceName),
+		semconv.ServiceVersionKey.String(c.ServiceVersion),
+	)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/broker"
+	"github.com/sksmith/smfg-inventory/db"
+	"github.com/sksmith/smfg-inventory/inventory"
+	"github.com/sksmith/smfg-inventory/tracing"
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordingChannel stands in for an amqp channel, keeping what is published on it.
+type recordingChannel struct {
+	published []amqp.Publishing
+}
+
+func (c *recordingChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	c.published = append(c.published, msg)
+	return nil
+}
+
+func TestTracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	mockRepo := inventory.NewMockRepo()
+	mockRepo.GetProductFunc = func(ctx context.Context, sku string, tx ...db.Transaction) (inventory.Product, error) {
+		return testProducts[0], nil
+	}
+	mockRepo.GetProductionEventByRequestIDFunc = func(ctx context.Context, requestID string, tx ...db.Transaction) (pe inventory.ProductionEvent, err error) {
+		return pe, sql.ErrNoRows
+	}
+	channel := &recordingChannel{}
+
+	ts := httptest.NewServer(testRouter(tracing.NewQueue(broker.NewClient(channel)), mockRepo, audit.NewMockRepo()))
+	defer ts.Close()
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	data, err := json.Marshal(testProductionEvents[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/inventory/v1/TestOneSKU/productionEvent", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("status got=%d want=%d", res.StatusCode, http.StatusCreated)
+	}
+
+	var server, producer sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.SpanKind() {
+		case trace.SpanKindServer:
+			server = span
+		case trace.SpanKindProducer:
+			producer = span
+		}
+	}
+	if server == nil {
+		t.Fatal("expected a server span")
+	}
+	if got := server.SpanContext().TraceID().String(); got != traceID {
+		t.Errorf("server trace id got=%s want=%s", got, traceID)
+	}
+	if got := server.Name(); got != "POST /inventory/v1/{sku}/productionEvent" {
+		t.Errorf("server span name got=%s", got)
+	}
+	if producer == nil {
+		t.Fatal("expected a producer span")
+	}
+	if producer.Parent().TraceID() != server.SpanContext().TraceID() {
+		t.Errorf("producer trace id got=%s want=%s", producer.Parent().TraceID(), traceID)
+	}
+
+	if len(channel.published) == 0 {
+		t.Fatal("expected a published message")
+	}
+	traceparent, _ := channel.published[0].Headers["traceparent"].(string)
+	want := "00-" + traceID + "-" + producer.SpanContext().SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("traceparent header got=%q want=%q", traceparent, want)
+	}
+
+	// a consumer of the message continues the same trace under the producer span
+	var consumed trace.SpanContext
+	tracing.Consumer("inventory", func(ctx context.Context, delivery amqp.Delivery) {
+		consumed = trace.SpanContextFromContext(ctx)
+	})(amqp.Delivery{Headers: channel.published[0].Headers, Body: channel.published[0].Body})
+
+	var consumer sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.SpanKind() == trace.SpanKindConsumer {
+			consumer = span
+		}
+	}
+	if consumer == nil {
+		t.Fatal("expected a consumer span")
+	}
+	if got := consumed.TraceID().String(); got != traceID {
+		t.Errorf("consumer trace id got=%s want=%s", got, traceID)
+	}
+	if consumer.Parent().SpanID() != producer.SpanContext().SpanID() {
+		t.Errorf("consumer parent got=%s want=%s", consumer.Parent().SpanID(), producer.SpanContext().SpanID())
+	}
+}
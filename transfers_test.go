@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/inventory"
+)
+
+type transferStep struct {
+	url  string
+	body interface{}
+}
+
+func ship(requestID, from, to string, qty int64) transferStep {
+	return transferStep{"/transfer", inventory.Transfer{RequestID: requestID, From: from, To: to, Quantity: qty}}
+}
+
+func receive(requestID string, qty int64, close bool, reason string) transferStep {
+	return transferStep{"/transfer/1/receipt",
+		inventory.TransferReceipt{RequestID: requestID, Quantity: qty, Close: close, Reason: reason}}
+}
+
+// newTransferServer holds ten of XferSKU at the main location and none in the east.
+func newTransferServer(t *testing.T, steps ...transferStep) (*memRepo, *memQueue, *httptest.Server) {
+	t.Helper()
+	m := newMemRepo(inventory.Product{Sku: "XferSKU", Upc: "5555555551", Name: "Transferred", Available: 10})
+	m.locations["east"] = inventory.Location{Code: "east", Name: "East"}
+	q := newMemQueue()
+	ts := httptest.NewServer(testRouter(q.queue, m.repo, audit.NewMockRepo()))
+	for _, step := range steps {
+		if res := call(t, ts, http.MethodPost, "/inventory/v1/XferSKU"+step.url, step.body, nil); res.StatusCode != http.StatusCreated {
+			ts.Close()
+			t.Fatalf("%s status got=%d want=%d", step.url, res.StatusCode, http.StatusCreated)
+		}
+	}
+	return m, q, ts
+}
+
+func TestTransfers(t *testing.T) {
+	shipped := ship("XferRID1", "main", "east", 6)
+	partly := receive("XferRcv1", 4, false, "")
+	closed := receive("XferRcv4", 1, true, "dropped")
+
+	tests := []struct {
+		name      string
+		before    []transferStep
+		step      transferStep
+		status    int
+		available int64
+		inTransit int64
+		east      int64
+	}{
+		{
+			name:      "ship to east",
+			step:      shipped,
+			status:    http.StatusCreated,
+			available: 4,
+			inTransit: 6,
+		},
+		{
+			name:      "retry ships once",
+			before:    []transferStep{shipped},
+			step:      shipped,
+			status:    http.StatusCreated,
+			available: 4,
+			inTransit: 6,
+		},
+		{
+			name:      "more than main holds",
+			before:    []transferStep{shipped},
+			step:      ship("XferRID2", "main", "east", 5),
+			status:    http.StatusUnprocessableEntity,
+			available: 4,
+			inTransit: 6,
+		},
+		{
+			name:      "unknown location",
+			step:      ship("XferRID3", "main", "west", 1),
+			status:    http.StatusNotFound,
+			available: 10,
+		},
+		{
+			name:      "partial receipt",
+			before:    []transferStep{shipped},
+			step:      partly,
+			status:    http.StatusCreated,
+			available: 8,
+			inTransit: 2,
+			east:      4,
+		},
+		{
+			name:      "more than shipped",
+			before:    []transferStep{shipped, partly},
+			step:      receive("XferRcv2", 3, false, ""),
+			status:    http.StatusConflict,
+			available: 8,
+			inTransit: 2,
+			east:      4,
+		},
+		{
+			name:   "reservations only take the stock at main",
+			before: []transferStep{shipped, partly},
+			step: transferStep{"/reservation",
+				inventory.Reservation{RequestID: "XferRes1", Requester: "Acme", RequestedQuantity: 6}},
+			status:    http.StatusCreated,
+			available: 4,
+			inTransit: 2,
+			east:      4,
+		},
+		{
+			name:   "adjustments only take the stock at main",
+			before: []transferStep{shipped, partly},
+			step: transferStep{"/adjustment",
+				inventory.Adjustment{RequestID: "XferAdj1", Quantity: -6, Reason: "lost"}},
+			status:    http.StatusUnprocessableEntity,
+			available: 8,
+			inTransit: 2,
+			east:      4,
+		},
+		{
+			name:      "close short without a reason",
+			before:    []transferStep{shipped, partly},
+			step:      receive("XferRcv3", 1, true, ""),
+			status:    http.StatusBadRequest,
+			available: 8,
+			inTransit: 2,
+			east:      4,
+		},
+		{
+			name:      "close short",
+			before:    []transferStep{shipped, partly},
+			step:      closed,
+			status:    http.StatusCreated,
+			available: 9,
+			east:      5,
+		},
+		{
+			name:      "retry receives once",
+			before:    []transferStep{shipped, partly, closed},
+			step:      closed,
+			status:    http.StatusCreated,
+			available: 9,
+			east:      5,
+		},
+		{
+			name:      "already received",
+			before:    []transferStep{shipped, partly, closed},
+			step:      receive("XferRcv5", 1, false, ""),
+			status:    http.StatusConflict,
+			available: 9,
+			east:      5,
+		},
+		{
+			name:      "ship from east",
+			before:    []transferStep{shipped, partly, closed},
+			step:      ship("XferRID4", "east", "main", 5),
+			status:    http.StatusCreated,
+			available: 4,
+			inTransit: 5,
+		},
+		{
+			name:      "more than east holds",
+			before:    []transferStep{shipped, partly, closed, ship("XferRID4", "east", "main", 5)},
+			step:      ship("XferRID5", "east", "main", 1),
+			status:    http.StatusUnprocessableEntity,
+			available: 4,
+			inTransit: 5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, _, ts := newTransferServer(t, test.before...)
+			defer ts.Close()
+
+			res := call(t, ts, http.MethodPost, "/inventory/v1/XferSKU"+test.step.url, test.step.body, nil)
+			if res.StatusCode != test.status {
+				t.Errorf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			product, east := m.products["XferSKU"], m.locationStock["XferSKU"]["east"]
+			if product.Available != test.available || product.InTransit != test.inTransit || east != test.east {
+				t.Errorf("got available=%d in transit=%d east=%d want=%d/%d/%d", product.Available,
+					product.InTransit, east, test.available, test.inTransit, test.east)
+			}
+		})
+	}
+}
+
+func TestGetTransfer(t *testing.T) {
+	tests := []struct {
+		name   string
+		url    string
+		status int
+	}{
+		{"received short", "/inventory/v1/XferSKU/transfer/1", http.StatusOK},
+		{"unknown", "/inventory/v1/XferSKU/transfer/9", http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, q, ts := newTransferServer(t, ship("XferRID1", "main", "east", 6), receive("XferRcv1", 4, false, ""),
+				receive("XferRcv4", 1, true, "dropped"))
+			defer ts.Close()
+
+			got := inventory.Transfer{}
+			if res := call(t, ts, http.MethodGet, test.url, nil, &got); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if test.status == http.StatusOK && (got.Status != inventory.TransferReceived || got.Received != 5 ||
+				got.Discrepancy != 1 || len(got.Receipts) != 2) {
+				t.Errorf("transfer got=%+v want received 5 of 6 with a discrepancy of 1 over 2 receipts", got)
+			}
+
+			// each step publishes the stock at both of its locations
+			var published []inventory.LocationStock
+			q.published(t, testExchanges.Location, &published)
+			if len(published) != 6 {
+				t.Fatalf("location stock published got=%d want=%d", len(published), 6)
+			}
+			if first := published[:2]; first[0].Location != "east" || first[0].Available != 0 ||
+				first[1].Location != inventory.DefaultLocation || first[1].Available != 4 {
+				t.Errorf("location stock after shipping got=%+v want east 0 and main 4", first)
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sksmith/smfg-inventory/audit"
+	"github.com/sksmith/smfg-inventory/inventory"
+)
+
+var uomHierarchy = inventory.Units{{Name: "pallet", Quantity: 40, Of: "case"}, {Name: "case", Quantity: 12}}
+
+// newUnitServer stocks UomSKU in pallets of forty cases of twelve.
+func newUnitServer(t *testing.T, available int64) (*memRepo, *httptest.Server) {
+	m := newMemRepo(inventory.Product{Sku: "UomSKU", Upc: "9999999999", Name: "Packaged", Available: available})
+	ts := httptest.NewServer(testRouter(newMemQueue().queue, m.repo, audit.NewMockRepo()))
+	res := call(t, ts, http.MethodPut, "/inventory/v1/UomSKU/units", map[string]interface{}{"units": uomHierarchy}, nil)
+	if res.StatusCode != http.StatusOK {
+		ts.Close()
+		t.Fatalf("save units status got=%d want=%d", res.StatusCode, http.StatusOK)
+	}
+	return m, ts
+}
+
+func TestSaveUnits(t *testing.T) {
+	tests := []struct {
+		name   string
+		units  inventory.Units
+		status int
+		base   []int64
+	}{
+		{
+			name:   "hierarchy",
+			units:  uomHierarchy,
+			status: http.StatusOK,
+			base:   []int64{480, 12},
+		},
+		{
+			name:   "cyclic",
+			units:  inventory.Units{{Name: "case", Quantity: 12, Of: "pallet"}, {Name: "pallet", Quantity: 40, Of: "case"}},
+			status: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newMemRepo(inventory.Product{Sku: "UomSKU", Upc: "9999999999", Name: "Packaged"})
+			ts := httptest.NewServer(testRouter(newMemQueue().queue, m.repo, audit.NewMockRepo()))
+			defer ts.Close()
+
+			saved := inventory.UnitsResponse{}
+			res := call(t, ts, http.MethodPut, "/inventory/v1/UomSKU/units", map[string]interface{}{"units": test.units}, &saved)
+			if res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if len(saved.Units) != len(test.base) {
+				t.Fatalf("units got=%+v want bases %v", saved.Units, test.base)
+			}
+			for i, base := range test.base {
+				if saved.Units[i].Base != base {
+					t.Errorf("%s base got=%d want=%d", saved.Units[i].Name, saved.Units[i].Base, base)
+				}
+			}
+		})
+	}
+}
+
+func TestQuantitiesInUnits(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		body      interface{}
+		status    int
+		quantity  int64
+		available int64
+	}{
+		{
+			name:      "produce pallets",
+			url:       "/inventory/v1/UomSKU/productionEvent",
+			body:      inventory.ProductionEvent{RequestID: "UomRID", Quantity: 2, Unit: "pallet"},
+			status:    http.StatusCreated,
+			quantity:  960,
+			available: 960,
+		},
+		{
+			name:   "produce an unknown unit",
+			url:    "/inventory/v1/UomSKU/productionEvent",
+			body:   inventory.ProductionEvent{RequestID: "UomRID", Quantity: 2, Unit: "crate"},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "reserve cases",
+			url:    "/inventory/v1/UomSKU/reservation",
+			body:   inventory.Reservation{RequestID: "UomResRID", Requester: "UomRequester", RequestedQuantity: 3, Unit: "case"},
+			status: http.StatusCreated,
+			// the response is in the base unit
+			quantity: 36,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, ts := newUnitServer(t, 0)
+			defer ts.Close()
+
+			got := struct {
+				Quantity          int64  `json:"quantity"`
+				RequestedQuantity int64  `json:"requestedQuantity"`
+				Unit              string `json:"unit"`
+			}{}
+			if res := call(t, ts, http.MethodPost, test.url, test.body, &got); res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			if quantity := got.Quantity + got.RequestedQuantity; quantity != test.quantity || got.Unit != "" {
+				t.Errorf("quantity got=%d %s want=%d", quantity, got.Unit, test.quantity)
+			}
+			if available := m.products["UomSKU"].Available; available != test.available {
+				t.Errorf("available got=%d want=%d", available, test.available)
+			}
+		})
+	}
+}
+
+func TestGetInUnits(t *testing.T) {
+	tests := []struct {
+		name      string
+		url       string
+		status    int
+		available int64
+		code      string
+	}{
+		{"base unit", "/inventory/v1/UomSKU", http.StatusOK, 924, ""},
+		{"in cases", "/inventory/v1/UomSKU?uom=case", http.StatusOK, 77, ""},
+		{"fractional pallets", "/inventory/v1/UomSKU?uom=pallet", http.StatusBadRequest, 0, inventory.CodeFractionalQuantity},
+		{"unknown unit", "/inventory/v1/UomSKU?uom=crate", http.StatusBadRequest, 0, inventory.CodeUnknownUnit},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, ts := newUnitServer(t, 924)
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != test.status {
+				t.Fatalf("status got=%d want=%d", res.StatusCode, test.status)
+			}
+			got := struct {
+				inventory.ProductResponse
+				Code string `json:"code"`
+			}{}
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Available != test.available || got.Reserved != 0 || got.Code != test.code {
+				t.Errorf("got=%d/%d %s want=%d/%d %s", got.Available, got.Reserved, got.Code, test.available, 0,
+					test.code)
+			}
+		})
+	}
+
+	// the unit is part of the entity tag, so a tag from the base unit doesn't match a representation in cases
+	t.Run("etag", func(t *testing.T) {
+		_, ts := newUnitServer(t, 924)
+		defer ts.Close()
+
+		base := call(t, ts, http.MethodGet, "/inventory/v1/UomSKU", nil, nil).Header.Get("ETag")
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/inventory/v1/UomSKU?uom=case", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-None-Match", base)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != http.StatusOK || res.Header.Get("ETag") == base {
+			t.Errorf("in cases got=%d etag=%s want=%d and an etag other than %s", res.StatusCode,
+				res.Header.Get("ETag"), http.StatusOK, base)
+		}
+	})
+
+	t.Run("reservation in cases", func(t *testing.T) {
+		m, ts := newUnitServer(t, 924)
+		defer ts.Close()
+		m.addReservations(inventory.Reservation{ID: 1, RequestID: "UomResRID", Requester: "UomRequester",
+			Sku: "UomSKU", State: inventory.Closed, RequestedQuantity: 36, ReservedQuantity: 36})
+
+		got := inventory.Reservation{}
+		if res := call(t, ts, http.MethodGet, "/inventory/v1/UomSKU/reservation/1?uom=case", nil, &got); res.StatusCode != http.StatusOK {
+			t.Fatalf("status got=%d want=%d", res.StatusCode, http.StatusOK)
+		}
+		if got.Unit != "case" || got.RequestedQuantity != 3 || got.ReservedQuantity != 3 {
+			t.Errorf("reservation got=%d/%d %s want=%d/%d %s", got.RequestedQuantity, got.ReservedQuantity,
+				got.Unit, 3, 3, "case")
+		}
+	})
+}